@@ -0,0 +1,316 @@
+package test
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/dmznlin/znlib-go/znlib/mqtt/broker"
+	mt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// waitToken 等待token完成,超时时测试失败
+func waitToken(t *testing.T, tk mt.Token) error {
+	t.Helper()
+	if !tk.WaitTimeout(3 * time.Second) {
+		t.Fatal("wait token timeout")
+	}
+	return tk.Error()
+}
+
+func brokerClient(t *testing.T, url, id, user, pwd string) mt.Client {
+	t.Helper()
+	opts := mt.NewClientOptions().AddBroker(url).SetClientID(id)
+	opts.SetUsername(user).SetPassword(pwd).SetAutoReconnect(false)
+
+	cli := mt.NewClient(opts)
+	if err := waitToken(t, cli.Connect()); err != nil {
+		t.Fatal(err)
+	}
+	return cli
+}
+
+func TestBroker(t *testing.T) {
+	bk := broker.New(&broker.Options{
+		TcpAddr: "127.0.0.1:0",
+		WsAddr:  "127.0.0.1:0",
+		Users:   map[string]string{"user": "pwd"},
+	})
+
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Stop()
+	url := "tcp://" + bk.TcpAddr()
+
+	bad := mt.NewClient(mt.NewClientOptions().AddBroker(url).SetClientID("bad").
+		SetUsername("user").SetPassword("none").SetAutoReconnect(false))
+	if err := waitToken(t, bad.Connect()); err == nil {
+		t.Fatal("expect auth failure")
+	}
+
+	pub := brokerClient(t, url, "pub", "user", "pwd")
+	defer pub.Disconnect(100)
+	if err := waitToken(t, pub.Publish("dev/1/status", 1, true, "online")); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 4)
+	sub := brokerClient(t, url, "sub", "user", "pwd")
+	defer sub.Disconnect(100)
+
+	err := waitToken(t, sub.Subscribe("dev/+/#", 1, func(client mt.Client, msg mt.Message) {
+		got <- msg.Topic() + "=" + string(msg.Payload())
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = waitToken(t, pub.Publish("dev/2/cmd", 0, false, "reset")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"dev/1/status=online", "dev/2/cmd=reset"} {
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Fatalf("expect %s, got %s", want, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("wait %s timeout", want)
+		}
+	}
+
+	if bk.Clients() != 2 {
+		t.Fatalf("expect 2 clients, got %d", bk.Clients())
+	}
+}
+
+func TestBrokerWebSocket(t *testing.T) {
+	bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:0", WsAddr: "127.0.0.1:0"})
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Stop()
+
+	got := make(chan string, 2)
+	ws := brokerClient(t, "ws://"+bk.WsAddr()+"/mqtt", "ws", "", "")
+	defer ws.Disconnect(100)
+
+	err := waitToken(t, ws.Subscribe("ws/#", 1, func(client mt.Client, msg mt.Message) {
+		got <- string(msg.Payload())
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tcp := brokerClient(t, "tcp://"+bk.TcpAddr(), "tcp", "", "")
+	defer tcp.Disconnect(100)
+	if err = waitToken(t, tcp.Publish("ws/1", 1, false, "over websocket")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg != "over websocket" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait websocket message timeout")
+	}
+
+	//websocket 客户端发布
+	if err = waitToken(t, ws.Publish("ws/2", 1, false, "from websocket")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-got:
+		if msg != "from websocket" {
+			t.Fatalf("unexpected message: %s", msg)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait websocket publish timeout")
+	}
+}
+
+// rawClient 直接收发报文的客户端
+type rawClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// rawConnect 以 clean session=false 连接broker
+func rawConnect(t *testing.T, addr, id string) (*rawClient, bool) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cp := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	cp.ProtocolName = "MQTT"
+	cp.ProtocolVersion = 4
+	cp.ClientIdentifier = id
+	cp.Keepalive = 30
+	if err = cp.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &rawClient{conn: conn, reader: bufio.NewReader(conn)}
+	ack, ok := rc.read(t, time.Second).(*packets.ConnackPacket)
+	if !ok || ack.ReturnCode != packets.Accepted {
+		t.Fatalf("unexpected connack: %v", ack)
+	}
+	return rc, ack.SessionPresent
+}
+
+// read 读取一个报文,超时返回nil
+func (rc *rawClient) read(t *testing.T, timeout time.Duration) packets.ControlPacket {
+	t.Helper()
+	_ = rc.conn.SetReadDeadline(time.Now().Add(timeout))
+	pkt, err := packets.ReadPacket(rc.reader)
+	if err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return nil
+		}
+		t.Fatal(err)
+	}
+	return pkt
+}
+
+// readPublish 读取一个发布报文
+func (rc *rawClient) readPublish(t *testing.T) *packets.PublishPacket {
+	t.Helper()
+	pub, ok := rc.read(t, 3*time.Second).(*packets.PublishPacket)
+	if !ok {
+		t.Fatal("expect publish packet")
+	}
+	return pub
+}
+
+// waitClients 等待在线客户端数降至n
+func waitClients(bk *broker.Broker, n int) {
+	deadline := time.Now().Add(3 * time.Second)
+	for bk.Clients() > n && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBrokerInflight(t *testing.T) {
+	bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:0"})
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Stop()
+
+	rc, present := rawConnect(t, bk.TcpAddr(), "inflight")
+	if present {
+		t.Fatal("expect new session")
+	}
+
+	sp := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	sp.MessageID = 1
+	sp.Topics = []string{"inflight/#"}
+	sp.Qoss = []byte{1}
+	if err := sp.Write(rc.conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := rc.read(t, time.Second).(*packets.SubackPacket); !ok {
+		t.Fatal("expect suback")
+	}
+
+	pub := brokerClient(t, "tcp://"+bk.TcpAddr(), "inflight-pub", "", "")
+	defer pub.Disconnect(100)
+	if err := waitToken(t, pub.Publish("inflight/1", 1, false, "first")); err != nil {
+		t.Fatal(err)
+	}
+
+	//收到但不确认,然后断开
+	first := rc.readPublish(t)
+	if string(first.Payload) != "first" || first.Dup {
+		t.Fatalf("unexpected publish: %v", first)
+	}
+	_ = rc.conn.Close()
+	waitClients(bk, 1)
+
+	//离线期间的消息
+	if err := waitToken(t, pub.Publish("inflight/2", 1, false, "second")); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, present = rawConnect(t, bk.TcpAddr(), "inflight")
+	defer rc.conn.Close()
+	if !present {
+		t.Fatal("expect session present")
+	}
+
+	resent := rc.readPublish(t)
+	if string(resent.Payload) != "first" || !resent.Dup || resent.MessageID != first.MessageID {
+		t.Fatalf("expect first resent with dup, got %v", resent)
+	}
+
+	queued := rc.readPublish(t)
+	if string(queued.Payload) != "second" || queued.Dup {
+		t.Fatalf("expect queued second, got %v", queued)
+	}
+
+	for _, id := range []uint16{resent.MessageID, queued.MessageID} {
+		ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		ack.MessageID = id
+		if err := ack.Write(rc.conn); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = rc.conn.Close()
+	waitClients(bk, 1)
+
+	//已确认,不再重发
+	rc, _ = rawConnect(t, bk.TcpAddr(), "inflight")
+	defer rc.conn.Close()
+	if pkt := rc.read(t, 200*time.Millisecond); pkt != nil {
+		t.Fatalf("expect nothing to resend, got %v", pkt)
+	}
+}
+
+// reconnect 断开后重新连接,返回是否恢复了会话
+func reconnect(t *testing.T, bk *broker.Broker, id string) bool {
+	t.Helper()
+	rc, present := rawConnect(t, bk.TcpAddr(), id)
+	_ = rc.conn.Close()
+	waitClients(bk, 0)
+	return present
+}
+
+func TestBrokerOfflineStates(t *testing.T) {
+	bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:0", MaxOffline: 2, SessionExpiry: 300 * time.Millisecond})
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Stop()
+
+	//数量超出时,删除最早离线的会话
+	for _, id := range []string{"s1", "s2", "s3"} {
+		if reconnect(t, bk, id) {
+			t.Fatalf("%s: expect new session", id)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if !reconnect(t, bk, "s3") || !reconnect(t, bk, "s2") {
+		t.Fatal("expect recent sessions present")
+	}
+
+	if reconnect(t, bk, "s1") {
+		t.Fatal("expect oldest session evicted")
+	}
+
+	//过期
+	time.Sleep(400 * time.Millisecond)
+	if reconnect(t, bk, "s1") {
+		t.Fatal("expect expired session removed")
+	}
+}
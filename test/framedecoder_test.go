@@ -0,0 +1,133 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+)
+
+func TestFrameDecoder_LengthField(t *testing.T) {
+	var frames [][]byte
+	decoder, err := NewFrameDecoder(FrameConfig{
+		Mode:         FrameLengthField,
+		MaxFrame:     32,
+		LengthOffset: 1,
+		LengthWidth:  2,
+		LengthOrder:  binary.LittleEndian,
+		LengthAdjust: 1,
+	}, func(frame []byte) {
+		frames = append(frames, frame)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//head + len(2) + data(3) + crc
+	f1 := []byte{0xAA, 3, 0, 1, 2, 3, 0xFF}
+	f2 := []byte{0xAA, 0, 0, 0xFE}
+	stream := append(append([]byte{}, f1...), f2...)
+
+	//split at every position
+	for _, b := range stream {
+		if _, err = decoder.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(frames) != 2 || !bytes.Equal(frames[0], f1) || !bytes.Equal(frames[1], f2) {
+		t.Fatalf("unexpected frames: %v", frames)
+	}
+
+	if decoder.Buffered() != 0 {
+		t.Fatalf("expect empty buffer, got %d", decoder.Buffered())
+	}
+
+	//max-frame guard
+	frames = nil
+	if _, err = decoder.Write([]byte{0xAA, 0xFF, 0}); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+
+	if decoder.Buffered() != 0 {
+		t.Fatalf("expect buffer discarded, got %d", decoder.Buffered())
+	}
+
+	if _, err = decoder.Write(f2); err != nil || len(frames) != 1 {
+		t.Fatalf("expect resync, got %v %v", err, frames)
+	}
+}
+
+func TestFrameDecoder_Delimiter(t *testing.T) {
+	var frames []string
+	handler := func(frame []byte) {
+		frames = append(frames, string(frame))
+	}
+
+	decoder, err := NewFrameDecoder(FrameConfig{
+		Mode:      FrameDelimiter,
+		MaxFrame:  16,
+		Delimiter: []byte{0x0D, 0x0A},
+	}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = decoder.Write([]byte("ab\r"))
+	_, _ = decoder.Write([]byte("\ncd\r\nef"))
+	if len(frames) != 2 || frames[0] != "ab\r\n" || frames[1] != "cd\r\n" {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+
+	if _, err = decoder.Write([]byte("0123456789abcdef")); err != ErrFrameTooLarge {
+		t.Fatalf("expect ErrFrameTooLarge, got %v", err)
+	}
+
+	//STX/ETX, garbage before STX is dropped
+	frames = nil
+	decoder, err = NewFrameDecoder(FrameConfig{
+		Mode:           FrameDelimiter,
+		StartFlag:      []byte{0x02},
+		Delimiter:      []byte{0x03},
+		StripDelimiter: true,
+	}, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = decoder.Write([]byte("xx\x02hel"))
+	_, _ = decoder.Write([]byte("lo\x03yy\x02world\x03"))
+	if len(frames) != 2 || frames[0] != "hello" || frames[1] != "world" {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+}
+
+func TestFrameDecoder_FixedLength(t *testing.T) {
+	var frames []string
+	decoder, err := NewFrameDecoder(FrameConfig{
+		Mode:        FrameFixedLength,
+		FixedLength: 4,
+	}, func(frame []byte) {
+		frames = append(frames, string(frame))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _ = decoder.Write([]byte("abcdef"))
+	_, _ = decoder.Write([]byte("ghij"))
+	if len(frames) != 2 || frames[0] != "abcd" || frames[1] != "efgh" || decoder.Buffered() != 2 {
+		t.Fatalf("unexpected frames: %q", frames)
+	}
+
+	decoder.Reset()
+	if decoder.Buffered() != 0 {
+		t.Fatal("expect empty buffer after reset")
+	}
+
+	if _, err = NewFrameDecoder(FrameConfig{Mode: FrameLengthField, LengthWidth: 3},
+		func([]byte) {}); err != ErrFrameConfig {
+		t.Fatalf("expect ErrFrameConfig, got %v", err)
+	}
+}
@@ -1,22 +1,81 @@
 package test
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"testing"
 	"time"
 
+	. "github.com/dmznlin/znlib-go/znlib"
 	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	"github.com/dmznlin/znlib-go/znlib/mqtt/broker"
 	mt "github.com/eclipse/paho.mqtt.golang"
 )
 
+// testBroker 启动测试用的内嵌 broker,返回连接地址
+func testBroker(t *testing.T) string {
+	bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:0"})
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(bk.Stop)
+	return "tcp://" + bk.TcpAddr()
+}
+
+// testClient 创建连接到url的客户端
+func testClient(t *testing.T, url, name string, queue MqttQueue) *mqtt.Utils {
+	mc := mqtt.NewUtils(name)
+	mc.HintInfo = false
+	if err := mc.ApplyConfig(&MqttConfig{
+		Name:     name,
+		Enable:   true,
+		Broker:   []string{url},
+		ClientID: name,
+		Queue:    queue,
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	mc.Options.SetAutoReconnect(false)
+	return mc
+}
+
+// topicLimiter 拒绝指定主题的限流器
+type topicLimiter struct {
+	RateLimiter
+	deny string
+}
+
+func (l *topicLimiter) Wait(ctx context.Context, key string) error {
+	if key == l.deny {
+		return errors.New("topic denied")
+	}
+	return nil
+}
+
 func TestMqtt(t *testing.T) {
+	mqtt.Client.HintInfo = false
+	if err := mqtt.Client.ApplyConfig(&MqttConfig{
+		Name:     mqtt.DefaultName,
+		Enable:   true,
+		Broker:   []string{testBroker(t)},
+		ClientID: "mt-test",
+		TopicPub: []*MqttTopic{{Name: "by_name", Topic: "test/cmd"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan string, 2)
 	if err := mqtt.Client.Start(func(client mt.Client, message mt.Message) {
-		t.Log(message.Topic(), string(message.Payload()))
+		got <- message.Topic() + "=" + string(message.Payload())
 	}); err != nil {
 		t.Fatal(err)
 	}
 
 	defer mqtt.Client.Stop()
-	if err := mqtt.Client.Subscribe("test/cmd", mqtt.Qos0); err != nil {
+	if err := mqtt.Client.Subscribe("test/cmd", mqtt.Qos0, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -24,9 +83,423 @@ func TestMqtt(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := mqtt.Client.Publish("by_name", mqtt.Qos0, []byte("hello")); err != nil {
+	if err := mqtt.Client.Publish("by_name", mqtt.Qos0, []byte("by name")); err != nil {
 		t.Fatal(err)
 	}
 
-	time.Sleep(1 * time.Second)
+	for _, want := range []string{"test/cmd=hello", "test/cmd=by name"} {
+		select {
+		case msg := <-got:
+			if msg != want {
+				t.Fatalf("expect %s, got %s", want, msg)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("wait %s timeout", want)
+		}
+	}
+}
+
+func TestMqttQueue(t *testing.T) {
+	file := t.TempDir() + "/mqtt.queue"
+	mc := mqtt.NewUtils("queue")
+
+	cfg := &MqttQueue{Enable: true, MaxSize: 3, Policy: mqtt.QueueDropOldest, File: file}
+	if err := mc.EnableQueue(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 5; i++ { //未连接,缓存消息
+		if err := mc.Publish("test/queue", mqtt.Qos1, []byte{byte(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	queued, dropped := mc.QueueStats()
+	if queued != 3 || dropped != 2 {
+		t.Fatalf("expect 3 queued and 2 dropped, got %d %d", queued, dropped)
+	}
+
+	reload := mqtt.NewUtils("reload")
+	if err := reload.EnableQueue(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	if queued, _ = reload.QueueStats(); queued != 3 {
+		t.Fatalf("expect 3 messages loaded from file, got %d", queued)
+	}
+}
+
+func TestMqttQueueRetry(t *testing.T) {
+	url := testBroker(t)
+	got := make(chan string, 4)
+	sub := testClient(t, url, "queue-sub", MqttQueue{})
+	if err := sub.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+
+	if err := sub.Subscribe("queue/#", mqtt.Qos1, func(client mt.Client, msg mt.Message) {
+		got <- msg.Topic()
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	mc := testClient(t, url, "queue-pub", MqttQueue{Enable: true, Retry: 2})
+	mc.Limiter = &topicLimiter{deny: "queue/bad"}
+	for _, topic := range []string{"queue/bad", "queue/good"} { //未连接,缓存消息
+		if err := mc.Publish(topic, mqtt.Qos1, []byte(topic)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mc.Start(nil); err != nil { //连接后补发
+		t.Fatal(err)
+	}
+	defer mc.Stop()
+
+	select {
+	case topic := <-got:
+		if topic != "queue/good" {
+			t.Fatalf("expect queue/good, got %s", topic)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("poison message blocks the queue")
+	}
+
+	queued, dropped := mc.QueueStats()
+	for deadline := time.Now().Add(time.Second); queued > 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond) //发送成功后才出队
+		queued, dropped = mc.QueueStats()
+	}
+
+	if queued != 0 || dropped != 1 {
+		t.Fatalf("expect 0 queued and 1 dropped, got %d %d", queued, dropped)
+	}
+}
+
+func TestMqttRouter(t *testing.T) {
+	cases := []struct {
+		pattern string
+		topic   string
+		match   bool
+	}{
+		{"a/b/c", "a/b/c", true},
+		{"a/+/c", "a/b/c", true},
+		{"a/+/c", "a/b/d", false},
+		{"a/#", "a", true},
+		{"a/#", "a/b/c", true},
+		{"#", "$SYS/info", false},
+		{"+/b", "a/b/c", false},
+		{"$share/g1/a/+", "a/b", true},
+	}
+
+	for _, v := range cases {
+		if mqtt.MatchTopic(v.pattern, v.topic) != v.match {
+			t.Errorf("MatchTopic(%s, %s) expect %v", v.pattern, v.topic, v.match)
+		}
+	}
+}
+
+// routeMsg 测试用消息
+type routeMsg struct {
+	mt.Message
+	topic string
+}
+
+func (m *routeMsg) Topic() string { return m.topic }
+
+func TestMqttDispatch(t *testing.T) {
+	var hits []string
+	router := mqtt.NewRouter()
+	router.Handle("dev/+/cmd", func(client mt.Client, message mt.Message) {
+		panic("handler failed")
+	})
+	router.Handle("dev/#", func(client mt.Client, message mt.Message) {
+		hits = append(hits, "all")
+	})
+	router.Default = func(client mt.Client, message mt.Message) {
+		hits = append(hits, "default")
+	}
+
+	router.Dispatch(nil, &routeMsg{topic: "dev/1/cmd"})
+	router.Dispatch(nil, &routeMsg{topic: "other"})
+	if len(hits) != 2 || hits[0] != "all" || hits[1] != "default" {
+		t.Fatalf("unexpected dispatch: %v", hits)
+	}
+}
+
+func TestMqttOverlapDispatch(t *testing.T) {
+	got := make(chan string, 8)
+	mc := testClient(t, testBroker(t), "overlap", MqttQueue{})
+	if err := mc.Start(func(client mt.Client, msg mt.Message) {
+		got <- "default"
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Stop()
+
+	for _, filter := range []string{"dev/#", "dev/+/cmd"} {
+		name := filter
+		if err := mc.Subscribe(filter, mqtt.Qos1, func(client mt.Client, msg mt.Message) {
+			got <- name
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mc.Publish("dev/1/cmd", mqtt.Qos1, []byte("reset")); err != nil {
+		t.Fatal(err)
+	}
+
+	hits := map[string]int{}
+	timeout := time.After(3 * time.Second)
+	for len(hits) < 2 {
+		select {
+		case name := <-got:
+			hits[name]++
+		case <-timeout:
+			t.Fatalf("wait dispatch timeout: %v", hits)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond) //重复分发
+	for len(got) > 0 {
+		hits[<-got]++
+	}
+
+	if len(hits) != 2 || hits["dev/#"] != 1 || hits["dev/+/cmd"] != 1 {
+		t.Fatalf("expect each handler once, got %v", hits)
+	}
+}
+
+func TestMqttRegistry(t *testing.T) {
+	if mqtt.Get("") != mqtt.Client || mqtt.Get(mqtt.DefaultName) != mqtt.Client {
+		t.Fatal("expect default client")
+	}
+
+	cloud := mqtt.Register(mqtt.NewUtils("cloud"))
+	if mqtt.Get("cloud") != cloud || cloud == mqtt.Client {
+		t.Fatal("expect named client")
+	}
+
+	if mqtt.Get("none") != nil {
+		t.Fatal("expect nil for unknown name")
+	}
+}
+
+func TestMqttEnvelope(t *testing.T) {
+	type status struct {
+		Dev   string `json:"dev"`
+		Value int    `json:"value"`
+	}
+
+	mc := mqtt.NewUtils("envelope")
+	mc.Options.SetClientID("env-01")
+	mc.Envelope = mqtt.EnvelopeOption{
+		Schema:    "1.0",
+		Zip:       true,
+		Encrypter: NewEncrypter(EncryptAesCbc, []byte("0123456789abcdef")),
+	}
+
+	data, err := mc.Pack(mqtt.CodecJSON, &status{Dev: "01", Value: 27})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var st status
+	env, err := mc.Unpack(data, &st)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if st.Dev != "01" || st.Value != 27 || env.Source != "env-01" || env.Schema != "1.0" ||
+		!env.Zip || !env.Encrypt {
+		t.Fatalf("unexpected envelope: %+v, %+v", env, st)
+	}
+
+	if _, err = mc.Pack(mqtt.CodecProto, &st); err == nil {
+		t.Fatal("expect proto codec error")
+	}
+}
+
+// point 模拟 tinylib/msgp 生成的类型
+type point struct {
+	X, Y uint8
+}
+
+func (p *point) MarshalMsg(b []byte) ([]byte, error) {
+	return append(b, p.X, p.Y), nil
+}
+
+func (p *point) UnmarshalMsg(b []byte) ([]byte, error) {
+	if len(b) < 2 {
+		return b, errors.New("short point")
+	}
+
+	p.X, p.Y = b[0], b[1]
+	return b[2:], nil
+}
+
+func TestMqttSubscribeTyped(t *testing.T) {
+	type status struct {
+		Dev   string `json:"dev"`
+		Value int    `json:"value"`
+	}
+
+	url := testBroker(t)
+	key := NewEncrypter(EncryptAesCbc, []byte("0123456789abcdef"))
+	sub := testClient(t, url, "typed-sub", MqttQueue{})
+	sub.Envelope.Encrypter = key
+	if err := sub.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Stop()
+
+	pub := testClient(t, url, "typed-pub", MqttQueue{})
+	if err := pub.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer pub.Stop()
+
+	type typed struct {
+		env  *mqtt.Envelope
+		data any
+	}
+
+	got := make(chan typed, 10)
+	err := mqtt.SubscribeTyped(sub, "typed/status", mqtt.Qos1, func(env *mqtt.Envelope, data *status) {
+		got <- typed{env, data}
+	})
+	if err == nil {
+		err = mqtt.SubscribeTyped(sub, "typed/point", mqtt.Qos1, func(env *mqtt.Envelope, data *point) {
+			got <- typed{env, data}
+		})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait := func() typed {
+		t.Helper()
+		select {
+		case v := <-got:
+			return v
+		case <-time.After(3 * time.Second):
+			t.Fatal("wait message timeout")
+			return typed{}
+		}
+	}
+
+	for idx, opt := range []mqtt.EnvelopeOption{
+		{Schema: "1.0"},
+		{Zip: true},
+		{Encrypter: key},
+		{Zip: true, Encrypter: key},
+	} {
+		pub.Envelope = opt
+		if err = pub.PublishJSON("typed/status", mqtt.Qos1, &status{Dev: "01", Value: idx}); err != nil {
+			t.Fatal(err)
+		}
+
+		v := wait()
+		st, ok := v.data.(*status)
+		if !ok || st.Value != idx || v.env.Schema != opt.Schema || v.env.Zip != opt.Zip ||
+			v.env.Encrypt != (opt.Encrypter != nil) || v.env.Source != "typed-pub" {
+			t.Fatalf("%d: unexpected message: %+v, %+v", idx, v.env, v.data)
+		}
+	}
+
+	//错误的密钥、错误的编码: 丢弃
+	pub.Envelope = mqtt.EnvelopeOption{Zip: true, Encrypter: NewEncrypter(EncryptAesCbc, []byte("fedcba9876543210"))}
+	if err = pub.PublishJSON("typed/status", mqtt.Qos1, &status{Dev: "02"}); err != nil {
+		t.Fatal(err)
+	}
+
+	pub.Envelope = mqtt.EnvelopeOption{}
+	if err = pub.PublishMsgpack("typed/status", mqtt.Qos1, &point{X: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = pub.PublishMsgpack("typed/point", mqtt.Qos1, &point{X: 3, Y: 4}); err != nil {
+		t.Fatal(err)
+	}
+
+	v := wait()
+	if pt, ok := v.data.(*point); !ok || pt.X != 3 || pt.Y != 4 || v.env.Codec != mqtt.CodecMsgpack {
+		t.Fatalf("unexpected message: %+v, %+v", v.env, v.data)
+	}
+}
+
+func TestMqttRpc(t *testing.T) {
+	url := testBroker(t)
+	server := testClient(t, url, "rpc-server", MqttQueue{})
+	if err := server.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer server.Stop()
+
+	err := server.HandleRequests("rpc/cmd", func(req []byte) ([]byte, error) {
+		switch string(req) {
+		case "fail":
+			return nil, errors.New("boom")
+		case "slow":
+			time.Sleep(300 * time.Millisecond)
+		}
+		return append([]byte("ok:"), req...), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := testClient(t, url, "rpc-client", MqttQueue{})
+	if err = client.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	reply, err := client.Request(ctx, "rpc/cmd", []byte("ping"))
+	if err != nil || string(reply) != "ok:ping" {
+		t.Fatalf("expect ok:ping, got %s %v", reply, err)
+	}
+
+	if _, err = client.Request(ctx, "rpc/cmd", []byte("fail")); err == nil || err.Error() != "boom" {
+		t.Fatalf("expect handler error, got %v", err)
+	}
+
+	//无人应答
+	short, stop := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer stop()
+	if _, err = client.Request(short, "rpc/none", []byte("ping")); err != mqtt.ErrRpcTimeout {
+		t.Fatalf("expect ErrRpcTimeout, got %v", err)
+	}
+
+	//主动取消
+	canceled, abort := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, abort)
+	if _, err = client.Request(canceled, "rpc/cmd", []byte("slow")); err != context.Canceled {
+		t.Fatalf("expect context.Canceled, got %v", err)
+	}
+
+	//伪造的应答(顺序编号)被忽略
+	spoofer := testClient(t, url, "rpc-spoofer", MqttQueue{})
+	if err = spoofer.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer spoofer.Stop()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		for i := 0; i < 100; i++ {
+			_ = spoofer.Publish(mqtt.RpcReplyPrefix+"/rpc-client", mqtt.Qos1,
+				[]byte(fmt.Sprintf(`{"id":"%d","payload":"c3Bvb2Y="}`, i)))
+		}
+	}()
+
+	reply, err = client.Request(ctx, "rpc/cmd", []byte("slow"))
+	if err != nil || string(reply) != "ok:slow" {
+		t.Fatalf("expect ok:slow, got %s %v", reply, err)
+	}
 }
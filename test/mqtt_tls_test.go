@@ -0,0 +1,244 @@
+package test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+)
+
+// testCert 测试证书
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+// newTestCert 签发证书,parent 为空时自签名(ca)
+func newTestCert(t *testing.T, parent *testCert, dns []string, ips []net.IP) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "znlib-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     dns,
+		IPAddresses:  ips,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	signer, signKey := tpl, key
+	if parent == nil {
+		tpl.IsCA = true
+		tpl.BasicConstraintsValid = true
+	} else {
+		signer, signKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, signer, &key.PublicKey, signKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// fingerprint 证书 sha256 指纹
+func (tc *testCert) fingerprint() string {
+	sum := sha256.Sum256(tc.cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// tlsProxy 启动 tls 监听,转发至 broker,返回监听端口
+func tlsProxy(t *testing.T, broker string, cert *testCert, maxVersion uint16) string {
+	t.Helper()
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}},
+		MaxVersion:   maxVersion,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+
+	addr := strings.TrimPrefix(broker, "tcp://")
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+				upstream, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer upstream.Close()
+
+				go func() { _, _ = io.Copy(upstream, conn) }()
+				_, _ = io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	return port
+}
+
+// tlsClient 创建 tls 客户端
+func tlsClient(t *testing.T, url string, cfg MqttTLS) (*mqtt.Utils, error) {
+	cfg.Used = true
+	mc := mqtt.NewUtils("tls")
+	mc.HintInfo = false
+	err := mc.ApplyConfig(&MqttConfig{
+		Name:     "tls",
+		Enable:   true,
+		Broker:   []string{url},
+		ClientID: "tls-test",
+		Tls:      cfg,
+	})
+
+	if err == nil {
+		mc.Options.SetAutoReconnect(false)
+		mc.Options.SetConnectTimeout(3 * time.Second)
+	}
+	return mc, err
+}
+
+// tlsConnect 使用 tls 连接,返回连接结果
+func tlsConnect(t *testing.T, url string, cfg MqttTLS) error {
+	t.Helper()
+	mc, err := tlsClient(t, url, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer mc.Stop()
+	return mc.Start(nil)
+}
+
+// writeFile 写入测试文件
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	if err := os.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestMqttTLS(t *testing.T) {
+	broker := testBroker(t)
+	ca := newTestCert(t, nil, nil, nil)
+	dir := t.TempDir()
+	caFile := writeFile(t, dir+"/ca.pem", ca.pem)
+
+	//证书: localhost, 127.0.0.1
+	local := newTestCert(t, ca, []string{"localhost"}, []net.IP{net.ParseIP("127.0.0.1")})
+	port := tlsProxy(t, broker, local, 0)
+
+	//1.单向认证(只有 ca)
+	for _, host := range []string{"localhost", "127.0.0.1"} {
+		if err := tlsConnect(t, "ssl://"+host+":"+port, MqttTLS{CA: caFile}); err != nil {
+			t.Fatalf("%s: expect connected, got %v", host, err)
+		}
+	}
+
+	//2.同一 ca 签发的其它主机证书
+	other := newTestCert(t, ca, []string{"other.host"}, nil)
+	port = tlsProxy(t, broker, other, 0)
+	if err := tlsConnect(t, "ssl://127.0.0.1:"+port, MqttTLS{CA: caFile}); err == nil {
+		t.Fatal("expect name mismatch by ip")
+	}
+
+	if err := tlsConnect(t, "ssl://localhost:"+port, MqttTLS{CA: caFile}); err == nil {
+		t.Fatal("expect name mismatch by host")
+	}
+
+	cfg := MqttTLS{CA: caFile, ServerName: "other.host"}
+	if err := tlsConnect(t, "ssl://127.0.0.1:"+port, cfg); err != nil {
+		t.Fatalf("expect connected with serverName, got %v", err)
+	}
+
+	//3.指纹
+	cfg = MqttTLS{SkipVerify: true, Fingerprints: []string{other.fingerprint()}}
+	if err := tlsConnect(t, "ssl://127.0.0.1:"+port, cfg); err != nil {
+		t.Fatalf("expect pinned certificate accepted, got %v", err)
+	}
+
+	cfg.Fingerprints = []string{local.fingerprint()}
+	if err := tlsConnect(t, "ssl://127.0.0.1:"+port, cfg); err == nil {
+		t.Fatal("expect fingerprint mismatch")
+	}
+
+	//4.最低版本
+	port = tlsProxy(t, broker, local, tls.VersionTLS12)
+	if err := tlsConnect(t, "ssl://localhost:"+port, MqttTLS{CA: caFile}); err != nil {
+		t.Fatalf("expect tls 1.2 accepted, got %v", err)
+	}
+
+	if err := tlsConnect(t, "ssl://localhost:"+port, MqttTLS{CA: caFile, MinVersion: "1.3"}); err == nil {
+		t.Fatal("expect tls 1.2 rejected")
+	}
+
+	if _, err := tlsClient(t, "ssl://localhost:"+port, MqttTLS{MinVersion: "2.0"}); err == nil {
+		t.Fatal("expect invalid minVersion")
+	}
+}
+
+func TestMqttTLSReload(t *testing.T) {
+	broker := testBroker(t)
+	ca := newTestCert(t, nil, nil, nil)
+	wrong := newTestCert(t, nil, nil, nil)
+	port := tlsProxy(t, broker, newTestCert(t, ca, []string{"localhost"}, nil), 0)
+
+	caFile := writeFile(t, t.TempDir()+"/ca.pem", wrong.pem)
+	mc, err := tlsClient(t, "ssl://localhost:"+port, MqttTLS{CA: caFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mc.Start(nil); err == nil {
+		t.Fatal("expect unknown authority")
+	}
+	mc.Stop()
+
+	//替换 ca 文件,修改时间变化后重新加载
+	writeFile(t, caFile, ca.pem)
+	modified := time.Now().Add(time.Minute)
+	if err = os.Chtimes(caFile, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = mc.Start(nil); err != nil {
+		t.Fatalf("expect reloaded ca accepted, got %v", err)
+	}
+	mc.Stop()
+}
@@ -0,0 +1,320 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	"github.com/dmznlin/znlib-go/znlib/mqtt/broker"
+	"github.com/dmznlin/znlib-go/znlib/mqttssh"
+	mt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+func TestMqttSSH(t *testing.T) {
+	bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:0"})
+	if err := bk.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer bk.Stop()
+
+	mc := mqtt.NewUtils("ssh")
+	mc.HintInfo = false
+	if err := mc.ApplyConfig(&MqttConfig{
+		Enable:   true,
+		Broker:   []string{"tcp://" + bk.TcpAddr()},
+		ClientID: "ssh-test",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mc.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Stop()
+
+	opts := &mqttssh.Options{Prefix: "ssh/test", Shell: "/bin/sh", Audit: true, Secret: "ssh-secret",
+		User: mqttssh.BasicAuth(map[string]string{"admin": "pwd"})}
+	srv, err := mqttssh.NewServer(mc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	gw, err := mqttssh.NewGateway(mc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := httptest.NewServer(gw)
+	defer hs.Close()
+
+	ws := dialTerminal(t, hs.URL, "admin", "pwd")
+	defer ws.Close()
+
+	cmd := func(data string) {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd(string(mqttssh.CmdTag) + "conn")
+	cmd(string(mqttssh.CmdTag) + "resize80,24")
+	cmd("echo $((20+7))_done\n")
+
+	var out string
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out, "27_done") {
+		_ = ws.SetReadDeadline(deadline)
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("wait output: %v, got %q", err, out)
+		}
+		out += string(data)
+	}
+
+	cmd("exit\n")
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err = ws.ReadMessage(); err != nil {
+			break //会话关闭
+		}
+	}
+
+	if srv.Sessions() != 0 {
+		t.Fatalf("expect no session, got %d", srv.Sessions())
+	}
+}
+
+func TestMqttSSHForged(t *testing.T) {
+	mc := testClient(t, testBroker(t), "ssh-forged", MqttQueue{})
+	if err := mc.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Stop()
+
+	srv, err := mqttssh.NewServer(mc, &mqttssh.Options{Prefix: "ssh/forged", Shell: "/bin/sh", Secret: "ssh-secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = srv.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	state := make(chan *mqttssh.Control, 1)
+	err = mc.Subscribe("ssh/forged/+/state", mqtt.Qos1, func(client mt.Client, msg mt.Message) {
+		var ctl mqttssh.Control
+		if json.Unmarshal(msg.Payload(), &ctl) == nil {
+			state <- &ctl
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//未经网页端签名,冒充用户
+	data, _ := json.Marshal(&mqttssh.Control{Cmd: mqttssh.CmdOpen, User: "root"})
+	if err = mc.Publish("ssh/forged/1/ctl", mqtt.Qos1, data); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case ctl := <-state:
+		if ctl.Cmd != mqttssh.CmdClosed || !strings.Contains(ctl.Reason, "signature") {
+			t.Fatalf("unexpected state: %+v", ctl)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("wait state timeout")
+	}
+
+	if srv.Sessions() != 0 {
+		t.Fatalf("expect no session, got %d", srv.Sessions())
+	}
+}
+
+// dialTerminal 使用 basic auth 连接网页端
+func dialTerminal(t *testing.T, url, user, pwd string) *websocket.Conn {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	req.SetBasicAuth(user, pwd)
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), req.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+// readTerminal 读取网页端输出,直到包含 expect
+func readTerminal(t *testing.T, ws *websocket.Conn, expect string) string {
+	t.Helper()
+	var out string
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !strings.Contains(out, expect) {
+		_ = ws.SetReadDeadline(deadline)
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			t.Fatalf("wait %s: %v, got %q", expect, err, out)
+		}
+		out += string(data)
+	}
+	return out
+}
+
+func TestMqttSSHOptions(t *testing.T) {
+	mc := mqtt.NewUtils("ssh-options")
+	if _, err := mqttssh.NewServer(mc, &mqttssh.Options{Prefix: "ssh/options"}); err == nil {
+		t.Fatal("expect server without secret rejected")
+	}
+
+	if _, err := mqttssh.NewGateway(mc, &mqttssh.Options{Prefix: "ssh/options", Secret: "s"}); err == nil {
+		t.Fatal("expect gateway without user authenticator rejected")
+	}
+
+	gw, err := mqttssh.NewGateway(mc, &mqttssh.Options{Prefix: "ssh/options", Secret: "s",
+		User: mqttssh.BasicAuth(map[string]string{"admin": "pwd"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := httptest.NewServer(gw)
+	defer hs.Close()
+
+	for _, auth := range [][2]string{{"", ""}, {"admin", ""}, {"admin", "wrong"}, {"guest", "pwd"}} {
+		req, _ := http.NewRequest(http.MethodGet, hs.URL, nil)
+		if auth[0] != "" {
+			req.SetBasicAuth(auth[0], auth[1])
+		}
+
+		_, res, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(hs.URL, "http"), req.Header)
+		if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("%v: expect unauthorized, got %v", auth, err)
+		}
+	}
+}
+
+func TestMqttSSHReplay(t *testing.T) {
+	url := testBroker(t)
+	mc := testClient(t, url, "ssh-replay", MqttQueue{})
+	if err := mc.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer mc.Stop()
+
+	spy := testClient(t, url, "ssh-spy", MqttQueue{})
+	if err := spy.Start(nil); err != nil {
+		t.Fatal(err)
+	}
+	defer spy.Stop()
+
+	opts := &mqttssh.Options{Prefix: "ssh/replay", Shell: "/bin/sh", Secret: "ssh-secret",
+		User: mqttssh.BasicAuth(map[string]string{"admin": "pwd"})}
+	srv, err := mqttssh.NewServer(mc, opts)
+	if err == nil {
+		err = srv.Start()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Stop()
+
+	gw, err := mqttssh.NewGateway(mc, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs := httptest.NewServer(gw)
+	defer hs.Close()
+
+	//其它 broker 客户端截获网页端数据
+	var lock sync.Mutex
+	var frames []mt.Message
+	err = spy.Subscribe("ssh/replay/+/+", mqtt.Qos1, func(client mt.Client, msg mt.Message) {
+		if strings.HasSuffix(msg.Topic(), "/in") || strings.HasSuffix(msg.Topic(), "/ctl") {
+			lock.Lock()
+			frames = append(frames, msg)
+			lock.Unlock()
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ws := dialTerminal(t, hs.URL, "admin", "pwd")
+	defer ws.Close()
+
+	cmd := func(data string) {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd(string(mqttssh.CmdTag) + "conn")
+	cmd("echo ok_$((3+4))\n")
+	out := readTerminal(t, ws, "ok_7")
+
+	lock.Lock()
+	captured := frames
+	lock.Unlock()
+
+	var sid string
+	for _, msg := range captured {
+		sid = strings.Split(msg.Topic(), "/")[2]
+		data := msg.Payload()
+		if err = spy.Publish(msg.Topic(), mqtt.Qos1, data); err != nil { //重放
+			t.Fatal(err)
+		}
+
+		if strings.HasSuffix(msg.Topic(), "/in") { //篡改
+			forged := append([]byte{}, data...)
+			forged[len(forged)-4] = '5'
+			if err = spy.Publish(msg.Topic(), mqtt.Qos1, forged); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if sid == "" {
+		t.Fatal("no frame captured")
+	}
+
+	//未签名的输入和指令
+	forged := []struct{ kind, data string }{
+		{"in", "echo forged_$((1+1))\n"},
+		{"ctl", `{"cmd":"resize","cols":10,"rows":10}`},
+		{"ctl", `{"cmd":"close"}`},
+	}
+	for _, v := range forged {
+		if err = spy.Publish("ssh/replay/"+sid+"/"+v.kind, mqtt.Qos1, []byte(v.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd("echo end_$((4+5))\n")
+	out += readTerminal(t, ws, "end_9")
+
+	if n := strings.Count(out, "ok_7"); n != 1 {
+		t.Fatalf("expect command run once, got %d: %q", n, out)
+	}
+
+	if strings.Contains(out, "forged_2") || strings.Contains(out, "ok_8") {
+		t.Fatalf("forged input executed: %q", out)
+	}
+
+	if srv.Sessions() != 1 {
+		t.Fatalf("expect session alive, got %d", srv.Sessions())
+	}
+}
@@ -0,0 +1,76 @@
+package test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+)
+
+func TestTokenBucket(t *testing.T) {
+	limiter := NewTokenBucket(RateLimit{Rate: 5, Period: 100 * time.Millisecond})
+	for i := 0; i < 5; i++ {
+		if !limiter.Allow("a") {
+			t.Fatalf("expect allow at %d", i)
+		}
+	}
+
+	if limiter.Allow("a") {
+		t.Fatal("expect deny when bucket is empty")
+	}
+
+	if !limiter.Allow("b") {
+		t.Fatal("expect allow for another key")
+	}
+
+	delay, err := limiter.Reserve("a")
+	if err != nil || delay <= 0 {
+		t.Fatalf("expect positive delay, got %v %v", delay, err)
+	}
+
+	start := time.Now()
+	if err = limiter.Wait(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expect wait for token")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = limiter.Wait(ctx, "a"); err != ErrRateLimitCanceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	limiter.SetLimit("c", RateLimit{Rate: 0})
+	for i := 0; i < 100; i++ {
+		if !limiter.Allow("c") {
+			t.Fatal("expect unlimited key")
+		}
+	}
+}
+
+func TestTokenBucketSweep(t *testing.T) {
+	limiter := NewTokenBucket(RateLimit{Rate: 2, Period: 50 * time.Millisecond})
+	limiter.SetLimit("slow", RateLimit{Rate: 1, Period: time.Hour})
+	for _, key := range []string{"a", "b", "slow"} {
+		limiter.Allow(key)
+	}
+
+	if limiter.Len() != 3 {
+		t.Fatalf("expect 3 buckets, got %d", limiter.Len())
+	}
+
+	//a,b 已回满,slow 尚未回满
+	time.Sleep(100 * time.Millisecond)
+	limiter.Sweep()
+	if limiter.Len() != 1 {
+		t.Fatalf("expect 1 bucket, got %d", limiter.Len())
+	}
+
+	if limiter.Allow("slow") {
+		t.Fatal("expect slow bucket kept")
+	}
+}
@@ -0,0 +1,336 @@
+package test
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	. "github.com/dmznlin/znlib-go/znlib/redis"
+)
+
+// fakeRedis 模拟 redis 节点、哨兵和集群拓扑
+type fakeRedis struct {
+	ln     net.Listener
+	addr   string
+	tlsCfg *tls.Config
+
+	lock   sync.Mutex
+	conns  map[net.Conn]bool //连接: true,已订阅
+	master string            //哨兵: 主节点地址
+	slots  bool              //集群: 拓扑可用
+}
+
+// newFakeRedis 在addr启动模拟服务
+func newFakeRedis(t *testing.T, addr string, tlsCfg *tls.Config) *fakeRedis {
+	t.Helper()
+	fr := &fakeRedis{tlsCfg: tlsCfg, conns: make(map[net.Conn]bool), slots: true}
+	fr.listen(t, addr)
+	t.Cleanup(fr.stop)
+	return fr
+}
+
+// listen 开始监听
+func (fr *fakeRedis) listen(t *testing.T, addr string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fr.tlsCfg != nil {
+		ln = tls.NewListener(ln, fr.tlsCfg)
+	}
+
+	fr.lock.Lock()
+	fr.ln, fr.addr = ln, ln.Addr().String()
+	fr.lock.Unlock()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+
+			fr.lock.Lock()
+			fr.conns[conn] = false
+			fr.lock.Unlock()
+			go fr.serve(conn)
+		}
+	}()
+}
+
+// stop 停止监听并断开所有连接
+func (fr *fakeRedis) stop() {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	_ = fr.ln.Close()
+	for conn := range fr.conns {
+		_ = conn.Close()
+		delete(fr.conns, conn)
+	}
+}
+
+// set 修改模拟状态
+func (fr *fakeRedis) set(fn func(fr *fakeRedis)) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+	fn(fr)
+}
+
+// publish 向订阅的连接发布消息
+func (fr *fakeRedis) publish(channel, msg string) {
+	fr.lock.Lock()
+	defer fr.lock.Unlock()
+
+	for conn, subscribed := range fr.conns {
+		if subscribed {
+			_, _ = fmt.Fprintf(conn, "*3\r\n$7\r\nmessage\r\n%s%s", bulk(channel), bulk(msg))
+		}
+	}
+}
+
+// bulk resp 字符串
+func bulk(str string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(str), str)
+}
+
+// serve 处理连接上的命令
+func (fr *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(rd)
+		if err != nil {
+			return
+		}
+
+		fr.lock.Lock()
+		reply := fr.reply(conn, args)
+		fr.lock.Unlock()
+
+		if _, err = conn.Write([]byte(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand 读取 resp 数组格式的命令
+func readCommand(rd *bufio.Reader) ([]string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	num, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+
+	args := make([]string, 0, num)
+	for i := 0; i < num; i++ {
+		if _, err = rd.ReadString('\n'); err != nil { //$len
+			return nil, err
+		}
+
+		if line, err = rd.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		args = append(args, strings.TrimSuffix(line, "\r\n"))
+	}
+	return args, nil
+}
+
+// reply 生成命令应答
+func (fr *fakeRedis) reply(conn net.Conn, args []string) string {
+	cmd := strings.ToLower(strings.Join(args, " "))
+	switch {
+	case cmd == "ping" && fr.conns[conn]:
+		return "*2\r\n$4\r\npong\r\n$0\r\n\r\n"
+	case cmd == "ping":
+		return "+PONG\r\n"
+	case strings.HasPrefix(cmd, "sentinel get-master-addr-by-name"):
+		host, port, _ := net.SplitHostPort(fr.master)
+		return "*2\r\n" + bulk(host) + bulk(port)
+	case strings.HasPrefix(cmd, "sentinel sentinels"):
+		return "*0\r\n"
+	case strings.HasPrefix(cmd, "subscribe"):
+		fr.conns[conn] = true
+		var buf strings.Builder
+		for idx, ch := range args[1:] {
+			buf.WriteString("*3\r\n" + bulk("subscribe") + bulk(ch) + fmt.Sprintf(":%d\r\n", idx+1))
+		}
+		return buf.String()
+	case cmd == "cluster slots":
+		if !fr.slots {
+			return "-CLUSTERDOWN the cluster is down\r\n"
+		}
+
+		host, port, _ := net.SplitHostPort(fr.addr)
+		return "*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n" + bulk(host) + ":" + port + "\r\n"
+	default:
+		return "+OK\r\n"
+	}
+}
+
+// nodeEvent 节点事件
+type nodeEvent struct {
+	node  string
+	event Event
+}
+
+// watchNodes 记录节点事件
+func watchNodes(ru *Utils) chan nodeEvent {
+	ch := make(chan nodeEvent, 32)
+	ru.RegisterEventHandler(func(ru *Utils, node string, event Event) {
+		select {
+		case ch <- nodeEvent{node, event}:
+		default:
+		}
+	})
+	return ch
+}
+
+// expectEvent 等待node节点的event事件
+func expectEvent(t *testing.T, ch chan nodeEvent, node string, event Event) {
+	t.Helper()
+	timeout := time.After(3 * time.Second)
+
+	for {
+		select {
+		case ev := <-ch:
+			if ev.node == node && ev.event == event {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("wait event %d of %s timeout", event, node)
+		}
+	}
+}
+
+// expectNode 等待node节点的状态
+func expectNode(t *testing.T, ru *Utils, node string, online bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+
+	for time.Now().Before(deadline) {
+		if val, ok := ru.NodeStatus()[node]; ok && val == online {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("expect %s online=%v, got %v", node, online, ru.NodeStatus())
+}
+
+// nodeTimeout 健康检查参数
+var nodeTimeout = RedisTimeout{Dial: time.Second, Read: time.Second, Health: 100 * time.Millisecond}
+
+func TestRedisSentinel(t *testing.T) {
+	m1 := newFakeRedis(t, "127.0.0.1:0", nil)
+	m2 := newFakeRedis(t, "127.0.0.1:0", nil)
+	st := newFakeRedis(t, "127.0.0.1:0", nil)
+	st.set(func(fr *fakeRedis) { fr.master = m1.addr })
+
+	ru, err := NewUtils(&RedisConfig{
+		Enable:   true,
+		Timeout:  nodeTimeout,
+		Sentinel: RedisSentinel{Master: "mymaster", Servers: []string{st.addr}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ru.Close()
+
+	expectNode(t, ru, "sentinel:"+st.addr, true)
+	expectNode(t, ru, m1.addr, true)
+	events := watchNodes(ru)
+
+	//主节点切换
+	st.set(func(fr *fakeRedis) { fr.master = m2.addr })
+	h1, p1, _ := net.SplitHostPort(m1.addr)
+	h2, p2, _ := net.SplitHostPort(m2.addr)
+	st.publish("+switch-master", strings.Join([]string{"mymaster", h1, p1, h2, p2}, " "))
+
+	expectEvent(t, events, m2.addr, EventFailover)
+	expectNode(t, ru, m2.addr, true)
+	if _, ok := ru.NodeStatus()[m1.addr]; ok {
+		t.Fatalf("expect old master removed, got %v", ru.NodeStatus())
+	}
+
+	//哨兵断开后恢复
+	st.stop()
+	expectEvent(t, events, "sentinel:"+st.addr, EventDisconnect)
+
+	st.listen(t, st.addr)
+	expectEvent(t, events, "sentinel:"+st.addr, EventReConnect)
+}
+
+func TestRedisCluster(t *testing.T) {
+	node := newFakeRedis(t, "127.0.0.1:0", nil)
+	node.set(func(fr *fakeRedis) { fr.slots = false })
+
+	ru, err := NewUtils(&RedisConfig{
+		Enable:  true,
+		Cluster: true,
+		Servers: []string{node.addr},
+		Timeout: nodeTimeout,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ru.Close()
+
+	expectNode(t, ru, "cluster", false)
+	events := watchNodes(ru)
+
+	//集群拓扑恢复
+	node.set(func(fr *fakeRedis) { fr.slots = true })
+	expectEvent(t, events, "cluster", EventReConnect)
+	expectNode(t, ru, node.addr, true)
+}
+
+func TestRedisTLS(t *testing.T) {
+	ca := newTestCert(t, nil, nil, nil)
+	cert := newTestCert(t, ca, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	srv := newFakeRedis(t, "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{cert.cert.Raw}, PrivateKey: cert.key}},
+	})
+
+	dir := t.TempDir()
+	caFile := writeFile(t, dir+"/ca.pem", ca.pem)
+	wrong := writeFile(t, dir+"/wrong.pem", newTestCert(t, nil, nil, nil).pem)
+
+	for _, v := range []struct {
+		ca     string
+		online bool
+	}{{caFile, true}, {wrong, false}} {
+		ru, err := NewUtils(&RedisConfig{
+			Enable:  true,
+			Servers: []string{srv.addr},
+			Timeout: nodeTimeout,
+			Tls:     RedisTLS{Used: true, CA: v.ca},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expectNode(t, ru, srv.addr, v.online)
+		if str, err := ru.Ping(); (str == "PONG") != v.online {
+			t.Fatalf("ca %s: unexpected ping result %s %v", v.ca, str, err)
+		}
+		_ = ru.Close()
+	}
+
+	if _, err := NewUtils(&RedisConfig{Servers: []string{srv.addr}, Tls: RedisTLS{Used: true, CA: dir + "/none.pem"}}); err == nil {
+		t.Fatal("expect missing ca rejected")
+	}
+}
@@ -1,6 +1,8 @@
 package test
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
@@ -46,3 +48,54 @@ func TestLock(t *testing.T) {
 	rg.Wait()
 	lock.Unlock()
 }
+
+func TestSlidingWindow(t *testing.T) {
+	if _, err := Client.Ping(); err != nil {
+		t.Skipf("redis not available: %v", err)
+	}
+
+	key := fmt.Sprintf("test.%d", time.Now().UnixNano())
+	limiter := NewSlidingWindow(Client, RateLimit{Rate: 3, Period: 500 * time.Millisecond})
+	for i := 0; i < 3; i++ {
+		if !limiter.Allow(key) {
+			t.Fatalf("expect allow at %d", i)
+		}
+	}
+
+	if limiter.Allow(key) {
+		t.Fatal("expect deny when window is full")
+	}
+
+	delay, err := limiter.Reserve(key)
+	if err != nil || delay <= 0 || delay > 500*time.Millisecond {
+		t.Fatalf("expect delay within window, got %v %v", delay, err)
+	}
+
+	//canceled wait gives its reservation back
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = limiter.Wait(ctx, key); err != ErrRateLimitCanceled {
+		t.Fatalf("expect canceled, got %v", err)
+	}
+
+	count, err := Client.ZCard(Application.Ctx, "ratelimit.window:"+key).Result()
+	if err != nil || count != 4 {
+		t.Fatalf("expect 4 members in window, got %d %v", count, err)
+	}
+
+	start := time.Now()
+	if err = limiter.Wait(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+
+	if time.Since(start) < 20*time.Millisecond {
+		t.Fatal("expect wait for window")
+	}
+
+	limiter.SetLimit(key, RateLimit{Rate: 0})
+	for i := 0; i < 10; i++ {
+		if !limiter.Allow(key) {
+			t.Fatal("expect unlimited key")
+		}
+	}
+}
@@ -56,21 +56,41 @@ type (
 	}
 
 	RedisTimeout struct {
-		Dial  time.Duration `json:"dial"`  //连接建立超时
-		Read  time.Duration `json:"read"`  //读超时
-		Write time.Duration `json:"write"` //写超时
-		Pool  time.Duration `json:"pool"`  //繁忙状态时等待
+		Dial   time.Duration `json:"dial"`   //连接建立超时
+		Read   time.Duration `json:"read"`   //读超时
+		Write  time.Duration `json:"write"`  //写超时
+		Pool   time.Duration `json:"pool"`   //繁忙状态时等待
+		Health time.Duration `json:"health"` //节点健康检查间隔
+	}
+
+	// RedisSentinel redis哨兵配置
+	RedisSentinel struct {
+		Master   string   `json:"master"`   //主节点名称
+		Servers  []string `json:"servers"`  //哨兵列表
+		Password string   `json:"password"` //哨兵密码
+	}
+
+	// RedisTLS redis安全连接
+	RedisTLS struct {
+		Used       bool   `json:"use"`        //启用 tls
+		CA         string `json:"ca"`         //ca 证书
+		Key        string `json:"key"`        //客户端秘钥
+		Cert       string `json:"cert"`       //客户端证书
+		ServerName string `json:"serverName"` //校验服务器名称
+		SkipVerify bool   `json:"skipVerify"` //不校验服务器证书
 	}
 
 	// RedisConfig redis配置
 	RedisConfig = struct {
-		Enable    bool         `json:"enable"`    //启用
-		Cluster   bool         `json:"cluster"`   //是否集群
-		Servers   []string     `json:"servers"`   //服务器列表
-		Password  string       `json:"password"`  //服务密码
-		PoolSize  int          `json:"poolSize"`  //最大连接数
-		DefaultDB int          `json:"defaultDB"` //默认数据库索引
-		Timeout   RedisTimeout `json:"timeout"`   //超时配置
+		Enable    bool          `json:"enable"`    //启用
+		Cluster   bool          `json:"cluster"`   //是否集群
+		Servers   []string      `json:"servers"`   //服务器列表
+		Password  string        `json:"password"`  //服务密码
+		PoolSize  int           `json:"poolSize"`  //最大连接数
+		DefaultDB int           `json:"defaultDB"` //默认数据库索引
+		Timeout   RedisTimeout  `json:"timeout"`   //超时配置
+		Sentinel  RedisSentinel `json:"sentinel"`  //哨兵模式
+		Tls       RedisTLS      `json:"tls"`       //安全连接
 	}
 
 	// DbConn 数据库连接
@@ -104,23 +124,39 @@ type (
 	}
 
 	MqttTLS = struct {
-		Used bool   `json:"use"`  //启用 tls
-		CA   string `json:"ca"`   //ca 证书
-		Key  string `json:"key"`  //客户端秘钥
-		Cert string `json:"cert"` //客户端证书
+		Used         bool     `json:"use"`          //启用 tls
+		CA           string   `json:"ca"`           //ca 证书
+		Key          string   `json:"key"`          //客户端秘钥
+		Cert         string   `json:"cert"`         //客户端证书
+		ServerName   string   `json:"serverName"`   //校验服务器名称
+		SkipVerify   bool     `json:"skipVerify"`   //不校验服务器证书
+		MinVersion   string   `json:"minVersion"`   //最低版本: 1.0,1.1,1.2,1.3
+		Fingerprints []string `json:"fingerprints"` //服务器证书指纹(sha256)
+	}
+
+	// MqttQueue 离线消息队列
+	MqttQueue = struct {
+		Enable  bool   `json:"enable"`  //启用
+		MaxSize int    `json:"maxSize"` //最大缓存条数,0不限制
+		Policy  string `json:"policy"`  //队列满时: oldest,丢弃最早;newest,丢弃最新
+		File    string `json:"file"`    //持久化文件,空则只在内存中缓存
+		Retry   int    `json:"retry"`   //单条消息补发失败次数,超过后丢弃
 	}
 
 	// MqttConfig mqtt参数
 	MqttConfig struct {
-		Enable   bool         `json:"enable"` //启用
-		Broker   []string     `json:"broker"` //服务器(集群)
-		ClientID string       `json:"client"` //客户端标识
-		IDAuto   int          `json:"auto"`   //以ClientID为前缀,自动增加n位随机id
-		User     string       `json:"user"`   //用户名
-		Password string       `json:"pwd"`    //登录密码(des)
-		Tls      MqttTLS      `json:"tls"`    //接入认证
-		TopicSub []*MqttTopic `json:"sub"`    //命令传输通道
-		TopicPub []*MqttTopic `json:"pub"`    //数据传输通道
+		Name     string        `json:"name"`   //连接名称
+		Enable   bool          `json:"enable"` //启用
+		Broker   []string      `json:"broker"` //服务器(集群)
+		ClientID string        `json:"client"` //客户端标识
+		IDAuto   int           `json:"auto"`   //以ClientID为前缀,自动增加n位随机id
+		User     string        `json:"user"`   //用户名
+		Password string        `json:"pwd"`    //登录密码(des)
+		Tls      MqttTLS       `json:"tls"`    //接入认证
+		TopicSub []*MqttTopic  `json:"sub"`    //命令传输通道
+		TopicPub []*MqttTopic  `json:"pub"`    //数据传输通道
+		Queue    MqttQueue     `json:"queue"`  //离线消息队列
+		Conns    []*MqttConfig `json:"conns"`  //其它命名连接
 	}
 
 	// LibConfig 配置文件结构体
@@ -166,10 +202,24 @@ var (
 			PoolSize:  0,
 			DefaultDB: 0,
 			Timeout: RedisTimeout{
-				Dial:  0, //连接建立超时时间,默认5秒
-				Read:  0, //读超时,默认3秒,-1表示取消读超时
-				Write: 0, //写超时,默认等于读超时,-1表示取消写超时
-				Pool:  0, //当所有连接都处在繁忙状态时,客户端等待可用连接的最大等待时长,默认为读超时+1秒
+				Dial:   0,  //连接建立超时时间,默认5秒
+				Read:   0,  //读超时,默认3秒,-1表示取消读超时
+				Write:  0,  //写超时,默认等于读超时,-1表示取消写超时
+				Pool:   0,  //当所有连接都处在繁忙状态时,客户端等待可用连接的最大等待时长,默认为读超时+1秒
+				Health: 10, //节点健康检查间隔,0表示不检查
+			},
+			Sentinel: RedisSentinel{
+				Master:   "", //主节点名称,不为空时启用哨兵模式
+				Servers:  []string{},
+				Password: "",
+			},
+			Tls: RedisTLS{
+				Used:       false,
+				CA:         "$path/cert/ca.crt",
+				Key:        "",
+				Cert:       "",
+				ServerName: "",
+				SkipVerify: false,
 			},
 		},
 		DB: DbConfig{
@@ -191,6 +241,7 @@ var (
 			},
 		},
 		Mqtt: MqttConfig{
+			Name:     "default",
 			Enable:   false,
 			Broker:   []string{"tcp://broker.hivemq.com:1883"},
 			ClientID: "mt-",
@@ -198,10 +249,14 @@ var (
 			User:     "",
 			Password: "",
 			Tls: MqttTLS{
-				Used: false,
-				CA:   "$path/cert/ca.crt",
-				Key:  "$path/cert/mqtt.key",
-				Cert: "$path/cert/mqtt.crt",
+				Used:         false,
+				CA:           "$path/cert/ca.crt",
+				Key:          "$path/cert/mqtt.key",
+				Cert:         "$path/cert/mqtt.crt",
+				ServerName:   "",
+				SkipVerify:   false,
+				MinVersion:   "1.2",
+				Fingerprints: []string{},
 			},
 			TopicSub: []*MqttTopic{
 				{
@@ -219,6 +274,14 @@ var (
 					Retain: false,
 				},
 			},
+			Queue: MqttQueue{
+				Enable:  false,
+				MaxSize: 1000,
+				Policy:  "oldest",
+				File:    "",
+				Retry:   3,
+			},
+			Conns: []*MqttConfig{},
 		},
 	}
 )
@@ -0,0 +1,337 @@
+// Package znlib
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 14:05:22
+  描述: 基于 RingBuffer 的流式分帧器,用于 TCP 等流式协议的拆包/粘包处理
+
+备注:
+*.使用方法
+  decoder, err := NewFrameDecoder(FrameConfig{
+    Mode:         FrameLengthField,
+    LengthOffset: 2,   //长度字段前有2字节帧头
+    LengthWidth:  2,   //长度字段占2字节
+    LengthAdjust: 2,   //长度字段之后还有2字节校验
+  }, func(frame []byte) {...})
+  //收到数据时写入,完整的帧会交给 handler
+  _, err = decoder.Write(data)
+  //或直接对接连接
+  _, err = io.Copy(decoder, conn)
+*.分帧模式
+  1.FrameLengthField: 帧长 = LengthOffset + LengthWidth + 长度字段值 + LengthAdjust
+  2.FrameDelimiter: 以 Delimiter 结尾(如 0x0D0A),设置 StartFlag 时以其开头(如 STX/ETX)
+  3.FrameFixedLength: 每帧 FixedLength 字节
+*.帧超过 MaxFrame 时返回 ErrFrameTooLarge,并丢弃缓冲区数据以便重新同步
+******************************************************************************/
+package znlib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var (
+	// ErrFrameTooLarge 帧超过最大长度
+	ErrFrameTooLarge = errors.New("znlib.FrameDecoder: frame too large")
+	// ErrFrameLength 长度字段无效
+	ErrFrameLength = errors.New("znlib.FrameDecoder: invalid frame length")
+	// ErrFrameConfig 分帧参数无效
+	ErrFrameConfig = errors.New("znlib.FrameDecoder: invalid config")
+)
+
+// FrameMode 分帧模式
+type FrameMode byte
+
+const (
+	FrameLengthField FrameMode = iota //长度字段
+	FrameDelimiter                    //分隔符
+	FrameFixedLength                  //固定长度
+)
+
+// DefaultMaxFrame 默认最大帧长度
+const DefaultMaxFrame = 64 * 1024
+
+// FrameConfig 分帧参数
+type FrameConfig struct {
+	Mode           FrameMode        //分帧模式
+	MaxFrame       int              //最大帧长度,默认 DefaultMaxFrame
+	LengthOffset   int              //长度字段偏移
+	LengthWidth    int              //长度字段宽度: 1,2,4,8
+	LengthOrder    binary.ByteOrder //长度字段字节序,默认大端
+	LengthAdjust   int              //长度修正: 长度字段值之外的字节数
+	StartFlag      []byte           //帧起始标记(如 STX),之前的数据被丢弃
+	Delimiter      []byte           //帧结束标记(如 0x0D0A, ETX)
+	StripDelimiter bool             //分发时去掉起始和结束标记
+	FixedLength    int              //固定帧长度
+}
+
+// FrameHandler 帧处理函数,frame 为独立拷贝
+type FrameHandler func(frame []byte)
+
+// FrameDecoder 流式分帧器
+type FrameDecoder struct {
+	cfg     FrameConfig  //分帧参数
+	buf     *RingBuffer  //数据缓冲
+	handler FrameHandler //帧处理
+	scanned int          //已查找过分隔符的长度
+}
+
+// NewFrameDecoder 2026-10-19 14:12:47
+/*
+ 参数: cfg,分帧参数
+ 参数: handler,帧处理函数
+ 描述: 创建流式分帧器
+*/
+func NewFrameDecoder(cfg FrameConfig, handler FrameHandler) (*FrameDecoder, error) {
+	if handler == nil {
+		return nil, ErrFrameConfig
+	}
+
+	if cfg.MaxFrame <= 0 {
+		cfg.MaxFrame = DefaultMaxFrame
+	}
+
+	switch cfg.Mode {
+	case FrameLengthField:
+		switch cfg.LengthWidth {
+		case 1, 2, 4, 8:
+		default:
+			return nil, ErrFrameConfig
+		}
+
+		if cfg.LengthOffset < 0 {
+			return nil, ErrFrameConfig
+		}
+
+		if cfg.LengthOrder == nil {
+			cfg.LengthOrder = binary.BigEndian
+		}
+	case FrameDelimiter:
+		if len(cfg.Delimiter) < 1 {
+			return nil, ErrFrameConfig
+		}
+	case FrameFixedLength:
+		if cfg.FixedLength < 1 || cfg.FixedLength > cfg.MaxFrame {
+			return nil, ErrFrameConfig
+		}
+	default:
+		return nil, ErrFrameConfig
+	}
+
+	size := 1024
+	if size > cfg.MaxFrame {
+		size = cfg.MaxFrame
+	}
+
+	return &FrameDecoder{
+		cfg:     cfg,
+		buf:     NewRingBuffer(size, true),
+		handler: handler,
+	}, nil
+}
+
+// Write 2026-10-19 14:20:31
+/*
+ 参数: data,流数据
+ 描述: 写入数据并分帧,完整的帧交给 handler
+ 备注: 返回 ErrFrameTooLarge 时缓冲区已清空,后续数据可继续写入
+*/
+func (fd *FrameDecoder) Write(data []byte) (n int, err error) {
+	var frames [][]byte
+	fd.buf.Lock()
+	n, _ = fd.buf.Write(data)
+	frames, err = fd.decode()
+	fd.buf.Unlock()
+
+	for _, frame := range frames {
+		fd.handler(frame)
+	}
+	return
+}
+
+// Buffered 2026-10-19 14:23:10
+/*
+ 描述: 尚未组成完整帧的数据长度
+*/
+func (fd *FrameDecoder) Buffered() int {
+	fd.buf.RLock()
+	defer fd.buf.RUnlock()
+	return fd.buf.Length()
+}
+
+// Reset 2026-10-19 14:23:52
+/*
+ 描述: 丢弃未完成的数据
+*/
+func (fd *FrameDecoder) Reset() {
+	fd.buf.Lock()
+	defer fd.buf.Unlock()
+	fd.buf.Reset()
+	fd.scanned = 0
+}
+
+// decode 2026-10-19 14:26:18
+/*
+ 描述: 从缓冲区拆出所有完整的帧
+*/
+func (fd *FrameDecoder) decode() (frames [][]byte, err error) {
+	var frame []byte
+	for {
+		switch fd.cfg.Mode {
+		case FrameLengthField:
+			frame, err = fd.decodeLength()
+		case FrameDelimiter:
+			frame, err = fd.decodeDelimiter()
+		default:
+			frame, err = fd.decodeFixed()
+		}
+
+		if err != nil {
+			fd.buf.RetrieveAll()
+			fd.scanned = 0
+			return
+		}
+
+		if frame == nil {
+			return
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// peek 2026-10-19 14:30:05
+/*
+ 参数: size,长度
+ 描述: 拷贝缓冲区头部 size 字节,不移动读指针
+*/
+func (fd *FrameDecoder) peek(size int) []byte {
+	first, end := fd.buf.Peek(size)
+	return fd.buf.joinBytes(first, end)
+}
+
+// take 2026-10-19 14:31:40
+/*
+ 参数: size,帧长度
+ 参数: head,帧头部待丢弃长度
+ 参数: tail,帧尾部待丢弃长度
+ 描述: 取出长度为 size 的帧
+*/
+func (fd *FrameDecoder) take(size, head, tail int) []byte {
+	frame := fd.peek(size)
+	fd.buf.Retrieve(size)
+	fd.scanned = 0
+	return frame[head : size-tail]
+}
+
+// decodeFixed 2026-10-19 14:33:26
+/*
+ 描述: 固定长度分帧
+*/
+func (fd *FrameDecoder) decodeFixed() ([]byte, error) {
+	if fd.buf.Length() < fd.cfg.FixedLength {
+		return nil, nil
+	}
+	return fd.take(fd.cfg.FixedLength, 0, 0), nil
+}
+
+// decodeLength 2026-10-19 14:35:51
+/*
+ 描述: 长度字段分帧
+*/
+func (fd *FrameDecoder) decodeLength() ([]byte, error) {
+	header := fd.cfg.LengthOffset + fd.cfg.LengthWidth
+	if fd.buf.Length() < header {
+		return nil, nil
+	}
+
+	field := fd.peek(header)[fd.cfg.LengthOffset:]
+	var val uint64
+	switch fd.cfg.LengthWidth {
+	case 1:
+		val = uint64(field[0])
+	case 2:
+		val = uint64(fd.cfg.LengthOrder.Uint16(field))
+	case 4:
+		val = uint64(fd.cfg.LengthOrder.Uint32(field))
+	default:
+		val = fd.cfg.LengthOrder.Uint64(field)
+	}
+
+	if val > uint64(fd.cfg.MaxFrame) {
+		return nil, ErrFrameTooLarge
+	}
+
+	size := header + int(val) + fd.cfg.LengthAdjust
+	if size < header {
+		return nil, ErrFrameLength
+	}
+
+	if size > fd.cfg.MaxFrame {
+		return nil, ErrFrameTooLarge
+	}
+
+	if fd.buf.Length() < size {
+		return nil, nil
+	}
+	return fd.take(size, 0, 0), nil
+}
+
+// decodeDelimiter 2026-10-19 14:41:09
+/*
+ 描述: 分隔符分帧
+*/
+func (fd *FrameDecoder) decodeDelimiter() ([]byte, error) {
+	length := fd.buf.Length()
+	if length < 1 {
+		return nil, nil
+	}
+
+	data := fd.peek(length)
+	start := 0
+	if flag := fd.cfg.StartFlag; len(flag) > 0 {
+		idx := bytes.Index(data, flag)
+		if idx < 0 {
+			//保留可能是起始标记前缀的尾部数据
+			keep := len(flag) - 1
+			if keep > length {
+				keep = length
+			}
+			fd.buf.Retrieve(length - keep)
+			fd.scanned = 0
+			return nil, nil
+		}
+
+		if idx > 0 {
+			fd.buf.Retrieve(idx)
+			data = data[idx:]
+			length -= idx
+			fd.scanned = 0
+		}
+		start = len(flag)
+	}
+
+	//跳过已查找过的数据
+	from := fd.scanned - len(fd.cfg.Delimiter) + 1
+	if from < start {
+		from = start
+	}
+
+	idx := bytes.Index(data[from:], fd.cfg.Delimiter)
+	if idx < 0 {
+		if length > fd.cfg.MaxFrame {
+			return nil, ErrFrameTooLarge
+		}
+
+		fd.scanned = length
+		return nil, nil
+	}
+
+	size := from + idx + len(fd.cfg.Delimiter)
+	if size > fd.cfg.MaxFrame {
+		return nil, ErrFrameTooLarge
+	}
+
+	if fd.cfg.StripDelimiter {
+		return fd.take(size, start, len(fd.cfg.Delimiter)), nil
+	}
+	return fd.take(size, 0, 0), nil
+}
@@ -0,0 +1,529 @@
+package modbus
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+type ACLEffect uint
+const (
+	// effects of ACL rules
+	ACL_DENY                ACLEffect = 1
+	ACL_ALLOW               ACLEffect = 2
+
+	// special roles of ACL rules
+	ANY_ROLE                string = "*"  // all clients (same as "")
+	NO_ROLE                 string = "-"  // clients without role
+)
+
+// ACL configuration object.
+type ACLConfiguration struct {
+	// Rules are evaluated in order, for each requested address: the first
+	// matching rule decides whether access is allowed or denied
+	Rules         []ACLRule
+	// Default applies to addresses not matched by any rule (defaults to
+	// ACL_DENY)
+	Default       ACLEffect
+	// OnDenied is called whenever a request is denied (optional), e.g. to
+	// keep an audit trail. Denials are logged in any case.
+	OnDenied      func(denial *ACLDenial)
+	// Logger provides a custom sink for log messages.
+	// If nil, messages will be written to stdout.
+	Logger        *log.Logger
+}
+
+// ACL rule, allowing or denying access to a range of a table.
+// Empty fields (and zero values) match everything.
+type ACLRule struct {
+	// Effect tells whether the rule allows or denies access
+	Effect        ACLEffect
+	// Role is the client role (tcp+tls only), NO_ROLE for clients without
+	// role (e.g. plain tcp or serial clients)
+	Role          string
+	// Source restricts the rule to client IP addresses, as an IP address
+	// (e.g. 192.168.1.10) or a CIDR block (e.g. 10.0.0.0/8). Rules with a
+	// source never match clients on serial links.
+	Source        string
+	// UnitId is the unit id covered by the rule (0 for all units)
+	UnitId        uint8
+	// Table is the table covered by the rule (0 for all tables and function
+	// codes, see ACLHandler)
+	Table         Table
+	// Addr and Quantity set the address range covered by the rule
+	// (Quantity 0 for all addresses from Addr)
+	Addr          uint16
+	Quantity      uint32
+	// Access is the kind of access covered by the rule (0 for both reads
+	// and writes)
+	Access        Access
+}
+
+// Denied request, as passed to the OnDenied hook.
+type ACLDenial struct {
+	ClientAddr    string  // the client address
+	ClientRole    string  // the client role (tcp+tls only)
+	UnitId        uint8   // the requested unit id
+	Table         Table   // the requested table (0 for other function codes)
+	Addr          uint16  // the first address requested
+	Quantity      uint16  // the number of addresses requested
+	Access        Access  // ACCESS_READ or ACCESS_WRITE
+}
+
+// Request handler enforcing access rules on top of another handler, to be
+// passed to NewServer in its place.
+// Requests denied for all of the requested addresses are answered with an
+// illegal function exception, requests denied for part of them with an
+// illegal data address exception.
+// The fifo queue function code is checked as a holding register read at the
+// fifo pointer address. Other function codes (file records, diagnostics,
+// device identification) are only matched by rules without table, whatever
+// their address range: file record writes and diagnostics (except return
+// query data) require write access, the others read access.
+// Gateways are wrapped by checking requests before forwarding them: mask
+// writes and read/write multiple registers require both read and write
+// access, unknown function codes write access to rules without table, and
+// malformed requests are denied with an illegal data value exception.
+type ACLHandler struct {
+	conf          ACLConfiguration
+	logger        *logger
+	handler       RequestHandler
+	sources       []*net.IPNet  // parsed rule sources (nil for all clients)
+}
+
+// NewACLHandler returns a handler enforcing conf on top of handler.
+func NewACLHandler(conf *ACLConfiguration, handler RequestHandler) (ah *ACLHandler, err error) {
+	var ipNet	*net.IPNet
+
+	ah	= &ACLHandler{
+		conf:		*conf,
+		logger:		newLogger("modbus-acl", conf.Logger),
+		handler:	handler,
+	}
+
+	if ah.conf.Default == 0 {
+		ah.conf.Default	= ACL_DENY
+	}
+
+	if ah.conf.Default != ACL_ALLOW && ah.conf.Default != ACL_DENY {
+		ah.logger.Errorf("invalid default effect %v", ah.conf.Default)
+		err	= ErrConfigurationError
+		return
+	}
+
+	for i, rule := range ah.conf.Rules {
+		if rule.Effect != ACL_ALLOW && rule.Effect != ACL_DENY {
+			ah.logger.Errorf("rule #%v: invalid effect %v", i, rule.Effect)
+			err	= ErrConfigurationError
+			return
+		}
+
+		ipNet, err	= parseACLSource(rule.Source)
+		if err != nil {
+			ah.logger.Errorf("rule #%v: invalid source '%s'", i, rule.Source)
+			err	= ErrConfigurationError
+			return
+		}
+		ah.sources	= append(ah.sources, ipNet)
+	}
+
+	return
+}
+
+func (ah *ACLHandler) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       TABLE_COILS, req.Addr, req.Quantity, accessOf(req.IsWrite))
+	if err != nil {
+		return
+	}
+
+	res, err	= ah.handler.HandleCoils(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       TABLE_DISCRETE_INPUTS, req.Addr, req.Quantity, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= ah.handler.HandleDiscreteInputs(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       TABLE_HOLDING_REGISTERS, req.Addr, req.Quantity, accessOf(req.IsWrite))
+	if err != nil {
+		return
+	}
+
+	res, err	= ah.handler.HandleHoldingRegisters(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       TABLE_INPUT_REGISTERS, req.Addr, req.Quantity, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= ah.handler.HandleInputRegisters(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleFifoQueue(req *FifoQueueRequest) (res []uint16, err error) {
+	var handler	FifoQueueHandler
+	var ok		bool
+
+	handler, ok	= ah.handler.(FifoQueueHandler)
+	if !ok {
+		err	= ErrIllegalFunction
+		return
+	}
+
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       TABLE_HOLDING_REGISTERS, req.Addr, 1, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= handler.HandleFifoQueue(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error) {
+	var handler	FileRecordHandler
+	var ok		bool
+
+	handler, ok	= ah.handler.(FileRecordHandler)
+	if !ok {
+		err	= ErrIllegalFunction
+		return
+	}
+
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       0, 0, 1, accessOf(req.IsWrite))
+	if err != nil {
+		return
+	}
+
+	res, err	= handler.HandleFileRecords(req)
+
+	return
+}
+
+func (ah *ACLHandler) HandleDiagnostics(req *DiagnosticsRequest) (res []byte, err error) {
+	var handler	DiagnosticsHandler
+	var ok		bool
+
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       0, 0, 1, accessOf(req.SubFunction != DIAG_RETURN_QUERY_DATA))
+	if err != nil {
+		return
+	}
+
+	handler, ok	= ah.handler.(DiagnosticsHandler)
+	switch {
+	case ok:
+		res, err	= handler.HandleDiagnostics(req)
+	case req.SubFunction == DIAG_RETURN_QUERY_DATA:
+		// same as the server without diagnostics handler
+		res		= req.Data
+	default:
+		err		= ErrIllegalFunction
+	}
+
+	return
+}
+
+func (ah *ACLHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) (
+	res *DeviceIdentification, err error) {
+	var handler	DeviceIdentificationHandler
+	var ok		bool
+
+	handler, ok	= ah.handler.(DeviceIdentificationHandler)
+	if !ok {
+		err	= ErrIllegalFunction
+		return
+	}
+
+	err	= ah.authorize(req.ClientAddr, req.ClientRole, req.UnitId,
+			       0, 0, 1, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= handler.HandleDeviceIdentification(req)
+
+	return
+}
+
+// Returns true if the wrapped handler forwards requests (i.e. is a gateway).
+func (ah *ACLHandler) forwards() (ok bool) {
+	var fwd	requestForwarder
+
+	fwd, ok	= ah.handler.(requestForwarder)
+	ok	= ok && fwd.forwards()
+
+	return
+}
+
+// Checks a raw request before forwarding it to the wrapped gateway.
+func (ah *ACLHandler) forwardRequest(req *pdu, clientAddr string, clientRole string) (
+	res *pdu, err error) {
+	var p		[]byte = req.payload
+	var word	func(i int) uint16
+
+	word	= func(i int) uint16 {
+		return bytesToUint16(BIG_ENDIAN, p[i:i + 2])
+	}
+
+	err	= ErrIllegalDataValue
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs, fcReadHoldingRegisters,
+	     fcReadInputRegisters:
+		if len(p) >= 4 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       fcTable(req.functionCode), word(0), word(2),
+					       ACCESS_READ)
+		}
+
+	case fcWriteSingleCoil, fcWriteSingleRegister:
+		if len(p) >= 4 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       fcTable(req.functionCode), word(0), 1, ACCESS_WRITE)
+		}
+
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		if len(p) >= 4 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       fcTable(req.functionCode), word(0), word(2),
+					       ACCESS_WRITE)
+		}
+
+	case fcMaskWriteRegister:
+		if len(p) >= 2 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       TABLE_HOLDING_REGISTERS, word(0), 1, ACCESS_READ)
+		}
+		if err == nil {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       TABLE_HOLDING_REGISTERS, word(0), 1, ACCESS_WRITE)
+		}
+
+	case fcReadWriteMultipleRegisters:
+		if len(p) >= 8 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       TABLE_HOLDING_REGISTERS, word(0), word(2), ACCESS_READ)
+		}
+		if err == nil {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       TABLE_HOLDING_REGISTERS, word(4), word(6), ACCESS_WRITE)
+		}
+
+	case fcReadFifoQueue:
+		if len(p) >= 2 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId,
+					       TABLE_HOLDING_REGISTERS, word(0), 1, ACCESS_READ)
+		}
+
+	case fcReadFileRecord, fcEncapsulatedInterface:
+		err	= ah.authorize(clientAddr, clientRole, req.unitId, 0, 0, 1, ACCESS_READ)
+
+	case fcDiagnostics:
+		if len(p) >= 2 {
+			err	= ah.authorize(clientAddr, clientRole, req.unitId, 0, 0, 1,
+					       accessOf(word(0) != DIAG_RETURN_QUERY_DATA))
+		}
+
+	default:
+		err	= ah.authorize(clientAddr, clientRole, req.unitId, 0, 0, 1, ACCESS_WRITE)
+	}
+
+	if err != nil {
+		return
+	}
+
+	res, err	= ah.handler.(requestForwarder).forwardRequest(req, clientAddr, clientRole)
+
+	return
+}
+
+// Checks that the client is allowed access to a range of a table (table 0
+// for function codes without table), logging denials.
+func (ah *ACLHandler) authorize(clientAddr string, role string, unitId uint8,
+	table Table, addr uint16, quantity uint16, access Access) (err error) {
+	var ip		net.IP
+	var allowed	uint32
+
+	ip	= clientIP(clientAddr)
+
+	for a := uint32(addr); a < uint32(addr) + uint32(quantity); a++ {
+		if ah.effect(ip, role, unitId, table, a, access) == ACL_ALLOW {
+			allowed++
+		}
+	}
+
+	switch {
+	case allowed == uint32(quantity):
+		return
+	case allowed == 0:
+		err	= ErrIllegalFunction
+	default:
+		err	= ErrIllegalDataAddress
+	}
+
+	ah.logger.Warningf("denied %s of %s 0x%04x-0x%04x (unit id: %v) to '%s' (role: '%s')",
+			   accessName(access), tableName(table), addr,
+			   uint32(addr) + uint32(quantity) - 1, unitId, clientAddr, role)
+
+	if ah.conf.OnDenied != nil {
+		ah.conf.OnDenied(&ACLDenial{
+			ClientAddr:	clientAddr,
+			ClientRole:	role,
+			UnitId:		unitId,
+			Table:		table,
+			Addr:		addr,
+			Quantity:	quantity,
+			Access:		access,
+		})
+	}
+
+	return
+}
+
+// Returns the effect of the first rule matching an address, or the default
+// effect if none does.
+func (ah *ACLHandler) effect(ip net.IP, role string, unitId uint8, table Table,
+	addr uint32, access Access) (effect ACLEffect) {
+	for i, rule := range ah.conf.Rules {
+		if matchRole(rule.Role, role) &&
+		   (ah.sources[i] == nil || (ip != nil && ah.sources[i].Contains(ip))) &&
+		   (rule.UnitId == 0 || rule.UnitId == unitId) &&
+		   (rule.Access == 0 || rule.Access & access != 0) &&
+		   (rule.Table == 0 ||
+		    (rule.Table == table && addr >= uint32(rule.Addr) &&
+		     (rule.Quantity == 0 || addr < uint32(rule.Addr) + rule.Quantity))) {
+			effect	= rule.Effect
+			return
+		}
+	}
+
+	effect	= ah.conf.Default
+
+	return
+}
+
+// Returns true if a rule role matches the role of a client.
+func matchRole(ruleRole string, role string) (ok bool) {
+	switch ruleRole {
+	case "", ANY_ROLE:
+		ok	= true
+	case NO_ROLE:
+		ok	= role == ""
+	default:
+		ok	= ruleRole == role
+	}
+
+	return
+}
+
+// Parses a rule source, returning a nil network for empty sources.
+func parseACLSource(source string) (ipNet *net.IPNet, err error) {
+	var ip	net.IP
+
+	if source == "" {
+		return
+	}
+
+	if strings.Contains(source, "/") {
+		_, ipNet, err	= net.ParseCIDR(source)
+		return
+	}
+
+	ip	= net.ParseIP(source)
+	if ip == nil {
+		err	= ErrConfigurationError
+		return
+	}
+
+	if ip.To4() != nil {
+		ipNet	= &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}
+	} else {
+		ipNet	= &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+	}
+
+	return
+}
+
+// Returns the IP address of a client ("host:port"), or nil if the client
+// is not an IP client (e.g. on serial links).
+func clientIP(clientAddr string) (ip net.IP) {
+	var host	string
+	var err		error
+
+	host, _, err	= net.SplitHostPort(clientAddr)
+	if err != nil {
+		host	= clientAddr
+	}
+	ip	= net.ParseIP(host)
+
+	return
+}
+
+// Returns the access right required by a read or a write.
+func accessOf(isWrite bool) (access Access) {
+	access	= ACCESS_READ
+	if isWrite {
+		access	= ACCESS_WRITE
+	}
+
+	return
+}
+
+func accessName(access Access) (name string) {
+	name	= "read"
+	if access == ACCESS_WRITE {
+		name	= "write"
+	}
+
+	return
+}
+
+// Returns the table accessed by a read or write function code.
+func fcTable(functionCode uint8) (table Table) {
+	switch functionCode {
+	case fcReadCoils, fcWriteSingleCoil, fcWriteMultipleCoils:
+		table	= TABLE_COILS
+	case fcReadDiscreteInputs:
+		table	= TABLE_DISCRETE_INPUTS
+	case fcReadHoldingRegisters, fcWriteSingleRegister, fcWriteMultipleRegisters:
+		table	= TABLE_HOLDING_REGISTERS
+	case fcReadInputRegisters:
+		table	= TABLE_INPUT_REGISTERS
+	}
+
+	return
+}
+
+func tableName(table Table) (name string) {
+	switch table {
+	case TABLE_COILS:		name = "coils"
+	case TABLE_DISCRETE_INPUTS:	name = "discrete inputs"
+	case TABLE_HOLDING_REGISTERS:	name = "holding registers"
+	case TABLE_INPUT_REGISTERS:	name = "input registers"
+	case 0:				name = "function"
+	default:			name = fmt.Sprintf("table %v", uint(table))
+	}
+
+	return
+}
@@ -0,0 +1,332 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestACLHandler(t *testing.T) {
+	var ds		*DataStore
+	var ah		*ACLHandler
+	var denials	[]ACLDenial
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{UnitIds: []uint8{1, 2}})
+
+	ah, err	= NewACLHandler(&ACLConfiguration{
+		Rules:		[]ACLRule{
+			// operators may write setpoints 0x100-0x10f of unit #1
+			{Effect: ACL_ALLOW, Role: "operator", UnitId: 1, Access: ACCESS_WRITE,
+			 Table: TABLE_HOLDING_REGISTERS, Addr: 0x100, Quantity: 0x10},
+			// no access to unit #2 from outside the local network
+			{Effect: ACL_DENY, UnitId: 2},
+			// everyone else may read anything from the local network,
+			// whatever their role
+			{Effect: ACL_ALLOW, Source: "192.168.1.0/24", Access: ACCESS_READ},
+			{Effect: ACL_ALLOW, Role: ANY_ROLE, Source: "::1", Access: ACCESS_READ},
+			// and clients without role from the plant network
+			{Effect: ACL_ALLOW, Role: NO_ROLE, Source: "172.16.0.0/12",
+			 Access: ACCESS_READ},
+		},
+		OnDenied:	func(denial *ACLDenial) {
+			denials	= append(denials, *denial)
+		},
+	}, ds)
+	if err != nil {
+		t.Fatalf("NewACLHandler() should have succeeded, got: %v", err)
+	}
+
+	for _, tc := range []struct {
+		clientAddr	string
+		role		string
+		unitId		uint8
+		addr		uint16
+		quantity	uint16
+		isWrite		bool
+		expected	error
+	}{
+		{"10.0.0.1:1234", "operator", 1, 0x100, 4, true, nil},
+		{"10.0.0.1:1234", "operator", 1, 0x10e, 4, true, ErrIllegalDataAddress},
+		{"10.0.0.1:1234", "operator", 1, 0x200, 1, true, ErrIllegalFunction},
+		{"10.0.0.1:1234", "operator", 1, 0x100, 1, false, ErrIllegalFunction},
+		{"192.168.1.20:1234", "", 1, 0x100, 1, false, nil},
+		{"192.168.1.20:1234", "viewer", 1, 0x100, 1, false, nil},
+		{"172.16.0.20:1234", "", 1, 0x100, 1, false, nil},
+		{"172.16.0.20:1234", "viewer", 1, 0x100, 1, false, ErrIllegalFunction},
+		{"192.168.1.20:1234", "", 1, 0x100, 1, true, ErrIllegalFunction},
+		{"192.168.1.20:1234", "", 2, 0x000, 1, false, ErrIllegalFunction},
+		{"192.168.2.20:1234", "", 1, 0x100, 1, false, ErrIllegalFunction},
+		{"[::1]:1234", "", 1, 0x000, 8, false, nil},
+		{"/dev/ttyUSB0", "", 1, 0x000, 1, false, ErrIllegalFunction},
+	} {
+		_, err	= ah.HandleHoldingRegisters(&HoldingRegistersRequest{
+			ClientAddr:	tc.clientAddr,
+			ClientRole:	tc.role,
+			UnitId:		tc.unitId,
+			Addr:		tc.addr,
+			Quantity:	tc.quantity,
+			IsWrite:	tc.isWrite,
+			Args:		make([]uint16, tc.quantity),
+		})
+		if err != tc.expected {
+			t.Errorf("%+v: expected %v, got: %v", tc, tc.expected, err)
+		}
+	}
+
+	if len(denials) != 8 {
+		t.Fatalf("expected 8 denials, got: %+v", denials)
+	}
+
+	if denials[0].Table != TABLE_HOLDING_REGISTERS || denials[0].Addr != 0x10e ||
+	   denials[0].Quantity != 4 || denials[0].Access != ACCESS_WRITE ||
+	   denials[0].ClientRole != "operator" {
+		t.Errorf("unexpected denial: %+v", denials[0])
+	}
+
+	// rules without table cover the other function codes
+	_, err	= ah.HandleDiagnostics(&DiagnosticsRequest{
+		ClientAddr:	"192.168.1.20:1234",
+		UnitId:		1,
+		SubFunction:	DIAG_RETURN_QUERY_DATA,
+		Data:		[]byte{0x12, 0x34},
+	})
+	if err != nil {
+		t.Errorf("HandleDiagnostics() should have succeeded, got: %v", err)
+	}
+
+	_, err	= ah.HandleDiagnostics(&DiagnosticsRequest{
+		ClientAddr:	"192.168.1.20:1234",
+		UnitId:		1,
+		SubFunction:	DIAG_CLEAR_COUNTERS,
+	})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	return
+}
+
+func TestACLConfiguration(t *testing.T) {
+	var ds	*DataStore
+	var err	error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{})
+
+	for _, conf := range []*ACLConfiguration{
+		{Rules: []ACLRule{{Role: ANY_ROLE}}},
+		{Rules: []ACLRule{{Effect: ACL_ALLOW, Source: "10.0.0.0/33"}}},
+		{Rules: []ACLRule{{Effect: ACL_ALLOW, Source: "localhost"}}},
+		{Default: 3},
+	} {
+		_, err	= NewACLHandler(conf, ds)
+		if err != ErrConfigurationError {
+			t.Errorf("%+v: expected ErrConfigurationError, got: %v", conf, err)
+		}
+	}
+
+	return
+}
+
+func TestACLGateway(t *testing.T) {
+	var ds		*DataStore
+	var device	*ModbusServer
+	var downlink	*ModbusClient
+	var gw		*Gateway
+	var ah		*ACLHandler
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var regs	[]uint16
+	var value	uint16
+	var err		error
+
+	// downstream device
+	ds, _	= NewDataStore(&DataStoreConfiguration{})
+	device, err	= NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5532",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err	= device.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer device.Stop()
+
+	downlink, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5532",
+		Timeout:	time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= downlink.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer downlink.Close()
+
+	// remote clients may read anything but only write setpoints
+	gw, _	= NewGateway(&GatewayConfiguration{
+		Routes:	[]GatewayRoute{{UnitId: 1, Client: downlink}},
+	})
+	ah, err	= NewACLHandler(&ACLConfiguration{
+		Rules:		[]ACLRule{
+			{Effect: ACL_ALLOW, Table: TABLE_HOLDING_REGISTERS, Addr: 0x100,
+			 Quantity: 0x10, Access: ACCESS_WRITE},
+			{Effect: ACL_ALLOW, Access: ACCESS_READ},
+		},
+	}, gw)
+	if err != nil {
+		t.Fatalf("NewACLHandler() should have succeeded, got: %v", err)
+	}
+
+	server, err	= NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5533",
+	}, ah)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err	= server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5533",
+		Timeout:	time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	ds.SetRegister(1, HOLDING_REGISTER, 0x00, 0x1234)
+	regs, err	= client.ReadRegisters(0x00, 1, HOLDING_REGISTER)
+	if err != nil || len(regs) != 1 || regs[0] != 0x1234 {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v, %v", regs, err)
+	}
+
+	err	= client.WriteRegisters(0x100, []uint16{1, 2})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	err	= client.MaskWriteRegister(0x102, 0x0000, 0x00ff)
+	if err != nil {
+		t.Errorf("MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// denied writes never reach the device
+	err	= client.WriteRegister(0x00, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	err	= client.WriteRegisters(0x10e, []uint16{1, 2, 3})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	err	= client.WriteCoil(0x00, true)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	_, err	= client.ReadWriteMultipleRegisters(0x00, 1, 0x00, []uint16{1})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	value, _	= ds.Register(1, HOLDING_REGISTER, 0x00)
+	if value != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x", value)
+	}
+
+	value, _	= ds.Register(1, HOLDING_REGISTER, 0x102)
+	if value != 0x00ff {
+		t.Errorf("expected 0x00ff, got: 0x%04x", value)
+	}
+
+	return
+}
+
+func TestACLServer(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var ah		*ACLHandler
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{})
+	ah, _	= NewACLHandler(&ACLConfiguration{
+		Rules:		[]ACLRule{
+			{Effect: ACL_DENY, Role: ANY_ROLE, Table: TABLE_COILS, Access: ACCESS_WRITE},
+			{Effect: ACL_DENY, Role: ANY_ROLE, Table: TABLE_HOLDING_REGISTERS,
+			 Addr: 0x10, Quantity: 0x10},
+		},
+		Default:	ACL_ALLOW,
+	}, ds)
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5522",
+	}, ah)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5522",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	_, err	= client.ReadCoils(0, 8)
+	if err != nil {
+		t.Errorf("ReadCoils() should have succeeded, got: %v", err)
+	}
+
+	err	= client.WriteCoil(0, true)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	_, err	= client.ReadRegisters(0x08, 8, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+
+	_, err	= client.ReadRegisters(0x0c, 8, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	err	= client.WriteRegister(0x10, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	return
+}
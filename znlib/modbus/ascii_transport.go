@@ -0,0 +1,295 @@
+package modbus
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// ':' + 2 hex chars per byte (unit id, function code, up to 252 bytes
+	// of data and LRC) + CR/LF
+	maxASCIIFrameLength	int = 1 + 2 * 255 + 2
+
+	// default max. delay between two characters of a frame (see the
+	// "modbus over serial line v1.02" document, section 2.5.2.1)
+	asciiInterCharTimeout	time.Duration = 1 * time.Second
+)
+
+// Modbus ASCII transport (+dmzn).
+// Frames start with ':', carry each byte as 2 hex characters, are protected
+// by an LRC and end with CR/LF. Being self-delimited, frames do not depend
+// on inter-frame delays: garbage is skipped until the next ':', and a frame
+// is dropped if a character takes longer than interCharTimeout to come.
+// LRC mismatches are reported as ErrBadCRC.
+type asciiTransport struct {
+	logger           *logger
+	link             rtuLink
+	timeout          time.Duration
+	interCharTimeout time.Duration
+	monitor          *linkMonitor
+	recorder         *rtuRecorder
+}
+
+// Returns a new ASCII transport.
+func newASCIITransport(link rtuLink, addr string, timeout time.Duration, customLogger *log.Logger) (at *asciiTransport) {
+	at = &asciiTransport{
+		logger:			newLogger(fmt.Sprintf("ascii-transport(%s)", addr), customLogger),
+		link:			link,
+		timeout:		timeout,
+		interCharTimeout:	asciiInterCharTimeout,
+	}
+
+	return
+}
+
+// Attaches a traffic monitor to the transport.
+func (at *asciiTransport) setMonitor(lm *linkMonitor) {
+	at.monitor	= lm
+	if at.recorder == nil {
+		at.recorder	= &rtuRecorder{rtuLink: at.link}
+		at.link		= at.recorder
+	}
+
+	return
+}
+
+// Closes the ascii link.
+func (at *asciiTransport) Close() (err error) {
+	err = at.link.Close()
+
+	return
+}
+
+// Runs a request across the ascii link and returns a response.
+func (at *asciiTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	err	= at.writeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// read the response back from the wire
+	res, err	= at.readASCIIFrame()
+
+	return
+}
+
+// Reads a request from the ascii link.
+func (at *asciiTransport) ReadRequest() (req *pdu, err error) {
+	// wait for up to timeout for the start of a request
+	err	= at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	req, err	= at.readASCIIFrame()
+
+	return
+}
+
+// Sends a request across the ascii link without waiting for a response.
+func (at *asciiTransport) writeRequest(req *pdu) (err error) {
+	// set an i/o deadline on the link
+	err	= at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	err	= at.writeFrame(req)
+
+	return
+}
+
+// Writes a response to the ascii link.
+func (at *asciiTransport) WriteResponse(res *pdu) (err error) {
+	err	= at.writeFrame(res)
+
+	return
+}
+
+// Reads up to len bytes off the link, as-is.
+func (at *asciiTransport) ReadRawData(len uint8) (data []byte, err error) {
+	var n	int
+
+	err	= at.link.SetDeadline(time.Now().Add(at.timeout))
+	if err != nil {
+		return
+	}
+
+	data	= make([]byte, len)
+	n, err	= io.ReadFull(at.link, data)
+	data	= data[0:n]
+	if n == 0 {
+		data	= nil
+	}
+
+	return
+}
+
+// Writes data to the link, as-is.
+func (at *asciiTransport) WriteRawData(data []byte) (err error) {
+	_, err	= at.link.Write(data)
+
+	return
+}
+
+// Encodes and writes a frame to the link.
+func (at *asciiTransport) writeFrame(p *pdu) (err error) {
+	var adu	[]byte
+
+	adu	= at.assembleASCIIFrame(p)
+	_, err	= at.link.Write(adu)
+	if err != nil {
+		return
+	}
+	at.monitor.sent(adu)
+
+	return
+}
+
+// Waits for, reads and decodes a frame from the ascii link.
+func (at *asciiTransport) readASCIIFrame() (p *pdu, err error) {
+	var rxbuf	[]byte
+	var frame	[]byte
+	var raw		[]byte
+	var lrc		lrc
+
+	defer func() {
+		if at.recorder != nil {
+			at.monitor.received(at.recorder.take())
+		}
+	}()
+
+	rxbuf	= make([]byte, 1)
+
+	// skip anything until the start of a frame
+	for {
+		_, err	= io.ReadFull(at.link, rxbuf)
+		if err != nil {
+			return
+		}
+
+		if rxbuf[0] == ':' {
+			break
+		}
+	}
+
+	// read characters up to LF, each within the inter-character timeout
+	for {
+		err	= at.link.SetDeadline(time.Now().Add(at.interCharTimeout))
+		if err != nil {
+			return
+		}
+
+		_, err	= io.ReadFull(at.link, rxbuf)
+		if err != nil {
+			at.logger.Warningf("incomplete frame: %v", err)
+			err	= ErrShortFrame
+			return
+		}
+
+		switch {
+		case rxbuf[0] == ':':
+			// the previous frame was cut short: start over
+			at.logger.Warning("dropping incomplete frame")
+			frame	= frame[:0]
+			continue
+
+		case rxbuf[0] == '\n':
+		default:
+			if len(frame) >= maxASCIIFrameLength - 2 {
+				err	= ErrProtocolError
+				return
+			}
+			frame	= append(frame, rxbuf[0])
+			continue
+		}
+
+		break
+	}
+
+	// the frame must end with CR/LF and hold at least a unit id, a function
+	// code and an LRC
+	if len(frame) == 0 || frame[len(frame) - 1] != '\r' {
+		err	= ErrProtocolError
+		return
+	}
+	frame	= frame[0:len(frame) - 1]
+
+	raw, err	= hex.DecodeString(string(frame))
+	if err != nil {
+		err	= ErrProtocolError
+		return
+	}
+
+	if len(raw) < 3 {
+		err	= ErrShortFrame
+		return
+	}
+
+	lrc.init()
+	lrc.add(raw[0:len(raw) - 1])
+	if lrc.value() != raw[len(raw) - 1] {
+		err	= ErrBadCRC
+		return
+	}
+
+	p	= &pdu{
+		unitId:		raw[0],
+		functionCode:	raw[1],
+		payload:	raw[2:len(raw) - 1],
+	}
+
+	return
+}
+
+// Turns a PDU into an ASCII frame (':' + hex chars + LRC + CR/LF).
+func (at *asciiTransport) assembleASCIIFrame(p *pdu) (adu []byte) {
+	var raw	[]byte
+	var lrc	lrc
+
+	raw	= append(raw, p.unitId, p.functionCode)
+	raw	= append(raw, p.payload...)
+
+	lrc.init()
+	lrc.add(raw)
+	raw	= append(raw, lrc.value())
+
+	adu	= append(adu, ':')
+	adu	= append(adu, strings.ToUpper(hex.EncodeToString(raw))...)
+	adu	= append(adu, '\r', '\n')
+
+	return
+}
+
+// Longitudinal redundancy check, as used by modbus ASCII: the two's
+// complement of the sum of all bytes.
+type lrc struct {
+	sum	uint8
+}
+
+// Prepares the LRC generator for use.
+func (l *lrc) init() {
+	l.sum	= 0
+
+	return
+}
+
+// Adds the given bytes to the LRC.
+func (l *lrc) add(in []byte) {
+	for _, b := range in {
+		l.sum	+= b
+	}
+
+	return
+}
+
+// Returns the LRC value.
+func (l *lrc) value() (value uint8) {
+	value	= -l.sum
+
+	return
+}
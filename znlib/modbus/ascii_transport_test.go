@@ -0,0 +1,230 @@
+package modbus
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestAssembleASCIIFrame(t *testing.T) {
+	var at		*asciiTransport
+	var frame	[]byte
+
+	at		= &asciiTransport{}
+
+	// read holding register 0 of unit #1: 0x01 + 0x03 + 0x01 = 0x05,
+	// LRC = -0x05 = 0xfb
+	frame		= at.assembleASCIIFrame(&pdu{
+		unitId:		0x01,
+		functionCode:	0x03,
+		payload:	[]byte{0x00, 0x00, 0x00, 0x01},
+	})
+	if string(frame) != ":010300000001FB\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	frame		= at.assembleASCIIFrame(&pdu{
+		unitId:		0xf7,
+		functionCode:	0x06,
+		payload:	[]byte{0x12, 0x34, 0xab, 0xcd},
+	})
+	if string(frame) != ":F7061234ABCD45\r\n" {
+		t.Errorf("unexpected frame: %q", frame)
+	}
+
+	return
+}
+
+func TestASCIITransportReadASCIIFrame(t *testing.T) {
+	var at		*asciiTransport
+	var p1, p2	net.Conn
+	var txchan	chan []byte
+	var res		*pdu
+	var err		error
+
+	txchan		= make(chan []byte, 2)
+	p1, p2		= net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	at		= newASCIITransport(p2, "", 100 * time.Millisecond, nil)
+	at.interCharTimeout	= 20 * time.Millisecond
+
+	// garbage before the start of frame is skipped, lower case hex is accepted
+	txchan		<- []byte("\x00\xff\r\n:0103020a0be5\r\n")
+	res, err	= at.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got: %v", err)
+	}
+
+	if res.unitId != 0x01 || res.functionCode != 0x03 ||
+	   len(res.payload) != 3 || res.payload[0] != 0x02 ||
+	   res.payload[1] != 0x0a || res.payload[2] != 0x0b {
+		t.Errorf("unexpected pdu: %+v", res)
+	}
+
+	// a start of frame in the middle of a frame restarts it
+	txchan		<- []byte(":0103:010300000001FB\r\n")
+	res, err	= at.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got: %v", err)
+	}
+
+	if res.unitId != 0x01 || res.functionCode != 0x03 || len(res.payload) != 4 {
+		t.Errorf("unexpected pdu: %+v", res)
+	}
+
+	// LRC mismatch
+	txchan		<- []byte(":010300000001FC\r\n")
+	_, err		= at.ReadRequest()
+	if err != ErrBadCRC {
+		t.Errorf("expected ErrBadCRC, got: %v", err)
+	}
+
+	// missing CR
+	txchan		<- []byte(":010300000001FB\n")
+	_, err		= at.ReadRequest()
+	if err != ErrProtocolError {
+		t.Errorf("expected ErrProtocolError, got: %v", err)
+	}
+
+	// invalid hex characters
+	txchan		<- []byte(":0103000000G1FB\r\n")
+	_, err		= at.ReadRequest()
+	if err != ErrProtocolError {
+		t.Errorf("expected ErrProtocolError, got: %v", err)
+	}
+
+	// too short to hold a unit id, a function code and an LRC
+	txchan		<- []byte(":01FF\r\n")
+	_, err		= at.ReadRequest()
+	if err != ErrShortFrame {
+		t.Errorf("expected ErrShortFrame, got: %v", err)
+	}
+
+	// the link goes silent in the middle of a frame
+	txchan		<- []byte(":010300")
+	_, err		= at.ReadRequest()
+	if err != ErrShortFrame {
+		t.Errorf("expected ErrShortFrame, got: %v", err)
+	}
+
+	// nothing to read at all
+	_, err		= at.ReadRequest()
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("expected a timeout, got: %v", err)
+	}
+
+	p1.Close()
+	p2.Close()
+
+	return
+}
+
+func TestASCIIOverTCPClientServer(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var regs	[]uint16
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{HoldingRegisters: 16})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"asciiovertcp://localhost:5523",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"asciiovertcp://localhost:5523",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	err	= client.WriteRegisters(2, []uint16{0x1234, 0xabcd})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err	= client.ReadRegisters(1, 3, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+
+	if len(regs) != 3 || regs[0] != 0 || regs[1] != 0x1234 || regs[2] != 0xabcd {
+		t.Errorf("unexpected registers: %v", regs)
+	}
+
+	_, err	= client.ReadRegisters(15, 2, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	return
+}
+
+func TestASCIISerialDefaults(t *testing.T) {
+	var client	*ModbusClient
+	var server	*ModbusServer
+	var err		error
+
+	for _, tc := range []struct {
+		dataBits	uint
+		parity		uint
+		stopBits	uint
+		expected	[3]uint
+	}{
+		{0, PARITY_NONE, 0, [3]uint{7, PARITY_EVEN, 1}},
+		{0, PARITY_ODD, 0, [3]uint{7, PARITY_ODD, 1}},
+		{7, PARITY_NONE, 0, [3]uint{7, PARITY_NONE, 2}},
+		{8, PARITY_NONE, 1, [3]uint{8, PARITY_NONE, 1}},
+	} {
+		client, err	= NewClient(&ClientConfiguration{
+			URL:		"ascii:///dev/ttyUSB0",
+			DataBits:	tc.dataBits,
+			Parity:		tc.parity,
+			StopBits:	tc.stopBits,
+		})
+		if err != nil {
+			t.Fatalf("failed to create client: %v", err)
+		}
+
+		if [3]uint{client.conf.DataBits, client.conf.Parity, client.conf.StopBits} != tc.expected {
+			t.Errorf("%+v: unexpected client settings: %v/%v/%v", tc,
+				 client.conf.DataBits, client.conf.Parity, client.conf.StopBits)
+		}
+
+		server, err	= NewServer(&ServerConfiguration{
+			URL:		"ascii:///dev/ttyUSB0",
+			DataBits:	tc.dataBits,
+			Parity:		tc.parity,
+			StopBits:	tc.stopBits,
+		}, &tcpTestHandler{})
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		if [3]uint{server.conf.DataBits, server.conf.Parity, server.conf.StopBits} != tc.expected {
+			t.Errorf("%+v: unexpected server settings: %v/%v/%v", tc,
+				 server.conf.DataBits, server.conf.Parity, server.conf.StopBits)
+		}
+	}
+
+	return
+}
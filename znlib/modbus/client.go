@@ -1,15 +1,22 @@
 package modbus
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
+	"reflect"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+
+	"github.com/dmznlin/znlib-go/znlib/restruct"
 )
 
 type RegType	uint
@@ -30,8 +37,61 @@ const (
 	// word order of 32-bit registers
 	HIGH_WORD_FIRST     WordOrder = 1
 	LOW_WORD_FIRST      WordOrder = 2
+
+	// diagnostics sub-function codes (function code 08)
+	DIAG_RETURN_QUERY_DATA                uint16 = 0x00
+	DIAG_RESTART_COMMUNICATIONS           uint16 = 0x01
+	DIAG_RETURN_DIAGNOSTIC_REGISTER       uint16 = 0x02
+	DIAG_CHANGE_ASCII_DELIMITER           uint16 = 0x03
+	DIAG_FORCE_LISTEN_ONLY                uint16 = 0x04
+	DIAG_CLEAR_COUNTERS                   uint16 = 0x0a
+	DIAG_RETURN_BUS_MESSAGE_COUNT         uint16 = 0x0b
+	DIAG_RETURN_BUS_COMM_ERROR_COUNT      uint16 = 0x0c
+	DIAG_RETURN_BUS_EXCEPTION_ERROR_COUNT uint16 = 0x0d
+	DIAG_RETURN_SERVER_MESSAGE_COUNT      uint16 = 0x0e
+	DIAG_RETURN_SERVER_NO_RESPONSE_COUNT  uint16 = 0x0f
+	DIAG_RETURN_SERVER_NAK_COUNT          uint16 = 0x10
+	DIAG_RETURN_SERVER_BUSY_COUNT         uint16 = 0x11
+	DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT    uint16 = 0x12
+	DIAG_CLEAR_OVERRUN_COUNTER            uint16 = 0x14
+
+	// read device id codes (function code 43/14)
+	DEVID_BASIC         uint8 = 0x01
+	DEVID_REGULAR       uint8 = 0x02
+	DEVID_EXTENDED      uint8 = 0x03
+	DEVID_SPECIFIC      uint8 = 0x04
+
+	// basic and regular device identification object ids
+	DEVID_VENDOR_NAME           uint8 = 0x00
+	DEVID_PRODUCT_CODE          uint8 = 0x01
+	DEVID_MAJOR_MINOR_REVISION  uint8 = 0x02
+	DEVID_VENDOR_URL            uint8 = 0x03
+	DEVID_PRODUCT_NAME          uint8 = 0x04
+	DEVID_MODEL_NAME            uint8 = 0x05
+	DEVID_USER_APPLICATION_NAME uint8 = 0x06
 )
 
+// File record, as read (function code 20) or written (function code 21).
+type FileRecord struct {
+	// FileNumber is the file number (1 to 0xffff)
+	FileNumber   uint16
+	// RecordNumber is the number of the first record (0 to 0x270f)
+	RecordNumber uint16
+	// Length is the number of 16-bit registers to read (reads only)
+	Length       uint16
+	// Values holds the record contents, as returned by a read or as
+	// passed to a write
+	Values       []uint16
+}
+
+// Device identification, as returned by function code 43/14.
+type DeviceIdentification struct {
+	// ConformityLevel is the identification conformity level of the device
+	ConformityLevel uint8
+	// Objects maps object ids to object values
+	Objects         map[uint8]string
+}
+
 // Modbus client configuration object.
 type ClientConfiguration struct {
 	// URL sets the client mode and target location in the form
@@ -45,6 +105,9 @@ type ClientConfiguration struct {
 	Parity        uint
 	// StopBits sets the number of serial stop bits (rtu only)
 	StopBits      uint
+	// RS485 enables RS-485 direction control (RTS toggling) by the kernel
+	// driver (rtu and ascii only, linux only) (+dmzn)
+	RS485         RS485Config
 	// Timeout sets the request timeout value
 	Timeout       time.Duration
 	// TLSClientCert sets the client-side TLS key pair (tcp+tls only)
@@ -56,6 +119,58 @@ type ClientConfiguration struct {
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger        *log.Logger
+	// Limiter throttles outgoing requests (optional). Requests are keyed
+	// by "<host or device>#<unit id>" (+dmzn)
+	Limiter       RequestLimiter
+	// Retry sets the policy applied to failed requests (see RetryPolicy).
+	// Requests are not retried by default (+dmzn)
+	Retry         RetryPolicy
+	// AutoReconnect re-opens the link of tcp, tcp+tls, rtuovertcp and
+	// asciiovertcp clients on transport errors (e.g. dropped connection or
+	// timeout), either before the next retry or before the next request
+	// (+dmzn)
+	AutoReconnect bool
+	// MaxInFlight enables pipelining (tcp and tcp+tls only) when greater
+	// than 1: up to MaxInFlight requests are kept outstanding on the
+	// connection, responses being matched by transaction id, and the client
+	// can be shared by concurrent goroutines without serializing requests.
+	// The target device must support pipelined requests (+dmzn)
+	MaxInFlight   uint
+	// Context, when set, aborts all requests (including pending retries)
+	// once done, e.g. znlib.Application.Ctx to give up on shutdown.
+	// Cancelled requests close the link: serial and udp links are re-opened
+	// right away, tcp links stay closed until Open() unless AutoReconnect
+	// is set (+dmzn)
+	Context       context.Context
+	// Capture receives a copy of every frame sent or received (optional),
+	// e.g. a HexDumpCapture or PcapCapture (+dmzn)
+	Capture       FrameCapture
+	// TurnaroundDelay sets how long to wait after a broadcast request (unit
+	// id 0) before addressing the bus again, to give slaves time to process
+	// it (serial line transports only, defaults to 100ms) (+dmzn)
+	TurnaroundDelay time.Duration
+}
+
+// Retry policy of failed requests (+dmzn).
+type RetryPolicy struct {
+	// Count is the number of retries after the first attempt (0: no retry)
+	Count         uint
+	// Backoff is the delay before the first retry, doubled after each
+	// retry up to MaxBackoff (0: retry immediately)
+	Backoff       time.Duration
+	// MaxBackoff caps the retry delay (0: no cap)
+	MaxBackoff    time.Duration
+	// Retriable tells whether a request failing with err should be retried
+	// (defaults to IsRetriable)
+	Retriable     func(err error) bool
+}
+
+// RequestLimiter 2026-10-19 11:52:30 +dmzn
+/*
+ 描述: 请求限流接口,znlib.RateLimiter 均可直接使用
+*/
+type RequestLimiter interface {
+	Wait(ctx context.Context, key string) error
 }
 
 // Modbus client object.
@@ -68,6 +183,11 @@ type ModbusClient struct {
 	transport     transport
 	unitId        uint8
 	transportType transportType
+	// set by Open(), cleared by Close(): links are only re-opened
+	// automatically while the client is meant to be open (+dmzn)
+	active        bool
+	// traffic counters and frame capture, kept across re-opens (+dmzn)
+	monitor       *linkMonitor
 }
 
 // NewClient creates, configures and returns a modbus client object.
@@ -76,7 +196,8 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 	var splitURL   []string
 
 	mc = &ModbusClient{
-		conf: *conf,
+		conf:    *conf,
+		monitor: newLinkMonitor(conf.URL, conf.Capture),
 	}
 
 	splitURL = strings.SplitN(mc.conf.URL, "://", 2)
@@ -119,6 +240,44 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 
 		mc.transportType    = modbusRTU
 
+	case "ascii":
+		// +dmzn: the "modbus over serial line v1.02" document specifies
+		// 7 data bits, even parity and 1 stop bit as default for ascii
+		// mode (2 stop bits when no parity is used).
+		if mc.conf.Speed == 0 {
+			mc.conf.Speed	= 9600
+		}
+
+		// default to 7E1: parity is only defaulted along with the data
+		// bits, so that e.g. 7N2 can be configured
+		if mc.conf.DataBits == 0 {
+			mc.conf.DataBits = 7
+			if mc.conf.Parity == PARITY_NONE {
+				mc.conf.Parity = PARITY_EVEN
+			}
+		}
+
+		if mc.conf.StopBits == 0 {
+			if mc.conf.Parity == PARITY_NONE {
+				mc.conf.StopBits = 2
+			} else {
+				mc.conf.StopBits = 1
+			}
+		}
+
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		mc.transportType    = modbusASCII
+
+	case "asciiovertcp":
+		if mc.conf.Timeout == 0 {
+			mc.conf.Timeout = 1 * time.Second
+		}
+
+		mc.transportType    = modbusASCIIOverTCP
+
 	case "rtuovertcp":
 		if mc.conf.Speed == 0 {
 			mc.conf.Speed   = 19200
@@ -189,6 +348,17 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 		return
 	}
 
+	if mc.conf.MaxInFlight > 1 &&
+	   mc.transportType != modbusTCP && mc.transportType != modbusTCPOverTLS {
+		mc.logger.Errorf("pipelining is only supported by tcp and tcp+tls clients")
+		err	= ErrConfigurationError
+		return
+	}
+
+	if mc.conf.TurnaroundDelay == 0 && mc.isSerialLine() {
+		mc.conf.TurnaroundDelay = 100 * time.Millisecond
+	}
+
 	mc.unitId     = 1
 	mc.endianness = BIG_ENDIAN
 	mc.wordOrder  = HIGH_WORD_FIRST
@@ -198,9 +368,6 @@ func NewClient(conf *ClientConfiguration) (mc *ModbusClient, err error) {
 
 // Opens the underlying transport (network socket or serial line).
 func (mc *ModbusClient) Open() (err error) {
-	var spw		*serialPortWrapper
-	var sock	net.Conn
-
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
@@ -209,6 +376,19 @@ func (mc *ModbusClient) Open() (err error) {
 		return nil
 	}
 
+	err	= mc.open()
+	if err == nil {
+		mc.active	= true
+	}
+
+	return
+}
+
+// Opens the underlying transport. Must be called with the lock held.
+func (mc *ModbusClient) open() (err error) {
+	var spw		*serialPortWrapper
+	var sock	net.Conn
+
 	switch mc.transportType {
 	case modbusRTU:
 		// create a serial port wrapper object
@@ -218,6 +398,7 @@ func (mc *ModbusClient) Open() (err error) {
 			DataBits:	mc.conf.DataBits,
 			Parity:		mc.conf.Parity,
 			StopBits:	mc.conf.StopBits,
+			RS485:		mc.conf.RS485,
 		})
 
 		// open the serial device
@@ -247,6 +428,41 @@ func (mc *ModbusClient) Open() (err error) {
 		mc.transport = newRTUTransport(
 			sock, mc.conf.URL, mc.conf.Speed, mc.conf.Timeout, mc.conf.Logger)
 
+	case modbusASCII:
+		// create a serial port wrapper object
+		spw = newSerialPortWrapper(&serialPortConfig{
+			Device:		mc.conf.URL,
+			Speed:		mc.conf.Speed,
+			DataBits:	mc.conf.DataBits,
+			Parity:		mc.conf.Parity,
+			StopBits:	mc.conf.StopBits,
+			RS485:		mc.conf.RS485,
+		})
+
+		// open the serial device
+		err = spw.Open()
+		if err != nil {
+			return
+		}
+
+		// discard potentially stale serial data
+		discard(spw)
+
+		// create the ASCII transport
+		mc.transport = newASCIITransport(
+			spw, mc.conf.URL, mc.conf.Timeout, mc.conf.Logger)
+
+	case modbusASCIIOverTCP:
+		// connect to the remote host
+		sock, err = net.DialTimeout("tcp", mc.conf.URL, 5 * time.Second)
+		if err != nil {
+			return
+		}
+
+		// create the ASCII transport
+		mc.transport = newASCIITransport(
+			sock, mc.conf.URL, mc.conf.Timeout, mc.conf.Logger)
+
 	case modbusRTUOverUDP:
 		// open a socket to the remote host (note: no actual connection is
 		// being made as UDP is connection-less)
@@ -270,7 +486,7 @@ func (mc *ModbusClient) Open() (err error) {
 		}
 
 		// create the TCP transport
-		mc.transport = newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+		mc.transport = mc.newTCPTransport(sock)
 
 	case modbusTCPOverTLS:
 		// connect to the remote host with TLS
@@ -300,8 +516,7 @@ func (mc *ModbusClient) Open() (err error) {
 		// create the TCP transport, wrapping the TLS socket in
 		// an adapter to work around write timeouts corrupting internal
 		// state (see https://pkg.go.dev/crypto/tls#Conn.SetWriteDeadline)
-		mc.transport = newTCPTransport(
-			newTLSSockWrapper(sock), mc.conf.Timeout, mc.conf.Logger)
+		mc.transport = mc.newTCPTransport(newTLSSockWrapper(sock))
 
 	case modbusTCPOverUDP:
 		// open a socket to the remote host (note: no actual connection is
@@ -322,6 +537,19 @@ func (mc *ModbusClient) Open() (err error) {
 		err = ErrConfigurationError
 	}
 
+	// +dmzn:count and capture traffic
+	if err == nil {
+		monitorTransport(mc.transport, mc.monitor)
+	}
+
+	return
+}
+
+// Returns the traffic counters of the client, accumulated since its
+// creation (+dmzn).
+func (mc *ModbusClient) Stats() (stats Stats) {
+	stats	= mc.monitor.snapshot()
+
 	return
 }
 
@@ -330,6 +558,7 @@ func (mc *ModbusClient) Close() (err error) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
+	mc.active	= false
 	if mc.transport != nil {
 		err = mc.transport.Close()
 
@@ -343,6 +572,10 @@ func (mc *ModbusClient) Close() (err error) {
 }
 
 // Sets the unit id of subsequent requests.
+// On serial line transports (rtu, ascii and their tcp/udp variants), unit id
+// 0 is the broadcast address: write requests are then sent without waiting
+// for a response, followed by the turnaround delay, while read requests are
+// rejected with ErrUnexpectedParameters (+dmzn).
 func (mc *ModbusClient) SetUnitId(id uint8) (err error) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
@@ -352,6 +585,28 @@ func (mc *ModbusClient) SetUnitId(id uint8) (err error) {
 	return
 }
 
+// Sets the request timeout of the link (and of links re-opened later on),
+// returning the previous value. Pipelined links keep their timeout until
+// re-opened (+dmzn).
+func (mc *ModbusClient) setTimeout(timeout time.Duration) (previous time.Duration) {
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	previous	= mc.conf.Timeout
+	mc.conf.Timeout	= timeout
+
+	switch tt := mc.transport.(type) {
+	case *tcpTransport:
+		tt.timeout	= timeout
+	case *rtuTransport:
+		tt.timeout	= timeout
+	case *asciiTransport:
+		tt.timeout	= timeout
+	}
+
+	return
+}
+
 // Sets the encoding (endianness and word ordering) of subsequent requests.
 func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder) (err error) {
 	mc.lock.Lock()
@@ -377,16 +632,30 @@ func (mc *ModbusClient) SetEncoding(endianness Endianness, wordOrder WordOrder)
 
 // Reads multiple coils (function code 01).
 func (mc *ModbusClient) ReadCoils(addr uint16, quantity uint16) (values []bool, err error) {
-	values, err	= mc.readBools(addr, quantity, false)
+	values, err	= mc.ReadCoilsCtx(context.Background(), addr, quantity)
+
+	return
+}
+
+// Same as ReadCoils, giving up when ctx is done.
+func (mc *ModbusClient) ReadCoilsCtx(ctx context.Context, addr uint16, quantity uint16) (values []bool, err error) {
+	values, err	= mc.readBools(ctx, addr, quantity, false)
 
 	return
 }
 
 // Reads a single coil (function code 01).
 func (mc *ModbusClient) ReadCoil(addr uint16) (value bool, err error) {
+	value, err	= mc.ReadCoilCtx(context.Background(), addr)
+
+	return
+}
+
+// Same as ReadCoil, giving up when ctx is done.
+func (mc *ModbusClient) ReadCoilCtx(ctx context.Context, addr uint16) (value bool, err error) {
 	var values	[]bool
 
-	values, err	= mc.readBools(addr, 1, false)
+	values, err	= mc.readBools(ctx, addr, 1, false)
 	if err == nil {
 		value = values[0]
 	}
@@ -396,16 +665,30 @@ func (mc *ModbusClient) ReadCoil(addr uint16) (value bool, err error) {
 
 // Reads multiple discrete inputs (function code 02).
 func (mc *ModbusClient) ReadDiscreteInputs(addr uint16, quantity uint16) (values []bool, err error) {
-	values, err	= mc.readBools(addr, quantity, true)
+	values, err	= mc.ReadDiscreteInputsCtx(context.Background(), addr, quantity)
+
+	return
+}
+
+// Same as ReadDiscreteInputs, giving up when ctx is done.
+func (mc *ModbusClient) ReadDiscreteInputsCtx(ctx context.Context, addr uint16, quantity uint16) (values []bool, err error) {
+	values, err	= mc.readBools(ctx, addr, quantity, true)
 
 	return
 }
 
 // Reads a single discrete input (function code 02).
 func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (value bool, err error) {
+	value, err	= mc.ReadDiscreteInputCtx(context.Background(), addr)
+
+	return
+}
+
+// Same as ReadDiscreteInput, giving up when ctx is done.
+func (mc *ModbusClient) ReadDiscreteInputCtx(ctx context.Context, addr uint16) (value bool, err error) {
 	var values	[]bool
 
-	values, err	= mc.readBools(addr, 1, true)
+	values, err	= mc.readBools(ctx, addr, 1, true)
 	if err == nil {
 		value = values[0]
 	}
@@ -415,10 +698,17 @@ func (mc *ModbusClient) ReadDiscreteInput(addr uint16) (value bool, err error) {
 
 // Reads multiple 16-bit registers (function code 03 or 04).
 func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegType) (values []uint16, err error) {
+	values, err	= mc.ReadRegistersCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadRegisters, giving up when ctx is done.
+func (mc *ModbusClient) ReadRegistersCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []uint16, err error) {
 	var mbPayload	[]byte
 
 	// read quantity uint16 registers, as bytes
-	mbPayload, err	= mc.readRegisters(addr, quantity, regType)
+	mbPayload, err	= mc.readRegisters(ctx, addr, quantity, regType)
 	if err != nil {
 		return
 	}
@@ -431,10 +721,17 @@ func (mc *ModbusClient) ReadRegisters(addr uint16, quantity uint16, regType RegT
 
 // Reads a single 16-bit register (function code 03 or 04).
 func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (value uint16, err error) {
+	value, err	= mc.ReadRegisterCtx(context.Background(), addr, regType)
+
+	return
+}
+
+// Same as ReadRegister, giving up when ctx is done.
+func (mc *ModbusClient) ReadRegisterCtx(ctx context.Context, addr uint16, regType RegType) (value uint16, err error) {
 	var values	[]uint16
 
 	// read 1 uint16 register, as bytes
-	values, err	= mc.ReadRegisters(addr, 1, regType)
+	values, err	= mc.ReadRegistersCtx(ctx, addr, 1, regType)
 	if err == nil {
 		value = values[0]
 	}
@@ -444,10 +741,17 @@ func (mc *ModbusClient) ReadRegister(addr uint16, regType RegType) (value uint16
 
 // Reads multiple 32-bit registers.
 func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegType) (values []uint32, err error) {
+	values, err	= mc.ReadUint32sCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadUint32s, giving up when ctx is done.
+func (mc *ModbusClient) ReadUint32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []uint32, err error) {
 	var mbPayload	[]byte
 
 	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err	= mc.readRegisters(addr, quantity * 2, regType)
+	mbPayload, err	= mc.readRegisters(ctx, addr, quantity * 2, regType)
 	if err != nil {
 		return
 	}
@@ -460,9 +764,16 @@ func (mc *ModbusClient) ReadUint32s(addr uint16, quantity uint16, regType RegTyp
 
 // Reads a single 32-bit register.
 func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (value uint32, err error) {
+	value, err	= mc.ReadUint32Ctx(context.Background(), addr, regType)
+
+	return
+}
+
+// Same as ReadUint32, giving up when ctx is done.
+func (mc *ModbusClient) ReadUint32Ctx(ctx context.Context, addr uint16, regType RegType) (value uint32, err error) {
 	var values	[]uint32
 
-	values, err	= mc.ReadUint32s(addr, 1, regType)
+	values, err	= mc.ReadUint32sCtx(ctx, addr, 1, regType)
 	if err == nil {
 		value	= values[0]
 	}
@@ -472,10 +783,17 @@ func (mc *ModbusClient) ReadUint32(addr uint16, regType RegType) (value uint32,
 
 // Reads multiple 32-bit float registers.
 func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegType) (values []float32, err error) {
+	values, err	= mc.ReadFloat32sCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadFloat32s, giving up when ctx is done.
+func (mc *ModbusClient) ReadFloat32sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []float32, err error) {
 	var mbPayload	[]byte
 
 	// read 2 * quantity uint16 registers, as bytes
-	mbPayload, err	= mc.readRegisters(addr, quantity * 2, regType)
+	mbPayload, err	= mc.readRegisters(ctx, addr, quantity * 2, regType)
 	if err != nil {
 		return
 	}
@@ -488,9 +806,16 @@ func (mc *ModbusClient) ReadFloat32s(addr uint16, quantity uint16, regType RegTy
 
 // Reads a single 32-bit float register.
 func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (value float32, err error) {
+	value, err	= mc.ReadFloat32Ctx(context.Background(), addr, regType)
+
+	return
+}
+
+// Same as ReadFloat32, giving up when ctx is done.
+func (mc *ModbusClient) ReadFloat32Ctx(ctx context.Context, addr uint16, regType RegType) (value float32, err error) {
 	var values	[]float32
 
-	values, err	= mc.ReadFloat32s(addr, 1, regType)
+	values, err	= mc.ReadFloat32sCtx(ctx, addr, 1, regType)
 	if err == nil {
 		value	= values[0]
 	}
@@ -500,10 +825,17 @@ func (mc *ModbusClient) ReadFloat32(addr uint16, regType RegType) (value float32
 
 // Reads multiple 64-bit registers.
 func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegType) (values []uint64, err error) {
+	values, err	= mc.ReadUint64sCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadUint64s, giving up when ctx is done.
+func (mc *ModbusClient) ReadUint64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []uint64, err error) {
 	var mbPayload	[]byte
 
 	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err	= mc.readRegisters(addr, quantity * 4, regType)
+	mbPayload, err	= mc.readRegisters(ctx, addr, quantity * 4, regType)
 	if err != nil {
 		return
 	}
@@ -516,9 +848,16 @@ func (mc *ModbusClient) ReadUint64s(addr uint16, quantity uint16, regType RegTyp
 
 // Reads a single 64-bit register.
 func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (value uint64, err error) {
+	value, err	= mc.ReadUint64Ctx(context.Background(), addr, regType)
+
+	return
+}
+
+// Same as ReadUint64, giving up when ctx is done.
+func (mc *ModbusClient) ReadUint64Ctx(ctx context.Context, addr uint16, regType RegType) (value uint64, err error) {
 	var values	[]uint64
 
-	values, err	= mc.ReadUint64s(addr, 1, regType)
+	values, err	= mc.ReadUint64sCtx(ctx, addr, 1, regType)
 	if err == nil {
 		value	= values[0]
 	}
@@ -528,10 +867,17 @@ func (mc *ModbusClient) ReadUint64(addr uint16, regType RegType) (value uint64,
 
 // Reads multiple 64-bit float registers.
 func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegType) (values []float64, err error) {
+	values, err	= mc.ReadFloat64sCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadFloat64s, giving up when ctx is done.
+func (mc *ModbusClient) ReadFloat64sCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []float64, err error) {
 	var mbPayload	[]byte
 
 	// read 4 * quantity uint16 registers, as bytes
-	mbPayload, err	= mc.readRegisters(addr, quantity * 4, regType)
+	mbPayload, err	= mc.readRegisters(ctx, addr, quantity * 4, regType)
 	if err != nil {
 		return
 	}
@@ -544,9 +890,16 @@ func (mc *ModbusClient) ReadFloat64s(addr uint16, quantity uint16, regType RegTy
 
 // Reads a single 64-bit float register.
 func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (value float64, err error) {
+	value, err	= mc.ReadFloat64Ctx(context.Background(), addr, regType)
+
+	return
+}
+
+// Same as ReadFloat64, giving up when ctx is done.
+func (mc *ModbusClient) ReadFloat64Ctx(ctx context.Context, addr uint16, regType RegType) (value float64, err error) {
 	var values	[]float64
 
-	values, err	= mc.ReadFloat64s(addr, 1, regType)
+	values, err	= mc.ReadFloat64sCtx(ctx, addr, 1, regType)
 	if err == nil {
 		value	= values[0]
 	}
@@ -557,7 +910,14 @@ func (mc *ModbusClient) ReadFloat64(addr uint16, regType RegType) (value float64
 // Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
 // A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
 func (mc *ModbusClient) ReadBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
-	values, err = mc.readBytes(addr, quantity, regType, true)
+	values, err	= mc.ReadBytesCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadBytes, giving up when ctx is done.
+func (mc *ModbusClient) ReadBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
+	values, err = mc.readBytes(ctx, addr, quantity, regType, true)
 
 	return
 }
@@ -566,7 +926,63 @@ func (mc *ModbusClient) ReadBytes(addr uint16, quantity uint16, regType RegType)
 // No byte or word reordering is performed: bytes are returned exactly as they come
 // off the wire, allowing the caller to handle encoding/endianness/word order manually.
 func (mc *ModbusClient) ReadRawBytes(addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
-	values, err = mc.readBytes(addr, quantity, regType, false)
+	values, err	= mc.ReadRawBytesCtx(context.Background(), addr, quantity, regType)
+
+	return
+}
+
+// Same as ReadRawBytes, giving up when ctx is done.
+func (mc *ModbusClient) ReadRawBytesCtx(ctx context.Context, addr uint16, quantity uint16, regType RegType) (values []byte, err error) {
+	values, err = mc.readBytes(ctx, addr, quantity, regType, false)
+
+	return
+}
+
+// Reads a struct from consecutive 16-bit registers (function code 03 or 04).
+// v must point to a fixed-size struct described with restruct tags (see the
+// restruct package), whose encoded size sets the number of registers to read.
+// Multi-byte fields are decoded as per the endianness and word order of the
+// client unless their tag sets a byte order, while single bytes are taken as
+// found on the wire. Structs spanning more than 125 registers are read with
+// as many requests as needed (+dmzn).
+func (mc *ModbusClient) ReadStruct(addr uint16, regType RegType, v interface{}) (err error) {
+	err	= mc.ReadStructCtx(context.Background(), addr, regType, v)
+
+	return
+}
+
+// Same as ReadStruct, giving up when ctx is done.
+func (mc *ModbusClient) ReadStructCtx(ctx context.Context, addr uint16, regType RegType, v interface{}) (err error) {
+	var size	int
+	var quantity	uint16
+	var count	uint16
+	var raw		[]byte
+	var values	[]byte
+
+	size, err	= mc.structSize(addr, v, true)
+	if err != nil {
+		return
+	}
+
+	quantity	= uint16((size + 1) / 2)
+	for offset := uint16(0); offset < quantity; offset += count {
+		count	= quantity - offset
+		if count > 125 {
+			count	= 125
+		}
+
+		values, err	= mc.readRegisters(ctx, addr + offset, count, regType)
+		if err != nil {
+			return
+		}
+		raw	= append(raw, values...)
+	}
+
+	err	= restruct.Unpack(raw[0:size], mc.registerOrder(), v)
+	if err != nil {
+		mc.logger.Errorf("failed to decode struct: %v", err)
+		err	= ErrUnexpectedParameters
+	}
 
 	return
 }
@@ -599,6 +1015,13 @@ func (mc *ModbusClient) WriteRawData(data []byte) (err error) {
 
 // Writes a single coil (function code 05)
 func (mc *ModbusClient) WriteCoil(addr uint16, value bool) (err error) {
+	err	= mc.WriteCoilCtx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteCoil, giving up when ctx is done.
+func (mc *ModbusClient) WriteCoilCtx(ctx context.Context, addr uint16, value bool) (err error) {
 	var payload uint16
 
 	mc.lock.Lock()
@@ -610,7 +1033,7 @@ func (mc *ModbusClient) WriteCoil(addr uint16, value bool) (err error) {
 		payload = 0x0000
 	}
 
-	err = mc.writeCoil(addr, payload)
+	err = mc.writeCoil(ctx, addr, payload)
 
 	return
 }
@@ -621,16 +1044,30 @@ func (mc *ModbusClient) WriteCoil(addr uint16, value bool) (err error) {
 // but a handful of vendors seem to be hiding various DO/coil control modes
 // behind it (e.g. toggle, interlock, delayed open/close, etc.).
 func (mc *ModbusClient) WriteCoilValue(addr uint16, payload uint16) (err error) {
+	err	= mc.WriteCoilValueCtx(context.Background(), addr, payload)
+
+	return
+}
+
+// Same as WriteCoilValue, giving up when ctx is done.
+func (mc *ModbusClient) WriteCoilValueCtx(ctx context.Context, addr uint16, payload uint16) (err error) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
-	err = mc.writeCoil(addr, payload)
+	err = mc.writeCoil(ctx, addr, payload)
 
 	return
 }
 
 // Writes multiple coils (function code 15)
 func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) (err error) {
+	err	= mc.WriteCoilsCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteCoils, giving up when ctx is done.
+func (mc *ModbusClient) WriteCoilsCtx(ctx context.Context, addr uint16, values []bool) (err error) {
 	var req           *pdu
 	var res           *pdu
 	var quantity      uint16
@@ -676,7 +1113,7 @@ func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) (err error) {
 	req.payload	= append(req.payload, encodedValues...)
 
 	// run the request across the transport and wait for a response
-	res, err = mc.executeRequest(req)
+	res, err = mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -712,6 +1149,13 @@ func (mc *ModbusClient) WriteCoils(addr uint16, values []bool) (err error) {
 
 // Writes a single 16-bit register (function code 06).
 func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
+	err	= mc.WriteRegisterCtx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteRegister, giving up when ctx is done.
+func (mc *ModbusClient) WriteRegisterCtx(ctx context.Context, addr uint16, value uint16) (err error) {
 	var req	*pdu
 	var res	*pdu
 
@@ -730,7 +1174,7 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
 	req.payload	= append(req.payload, uint16ToBytes(mc.endianness, value)...)
 
 	// run the request across the transport and wait for a response
-	res, err	= mc.executeRequest(req)
+	res, err	= mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -766,6 +1210,13 @@ func (mc *ModbusClient) WriteRegister(addr uint16, value uint16) (err error) {
 
 // Writes multiple 16-bit registers (function code 16).
 func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) (err error) {
+	err	= mc.WriteRegistersCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteRegisters, giving up when ctx is done.
+func (mc *ModbusClient) WriteRegistersCtx(ctx context.Context, addr uint16, values []uint16) (err error) {
 	var payload	[]byte
 
 	// turn registers to bytes
@@ -773,13 +1224,20 @@ func (mc *ModbusClient) WriteRegisters(addr uint16, values []uint16) (err error)
 		payload	= append(payload, uint16ToBytes(mc.endianness, value)...)
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	err = mc.writeRegisters(ctx, addr, payload)
 
 	return
 }
 
 // Writes multiple 32-bit registers.
 func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
+	err	= mc.WriteUint32sCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteUint32s, giving up when ctx is done.
+func (mc *ModbusClient) WriteUint32sCtx(ctx context.Context, addr uint16, values []uint32) (err error) {
 	var payload	[]byte
 
 	// turn registers to bytes
@@ -787,20 +1245,34 @@ func (mc *ModbusClient) WriteUint32s(addr uint16, values []uint32) (err error) {
 		payload	= append(payload, uint32ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	err = mc.writeRegisters(ctx, addr, payload)
 
 	return
 }
 
 // Writes a single 32-bit register.
 func (mc *ModbusClient) WriteUint32(addr uint16, value uint32) (err error) {
-	err = mc.writeRegisters(addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
+	err	= mc.WriteUint32Ctx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteUint32, giving up when ctx is done.
+func (mc *ModbusClient) WriteUint32Ctx(ctx context.Context, addr uint16, value uint32) (err error) {
+	err = mc.writeRegisters(ctx, addr, uint32ToBytes(mc.endianness, mc.wordOrder, value))
 
 	return
 }
 
 // Writes multiple 32-bit float registers.
 func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error) {
+	err	= mc.WriteFloat32sCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteFloat32s, giving up when ctx is done.
+func (mc *ModbusClient) WriteFloat32sCtx(ctx context.Context, addr uint16, values []float32) (err error) {
 	var payload	[]byte
 
 	// turn registers to bytes
@@ -808,20 +1280,34 @@ func (mc *ModbusClient) WriteFloat32s(addr uint16, values []float32) (err error)
 		payload	= append(payload, float32ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	err = mc.writeRegisters(ctx, addr, payload)
 
 	return
 }
 
 // Writes a single 32-bit float register.
 func (mc *ModbusClient) WriteFloat32(addr uint16, value float32) (err error) {
-	err = mc.writeRegisters(addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
+	err	= mc.WriteFloat32Ctx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteFloat32, giving up when ctx is done.
+func (mc *ModbusClient) WriteFloat32Ctx(ctx context.Context, addr uint16, value float32) (err error) {
+	err = mc.writeRegisters(ctx, addr, float32ToBytes(mc.endianness, mc.wordOrder, value))
 
 	return
 }
 
 // Writes multiple 64-bit registers.
 func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
+	err	= mc.WriteUint64sCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteUint64s, giving up when ctx is done.
+func (mc *ModbusClient) WriteUint64sCtx(ctx context.Context, addr uint16, values []uint64) (err error) {
 	var payload	[]byte
 
 	// turn registers to bytes
@@ -829,20 +1315,34 @@ func (mc *ModbusClient) WriteUint64s(addr uint16, values []uint64) (err error) {
 		payload	= append(payload, uint64ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	err = mc.writeRegisters(ctx, addr, payload)
 
 	return
 }
 
 // Writes a single 64-bit register.
 func (mc *ModbusClient) WriteUint64(addr uint16, value uint64) (err error) {
-	err = mc.writeRegisters(addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
+	err	= mc.WriteUint64Ctx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteUint64, giving up when ctx is done.
+func (mc *ModbusClient) WriteUint64Ctx(ctx context.Context, addr uint16, value uint64) (err error) {
+	err = mc.writeRegisters(ctx, addr, uint64ToBytes(mc.endianness, mc.wordOrder, value))
 
 	return
 }
 
 // Writes multiple 64-bit float registers.
 func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error) {
+	err	= mc.WriteFloat64sCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteFloat64s, giving up when ctx is done.
+func (mc *ModbusClient) WriteFloat64sCtx(ctx context.Context, addr uint16, values []float64) (err error) {
 	var payload	[]byte
 
 	// turn registers to bytes
@@ -850,14 +1350,21 @@ func (mc *ModbusClient) WriteFloat64s(addr uint16, values []float64) (err error)
 		payload	= append(payload, float64ToBytes(mc.endianness, mc.wordOrder, value)...)
 	}
 
-	err = mc.writeRegisters(addr, payload)
+	err = mc.writeRegisters(ctx, addr, payload)
 
 	return
 }
 
 // Writes a single 64-bit float register.
 func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) (err error) {
-	err = mc.writeRegisters(addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
+	err	= mc.WriteFloat64Ctx(context.Background(), addr, value)
+
+	return
+}
+
+// Same as WriteFloat64, giving up when ctx is done.
+func (mc *ModbusClient) WriteFloat64Ctx(ctx context.Context, addr uint16, value float64) (err error) {
+	err = mc.writeRegisters(ctx, addr, float64ToBytes(mc.endianness, mc.wordOrder, value))
 
 	return
 }
@@ -866,7 +1373,14 @@ func (mc *ModbusClient) WriteFloat64(addr uint16, value float64) (err error) {
 // A per-register byteswap is performed if endianness is set to LITTLE_ENDIAN.
 // Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
 func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) (err error) {
-	err = mc.writeBytes(addr, values, true)
+	err	= mc.WriteBytesCtx(context.Background(), addr, values)
+
+	return
+}
+
+// Same as WriteBytes, giving up when ctx is done.
+func (mc *ModbusClient) WriteBytesCtx(ctx context.Context, addr uint16, values []byte) (err error) {
+	err = mc.writeBytes(ctx, addr, values, true)
 
 	return
 }
@@ -876,26 +1390,756 @@ func (mc *ModbusClient) WriteBytes(addr uint16, values []byte) (err error) {
 // allowing the caller to handle encoding/endianness/word order manually.
 // Odd byte quantities are padded with a null byte to fall on 16-bit register boundaries.
 func (mc *ModbusClient) WriteRawBytes(addr uint16, values []byte) (err error) {
-	err = mc.writeBytes(addr, values, false)
+	err	= mc.WriteRawBytesCtx(context.Background(), addr, values)
 
 	return
 }
 
-/*** unexported methods ***/
-// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
-func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType, observeEndianness bool) (values []byte, err error) {
-	var regCount uint16
+// Same as WriteRawBytes, giving up when ctx is done.
+func (mc *ModbusClient) WriteRawBytesCtx(ctx context.Context, addr uint16, values []byte) (err error) {
+	err = mc.writeBytes(ctx, addr, values, false)
 
-	// read enough registers to get the requested number of bytes
-	// (2 bytes per reg)
-	regCount = (quantity / 2) + (quantity % 2)
+	return
+}
+
+// Writes a struct to consecutive 16-bit registers (function code 16).
+// v is a struct (or a pointer to one) described with restruct tags, encoded
+// the same way ReadStruct decodes it (bytes skipped by the layout are written
+// as zeros). Its encoded size must be a multiple of 2 bytes so as to cover
+// full registers. Structs spanning more than 123 registers are written with
+// as many requests as needed (+dmzn).
+func (mc *ModbusClient) WriteStruct(addr uint16, v interface{}) (err error) {
+	err	= mc.WriteStructCtx(context.Background(), addr, v)
+
+	return
+}
 
-	values, err = mc.readRegisters(addr, regCount, regType)
+// Same as WriteStruct, giving up when ctx is done.
+func (mc *ModbusClient) WriteStructCtx(ctx context.Context, addr uint16, v interface{}) (err error) {
+	var size	int
+	var data	[]byte
+	var count	int
+	var val		reflect.Value
+
+	size, err	= mc.structSize(addr, v, false)
 	if err != nil {
 		return
 	}
 
-	// swap bytes on register boundaries if requested by the caller
+	// restruct encodes structs passed by value as zeros: pass a copy by
+	// pointer instead
+	val	= reflect.ValueOf(v)
+	if val.Kind() != reflect.Ptr {
+		val	= reflect.New(val.Type())
+		val.Elem().Set(reflect.ValueOf(v))
+		v	= val.Interface()
+	}
+
+	if size % 2 == 1 {
+		err	= ErrUnexpectedParameters
+		mc.logger.Errorf("struct size (%v bytes) is not a multiple of 2 bytes", size)
+		return
+	}
+
+	data, err	= restruct.Pack(mc.registerOrder(), v)
+	if err != nil {
+		mc.logger.Errorf("failed to encode struct: %v", err)
+		err	= ErrUnexpectedParameters
+		return
+	}
+
+	for offset := 0; offset < len(data); offset += count {
+		count	= len(data) - offset
+		if count > 2 * 123 {
+			count	= 2 * 123
+		}
+
+		err	= mc.writeRegisters(ctx, addr + uint16(offset / 2), data[offset:offset + count])
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Modifies the contents of a holding register using a combination of an AND
+// mask, an OR mask and the register's current contents (function code 22),
+// i.e. value = (current AND andMask) OR (orMask AND (NOT andMask)).
+func (mc *ModbusClient) MaskWriteRegister(addr uint16, andMask uint16, orMask uint16) (err error) {
+	err	= mc.MaskWriteRegisterCtx(context.Background(), addr, andMask, orMask)
+
+	return
+}
+
+// Same as MaskWriteRegister, giving up when ctx is done.
+func (mc *ModbusClient) MaskWriteRegisterCtx(ctx context.Context, addr uint16, andMask uint16, orMask uint16) (err error) {
+	var req	*pdu
+	var res	*pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcMaskWriteRegister,
+	}
+
+	// register address
+	req.payload	= uint16ToBytes(BIG_ENDIAN, addr)
+	// AND mask
+	req.payload	= append(req.payload, uint16ToBytes(mc.endianness, andMask)...)
+	// OR mask
+	req.payload	= append(req.payload, uint16ToBytes(mc.endianness, orMask)...)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request (2 bytes of address + 2 bytes of
+		// AND mask + 2 bytes of OR mask)
+		if len(res.payload) != 6 ||
+		   bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != addr ||
+		   bytesToUint16(mc.endianness, res.payload[2:4]) != andMask ||
+		   bytesToUint16(mc.endianness, res.payload[4:6]) != orMask {
+			   err = ErrProtocolError
+			   return
+		   }
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Writes multiple holding registers then reads multiple holding registers
+// in a single transaction (function code 23). The write is performed
+// before the read.
+func (mc *ModbusClient) ReadWriteMultipleRegisters(readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint16) (results []uint16, err error) {
+	results, err	= mc.ReadWriteMultipleRegistersCtx(context.Background(), readAddr, readQuantity, writeAddr, values)
+
+	return
+}
+
+// Same as ReadWriteMultipleRegisters, giving up when ctx is done.
+func (mc *ModbusClient) ReadWriteMultipleRegistersCtx(ctx context.Context, readAddr uint16, readQuantity uint16,
+	writeAddr uint16, values []uint16) (results []uint16, err error) {
+	var req           *pdu
+	var res           *pdu
+	var writeQuantity uint16
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	writeQuantity	= uint16(len(values))
+
+	if readQuantity == 0 || writeQuantity == 0 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers is 0")
+		return
+	}
+
+	if readQuantity > 125 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to read exceeds 125")
+		return
+	}
+
+	if writeQuantity > 121 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("quantity of registers to write exceeds 121")
+		return
+	}
+
+	if uint32(readAddr) + uint32(readQuantity) - 1 > 0xffff ||
+	   uint32(writeAddr) + uint32(writeQuantity) - 1 > 0xffff {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("end register address is past 0xffff")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadWriteMultipleRegisters,
+	}
+
+	// read base address and quantity
+	req.payload	= uint16ToBytes(BIG_ENDIAN, readAddr)
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, readQuantity)...)
+	// write base address and quantity
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, writeAddr)...)
+	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, writeQuantity)...)
+	// byte count
+	req.payload	= append(req.payload, byte(writeQuantity * 2))
+	// registers value
+	for _, value := range values {
+		req.payload	= append(req.payload, uint16ToBytes(mc.endianness, value)...)
+	}
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// make sure the payload length is what we expect
+		// (1 byte of length + 2 bytes per register)
+		if len(res.payload) != 1 + 2 * int(readQuantity) ||
+		   int(res.payload[0]) != 2 * int(readQuantity) {
+			err = ErrProtocolError
+			return
+		}
+
+		results	= bytesToUint16s(mc.endianness, res.payload[1:])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads the contents of a first-in-first-out queue of registers
+// (function code 24). Up to 31 registers can be returned.
+func (mc *ModbusClient) ReadFifoQueue(addr uint16) (values []uint16, err error) {
+	values, err	= mc.ReadFifoQueueCtx(context.Background(), addr)
+
+	return
+}
+
+// Same as ReadFifoQueue, giving up when ctx is done.
+func (mc *ModbusClient) ReadFifoQueueCtx(ctx context.Context, addr uint16) (values []uint16, err error) {
+	var req	  *pdu
+	var res	  *pdu
+	var count uint16
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadFifoQueue,
+	}
+
+	// fifo pointer address
+	req.payload	= uint16ToBytes(BIG_ENDIAN, addr)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 2 bytes of byte count + 2 bytes of fifo count, followed
+		// by 2 bytes per register
+		if len(res.payload) < 4 {
+			err = ErrProtocolError
+			return
+		}
+
+		count	= bytesToUint16(BIG_ENDIAN, res.payload[2:4])
+		if count > 31 ||
+		   int(bytesToUint16(BIG_ENDIAN, res.payload[0:2])) != 2 + 2 * int(count) ||
+		   len(res.payload) != 4 + 2 * int(count) {
+			err = ErrProtocolError
+			return
+		}
+
+		values	= bytesToUint16s(mc.endianness, res.payload[4:])
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Reads length registers from a single file record (function code 20).
+func (mc *ModbusClient) ReadFileRecord(fileNumber uint16, recordNumber uint16, length uint16) (values []uint16, err error) {
+	values, err	= mc.ReadFileRecordCtx(context.Background(), fileNumber, recordNumber, length)
+
+	return
+}
+
+// Same as ReadFileRecord, giving up when ctx is done.
+func (mc *ModbusClient) ReadFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, length uint16) (values []uint16, err error) {
+	var records	[]*FileRecord
+
+	records	= []*FileRecord{{
+		FileNumber:	fileNumber,
+		RecordNumber:	recordNumber,
+		Length:		length,
+	}}
+
+	err	= mc.ReadFileRecordsCtx(ctx, records)
+	if err != nil {
+		return
+	}
+
+	values	= records[0].Values
+
+	return
+}
+
+// Reads multiple file records in a single request (function code 20).
+// The Values field of each record is filled in with the registers read.
+func (mc *ModbusClient) ReadFileRecords(records []*FileRecord) (err error) {
+	err	= mc.ReadFileRecordsCtx(context.Background(), records)
+
+	return
+}
+
+// Same as ReadFileRecords, giving up when ctx is done.
+func (mc *ModbusClient) ReadFileRecordsCtx(ctx context.Context, records []*FileRecord) (err error) {
+	var req	   *pdu
+	var res	   *pdu
+	var offset int
+	var length int
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// each sub-request is 7 bytes long, the response to each sub-request
+	// 2 bytes + 2 bytes per register
+	length	= 0
+	for _, record := range records {
+		err	= checkFileRecord(record, record.Length)
+		if err != nil {
+			mc.logger.Error(err.Error())
+			err = ErrUnexpectedParameters
+			return
+		}
+		length	+= 2 + 2 * int(record.Length)
+	}
+
+	if len(records) == 0 || len(records) * 7 > 0xf5 || length > 0xf5 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("file record request or response would exceed 245 bytes")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcReadFileRecord,
+	}
+
+	// byte count
+	req.payload	= []byte{byte(len(records) * 7)}
+	for _, record := range records {
+		// reference type (always 6), file number, record number, record length
+		req.payload	= append(req.payload, 0x06)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.Length)...)
+	}
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 1 byte of response data length followed by one
+		// sub-response per record
+		if len(res.payload) != 1 + length || int(res.payload[0]) != length {
+			err = ErrProtocolError
+			return
+		}
+
+		offset	= 1
+		for _, record := range records {
+			// file response length (reference type + data) and reference type
+			if int(res.payload[offset]) != 1 + 2 * int(record.Length) ||
+			   res.payload[offset + 1] != 0x06 {
+				err = ErrProtocolError
+				return
+			}
+			offset	+= 2
+
+			record.Values	= bytesToUint16s(mc.endianness,
+				res.payload[offset:offset + 2 * int(record.Length)])
+			offset	+= 2 * int(record.Length)
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Writes registers to a single file record (function code 21).
+func (mc *ModbusClient) WriteFileRecord(fileNumber uint16, recordNumber uint16, values []uint16) (err error) {
+	err	= mc.WriteFileRecordCtx(context.Background(), fileNumber, recordNumber, values)
+
+	return
+}
+
+// Same as WriteFileRecord, giving up when ctx is done.
+func (mc *ModbusClient) WriteFileRecordCtx(ctx context.Context, fileNumber uint16, recordNumber uint16, values []uint16) (err error) {
+	err	= mc.WriteFileRecordsCtx(ctx, []*FileRecord{{
+		FileNumber:	fileNumber,
+		RecordNumber:	recordNumber,
+		Values:		values,
+	}})
+
+	return
+}
+
+// Writes multiple file records in a single request (function code 21).
+// The Length field of each record is ignored: the number of registers
+// written is that of its Values field.
+func (mc *ModbusClient) WriteFileRecords(records []*FileRecord) (err error) {
+	err	= mc.WriteFileRecordsCtx(context.Background(), records)
+
+	return
+}
+
+// Same as WriteFileRecords, giving up when ctx is done.
+func (mc *ModbusClient) WriteFileRecordsCtx(ctx context.Context, records []*FileRecord) (err error) {
+	var req	*pdu
+	var res	*pdu
+	var length int
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	// each sub-request is 7 bytes long + 2 bytes per register
+	length	= 0
+	for _, record := range records {
+		err	= checkFileRecord(record, uint16(len(record.Values)))
+		if err != nil {
+			mc.logger.Error(err.Error())
+			err = ErrUnexpectedParameters
+			return
+		}
+		length	+= 7 + 2 * len(record.Values)
+	}
+
+	if len(records) == 0 || length > 0xf5 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("file record request would exceed 245 bytes")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcWriteFileRecord,
+	}
+
+	// byte count
+	req.payload	= []byte{byte(length)}
+	for _, record := range records {
+		// reference type (always 6), file number, record number,
+		// record length and record data
+		req.payload	= append(req.payload, 0x06)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.FileNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, record.RecordNumber)...)
+		req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, uint16(len(record.Values)))...)
+		for _, value := range record.Values {
+			req.payload	= append(req.payload, uint16ToBytes(mc.endianness, value)...)
+		}
+	}
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect an echo of the request
+		if len(res.payload) != len(req.payload) {
+			err = ErrProtocolError
+			return
+		}
+
+		for i := range res.payload {
+			if res.payload[i] != req.payload[i] {
+				err = ErrProtocolError
+				return
+			}
+		}
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Runs a diagnostics sub-function (function code 08) and returns the data
+// field of the response.
+// Note that on RTU links, data is expected to be exactly 2 bytes long (as
+// for all sub-functions but DIAG_RETURN_QUERY_DATA), since the response
+// length cannot be known otherwise. Also note that servers do not reply to
+// DIAG_FORCE_LISTEN_ONLY requests, which will then time out.
+func (mc *ModbusClient) Diagnostics(subFunction uint16, data []byte) (results []byte, err error) {
+	results, err	= mc.DiagnosticsCtx(context.Background(), subFunction, data)
+
+	return
+}
+
+// Same as Diagnostics, giving up when ctx is done.
+func (mc *ModbusClient) DiagnosticsCtx(ctx context.Context, subFunction uint16, data []byte) (results []byte, err error) {
+	var req	*pdu
+	var res	*pdu
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if (mc.transportType == modbusRTU ||
+	    mc.transportType == modbusRTUOverTCP ||
+	    mc.transportType == modbusRTUOverUDP) && len(data) != 2 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("diagnostics data must be 2 bytes long on rtu links")
+		return
+	}
+
+	if len(data) > 250 {
+		err = ErrUnexpectedParameters
+		mc.logger.Error("diagnostics data exceeds 250 bytes")
+		return
+	}
+
+	// create and fill in the request object
+	req	= &pdu{
+		unitId:	      mc.unitId,
+		functionCode: fcDiagnostics,
+	}
+
+	// sub-function code
+	req.payload	= uint16ToBytes(BIG_ENDIAN, subFunction)
+	// data
+	req.payload	= append(req.payload, data...)
+
+	// run the request across the transport and wait for a response
+	res, err	= mc.executeRequest(ctx, req)
+	if err != nil {
+		return
+	}
+
+	// validate the response code
+	switch {
+	case res.functionCode == req.functionCode:
+		// expect 2 bytes of sub-function code followed by data
+		if len(res.payload) < 2 ||
+		   bytesToUint16(BIG_ENDIAN, res.payload[0:2]) != subFunction {
+			err = ErrProtocolError
+			return
+		}
+
+		results	= res.payload[2:]
+
+	case res.functionCode == (req.functionCode | 0x80):
+		if len(res.payload) != 1 {
+			err	= ErrProtocolError
+			return
+		}
+
+		err	= mapExceptionCodeToError(res.payload[0])
+
+	default:
+		err	= ErrProtocolError
+		mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+	}
+
+	return
+}
+
+// Runs a diagnostics sub-function taking and returning a single 16-bit
+// value (function code 08), e.g. to read one of the diagnostic counters.
+func (mc *ModbusClient) DiagnosticsValue(subFunction uint16, value uint16) (result uint16, err error) {
+	result, err	= mc.DiagnosticsValueCtx(context.Background(), subFunction, value)
+
+	return
+}
+
+// Same as DiagnosticsValue, giving up when ctx is done.
+func (mc *ModbusClient) DiagnosticsValueCtx(ctx context.Context, subFunction uint16, value uint16) (result uint16, err error) {
+	var res	[]byte
+
+	res, err	= mc.DiagnosticsCtx(ctx, subFunction, uint16ToBytes(BIG_ENDIAN, value))
+	if err != nil {
+		return
+	}
+
+	if len(res) != 2 {
+		err	= ErrProtocolError
+		return
+	}
+
+	result	= bytesToUint16(BIG_ENDIAN, res)
+
+	return
+}
+
+// Reads device identification objects (function code 43/14).
+// readCode is one of DEVID_BASIC, DEVID_REGULAR, DEVID_EXTENDED (stream
+// access, starting at objectId) or DEVID_SPECIFIC (individual access to
+// objectId). Responses split over multiple transactions are reassembled.
+func (mc *ModbusClient) ReadDeviceIdentification(readCode uint8, objectId uint8) (devId *DeviceIdentification, err error) {
+	devId, err	= mc.ReadDeviceIdentificationCtx(context.Background(), readCode, objectId)
+
+	return
+}
+
+// Same as ReadDeviceIdentification, giving up when ctx is done.
+func (mc *ModbusClient) ReadDeviceIdentificationCtx(ctx context.Context, readCode uint8, objectId uint8) (devId *DeviceIdentification, err error) {
+	var req	       *pdu
+	var res	       *pdu
+	var moreFollows bool
+
+	mc.lock.Lock()
+	defer mc.lock.Unlock()
+
+	if readCode < DEVID_BASIC || readCode > DEVID_SPECIFIC {
+		err = ErrUnexpectedParameters
+		mc.logger.Errorf("invalid read device id code (%v)", readCode)
+		return
+	}
+
+	devId	= &DeviceIdentification{
+		Objects: map[uint8]string{},
+	}
+
+	// there are at most 256 objects: never loop more than that
+	for i := 0; i < 256; i++ {
+		// create and fill in the request object
+		req	= &pdu{
+			unitId:	      mc.unitId,
+			functionCode: fcEncapsulatedInterface,
+			payload:      []byte{meiReadDeviceId, readCode, objectId},
+		}
+
+		// run the request across the transport and wait for a response
+		res, err	= mc.executeRequest(ctx, req)
+		if err != nil {
+			devId = nil
+			return
+		}
+
+		// validate the response code
+		switch {
+		case res.functionCode == req.functionCode:
+			moreFollows, objectId, err = decodeDeviceIdentification(res.payload, readCode, devId)
+			if err != nil {
+				devId = nil
+				return
+			}
+
+		case res.functionCode == (req.functionCode | 0x80):
+			devId	= nil
+			if len(res.payload) != 1 {
+				err	= ErrProtocolError
+				return
+			}
+
+			err	= mapExceptionCodeToError(res.payload[0])
+			return
+
+		default:
+			devId	= nil
+			err	= ErrProtocolError
+			mc.logger.Warningf("unexpected response code (%v)", res.functionCode)
+			return
+		}
+
+		if !moreFollows || readCode == DEVID_SPECIFIC {
+			return
+		}
+	}
+
+	devId	= nil
+	err	= ErrProtocolError
+	mc.logger.Warning("too many device identification transactions")
+
+	return
+}
+
+/*** unexported methods ***/
+// Reads one or multiple 16-bit registers (function code 03 or 04) as bytes.
+func (mc *ModbusClient) readBytes(ctx context.Context, addr uint16, quantity uint16, regType RegType, observeEndianness bool) (values []byte, err error) {
+	var regCount uint16
+
+	// read enough registers to get the requested number of bytes
+	// (2 bytes per reg)
+	regCount = (quantity / 2) + (quantity % 2)
+
+	values, err = mc.readRegisters(ctx, addr, regCount, regType)
+	if err != nil {
+		return
+	}
+
+	// swap bytes on register boundaries if requested by the caller
 	// and endianness is set to little endian
 	if observeEndianness && mc.endianness == LITTLE_ENDIAN {
 		for i := 0; i < len(values); i += 2 {
@@ -911,8 +2155,65 @@ func (mc *ModbusClient) readBytes(addr uint16, quantity uint16, regType RegType,
 	return
 }
 
+// Returns the encoded size of struct v, in bytes, making sure that it fits
+// in the register space from addr. Reads require a pointer to a struct.
+func (mc *ModbusClient) structSize(addr uint16, v interface{}, isRead bool) (size int, err error) {
+	var val	reflect.Value
+
+	val	= reflect.ValueOf(v)
+	if isRead && (val.Kind() != reflect.Ptr || val.IsNil()) {
+		err	= ErrUnexpectedParameters
+		mc.logger.Error("struct to read into must be passed by pointer")
+		return
+	}
+
+	if val.Kind() == reflect.Ptr && !val.IsNil() {
+		val	= val.Elem()
+	}
+
+	if val.Kind() != reflect.Struct {
+		err	= ErrUnexpectedParameters
+		mc.logger.Errorf("unexpected type %T (expected a struct)", v)
+		return
+	}
+
+	size, err	= restruct.SizeOf(v)
+	if err != nil {
+		mc.logger.Errorf("failed to size struct: %v", err)
+		err	= ErrUnexpectedParameters
+		return
+	}
+
+	if size == 0 {
+		err	= ErrUnexpectedParameters
+		mc.logger.Error("struct size is 0")
+		return
+	}
+
+	if uint32(addr) + uint32((size + 1) / 2) - 1 > 0xffff {
+		err	= ErrUnexpectedParameters
+		mc.logger.Error("end register address is past 0xffff")
+		return
+	}
+
+	return
+}
+
+// Returns the byte order of multi-byte values held in registers, as per the
+// encoding of the client.
+func (mc *ModbusClient) registerOrder() (order registerOrder) {
+	mc.lock.Lock()
+	order	= registerOrder{
+		endianness:	mc.endianness,
+		wordOrder:	mc.wordOrder,
+	}
+	mc.lock.Unlock()
+
+	return
+}
+
 // Writes the given slice of bytes to 16-bit registers starting at addr.
-func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness bool) (err error) {
+func (mc *ModbusClient) writeBytes(ctx context.Context, addr uint16, values []byte, observeEndianness bool) (err error) {
 	// pad odd quantities to make for full registers
 	if len(values) % 2 == 1 {
 		values = append(values, 0x00)
@@ -926,14 +2227,14 @@ func (mc *ModbusClient) writeBytes(addr uint16, values []byte, observeEndianness
 		}
 	}
 
-	err = mc.writeRegisters(addr, values)
+	err = mc.writeRegisters(ctx, addr, values)
 
 	return
 }
 
 // Reads and returns quantity booleans.
 // Digital inputs are read if di is true, otherwise coils are read.
-func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values []bool, err error) {
+func (mc *ModbusClient) readBools(ctx context.Context, addr uint16, quantity uint16, di bool) (values []bool, err error) {
 	var req	        *pdu
 	var res	        *pdu
 	var expectedLen int
@@ -976,7 +2277,7 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
 
 	// run the request across the transport and wait for a response
-	res, err = mc.executeRequest(req)
+	res, err = mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -1023,16 +2324,25 @@ func (mc *ModbusClient) readBools(addr uint16, quantity uint16, di bool) (values
 }
 
 // Reads and returns quantity registers of type regType, as bytes.
-func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegType) (bytes []byte, err error) {
-	var req	*pdu
-	var res	*pdu
-
+func (mc *ModbusClient) readRegisters(ctx context.Context, addr uint16, quantity uint16, regType RegType) (bytes []byte, err error) {
 	mc.lock.Lock()
 	defer mc.lock.Unlock()
 
+	bytes, err	= mc.readUnitRegisters(ctx, mc.unitId, addr, quantity, regType)
+
+	return
+}
+
+// Reads and returns quantity registers of type regType from unit unitId,
+// as bytes. Must be called with the lock held.
+func (mc *ModbusClient) readUnitRegisters(ctx context.Context, unitId uint8, addr uint16,
+	quantity uint16, regType RegType) (bytes []byte, err error) {
+	var req	*pdu
+	var res	*pdu
+
 	// create and fill in the request object
 	req	= &pdu{
-		unitId:	mc.unitId,
+		unitId:	unitId,
 	}
 
 	switch regType {
@@ -1068,7 +2378,7 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 	req.payload	= append(req.payload, uint16ToBytes(BIG_ENDIAN, quantity)...)
 
 	// run the request across the transport and wait for a response
-	res, err = mc.executeRequest(req)
+	res, err = mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -1110,7 +2420,7 @@ func (mc *ModbusClient) readRegisters(addr uint16, quantity uint16, regType RegT
 }
 
 // Writes a single coil (function code 05) using the specified payload.
-func (mc *ModbusClient) writeCoil(addr uint16, payload uint16) (err error) {
+func (mc *ModbusClient) writeCoil(ctx context.Context, addr uint16, payload uint16) (err error) {
 	var req	*pdu
 	var res	*pdu
 
@@ -1126,7 +2436,7 @@ func (mc *ModbusClient) writeCoil(addr uint16, payload uint16) (err error) {
 	req.payload = append(req.payload, uint16ToBytes(BIG_ENDIAN, payload)...)
 
 	// run the request across the transport and wait for a response
-	res, err	= mc.executeRequest(req)
+	res, err	= mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -1163,7 +2473,7 @@ func (mc *ModbusClient) writeCoil(addr uint16, payload uint16) (err error) {
 
 // Writes multiple registers starting from base address addr.
 // Register values are passed as bytes, each value being exactly 2 bytes.
-func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
+func (mc *ModbusClient) writeRegisters(ctx context.Context, addr uint16, values []byte) (err error) {
 	var req           *pdu
 	var res           *pdu
 	var payloadLength uint16
@@ -1209,7 +2519,7 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 	req.payload	= append(req.payload, values...)
 
 	// run the request across the transport and wait for a response
-	res, err	= mc.executeRequest(req)
+	res, err	= mc.executeRequest(ctx, req)
 	if err != nil {
 		return
 	}
@@ -1243,17 +2553,164 @@ func (mc *ModbusClient) writeRegisters(addr uint16, values []byte) (err error) {
 	return
 }
 
-func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
+// Runs a request across the transport, retrying as per the retry policy and
+// re-opening the link if needed. Must be called with the lock held, which is
+// released while the request is in flight on pipelined transports.
+func (mc *ModbusClient) executeRequest(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var cancel	context.CancelFunc
+	var backoff	time.Duration
+	var timer	*time.Timer
+	var retriable	func(err error) bool
+
+	ctx, cancel	= mc.requestContext(ctx)
+	defer cancel()
+
+	retriable	= mc.conf.Retry.Retriable
+	if retriable == nil {
+		retriable	= IsRetriable
+	}
+	backoff		= mc.conf.Retry.Backoff
+
+	for attempt := uint(0); ; attempt++ {
+		res, err	= mc.executeOnce(ctx, req)
+		if err == nil || attempt >= mc.conf.Retry.Count ||
+		   ctx.Err() != nil || !retriable(err) {
+			return
+		}
+
+		mc.logger.Warningf("request failed (%v), retrying (%v/%v)",
+				   err, attempt + 1, mc.conf.Retry.Count)
+
+		if backoff > 0 {
+			timer	= time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				err	= ctx.Err()
+				return
+			case <-timer.C:
+			}
+
+			backoff	*= 2
+			if mc.conf.Retry.MaxBackoff > 0 && backoff > mc.conf.Retry.MaxBackoff {
+				backoff	= mc.conf.Retry.MaxBackoff
+			}
+		}
+	}
+}
+
+// Runs a single request across the transport.
+func (mc *ModbusClient) executeOnce(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var done	chan struct{}
+	var tr		transport
+	var pipe	*tcpPipeline
+	var pipelined	bool
+	var start	time.Time
+
+	err	= ctx.Err()
+	if err != nil {
+		return
+	}
+
+	// +dmzn:throttle requests, without holding the lock so that a
+	// throttled unit does not stall other goroutines using the client
+	if mc.conf.Limiter != nil {
+		mc.lock.Unlock()
+		err = mc.conf.Limiter.Wait(ctx,
+			fmt.Sprintf("%s#%d", mc.conf.URL, req.unitId))
+		mc.lock.Lock()
+		if err != nil {
+			return
+		}
+	}
+
+	// +dmzn:re-open dropped links
+	if mc.transport == nil {
+		if !mc.active || !mc.canReconnect() {
+			err	= ErrTransportNotOpen
+			return
+		}
+
+		err	= mc.open()
+		if err != nil {
+			mc.logger.Warningf("failed to re-open link: %v", err)
+			return
+		}
+		mc.logger.Info("link re-opened")
+	}
+
+	tr		= mc.transport
+
+	// +dmzn:slaves do not respond to broadcasts on serial lines
+	if req.unitId == 0 && mc.isSerialLine() {
+		res, err	= mc.broadcast(ctx, tr, req)
+		return
+	}
+
+	pipe, pipelined	= tr.(*tcpPipeline)
+	start		= time.Now()
+	mc.monitor.request()
+
 	// send the request over the wire, wait for and decode the response
-	res, err	= mc.transport.ExecuteRequest(req)
+	switch {
+	case pipelined:
+		// let other goroutines use the client while the request is in
+		// flight, responses being matched by transaction id
+		mc.lock.Unlock()
+		res, err	= pipe.executeRequestCtx(ctx, req)
+		mc.lock.Lock()
+
+	case ctx.Done() == nil:
+		res, err	= tr.ExecuteRequest(req)
+
+	default:
+		done	= make(chan struct{})
+		go func() {
+			res, err	= tr.ExecuteRequest(req)
+			close(done)
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// closing the link is the only way to interrupt a pending
+			// read/write: wait for the request to return, then drop the
+			// transport so that it gets re-opened (or reported as closed)
+			// by the next request
+			tr.Close()
+			<-done
+			if mc.transport == tr {
+				mc.transport	= nil
+				mc.reopenLocalLink()
+			}
+			res		= nil
+			err		= ctx.Err()
+			return
+		}
+	}
+
 	if err != nil {
 		// map i/o timeouts to ErrRequestTimedOut
 		if os.IsTimeout(err) {
 			err = ErrRequestTimedOut
 		}
+		if ctx.Err() == nil {
+			mc.monitor.failure(err)
+		}
+
+		// the stream may be out of sync or the connection gone: start
+		// over with a fresh link (unless another request already did,
+		// or the pipeline is still healthy)
+		if mc.canReconnect() && mc.transport == tr &&
+		   !(pipelined && (err == ErrRequestTimedOut || ctx.Err() != nil)) {
+			tr.Close()
+			mc.transport	= nil
+		}
 		return
 	}
 
+	mc.monitor.response(res, time.Since(start))
+
 	// make sure the source unit id matches that of the request
 	if (res.functionCode & 0x80) == 0x00 && res.unitId != req.unitId {
 		err = ErrBadUnitId
@@ -1268,3 +2725,261 @@ func (mc *ModbusClient) executeRequest(req *pdu) (res *pdu, err error) {
 
 	return
 }
+
+// Sends a broadcast request, which slaves process without responding, then
+// observes the turnaround delay. The returned response is that expected from
+// a single slave, for callers to validate as usual.
+func (mc *ModbusClient) broadcast(ctx context.Context, tr transport, req *pdu) (res *pdu, err error) {
+	var rw		requestWriter
+	var ok		bool
+	var timer	*time.Timer
+
+	res	= broadcastResponse(req)
+	if res == nil {
+		err	= ErrUnexpectedParameters
+		mc.logger.Errorf("function code 0x%02x cannot be broadcast", req.functionCode)
+		return
+	}
+
+	rw, ok	= tr.(requestWriter)
+	if !ok {
+		res	= nil
+		err	= ErrUnexpectedParameters
+		mc.logger.Error("broadcasts are not supported by the transport")
+		return
+	}
+
+	mc.monitor.request()
+	err	= rw.writeRequest(req)
+	if err != nil {
+		if os.IsTimeout(err) {
+			err = ErrRequestTimedOut
+		}
+		mc.monitor.failure(err)
+		res	= nil
+		return
+	}
+
+	// keep the bus quiet while slaves process the request
+	timer	= time.NewTimer(mc.conf.TurnaroundDelay)
+	select {
+	case <-ctx.Done():
+		timer.Stop()
+		res	= nil
+		err	= ctx.Err()
+	case <-timer.C:
+	}
+
+	return
+}
+
+// Returns the response a slave would send to a broadcast request, or nil
+// if the function code does not allow broadcasting (i.e. reads).
+func broadcastResponse(req *pdu) (res *pdu) {
+	switch req.functionCode {
+	case fcWriteSingleCoil, fcWriteSingleRegister, fcMaskWriteRegister,
+	     fcWriteFileRecord:
+		// the response is an echo of the request
+		res	= &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+			payload:	req.payload,
+		}
+
+	case fcWriteMultipleCoils, fcWriteMultipleRegisters:
+		// the response echoes the address and quantity
+		if len(req.payload) >= 4 {
+			res	= &pdu{
+				unitId:		req.unitId,
+				functionCode:	req.functionCode,
+				payload:	req.payload[0:4],
+			}
+		}
+	}
+
+	return
+}
+
+// Returns the context of a request, done when either ctx or the client
+// context (if any) is done.
+func (mc *ModbusClient) requestContext(ctx context.Context) (reqCtx context.Context, cancel context.CancelFunc) {
+	var base	context.Context = mc.conf.Context
+
+	switch {
+	case base == nil || base == ctx:
+		reqCtx, cancel	= ctx, func() {}
+
+	case ctx.Done() == nil:
+		reqCtx, cancel	= base, func() {}
+
+	default:
+		reqCtx, cancel	= context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-base.Done():
+				cancel()
+			case <-reqCtx.Done():
+			}
+		}()
+	}
+
+	return
+}
+
+// Returns a TCP transport for sock, pipelined if so configured.
+func (mc *ModbusClient) newTCPTransport(sock net.Conn) (tr transport) {
+	if mc.conf.MaxInFlight > 1 {
+		tr	= newTCPPipeline(sock, mc.conf.Timeout, mc.conf.MaxInFlight,
+				       mc.monitor, mc.conf.Logger)
+	} else {
+		tr	= newTCPTransport(sock, mc.conf.Timeout, mc.conf.Logger)
+	}
+
+	return
+}
+
+// Returns true if the link of the client can be re-opened transparently.
+func (mc *ModbusClient) canReconnect() (ok bool) {
+	switch mc.transportType {
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
+		ok	= mc.conf.AutoReconnect
+	}
+
+	return
+}
+
+// Re-opens serial ports and udp sockets dropped by a cancelled request, which
+// the next request would otherwise report as closed: re-opening them involves
+// no exchange with the remote end. tcp links are left closed unless they can
+// be re-opened transparently (see AutoReconnect) (+dmzn).
+func (mc *ModbusClient) reopenLocalLink() {
+	var err	error
+
+	if !mc.active || mc.canReconnect() {
+		return
+	}
+
+	switch mc.transportType {
+	case modbusRTU, modbusASCII, modbusRTUOverUDP, modbusTCPOverUDP:
+		err	= mc.open()
+		if err != nil {
+			mc.logger.Warningf("failed to re-open link: %v", err)
+		}
+	}
+}
+
+// Returns true if the client talks to a serial line, directly or tunneled
+// over tcp/udp, where unit id 0 is the broadcast address (+dmzn).
+func (mc *ModbusClient) isSerialLine() (ok bool) {
+	switch mc.transportType {
+	case modbusRTU, modbusRTUOverTCP, modbusRTUOverUDP,
+	     modbusASCII, modbusASCIIOverTCP:
+		ok	= true
+	}
+
+	return
+}
+
+// IsRetriable is the default retry predicate of RetryPolicy: requests are
+// retried on timeouts, transport errors (e.g. dropped connections, bad CRCs or
+// short frames) and busy devices, but not on other modbus exceptions nor on
+// context cancellation (+dmzn).
+func IsRetriable(err error) (ok bool) {
+	switch {
+	case err == nil:
+		ok	= false
+
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		ok	= false
+
+	case errors.Is(err, ErrRequestTimedOut), errors.Is(err, ErrBadCRC),
+	     errors.Is(err, ErrShortFrame), errors.Is(err, ErrBadTransactionId),
+	     errors.Is(err, ErrServerDeviceBusy), errors.Is(err, ErrGWTargetFailedToRespond):
+		ok	= true
+
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+	     errors.Is(err, net.ErrClosed), errors.Is(err, syscall.ECONNRESET),
+	     errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.EPIPE):
+		ok	= true
+
+	default:
+		// other network errors (dial/read/write failures)
+		var netErr	*net.OpError
+		ok	= errors.As(err, &netErr)
+	}
+
+	return
+}
+
+// Makes sure a file record sub-request is within protocol limits.
+func checkFileRecord(record *FileRecord, length uint16) (err error) {
+	if record.FileNumber == 0 {
+		err = fmt.Errorf("file number is 0")
+		return
+	}
+
+	if record.RecordNumber > 0x270f {
+		err = fmt.Errorf("record number exceeds 0x270f")
+		return
+	}
+
+	if length == 0 {
+		err = fmt.Errorf("record length is 0")
+		return
+	}
+
+	if uint32(record.RecordNumber) + uint32(length) - 1 > 0x270f {
+		err = fmt.Errorf("end record number is past 0x270f")
+		return
+	}
+
+	return
+}
+
+// Decodes a read device identification response payload into devId.
+// Returns whether more objects follow and the id of the next object to
+// request in that case.
+func decodeDeviceIdentification(payload []byte, readCode uint8,
+	devId *DeviceIdentification) (moreFollows bool, nextObjectId uint8, err error) {
+	var offset   int
+	var objCount int
+	var objLen   int
+
+	// expect MEI type, read device id code, conformity level, more follows,
+	// next object id and number of objects (1 byte each)
+	if len(payload) < 6 ||
+	   payload[0] != meiReadDeviceId || payload[1] != readCode {
+		err = ErrProtocolError
+		return
+	}
+
+	devId.ConformityLevel	= payload[2]
+	moreFollows		= payload[3] == 0xff
+	nextObjectId		= payload[4]
+	objCount		= int(payload[5])
+
+	offset	= 6
+	for i := 0; i < objCount; i++ {
+		// object id, object length and object value
+		if offset + 2 > len(payload) {
+			err = ErrProtocolError
+			return
+		}
+
+		objLen	= int(payload[offset + 1])
+		if offset + 2 + objLen > len(payload) {
+			err = ErrProtocolError
+			return
+		}
+
+		devId.Objects[payload[offset]] = string(payload[offset + 2:offset + 2 + objLen])
+		offset	+= 2 + objLen
+	}
+
+	if offset != len(payload) {
+		err = ErrProtocolError
+		return
+	}
+
+	return
+}
@@ -0,0 +1,580 @@
+package modbus
+
+import (
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIsRetriable(t *testing.T) {
+	for _, err := range []error{
+		ErrRequestTimedOut, ErrBadCRC, ErrShortFrame, ErrServerDeviceBusy,
+		io.EOF, io.ErrUnexpectedEOF, &net.OpError{Op: "read", Err: io.EOF},
+	} {
+		if !IsRetriable(err) {
+			t.Errorf("%v should be retriable", err)
+		}
+	}
+
+	for _, err := range []error{
+		nil, ErrIllegalFunction, ErrIllegalDataAddress, ErrUnexpectedParameters,
+		ErrTransportNotOpen, context.Canceled, context.DeadlineExceeded,
+	} {
+		if IsRetriable(err) {
+			t.Errorf("%v should not be retriable", err)
+		}
+	}
+
+	return
+}
+
+func TestClientNotOpen(t *testing.T) {
+	var client	*ModbusClient
+	var err		error
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5515",
+		AutoReconnect:	true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	// links are only re-opened once Open() has been called
+	_, err	= client.ReadCoils(0, 1)
+	if err != ErrTransportNotOpen {
+		t.Errorf("expected ErrTransportNotOpen, got: %v", err)
+	}
+
+	return
+}
+
+func TestClientRetryAndReconnect(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var plain	*ModbusClient
+	var ds		*DataStore
+	var value	uint16
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{})
+	ds.SetRegister(1, HOLDING_REGISTER, 0x10, 0x1234)
+
+	startServer := func() {
+		server, err = NewServer(&ServerConfiguration{
+			URL:		"tcp://localhost:5515",
+		}, ds)
+		if err != nil {
+			t.Fatalf("failed to create server: %v", err)
+		}
+
+		err = server.Start()
+		if err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+	}
+	startServer()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5515",
+		AutoReconnect:	true,
+		Retry:		RetryPolicy{Count: 5, Backoff: 10 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	plain, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5515",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	for _, c := range []*ModbusClient{client, plain} {
+		err	= c.Open()
+		if err != nil {
+			t.Fatalf("failed to open client: %v", err)
+		}
+		defer c.Close()
+
+		value, err	= c.ReadRegister(0x10, HOLDING_REGISTER)
+		if err != nil || value != 0x1234 {
+			t.Errorf("expected 0x1234, got: 0x%04x (%v)", value, err)
+		}
+	}
+
+	// restarting the server drops all connections
+	server.Stop()
+	startServer()
+	defer server.Stop()
+
+	value, err	= client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != nil || value != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x (%v)", value, err)
+	}
+
+	_, err	= plain.ReadRegister(0x10, HOLDING_REGISTER)
+	if err == nil {
+		t.Errorf("ReadRegister() should have failed without auto reconnect")
+	}
+
+	// exceptions are not retried (unit #2 is not served by the store)
+	client.SetUnitId(2)
+	_, err	= client.ReadRegister(0x10, HOLDING_REGISTER)
+	if err != ErrServerDeviceFailure {
+		t.Errorf("expected ErrServerDeviceFailure, got: %v", err)
+	}
+
+	return
+}
+
+func TestClientRetryPolicy(t *testing.T) {
+	var sock	net.Listener
+	var client	*ModbusClient
+	var attempts	int
+	var err		error
+
+	// a server which never answers
+	sock, err	= net.Listen("tcp", "localhost:5516")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5516",
+		Timeout:	20 * time.Millisecond,
+		Retry:		RetryPolicy{
+			Count:		2,
+			Backoff:	10 * time.Millisecond,
+			Retriable:	func(err error) bool {
+				attempts++
+				return IsRetriable(err)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	_, err	= client.ReadCoils(0, 1)
+	if err != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", err)
+	}
+
+	if attempts != 2 {
+		t.Errorf("expected 2 retries, got: %v", attempts)
+	}
+
+	return
+}
+
+func TestClientContext(t *testing.T) {
+	var sock	net.Listener
+	var client	*ModbusClient
+	var base	context.Context
+	var stop	context.CancelFunc
+	var ctx		context.Context
+	var cancel	context.CancelFunc
+	var start	time.Time
+	var err		error
+
+	sock, err	= net.Listen("tcp", "localhost:5517")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	base, stop	= context.WithCancel(context.Background())
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5517",
+		Timeout:	5 * time.Second,
+		Context:	base,
+		AutoReconnect:	true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// pending requests are aborted when ctx is done
+	ctx, cancel	= context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+
+	start	= time.Now()
+	_, err	= client.ReadRegistersCtx(ctx, 0, 1, HOLDING_REGISTER)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("request should have been aborted, took %v", time.Since(start))
+	}
+
+	// done contexts are checked before sending anything
+	_, err	= client.ReadCoilCtx(ctx, 0)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	// the client context aborts all requests
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		stop()
+	}()
+
+	start	= time.Now()
+	err	= client.WriteRegister(0, 1)
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+
+	if time.Since(start) > time.Second {
+		t.Errorf("request should have been aborted, took %v", time.Since(start))
+	}
+
+	return
+}
+
+func TestClientCancelReopensLink(t *testing.T) {
+	var sock	net.PacketConn
+	var lsock	net.Listener
+	var client	*ModbusClient
+	var ctx		context.Context
+	var cancel	context.CancelFunc
+	var err		error
+
+	// a udp peer which never responds
+	sock, err	= net.ListenPacket("udp", "localhost:5528")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"rtuoverudp://localhost:5528",
+		Timeout:	5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 2; i++ {
+		ctx, cancel	= context.WithTimeout(context.Background(), 50 * time.Millisecond)
+		_, err		= client.ReadRegistersCtx(ctx, 0, 1, HOLDING_REGISTER)
+		cancel()
+
+		// the link is re-opened right away rather than reported as closed
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+		}
+
+		if client.transport == nil {
+			t.Errorf("the link should have been re-opened")
+		}
+	}
+
+	// tcp links are left closed without AutoReconnect
+	lsock, err	= net.Listen("tcp", "localhost:5529")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer lsock.Close()
+
+	go func() {
+		for {
+			conn, err := lsock.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5529",
+		Timeout:	5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	ctx, cancel	= context.WithTimeout(context.Background(), 50 * time.Millisecond)
+	defer cancel()
+
+	_, err	= client.ReadRegistersCtx(ctx, 0, 1, HOLDING_REGISTER)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+
+	_, err	= client.ReadRegisters(0, 1, HOLDING_REGISTER)
+	if err != ErrTransportNotOpen {
+		t.Errorf("expected ErrTransportNotOpen, got: %v", err)
+	}
+
+	return
+}
+
+// Limiter holding back requests to unit #2 until released.
+type testUnitLimiter struct {
+	release	chan struct{}
+}
+
+func (tl *testUnitLimiter) Wait(ctx context.Context, key string) (err error) {
+	if strings.HasSuffix(key, "#2") {
+		select {
+		case <-tl.release:
+		case <-ctx.Done():
+			err	= ctx.Err()
+		}
+	}
+
+	return
+}
+
+func TestClientLimiterUnlocked(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var limiter	*testUnitLimiter
+	var throttled	chan error
+	var regs	[]uint16
+	var err		error
+
+	server, err	= NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5530",
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err	= server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	limiter	= &testUnitLimiter{release: make(chan struct{})}
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5530",
+		Timeout:	time.Second,
+		Limiter:	limiter,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	throttled	= make(chan error, 1)
+	go func() {
+		client.lock.Lock()
+		defer client.lock.Unlock()
+
+		_, err := client.readUnitRegisters(context.Background(), 2, 0, 1, HOLDING_REGISTER)
+		throttled <- err
+	}()
+
+	// unit #9 is served while unit #2 is being throttled
+	time.Sleep(50 * time.Millisecond)
+	regs, err	= client.ReadRegisters(0, 1, HOLDING_REGISTER)
+	if err != nil || len(regs) != 1 {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v, %v", regs, err)
+	}
+
+	select {
+	case err = <-throttled:
+		t.Errorf("unit #2 should still be throttled, got: %v", err)
+	default:
+	}
+
+	close(limiter.release)
+	select {
+	case err = <-throttled:
+		// the test handler only serves unit #9
+		if err != ErrIllegalFunction {
+			t.Errorf("expected ErrIllegalFunction, got: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("throttled request should have completed")
+	}
+
+	return
+}
+
+// Register map of a fictional device.
+type testDeviceMap struct {
+	Status   uint16
+	Flags    int16
+	Counter  uint32
+	Setpoint float32
+	Serial   [4]byte
+	Energy   uint64
+	Mode     uint16 `struct:"skip=2"`
+}
+
+func TestClientStructs(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var dm		testDeviceMap
+	var regs	[]uint16
+	var large	struct {
+		Values	[130]uint16
+	}
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{HoldingRegisters: 300})
+	ds.SetRegisters(1, HOLDING_REGISTER, 0, []uint16{
+		0x0001, 0xfffe, 0x1122, 0x3344, 0x4048, 0xf5c3, 0x4142, 0x4344,
+		0x0000, 0x0000, 0x0001, 0x0002, 0xdead, 0x0003,
+	})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5526",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5526",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// big endian, high word first
+	err	= client.ReadStruct(0, HOLDING_REGISTER, &dm)
+	if err != nil {
+		t.Fatalf("ReadStruct() should have succeeded, got: %v", err)
+	}
+
+	if dm.Status != 1 || dm.Flags != -2 || dm.Counter != 0x11223344 ||
+	   dm.Setpoint != 3.14 || string(dm.Serial[:]) != "ABCD" ||
+	   dm.Energy != 0x10002 || dm.Mode != 3 {
+		t.Errorf("unexpected struct: %+v", dm)
+	}
+
+	// little endian, low word first
+	client.SetEncoding(LITTLE_ENDIAN, LOW_WORD_FIRST)
+	dm.Counter	= 0x55667788
+	err	= client.WriteStruct(0, dm)
+	if err != nil {
+		t.Fatalf("WriteStruct() should have succeeded, got: %v", err)
+	}
+
+	regs, _	= ds.Registers(1, HOLDING_REGISTER, 0, 14)
+	for i, v := range []uint16{
+		0x0100, 0xfeff, 0x8877, 0x6655, 0xc3f5, 0x4840, 0x4142, 0x4344,
+		0x0200, 0x0100, 0x0000, 0x0000, 0x0000, 0x0300,
+	} {
+		if regs[i] != v {
+			t.Errorf("expected 0x%04x at register %v, got 0x%04x", v, i, regs[i])
+		}
+	}
+
+	dm	= testDeviceMap{}
+	err	= client.ReadStruct(0, HOLDING_REGISTER, &dm)
+	if err != nil || dm.Counter != 0x55667788 || dm.Setpoint != 3.14 || dm.Mode != 3 {
+		t.Errorf("unexpected struct: %+v (err: %v)", dm, err)
+	}
+
+	// structs too large for a single request
+	for i := range large.Values {
+		large.Values[i]	= uint16(i)
+	}
+
+	err	= client.WriteStruct(100, &large)
+	if err != nil {
+		t.Errorf("WriteStruct() should have succeeded, got: %v", err)
+	}
+
+	large.Values	= [130]uint16{}
+	err	= client.ReadStruct(100, HOLDING_REGISTER, &large)
+	if err != nil || large.Values[0] != 0 || large.Values[124] != 124 ||
+	   large.Values[129] != 129 {
+		t.Errorf("unexpected struct: %+v (err: %v)", large, err)
+	}
+
+	// invalid parameters
+	err	= client.ReadStruct(0, HOLDING_REGISTER, dm)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	err	= client.WriteStruct(0, &struct{ A uint8 }{})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	err	= client.WriteStruct(0xfffa, &dm)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	return
+}
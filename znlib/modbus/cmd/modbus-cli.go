@@ -2,16 +2,23 @@ package main
 
 import (
 	"crypto/tls"
+	"encoding/csv"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"flag"
+	"io"
+	"log"
 	"os"
+	"os/signal"
 	"strings"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/dmznlin/znlib-go/znlib/modbus"
+	"github.com/dmznlin/znlib-go/znlib/modbus/serialport"
 )
 
 func main() {
@@ -28,19 +35,35 @@ func main() {
 	var dataBits      uint
 	var parity        string
 	var stopBits      uint
+	var rs485         bool
 	var endianness    string
 	var wordOrder     string
 	var timeout       string
 	var cEndianess    modbus.Endianness
 	var cWordOrder    modbus.WordOrder
 	var unitId        uint
+	var configPath    string
+	var profileName   string
+	var output        string
+	var out           *resultWriter
 	var runList       []operation
 
+	// the serve subcommand comes with its own set of options
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		os.Exit(runServer(os.Args[2:]))
+	}
+
+	// so does the detect subcommand
+	if len(os.Args) > 1 && os.Args[1] == "detect" {
+		os.Exit(runDetect(os.Args[2:]))
+	}
+
 	flag.StringVar(&target, "target", "", "target device to connect to (e.g. tcp://somehost:502) [required]")
 	flag.UintVar(&speed, "speed", 19200, "serial bus speed in bps (rtu)")
 	flag.UintVar(&dataBits, "data-bits", 8, "number of bits per character on the serial bus (rtu)")
 	flag.StringVar(&parity, "parity", "none", "parity bit <none|even|odd> on the serial bus (rtu)")
 	flag.UintVar(&stopBits, "stop-bits", 2, "number of stop bits <0|1|2>) on the serial bus (rtu)")
+	flag.BoolVar(&rs485, "rs485", false, "let the kernel driver toggle RTS around transmissions (rtu, linux only)")
 	flag.StringVar(&timeout, "timeout", "3s", "timeout value")
 	flag.StringVar(&endianness, "endianness", "big", "register endianness <little|big>")
 	flag.StringVar(&wordOrder, "word-order", "highfirst", "word ordering for 32-bit registers <highfirst|hf|lowfirst|lf>")
@@ -48,6 +71,9 @@ func main() {
 	flag.StringVar(&certPath, "cert", "", "path to TLS client certificate")
 	flag.StringVar(&keyPath, "key", "", "path to TLS client key")
 	flag.StringVar(&caPath, "ca", "", "path to TLS CA/server certificate")
+	flag.StringVar(&configPath, "config", "modbus-cli.json", "path to the target profiles file")
+	flag.StringVar(&profileName, "profile", "", "target profile to load from the profiles file")
+	flag.StringVar(&output, "output", "text", "output format of reads <text|json|csv>")
 	flag.BoolVar(&help, "help", false, "show a wall-of-text help message")
 	flag.Parse()
 
@@ -56,8 +82,23 @@ func main() {
 		os.Exit(0)
 	}
 
+	// options given on the command line take precedence over the profile
+	if profileName != "" {
+		err = loadProfile(configPath, profileName)
+		if err != nil {
+			fmt.Printf("failed to load profile '%s': %v\n", profileName, err)
+			os.Exit(1)
+		}
+	}
+
+	out, err	= newResultWriter(output)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+
 	if target == "" {
-		fmt.Printf("no target specified, please use --target\n")
+		fmt.Printf("no target specified, please use --target or --profile\n")
 		os.Exit(1)
 	}
 
@@ -67,6 +108,7 @@ func main() {
 		Speed:		speed,
 		DataBits:	dataBits,
 		StopBits:	stopBits,
+		RS485:		rs485Config(rs485),
 	}
 
 	switch parity {
@@ -146,7 +188,8 @@ func main() {
 		var o		operation
 
 		splitArgs	= strings.Split(arg, ":")
-		if len(splitArgs) < 2 && splitArgs[0] != "repeat" && splitArgs[0] != "date" {
+		if len(splitArgs) < 2 && splitArgs[0] != "repeat" && splitArgs[0] != "date" &&
+		   splitArgs[0] != "watch" {
 			fmt.Printf("illegal command format (should be command:arg1:arg2..., e.g. rh:uint32:0x1000+5)\n")
 			os.Exit(2)
 		}
@@ -336,6 +379,23 @@ func main() {
 
 			o.op		= repeat
 
+		case "watch":
+			if len(splitArgs) > 2 {
+				fmt.Printf("need at most 1 argument after watch, got %v\n",
+					   len(splitArgs) - 1)
+				os.Exit(2)
+			}
+
+			o.op		= watch
+			o.duration	= time.Second
+			if len(splitArgs) == 2 {
+				o.duration, err	= time.ParseDuration(splitArgs[1])
+				if err != nil {
+					fmt.Printf("failed to parse '%s' as duration: %v\n", splitArgs[1], err)
+					os.Exit(2)
+				}
+			}
+
 		case "date":
 			if len(splitArgs) != 1 {
 				fmt.Printf("date takes no arguments, got %v\n",
@@ -429,6 +489,7 @@ func main() {
 		os.Exit(1)
 	}
 	client.SetUnitId(uint8(unitId))
+	out.unitId	= uint8(unitId)
 
 	// connect to the remote host/open the serial port
 	err		= client.Open()
@@ -450,13 +511,14 @@ func main() {
 				res, err = client.ReadDiscreteInputs(o.addr, o.quantity + 1)
 			}
 			if err != nil {
-				fmt.Printf("failed to read coils/discrete inputs: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read coils/discrete inputs: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
-					fmt.Printf("0x%04x\t%-5v : %v\n", o.addr + uint16(idx),
-									   o.addr + uint16(idx),
-								           res[idx])
+					values	= append(values, res[idx])
 				}
+				out.write(o, "bool", 1, values)
 			}
 
 		case readUint16, readInt16:
@@ -468,21 +530,18 @@ func main() {
 				res, err = client.ReadRegisters(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
 					if o.op == readUint16 {
-						fmt.Printf("0x%04x\t%-5v : 0x%04x\t%v\n",
-							   o.addr + uint16(idx),
-							   o.addr + uint16(idx),
-							   res[idx], res[idx])
+						values	= append(values, res[idx])
 					} else {
-						fmt.Printf("0x%04x\t%-5v : 0x%04x\t%v\n",
-							   o.addr + uint16(idx),
-							   o.addr + uint16(idx),
-							   res[idx], int16(res[idx]))
+						values	= append(values, int16(res[idx]))
 					}
 				}
+				out.write(o, typeNames[o.op], 1, values)
 			}
 
 		case readUint32, readInt32:
@@ -494,21 +553,18 @@ func main() {
 				res, err = client.ReadUint32s(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
 					if o.op == readUint32 {
-						fmt.Printf("0x%04x\t%-5v : 0x%08x\t%v\n",
-							   o.addr + (uint16(idx) * 2),
-							   o.addr + (uint16(idx) * 2),
-							   res[idx], res[idx])
+						values	= append(values, res[idx])
 					} else {
-						fmt.Printf("0x%04x\t%-5v : 0x%08x\t%v\n",
-							   o.addr + (uint16(idx) * 2),
-							   o.addr + (uint16(idx) * 2),
-							   res[idx], int32(res[idx]))
+						values	= append(values, int32(res[idx]))
 					}
 				}
+				out.write(o, typeNames[o.op], 2, values)
 			}
 
 		case readFloat32:
@@ -520,14 +576,14 @@ func main() {
 				res, err = client.ReadFloat32s(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
-					fmt.Printf("0x%04x\t%-5v : %f\n",
-						   o.addr + (uint16(idx) * 2),
-						   o.addr + (uint16(idx) * 2),
-						   res[idx])
+					values	= append(values, res[idx])
 				}
+				out.write(o, "float32", 2, values)
 			}
 
 		case readUint64, readInt64:
@@ -539,21 +595,18 @@ func main() {
 				res, err = client.ReadUint64s(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
 					if o.op == readUint64 {
-						fmt.Printf("0x%04x\t%-5v : 0x%016x\t%v\n",
-							   o.addr + (uint16(idx) * 4),
-							   o.addr + (uint16(idx) * 4),
-							   res[idx], res[idx])
+						values	= append(values, res[idx])
 					} else {
-						fmt.Printf("0x%04x\t%-5v : 0x%016x\t%v\n",
-							   o.addr + (uint16(idx) * 4),
-							   o.addr + (uint16(idx) * 4),
-							   res[idx], int64(res[idx]))
+						values	= append(values, int64(res[idx]))
 					}
 				}
+				out.write(o, typeNames[o.op], 4, values)
 			}
 
 		case readFloat64:
@@ -565,14 +618,14 @@ func main() {
 				res, err = client.ReadFloat64s(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
+				var values	[]interface{}
+
 				for idx := range res {
-					fmt.Printf("0x%04x\t%-5v : %f\n",
-						   o.addr + (uint16(idx) * 4),
-						   o.addr + (uint16(idx) * 4),
-						   res[idx])
+					values	= append(values, res[idx])
 				}
+				out.write(o, "float64", 4, values)
 			}
 
 		case readBytes:
@@ -584,121 +637,108 @@ func main() {
 				res, err = client.ReadBytes(o.addr, o.quantity + 1, modbus.INPUT_REGISTER)
 			}
 			if err != nil {
-				fmt.Printf("failed to read holding/input registers: %v\n", err)
+				fmt.Fprintf(out.msg, "failed to read holding/input registers: %v\n", err)
 			} else {
-				for idx := range res {
-					if (idx % 16) == 0 {
-						fmt.Printf("0x%04x\t%-5v : ",
-							o.addr + (uint16(idx/2)), o.addr + (uint16(idx/2)))
-					}
-					fmt.Printf("%02x", res[idx])
-
-					if (idx % 16) == 15 || idx == len(res) - 1 {
-						fmt.Printf(" <%s>\n",
-							decodeString(res[(idx/16*16):(idx/16*16)+(idx%16)+1]))
-					} else if (idx % 16) == 7 {
-						fmt.Printf(" ")
-					}
-				}
+				out.write(o, "bytes", 0, []interface{}{res})
 			}
 
 		case writeCoil:
 			err	= client.WriteCoil(o.addr, o.coil)
 			if err != nil {
-				fmt.Printf("failed to write %v at coil address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at coil address 0x%04x: %v\n",
 					   o.coil, o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at coil address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at coil address 0x%04x\n",
 					   o.coil, o.addr)
 			}
 
 		case writeUint16:
 			err	= client.WriteRegister(o.addr, o.u16)
 			if err != nil {
-				fmt.Printf("failed to write %v at register address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at register address 0x%04x: %v\n",
 					   o.u16, o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at register address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at register address 0x%04x\n",
 					   o.u16, o.addr)
 			}
 
 		case writeInt16:
 			err	= client.WriteRegister(o.addr, o.u16)
 			if err != nil {
-				fmt.Printf("failed to write %v at register address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at register address 0x%04x: %v\n",
 					   int16(o.u16), o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at register address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at register address 0x%04x\n",
 					   int16(o.u16), o.addr)
 			}
 
 		case writeUint32:
 			err	= client.WriteUint32(o.addr, o.u32)
 			if err != nil {
-				fmt.Printf("failed to write %v at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at address 0x%04x: %v\n",
 					   o.u32, o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at address 0x%04x\n",
 					   o.u32, o.addr)
 			}
 
 		case writeInt32:
 			err	= client.WriteUint32(o.addr, o.u32)
 			if err != nil {
-				fmt.Printf("failed to write %v at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at address 0x%04x: %v\n",
 					   int32(o.u32), o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at address 0x%04x\n",
 					   int32(o.u32), o.addr)
 			}
 
 		case writeFloat32:
 			err	= client.WriteFloat32(o.addr, o.f32)
 			if err != nil {
-				fmt.Printf("failed to write %f at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %f at address 0x%04x: %v\n",
 					   o.f32, o.addr, err)
 			} else {
-				fmt.Printf("wrote %f at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %f at address 0x%04x\n",
 					   o.f32, o.addr)
 			}
 
 		case writeUint64:
 			err	= client.WriteUint64(o.addr, o.u64)
 			if err != nil {
-				fmt.Printf("failed to write %v at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at address 0x%04x: %v\n",
 					   o.u64, o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at address 0x%04x\n",
 					   o.u64, o.addr)
 			}
 
 		case writeInt64:
 			err	= client.WriteUint64(o.addr, o.u64)
 			if err != nil {
-				fmt.Printf("failed to write %v at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at address 0x%04x: %v\n",
 					   int64(o.u64), o.addr, err)
 			} else {
-				fmt.Printf("wrote %v at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v at address 0x%04x\n",
 					   int64(o.u64), o.addr)
 			}
 
 		case writeFloat64:
 			err	= client.WriteFloat64(o.addr, o.f64)
 			if err != nil {
-				fmt.Printf("failed to write %f at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %f at address 0x%04x: %v\n",
 					   o.f64, o.addr, err)
 			} else {
-				fmt.Printf("wrote %f at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %f at address 0x%04x\n",
 					   o.f64, o.addr)
 			}
 
 		case writeBytes:
 			err = client.WriteBytes(o.addr, o.bytes)
 			if err != nil {
-				fmt.Printf("failed to write %v at address 0x%04x: %v\n",
+				fmt.Fprintf(out.msg, "failed to write %v at address 0x%04x: %v\n",
 					o.bytes, o.addr, err)
 			} else {
-				fmt.Printf("wrote %v bytes at address 0x%04x\n",
+				fmt.Fprintf(out.msg, "wrote %v bytes at address 0x%04x\n",
 					len(o.bytes), o.addr)
 			}
 
@@ -707,13 +747,20 @@ func main() {
 
 		case setUnitId:
 			client.SetUnitId(o.unitId)
+			out.unitId	= o.unitId
 
 		case repeat:
 			// start over
 			opIdx = -1
 
+		case watch:
+			// start over after a while, only reporting changes from now on
+			time.Sleep(o.duration)
+			out.startPass()
+			opIdx = -1
+
 		case date:
-			fmt.Printf("%s\n", time.Now().Format(time.RFC3339))
+			fmt.Fprintf(out.msg, "%s\n", time.Now().Format(time.RFC3339))
 
 		case scanBools:
 			performBoolScan(client, o.isCoil)
@@ -761,6 +808,7 @@ const (
 	setUnitId
 	sleep
 	repeat
+	watch
 	date
 	scanBools
 	scanRegisters
@@ -785,6 +833,536 @@ type operation struct {
 	unitId       uint8
 }
 
+// Names of the register types read, as reported in json and csv outputs.
+var typeNames = map[uint]string{
+	readUint16:	"uint16",
+	readInt16:	"int16",
+	readUint32:	"uint32",
+	readInt32:	"int32",
+	readUint64:	"uint64",
+	readInt64:	"int64",
+}
+
+// Prints the values read in the requested format and keeps track of their
+// changes, to highlight them (text) or only report them (json, csv) once
+// watching.
+type resultWriter struct {
+	format       string
+	msg          io.Writer          // messages other than values read
+	csv          *csv.Writer
+	json         *json.Encoder
+	unitId       uint8              // unit id the values are read from
+	watching     bool               // set once the first watch pass is over
+	color        bool               // highlight changes with ANSI escape codes
+	last         map[string]string  // last values, by unit/table/address/type
+}
+
+// Value read, as printed in json and csv outputs.
+type readRecord struct {
+	Time         string             `json:"time"`
+	UnitId       uint8              `json:"unit_id"`
+	Table        string             `json:"table"`
+	Addr         uint16             `json:"addr"`
+	Type         string             `json:"type"`
+	Value        interface{}        `json:"value"`
+}
+
+func newResultWriter(format string) (rw *resultWriter, err error) {
+	rw	= &resultWriter{
+		format:	format,
+		msg:	os.Stdout,
+		last:	map[string]string{},
+	}
+
+	switch format {
+	case "text":
+		rw.color	= isTerminal(os.Stdout) &&
+				  os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+
+	case "json":
+		// keep stdout parseable
+		rw.msg		= os.Stderr
+		rw.json		= json.NewEncoder(os.Stdout)
+
+	case "csv":
+		rw.msg		= os.Stderr
+		rw.csv		= csv.NewWriter(os.Stdout)
+		rw.csv.Write([]string{"time", "unit_id", "table", "addr", "type", "value"})
+		rw.csv.Flush()
+
+	default:
+		err	= fmt.Errorf("unknown output format '%s' (should be one of text, json or csv)",
+				     format)
+	}
+
+	return
+}
+
+// Prints the values read by o, width registers apart.
+func (rw *resultWriter) write(o *operation, typ string, width uint16, values []interface{}) {
+	var ts		string
+	var table	string
+
+	ts	= time.Now().Format(time.RFC3339Nano)
+	table	= tableName(o)
+
+	for idx, value := range values {
+		var addr	uint16 = o.addr + uint16(idx) * width
+		var key		string
+		var current	string
+		var previous	string
+		var changed	bool
+
+		key		= fmt.Sprintf("%v/%s/%v/%s", rw.unitId, table, addr, typ)
+		current		= fmt.Sprint(value)
+		previous, changed	= rw.last[key]
+		changed		= !changed || previous != current
+		rw.last[key]	= current
+
+		if rw.format == "text" {
+			for _, line := range textLines(addr, value) {
+				if rw.watching && changed {
+					line	= rw.highlight(line)
+				}
+				fmt.Println(line)
+			}
+			continue
+		}
+
+		// only report changes once watching
+		if rw.watching && !changed {
+			continue
+		}
+
+		if raw, ok := value.([]byte); ok {
+			value	= hex.EncodeToString(raw)
+		}
+
+		if rw.json != nil {
+			rw.json.Encode(&readRecord{
+				Time:	ts,
+				UnitId:	rw.unitId,
+				Table:	table,
+				Addr:	addr,
+				Type:	typ,
+				Value:	value,
+			})
+		} else {
+			rw.csv.Write([]string{
+				ts, fmt.Sprint(rw.unitId), table, fmt.Sprint(addr), typ,
+				fmt.Sprint(value),
+			})
+		}
+	}
+
+	if rw.csv != nil {
+		rw.csv.Flush()
+	}
+
+	return
+}
+
+// Marks the start of a new watch pass.
+func (rw *resultWriter) startPass() {
+	rw.watching	= true
+
+	if rw.format == "text" {
+		fmt.Printf("--- %s\n", time.Now().Format(time.RFC3339))
+	}
+
+	return
+}
+
+func (rw *resultWriter) highlight(line string) (out string) {
+	if rw.color {
+		out	= "\x1b[7m" + line + "\x1b[0m"
+	} else {
+		out	= line + "\t*"
+	}
+
+	return
+}
+
+// Returns the text output lines of a value read at addr.
+func textLines(addr uint16, value interface{}) (lines []string) {
+	var prefix	string = fmt.Sprintf("0x%04x\t%-5v : ", addr, addr)
+
+	switch v := value.(type) {
+	case bool:	lines = []string{prefix + fmt.Sprintf("%v", v)}
+	case uint16:	lines = []string{prefix + fmt.Sprintf("0x%04x\t%v", v, v)}
+	case int16:	lines = []string{prefix + fmt.Sprintf("0x%04x\t%v", uint16(v), v)}
+	case uint32:	lines = []string{prefix + fmt.Sprintf("0x%08x\t%v", v, v)}
+	case int32:	lines = []string{prefix + fmt.Sprintf("0x%08x\t%v", uint32(v), v)}
+	case uint64:	lines = []string{prefix + fmt.Sprintf("0x%016x\t%v", v, v)}
+	case int64:	lines = []string{prefix + fmt.Sprintf("0x%016x\t%v", uint64(v), v)}
+	case float32:	lines = []string{prefix + fmt.Sprintf("%f", v)}
+	case float64:	lines = []string{prefix + fmt.Sprintf("%f", v)}
+	case []byte:
+		// 16 bytes per line, followed by their printable representation
+		for idx := 0; idx < len(v); idx += 16 {
+			var chunk	[]byte = v[idx:]
+			var line	string
+
+			if len(chunk) > 16 {
+				chunk	= chunk[0:16]
+			}
+
+			line	= fmt.Sprintf("0x%04x\t%-5v : ",
+					      addr + uint16(idx/2), addr + uint16(idx/2))
+			for i := range chunk {
+				line	+= fmt.Sprintf("%02x", chunk[i])
+				if i == 7 && len(chunk) > 8 {
+					line	+= " "
+				}
+			}
+			lines	= append(lines, line + fmt.Sprintf(" <%s>", decodeString(chunk)))
+		}
+	}
+
+	return
+}
+
+func tableName(o *operation) (name string) {
+	switch {
+	case o.op == readBools && o.isCoil:	name = "coils"
+	case o.op == readBools:			name = "discrete_inputs"
+	case o.isHoldingReg:			name = "holding_registers"
+	default:				name = "input_registers"
+	}
+
+	return
+}
+
+func isTerminal(f *os.File) (yes bool) {
+	var fi	os.FileInfo
+	var err	error
+
+	fi, err	= f.Stat()
+	yes	= err == nil && fi.Mode() & os.ModeCharDevice != 0
+
+	return
+}
+
+// Loads target profile name from the profiles file at path and applies its
+// options, except those given on the command line.
+// Profiles files are JSON documents mapping profile names to options, e.g.
+// {"profiles": {"plc1": {"target": "tcp://10.0.0.1:502", "unit-id": 3}}}.
+func loadProfile(path string, name string) (err error) {
+	var data	[]byte
+	var conf	struct {
+		Profiles	map[string]map[string]interface{}	`json:"profiles"`
+	}
+	var profile	map[string]interface{}
+	var ok		bool
+	var set		map[string]bool = map[string]bool{}
+
+	data, err	= os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	err	= json.Unmarshal(data, &conf)
+	if err != nil {
+		return
+	}
+
+	profile, ok	= conf.Profiles[name]
+	if !ok {
+		err	= fmt.Errorf("no such profile in %s", path)
+		return
+	}
+
+	flag.Visit(func(f *flag.Flag) {
+		set[f.Name]	= true
+	})
+
+	for option, value := range profile {
+		switch option {
+		case "config", "profile", "help":
+			err	= fmt.Errorf("option '%s' cannot be set by profiles", option)
+			return
+		}
+
+		if set[option] {
+			continue
+		}
+
+		err	= flag.Set(option, fmt.Sprint(value))
+		if err != nil {
+			err	= fmt.Errorf("option '%s': %v", option, err)
+			return
+		}
+	}
+
+	return
+}
+
+// Runs a simulated device (see the serve command in the help message) until
+// interrupted, and returns the exit code.
+func runServer(args []string) (rc int) {
+	var err		error
+	var fs		*flag.FlagSet
+	var listen	string
+	var snapshot	string
+	var save	string
+	var unitIds	string
+	var speed	uint
+	var dataBits	uint
+	var parity	string
+	var stopBits	uint
+	var rs485	bool
+	var timeout	string
+	var maxClients	uint
+	var verbose	bool
+	var dsConf	modbus.DataStoreConfiguration
+	var config	*modbus.ServerConfiguration
+	var ds		*modbus.DataStore
+	var server	*modbus.ModbusServer
+	var sigs	chan os.Signal
+
+	fs	= flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.StringVar(&listen, "listen", "tcp://localhost:502", "where to listen at (e.g. tcp://[::]:502, rtu:///dev/ttyUSB0)")
+	fs.StringVar(&snapshot, "snapshot", "", "register snapshot to load at startup")
+	fs.StringVar(&save, "save", "", "where to save a register snapshot on exit")
+	fs.StringVar(&unitIds, "unit-ids", "1", "comma-separated list of unit ids to serve")
+	fs.UintVar(&speed, "speed", 19200, "serial bus speed in bps (rtu)")
+	fs.UintVar(&dataBits, "data-bits", 8, "number of bits per character on the serial bus (rtu)")
+	fs.StringVar(&parity, "parity", "none", "parity bit <none|even|odd> on the serial bus (rtu)")
+	fs.UintVar(&stopBits, "stop-bits", 2, "number of stop bits <0|1|2>) on the serial bus (rtu)")
+	fs.BoolVar(&rs485, "rs485", false, "let the kernel driver toggle RTS around transmissions (rtu, linux only)")
+	fs.StringVar(&timeout, "timeout", "0s", "idle client timeout (0: default)")
+	fs.UintVar(&maxClients, "max-clients", 0, "max. number of concurrent clients (0: default)")
+	fs.BoolVar(&verbose, "verbose", false, "print changes made by clients")
+	fs.Parse(args)
+
+	for _, id := range strings.Split(unitIds, ",") {
+		var unitId	uint8
+
+		unitId, err	= parseUnitId(strings.TrimSpace(id))
+		if err != nil {
+			fmt.Printf("failed to parse '%s' as unit id: %v\n", id, err)
+			rc	= 2
+			return
+		}
+		dsConf.UnitIds	= append(dsConf.UnitIds, unitId)
+	}
+
+	if verbose {
+		dsConf.OnChange	= func(change *modbus.DataChange) {
+			if change.ClientAddr != "" {
+				fmt.Printf("%s: unit id %v: %s 0x%04x+%v written by %s\n",
+					   time.Now().Format(time.RFC3339), change.UnitId,
+					   storeTableName(change.Table), change.Addr,
+					   change.Quantity - 1, change.ClientAddr)
+			}
+		}
+	}
+
+	config	= &modbus.ServerConfiguration{
+		URL:		listen,
+		Speed:		speed,
+		DataBits:	dataBits,
+		StopBits:	stopBits,
+		RS485:		rs485Config(rs485),
+		MaxClients:	maxClients,
+	}
+
+	switch parity {
+	case "none":	config.Parity	= modbus.PARITY_NONE
+	case "odd":	config.Parity	= modbus.PARITY_ODD
+	case "even":	config.Parity	= modbus.PARITY_EVEN
+	default:
+		fmt.Printf("unknown parity setting '%s' (should be one of none, odd or even)\n",
+	                   parity)
+		rc	= 1
+		return
+	}
+
+	config.Timeout, err = time.ParseDuration(timeout)
+	if err != nil {
+		fmt.Printf("failed to parse timeout setting '%s': %v\n", timeout, err)
+		rc	= 1
+		return
+	}
+
+	ds, err	= modbus.NewDataStore(&dsConf)
+	if err != nil {
+		fmt.Printf("failed to create data store: %v\n", err)
+		rc	= 1
+		return
+	}
+
+	if snapshot != "" {
+		err	= ds.LoadSnapshot(snapshot)
+		if err != nil {
+			fmt.Printf("failed to load snapshot: %v\n", err)
+			rc	= 1
+			return
+		}
+	}
+
+	server, err	= modbus.NewServer(config, ds)
+	if err != nil {
+		fmt.Printf("failed to create server: %v\n", err)
+		rc	= 1
+		return
+	}
+
+	err	= server.Start()
+	if err != nil {
+		fmt.Printf("failed to start server: %v\n", err)
+		rc	= 1
+		return
+	}
+
+	fmt.Printf("serving unit ids %v on %s\n", ds.UnitIds(), listen)
+
+	// serve until interrupted
+	sigs	= make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	<-sigs
+
+	server.Stop()
+
+	if save != "" {
+		err	= ds.SaveSnapshot(save)
+		if err != nil {
+			fmt.Printf("failed to save snapshot: %v\n", err)
+			rc	= 1
+			return
+		}
+	}
+
+	return
+}
+
+// Lists serial ports and/or detects the line settings of the devices attached
+// to them (see the detect command in the help message), and returns the exit
+// code.
+func runDetect(args []string) (rc int) {
+	var err		error
+	var fs		*flag.FlagSet
+	var unitId	uint
+	var timeout	string
+	var speeds	string
+	var rs485	bool
+	var list	bool
+	var devices	[]string
+	var ports	[]*serialport.PortInfo
+	var conf	*serialport.DetectConfiguration
+	var settings	*serialport.Settings
+	var parity	string
+
+	fs	= flag.NewFlagSet("detect", flag.ExitOnError)
+	fs.UintVar(&unitId, "unit-id", 1, "unit/slave id to probe")
+	fs.StringVar(&timeout, "timeout", "200ms", "how long to wait for a response to each probe")
+	fs.StringVar(&speeds, "speeds", "", "comma-separated list of speeds to try (default: common speeds)")
+	fs.BoolVar(&rs485, "rs485", false, "let the kernel driver toggle RTS around transmissions (linux only)")
+	fs.BoolVar(&list, "list", false, "only list serial ports")
+	fs.Parse(args)
+
+	if unitId == 0 || unitId > 247 {
+		fmt.Printf("unit id should be between 1 and 247\n")
+		rc	= 2
+		return
+	}
+
+	conf	= &serialport.DetectConfiguration{
+		UnitId:	uint8(unitId),
+		RS485:	rs485Config(rs485),
+		// keep probe timeouts out of the output
+		Logger:	log.New(io.Discard, "", 0),
+	}
+
+	conf.Timeout, err	= time.ParseDuration(timeout)
+	if err != nil {
+		fmt.Printf("failed to parse timeout setting '%s': %v\n", timeout, err)
+		rc	= 2
+		return
+	}
+
+	if speeds != "" {
+		for _, s := range strings.Split(speeds, ",") {
+			var speed	uint64
+
+			speed, err	= strconv.ParseUint(strings.TrimSpace(s), 0, 32)
+			if err != nil || speed == 0 {
+				fmt.Printf("failed to parse '%s' as speed\n", s)
+				rc	= 2
+				return
+			}
+			conf.Speeds	= append(conf.Speeds, uint(speed))
+		}
+	}
+
+	devices	= fs.Args()
+	if len(devices) == 0 {
+		ports, err	= serialport.ListPorts()
+		if err != nil {
+			fmt.Printf("failed to list serial ports: %v\n", err)
+			rc	= 1
+			return
+		}
+
+		for _, port := range ports {
+			fmt.Printf("%s\tdriver: %s\t%s\n", port.Path, port.Driver, port.ID)
+			devices	= append(devices, port.Path)
+		}
+
+		if len(ports) == 0 {
+			fmt.Printf("no serial port found\n")
+		}
+	}
+
+	if list {
+		return
+	}
+
+	for _, device := range devices {
+		fmt.Printf("%s: probing unit id %v...\n", device, unitId)
+
+		settings, err	= serialport.Detect(device, conf)
+		if err != nil {
+			fmt.Printf("%s: %v\n", device, err)
+			rc	= 1
+			continue
+		}
+
+		switch settings.Parity {
+		case modbus.PARITY_EVEN:	parity	= "even"
+		case modbus.PARITY_ODD:		parity	= "odd"
+		default:			parity	= "none"
+		}
+
+		fmt.Printf("%s: detected %s, use: --target %s --speed %v --data-bits %v --parity %s --stop-bits %v\n",
+			   device, settings, settings.URL(), settings.Speed, settings.DataBits,
+			   parity, settings.StopBits)
+	}
+
+	return
+}
+
+// Returns the RS-485 settings of the --rs485 option (RTS high while sending).
+func rs485Config(enabled bool) (conf modbus.RS485Config) {
+	if enabled {
+		conf.Enabled		= true
+		conf.RtsHighDuringSend	= true
+	}
+
+	return
+}
+
+func storeTableName(table modbus.Table) (name string) {
+	switch table {
+	case modbus.TABLE_COILS:		name = "coils"
+	case modbus.TABLE_DISCRETE_INPUTS:	name = "discrete inputs"
+	case modbus.TABLE_HOLDING_REGISTERS:	name = "holding registers"
+	default:				name = "input registers"
+	}
+
+	return
+}
+
 func parseUint16(in string) (u16 uint16, err error) {
 	var val uint64
 
@@ -1210,6 +1788,14 @@ Available commands:
   rh:uint32:100 sleep:1s repeat  reads a 32-bit unsigned integer at addresses 100-101 and
                                  pauses for one second, forever in a loop.
 
+* watch[:interval]
+  Restart execution of the given commands after [interval] (1s if not specified), forever.
+  Values which changed since the previous pass are highlighted (text output) or are the only
+  ones printed (json and csv outputs).
+
+  rh:uint16:0+9 rc:0+15 watch:500ms  polls 10 holding registers and 16 coils twice a second
+                                     and shows what changes.
+
 * date
   Print the current date and time (can be useful for long-running scripts).
 
@@ -1248,10 +1834,42 @@ Register endianness and word order:
   float64), the word order can be set with --word-order <highfirst|lowfirst> and arbitrarily
   defaults to highfirst (i.e. most significant word first).
 
+Output formats:
+  Values read are printed as text by default. With --output json, each value is printed as a
+  JSON object (one per line) with time, unit_id, table, addr, type and value fields. With
+  --output csv, values are printed as CSV rows with the same columns, after a header row.
+  In both cases, other messages (e.g. write confirmations and errors) go to stderr.
+
+Target profiles:
+  Target settings can be stored as named profiles in a JSON file (modbus-cli.json in the
+  current directory or the file given with --config), mapping option names to values:
+    {"profiles": {"plc1": {"target": "rtu:///dev/ttyUSB0", "speed": 9600, "parity": "even",
+                           "unit-id": 3, "endianness": "little", "word-order": "lf"}}}
+  and loaded with --profile <name>. Options given on the command line override the profile.
+
+Simulated devices:
+  $ modbus-cli serve [--listen <url>] [--snapshot <file>] [--save <file>] [--unit-ids <ids>]
+  Serves in-memory registers (unit ids 1 by default) on <url> (tcp://localhost:502 by default,
+  any server scheme listed below), optionally loaded from a data store snapshot and saved back
+  on exit (SIGINT/SIGTERM), e.g. to run fixtures in scripts and CI jobs. Snapshots are JSON
+  documents such as:
+    {"units": {"1": {"coils": {"3": true}, "holding_registers": {"0x10": 4660}}}}
+  See modbus-cli serve --help for all options.
+
+Serial port discovery:
+  $ modbus-cli detect [--unit-id <id>] [--timeout <duration>] [--speeds <list>] [--list] [device...]
+  Lists serial ports (linux only) when no device is given, then probes unit <id> (1 by default)
+  on each device with common speeds and framings (8E1, 8N2, 8N1 and 8O1) until it answers, and
+  prints the options to use with the detected settings. Use --list to only list serial ports.
+  With --rs485 (here or with other commands), the kernel driver toggles RTS around
+  transmissions to drive RS-485 transceivers, where supported.
+
 Supported transports and associated target schemes:
   - Modbus RTU using a local serial device:               rtu:///path/to/device
   - Modbus RTU over TCP (RTU framing over a TCP socket):  rtuovertcp://host:port
   - Modbus RTU over UDP (RTU framing over an UDP socket): rtuoverudp://host:port
+  - Modbus ASCII using a local serial device:             ascii:///path/to/device
+  - Modbus ASCII over TCP (ASCII framing over TCP):       asciiovertcp://host:port
   - Modbus TCP (MBAP):                                    tcp://host:port
   - Modbus TCP over TLS (MBAPS or Modbus Security):       tcp+tls://host:port
   - Modbus TCP over UDP (MBAP over UDP):                  udp://host:port
@@ -1282,6 +1900,10 @@ Examples:
   the server, then read holding registers 0x3000-0x3001 as a 32-bit unsigned integer.
   Note that ca.cert.pem can either be a CA (Certificate Authority) or the server (leaf)
   certificate.
+
+  $ modbus-cli --profile plc1 --output csv ri:float32:0+3 watch:10s >> plc1.csv
+  Load target settings from the plc1 profile of modbus-cli.json, then read 4 32-bit floats
+  from input registers 0-7 every 10 seconds, appending changed values to plc1.csv.
 `)
 
 	return
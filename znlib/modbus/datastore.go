@@ -0,0 +1,873 @@
+package modbus
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+type Table uint
+type Access uint
+const (
+	// data store tables
+	TABLE_COILS             Table = 1
+	TABLE_DISCRETE_INPUTS   Table = 2
+	TABLE_HOLDING_REGISTERS Table = 3
+	TABLE_INPUT_REGISTERS   Table = 4
+
+	// access rights
+	ACCESS_NONE             Access = 0
+	ACCESS_READ             Access = 1
+	ACCESS_WRITE            Access = 2
+	ACCESS_READ_WRITE       Access = ACCESS_READ | ACCESS_WRITE
+
+	defaultTableSize        uint32 = 0x10000
+)
+
+// Data store configuration object.
+type DataStoreConfiguration struct {
+	// UnitIds sets the unit ids served by the store (defaults to unit id 1).
+	// More units can be added later on with AddUnit().
+	UnitIds          []uint8
+	// Coils, DiscreteInputs, HoldingRegisters and InputRegisters set the
+	// number of entries of each table, per unit (default to 65536)
+	Coils            uint32
+	DiscreteInputs   uint32
+	HoldingRegisters uint32
+	InputRegisters   uint32
+	// Endianness and WordOrder set the encoding used by the typed accessors
+	// (default to BIG_ENDIAN and HIGH_WORD_FIRST)
+	Endianness       Endianness
+	WordOrder        WordOrder
+	// Rules restricts client access to the store per client role, client
+	// address, unit id and address range. They are evaluated as by an
+	// ACLHandler (see ACLRule): the first matching rule decides, access is
+	// denied if none does. When empty, clients may read all tables and
+	// write coils and holding registers.
+	Rules            []ACLRule
+	// OnChange is called whenever values are changed, either by clients or
+	// through the store accessors (see DataChange).
+	OnChange         func(change *DataChange)
+}
+
+// Data change, as passed to the change hook.
+type DataChange struct {
+	UnitId     uint8   // the unit id whose data changed
+	Table      Table   // the table which changed
+	Addr       uint16  // the first address written
+	Quantity   uint16  // the number of addresses written
+	ClientAddr string  // the client address ("" for local changes)
+	ClientRole string  // the client role (tcp+tls only)
+}
+
+// In-memory data store, implementing RequestHandler. It can be passed as-is
+// to NewServer() to simulate devices or serve a simple register map.
+// Broadcast writes (unit id 0) are applied to all units, broadcast reads are
+// rejected.
+type DataStore struct {
+	conf          DataStoreConfiguration
+	lock          sync.RWMutex
+	units         map[uint8]*dataUnit
+	acl           *ACLHandler  // enforces Rules (nil without rules)
+}
+
+// Tables of a single unit.
+type dataUnit struct {
+	coils         []bool
+	discreteInput []bool
+	holding       []uint16
+	input         []uint16
+}
+
+// Data store snapshot, as saved to JSON. Only non-zero values are saved,
+// keyed by address.
+type dataStoreSnapshot struct {
+	Units map[string]*dataUnitSnapshot `json:"units"`
+}
+
+type dataUnitSnapshot struct {
+	Coils            map[string]bool   `json:"coils,omitempty"`
+	DiscreteInputs   map[string]bool   `json:"discrete_inputs,omitempty"`
+	HoldingRegisters map[string]uint16 `json:"holding_registers,omitempty"`
+	InputRegisters   map[string]uint16 `json:"input_registers,omitempty"`
+}
+
+// NewDataStore returns a new, zeroed data store.
+func NewDataStore(conf *DataStoreConfiguration) (ds *DataStore, err error) {
+	ds	= &DataStore{
+		conf:	*conf,
+		units:	map[uint8]*dataUnit{},
+	}
+
+	for _, size := range []*uint32{
+		&ds.conf.Coils, &ds.conf.DiscreteInputs,
+		&ds.conf.HoldingRegisters, &ds.conf.InputRegisters} {
+		if *size == 0 {
+			*size	= defaultTableSize
+		}
+
+		if *size > defaultTableSize {
+			err	= ErrConfigurationError
+			ds	= nil
+			return
+		}
+	}
+
+	if ds.conf.Endianness == 0 {
+		ds.conf.Endianness	= BIG_ENDIAN
+	}
+
+	if ds.conf.WordOrder == 0 {
+		ds.conf.WordOrder	= HIGH_WORD_FIRST
+	}
+
+	if len(ds.conf.UnitIds) == 0 {
+		ds.conf.UnitIds	= []uint8{1}
+	}
+
+	if len(ds.conf.Rules) > 0 {
+		ds.acl, err	= NewACLHandler(&ACLConfiguration{
+			Rules:	ds.conf.Rules,
+		}, ds)
+		if err != nil {
+			ds	= nil
+			return
+		}
+	}
+
+	for _, unitId := range ds.conf.UnitIds {
+		ds.AddUnit(unitId)
+	}
+
+	return
+}
+
+// Adds a zeroed unit to the store, if not already present.
+func (ds *DataStore) AddUnit(unitId uint8) {
+	ds.lock.Lock()
+	defer ds.lock.Unlock()
+
+	ds.addUnit(unitId)
+
+	return
+}
+
+// Returns the unit ids served by the store, in ascending order.
+func (ds *DataStore) UnitIds() (unitIds []uint8) {
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	for unitId := range ds.units {
+		unitIds	= append(unitIds, unitId)
+	}
+	sort.Slice(unitIds, func(i, j int) bool { return unitIds[i] < unitIds[j] })
+
+	return
+}
+
+/*** RequestHandler methods ***/
+// Coil handler method.
+func (ds *DataStore) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	var access	Access = ACCESS_READ
+
+	if req.IsWrite {
+		access	= ACCESS_WRITE
+	}
+
+	err	= ds.authorize(req.ClientAddr, req.ClientRole, req.UnitId, TABLE_COILS, req.Addr, req.Quantity, access)
+	if err != nil {
+		return
+	}
+
+	if req.IsWrite && req.UnitId == 0 {
+		err	= ds.broadcast(func(unitId uint8) error {
+			return ds.setBools(unitId, TABLE_COILS, req.Addr, req.Args,
+					   req.ClientAddr, req.ClientRole)
+		})
+		if err == nil {
+			res	= req.Args
+		}
+		return
+	}
+
+	if req.IsWrite {
+		err	= ds.setBools(req.UnitId, TABLE_COILS, req.Addr, req.Args,
+				      req.ClientAddr, req.ClientRole)
+		if err != nil {
+			return
+		}
+	}
+
+	res, err	= ds.bools(req.UnitId, TABLE_COILS, req.Addr, req.Quantity)
+
+	return
+}
+
+// Discrete input handler method.
+func (ds *DataStore) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	err	= ds.authorize(req.ClientAddr, req.ClientRole, req.UnitId, TABLE_DISCRETE_INPUTS,
+			       req.Addr, req.Quantity, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= ds.bools(req.UnitId, TABLE_DISCRETE_INPUTS, req.Addr, req.Quantity)
+
+	return
+}
+
+// Holding register handler method.
+func (ds *DataStore) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	var access	Access = ACCESS_READ
+
+	if req.IsWrite {
+		access	= ACCESS_WRITE
+	}
+
+	err	= ds.authorize(req.ClientAddr, req.ClientRole, req.UnitId, TABLE_HOLDING_REGISTERS,
+			       req.Addr, req.Quantity, access)
+	if err != nil {
+		return
+	}
+
+	if req.IsWrite && req.UnitId == 0 {
+		err	= ds.broadcast(func(unitId uint8) error {
+			return ds.setRegisters(unitId, TABLE_HOLDING_REGISTERS, req.Addr, req.Args,
+					       req.ClientAddr, req.ClientRole)
+		})
+		if err == nil {
+			res	= req.Args
+		}
+		return
+	}
+
+	if req.IsWrite {
+		err	= ds.setRegisters(req.UnitId, TABLE_HOLDING_REGISTERS, req.Addr, req.Args,
+					  req.ClientAddr, req.ClientRole)
+		if err != nil {
+			return
+		}
+	}
+
+	res, err	= ds.registers(req.UnitId, TABLE_HOLDING_REGISTERS, req.Addr, req.Quantity)
+
+	return
+}
+
+// Input register handler method.
+func (ds *DataStore) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	err	= ds.authorize(req.ClientAddr, req.ClientRole, req.UnitId, TABLE_INPUT_REGISTERS,
+			       req.Addr, req.Quantity, ACCESS_READ)
+	if err != nil {
+		return
+	}
+
+	res, err	= ds.registers(req.UnitId, TABLE_INPUT_REGISTERS, req.Addr, req.Quantity)
+
+	return
+}
+
+/*** typed accessors ***/
+// Reads quantity coils.
+func (ds *DataStore) Coils(unitId uint8, addr uint16, quantity uint16) (values []bool, err error) {
+	values, err	= ds.bools(unitId, TABLE_COILS, addr, quantity)
+
+	return
+}
+
+// Reads a single coil.
+func (ds *DataStore) Coil(unitId uint8, addr uint16) (value bool, err error) {
+	var values	[]bool
+
+	values, err	= ds.bools(unitId, TABLE_COILS, addr, 1)
+	if err == nil {
+		value	= values[0]
+	}
+
+	return
+}
+
+// Writes multiple coils.
+func (ds *DataStore) SetCoils(unitId uint8, addr uint16, values []bool) (err error) {
+	err	= ds.setBools(unitId, TABLE_COILS, addr, values, "", "")
+
+	return
+}
+
+// Writes a single coil.
+func (ds *DataStore) SetCoil(unitId uint8, addr uint16, value bool) (err error) {
+	err	= ds.setBools(unitId, TABLE_COILS, addr, []bool{value}, "", "")
+
+	return
+}
+
+// Reads quantity discrete inputs.
+func (ds *DataStore) DiscreteInputs(unitId uint8, addr uint16, quantity uint16) (values []bool, err error) {
+	values, err	= ds.bools(unitId, TABLE_DISCRETE_INPUTS, addr, quantity)
+
+	return
+}
+
+// Reads a single discrete input.
+func (ds *DataStore) DiscreteInput(unitId uint8, addr uint16) (value bool, err error) {
+	var values	[]bool
+
+	values, err	= ds.bools(unitId, TABLE_DISCRETE_INPUTS, addr, 1)
+	if err == nil {
+		value	= values[0]
+	}
+
+	return
+}
+
+// Writes multiple discrete inputs.
+func (ds *DataStore) SetDiscreteInputs(unitId uint8, addr uint16, values []bool) (err error) {
+	err	= ds.setBools(unitId, TABLE_DISCRETE_INPUTS, addr, values, "", "")
+
+	return
+}
+
+// Writes a single discrete input.
+func (ds *DataStore) SetDiscreteInput(unitId uint8, addr uint16, value bool) (err error) {
+	err	= ds.setBools(unitId, TABLE_DISCRETE_INPUTS, addr, []bool{value}, "", "")
+
+	return
+}
+
+// Reads quantity 16-bit registers.
+func (ds *DataStore) Registers(unitId uint8, regType RegType, addr uint16, quantity uint16) (values []uint16, err error) {
+	values, err	= ds.registers(unitId, registerTable(regType), addr, quantity)
+
+	return
+}
+
+// Reads a single 16-bit register.
+func (ds *DataStore) Register(unitId uint8, regType RegType, addr uint16) (value uint16, err error) {
+	var values	[]uint16
+
+	values, err	= ds.registers(unitId, registerTable(regType), addr, 1)
+	if err == nil {
+		value	= values[0]
+	}
+
+	return
+}
+
+// Writes multiple 16-bit registers.
+func (ds *DataStore) SetRegisters(unitId uint8, regType RegType, addr uint16, values []uint16) (err error) {
+	err	= ds.setRegisters(unitId, registerTable(regType), addr, values, "", "")
+
+	return
+}
+
+// Writes a single 16-bit register.
+func (ds *DataStore) SetRegister(unitId uint8, regType RegType, addr uint16, value uint16) (err error) {
+	err	= ds.setRegisters(unitId, registerTable(regType), addr, []uint16{value}, "", "")
+
+	return
+}
+
+// Reads a 32-bit unsigned integer held in 2 consecutive registers.
+func (ds *DataStore) Uint32(unitId uint8, regType RegType, addr uint16) (value uint32, err error) {
+	var raw	[]byte
+
+	raw, err	= ds.rawBytes(unitId, regType, addr, 2)
+	if err == nil {
+		value	= bytesToUint32s(ds.conf.Endianness, ds.conf.WordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Writes a 32-bit unsigned integer to 2 consecutive registers.
+func (ds *DataStore) SetUint32(unitId uint8, regType RegType, addr uint16, value uint32) (err error) {
+	err	= ds.setRawBytes(unitId, regType, addr,
+				 uint32ToBytes(ds.conf.Endianness, ds.conf.WordOrder, value))
+
+	return
+}
+
+// Reads a 32-bit float held in 2 consecutive registers.
+func (ds *DataStore) Float32(unitId uint8, regType RegType, addr uint16) (value float32, err error) {
+	var raw	[]byte
+
+	raw, err	= ds.rawBytes(unitId, regType, addr, 2)
+	if err == nil {
+		value	= bytesToFloat32s(ds.conf.Endianness, ds.conf.WordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Writes a 32-bit float to 2 consecutive registers.
+func (ds *DataStore) SetFloat32(unitId uint8, regType RegType, addr uint16, value float32) (err error) {
+	err	= ds.setRawBytes(unitId, regType, addr,
+				 float32ToBytes(ds.conf.Endianness, ds.conf.WordOrder, value))
+
+	return
+}
+
+// Reads a 64-bit unsigned integer held in 4 consecutive registers.
+func (ds *DataStore) Uint64(unitId uint8, regType RegType, addr uint16) (value uint64, err error) {
+	var raw	[]byte
+
+	raw, err	= ds.rawBytes(unitId, regType, addr, 4)
+	if err == nil {
+		value	= bytesToUint64s(ds.conf.Endianness, ds.conf.WordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Writes a 64-bit unsigned integer to 4 consecutive registers.
+func (ds *DataStore) SetUint64(unitId uint8, regType RegType, addr uint16, value uint64) (err error) {
+	err	= ds.setRawBytes(unitId, regType, addr,
+				 uint64ToBytes(ds.conf.Endianness, ds.conf.WordOrder, value))
+
+	return
+}
+
+// Reads a 64-bit float held in 4 consecutive registers.
+func (ds *DataStore) Float64(unitId uint8, regType RegType, addr uint16) (value float64, err error) {
+	var raw	[]byte
+
+	raw, err	= ds.rawBytes(unitId, regType, addr, 4)
+	if err == nil {
+		value	= bytesToFloat64s(ds.conf.Endianness, ds.conf.WordOrder, raw)[0]
+	}
+
+	return
+}
+
+// Writes a 64-bit float to 4 consecutive registers.
+func (ds *DataStore) SetFloat64(unitId uint8, regType RegType, addr uint16, value float64) (err error) {
+	err	= ds.setRawBytes(unitId, regType, addr,
+				 float64ToBytes(ds.conf.Endianness, ds.conf.WordOrder, value))
+
+	return
+}
+
+/*** snapshots ***/
+// Returns a JSON snapshot of the non-zero values of all units.
+func (ds *DataStore) Snapshot() (data []byte, err error) {
+	var snap	dataStoreSnapshot
+	var us		*dataUnitSnapshot
+
+	ds.lock.RLock()
+	snap.Units	= map[string]*dataUnitSnapshot{}
+	for unitId, unit := range ds.units {
+		us	= &dataUnitSnapshot{
+			Coils:			sparseBools(unit.coils),
+			DiscreteInputs:		sparseBools(unit.discreteInput),
+			HoldingRegisters:	sparseRegisters(unit.holding),
+			InputRegisters:		sparseRegisters(unit.input),
+		}
+		snap.Units[strconv.Itoa(int(unitId))]	= us
+	}
+	ds.lock.RUnlock()
+
+	data, err	= json.MarshalIndent(&snap, "", "  ")
+
+	return
+}
+
+// Restores a JSON snapshot, as returned by Snapshot(). Units found in the
+// snapshot are reset then loaded (and created if needed), other units are
+// left untouched. Change hooks are not called.
+func (ds *DataStore) Restore(data []byte) (err error) {
+	var snap	dataStoreSnapshot
+	var units	map[uint8]*dataUnit
+	var unitId	uint64
+
+	err	= json.Unmarshal(data, &snap)
+	if err != nil {
+		return
+	}
+
+	// decode everything before touching the store so that a bad snapshot
+	// leaves it untouched
+	units	= map[uint8]*dataUnit{}
+	for id, us := range snap.Units {
+		unitId, err	= strconv.ParseUint(id, 10, 8)
+		if err != nil {
+			err	= fmt.Errorf("%w: bad unit id '%s'", ErrConfigurationError, id)
+			return
+		}
+
+		units[uint8(unitId)]	= ds.newUnit()
+		err	= units[uint8(unitId)].load(us)
+		if err != nil {
+			err	= fmt.Errorf("%w: unit %v: %v", ErrConfigurationError, id, err)
+			return
+		}
+	}
+
+	ds.lock.Lock()
+	for unitId, unit := range units {
+		ds.units[unitId]	= unit
+	}
+	ds.lock.Unlock()
+
+	return
+}
+
+// Saves a JSON snapshot of the store to a file.
+func (ds *DataStore) SaveSnapshot(path string) (err error) {
+	var data	[]byte
+
+	data, err	= ds.Snapshot()
+	if err != nil {
+		return
+	}
+
+	err	= os.WriteFile(path, data, 0644)
+
+	return
+}
+
+// Restores a JSON snapshot from a file.
+func (ds *DataStore) LoadSnapshot(path string) (err error) {
+	var data	[]byte
+
+	data, err	= os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	err	= ds.Restore(data)
+
+	return
+}
+
+/*** unexported methods ***/
+// Returns a zeroed unit. Must be called with the lock held when adding
+// the unit to the store.
+func (ds *DataStore) newUnit() (unit *dataUnit) {
+	unit	= &dataUnit{
+		coils:		make([]bool, ds.conf.Coils),
+		discreteInput:	make([]bool, ds.conf.DiscreteInputs),
+		holding:	make([]uint16, ds.conf.HoldingRegisters),
+		input:		make([]uint16, ds.conf.InputRegisters),
+	}
+
+	return
+}
+
+// Adds a zeroed unit. Must be called with the lock held.
+func (ds *DataStore) addUnit(unitId uint8) {
+	if ds.units[unitId] == nil {
+		ds.units[unitId]	= ds.newUnit()
+	}
+
+	return
+}
+
+// Checks that the client is allowed access to a range of a table.
+func (ds *DataStore) authorize(clientAddr string, role string, unitId uint8, table Table,
+	addr uint16, quantity uint16, access Access) (err error) {
+	// reads cannot be broadcast
+	if unitId == 0 && access & ACCESS_READ != 0 {
+		err	= ErrIllegalFunction
+		return
+	}
+
+	// read-only tables
+	if access & ACCESS_WRITE != 0 &&
+	   (table == TABLE_DISCRETE_INPUTS || table == TABLE_INPUT_REGISTERS) {
+		err	= ErrIllegalFunction
+		return
+	}
+
+	if ds.acl != nil {
+		err	= ds.acl.authorize(clientAddr, role, unitId, table, addr, quantity, access)
+	}
+
+	return
+}
+
+// Applies a broadcast (unit id 0) write to every unit of the store, as all
+// slaves of a bus do.
+func (ds *DataStore) broadcast(write func(unitId uint8) error) (err error) {
+	for _, unitId := range ds.UnitIds() {
+		err	= write(unitId)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Returns the unit and bool table for unitId, table.
+func (ds *DataStore) boolTable(unitId uint8, table Table) (values []bool, err error) {
+	var unit	*dataUnit
+
+	unit	= ds.units[unitId]
+	if unit == nil {
+		err	= ErrBadUnitId
+		return
+	}
+
+	switch table {
+	case TABLE_COILS:		values = unit.coils
+	case TABLE_DISCRETE_INPUTS:	values = unit.discreteInput
+	default:			err = ErrUnexpectedParameters
+	}
+
+	return
+}
+
+// Returns the register table for unitId, table.
+func (ds *DataStore) registerTable(unitId uint8, table Table) (values []uint16, err error) {
+	var unit	*dataUnit
+
+	unit	= ds.units[unitId]
+	if unit == nil {
+		err	= ErrBadUnitId
+		return
+	}
+
+	switch table {
+	case TABLE_HOLDING_REGISTERS:	values = unit.holding
+	case TABLE_INPUT_REGISTERS:	values = unit.input
+	default:			err = ErrUnexpectedParameters
+	}
+
+	return
+}
+
+// Reads quantity bools from a table.
+func (ds *DataStore) bools(unitId uint8, table Table, addr uint16, quantity uint16) (values []bool, err error) {
+	var tbl	[]bool
+
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	tbl, err	= ds.boolTable(unitId, table)
+	if err != nil {
+		return
+	}
+
+	if int(addr) + int(quantity) > len(tbl) {
+		err	= ErrIllegalDataAddress
+		return
+	}
+
+	values	= make([]bool, quantity)
+	copy(values, tbl[addr:])
+
+	return
+}
+
+// Writes bools to a table and calls the change hook if any value changed.
+func (ds *DataStore) setBools(unitId uint8, table Table, addr uint16, values []bool,
+	clientAddr string, clientRole string) (err error) {
+	var tbl		[]bool
+	var changed	bool
+
+	ds.lock.Lock()
+	tbl, err	= ds.boolTable(unitId, table)
+	if err == nil && int(addr) + len(values) > len(tbl) {
+		err	= ErrIllegalDataAddress
+	}
+
+	if err == nil {
+		for i, value := range values {
+			if tbl[int(addr) + i] != value {
+				tbl[int(addr) + i]	= value
+				changed			= true
+			}
+		}
+	}
+	ds.lock.Unlock()
+
+	if changed {
+		ds.notify(unitId, table, addr, len(values), clientAddr, clientRole)
+	}
+
+	return
+}
+
+// Reads quantity registers from a table.
+func (ds *DataStore) registers(unitId uint8, table Table, addr uint16, quantity uint16) (values []uint16, err error) {
+	var tbl	[]uint16
+
+	ds.lock.RLock()
+	defer ds.lock.RUnlock()
+
+	tbl, err	= ds.registerTable(unitId, table)
+	if err != nil {
+		return
+	}
+
+	if int(addr) + int(quantity) > len(tbl) {
+		err	= ErrIllegalDataAddress
+		return
+	}
+
+	values	= make([]uint16, quantity)
+	copy(values, tbl[addr:])
+
+	return
+}
+
+// Writes registers to a table and calls the change hook if any value changed.
+func (ds *DataStore) setRegisters(unitId uint8, table Table, addr uint16, values []uint16,
+	clientAddr string, clientRole string) (err error) {
+	var tbl		[]uint16
+	var changed	bool
+
+	ds.lock.Lock()
+	tbl, err	= ds.registerTable(unitId, table)
+	if err == nil && int(addr) + len(values) > len(tbl) {
+		err	= ErrIllegalDataAddress
+	}
+
+	if err == nil {
+		for i, value := range values {
+			if tbl[int(addr) + i] != value {
+				tbl[int(addr) + i]	= value
+				changed			= true
+			}
+		}
+	}
+	ds.lock.Unlock()
+
+	if changed {
+		ds.notify(unitId, table, addr, len(values), clientAddr, clientRole)
+	}
+
+	return
+}
+
+// Reads quantity registers as wire (big endian) bytes.
+func (ds *DataStore) rawBytes(unitId uint8, regType RegType, addr uint16, quantity uint16) (raw []byte, err error) {
+	var values	[]uint16
+
+	values, err	= ds.registers(unitId, registerTable(regType), addr, quantity)
+	if err == nil {
+		raw	= uint16sToBytes(BIG_ENDIAN, values)
+	}
+
+	return
+}
+
+// Writes wire (big endian) bytes to registers.
+func (ds *DataStore) setRawBytes(unitId uint8, regType RegType, addr uint16, raw []byte) (err error) {
+	err	= ds.setRegisters(unitId, registerTable(regType), addr,
+				  bytesToUint16s(BIG_ENDIAN, raw), "", "")
+
+	return
+}
+
+// Calls the change hook, if any.
+func (ds *DataStore) notify(unitId uint8, table Table, addr uint16, quantity int,
+	clientAddr string, clientRole string) {
+	if ds.conf.OnChange == nil {
+		return
+	}
+
+	ds.conf.OnChange(&DataChange{
+		UnitId:		unitId,
+		Table:		table,
+		Addr:		addr,
+		Quantity:	uint16(quantity),
+		ClientAddr:	clientAddr,
+		ClientRole:	clientRole,
+	})
+
+	return
+}
+
+// Loads a unit snapshot.
+func (unit *dataUnit) load(us *dataUnitSnapshot) (err error) {
+	if us == nil {
+		return
+	}
+
+	err	= loadBools(unit.coils, us.Coils)
+	if err == nil {
+		err	= loadBools(unit.discreteInput, us.DiscreteInputs)
+	}
+	if err == nil {
+		err	= loadRegisters(unit.holding, us.HoldingRegisters)
+	}
+	if err == nil {
+		err	= loadRegisters(unit.input, us.InputRegisters)
+	}
+
+	return
+}
+
+// Maps a register type to a table.
+func registerTable(regType RegType) (table Table) {
+	table	= TABLE_HOLDING_REGISTERS
+	if regType == INPUT_REGISTER {
+		table	= TABLE_INPUT_REGISTERS
+	}
+
+	return
+}
+
+// Returns the set (true) values of tbl, keyed by address.
+func sparseBools(tbl []bool) (values map[string]bool) {
+	for addr, value := range tbl {
+		if value {
+			if values == nil {
+				values	= map[string]bool{}
+			}
+			values[strconv.Itoa(addr)]	= true
+		}
+	}
+
+	return
+}
+
+// Returns the non-zero values of tbl, keyed by address.
+func sparseRegisters(tbl []uint16) (values map[string]uint16) {
+	for addr, value := range tbl {
+		if value != 0 {
+			if values == nil {
+				values	= map[string]uint16{}
+			}
+			values[strconv.Itoa(addr)]	= value
+		}
+	}
+
+	return
+}
+
+// Loads values keyed by address into tbl.
+func loadBools(tbl []bool, values map[string]bool) (err error) {
+	var addr	uint64
+
+	for key, value := range values {
+		addr, err	= strconv.ParseUint(key, 0, 16)
+		if err != nil || int(addr) >= len(tbl) {
+			err	= fmt.Errorf("bad address '%s'", key)
+			return
+		}
+		tbl[addr]	= value
+	}
+
+	return
+}
+
+// Loads values keyed by address into tbl.
+func loadRegisters(tbl []uint16, values map[string]uint16) (err error) {
+	var addr	uint64
+
+	for key, value := range values {
+		addr, err	= strconv.ParseUint(key, 0, 16)
+		if err != nil || int(addr) >= len(tbl) {
+			err	= fmt.Errorf("bad address '%s'", key)
+			return
+		}
+		tbl[addr]	= value
+	}
+
+	return
+}
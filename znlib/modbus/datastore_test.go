@@ -0,0 +1,394 @@
+package modbus
+
+import (
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDataStoreAccessors(t *testing.T) {
+	var ds		*DataStore
+	var f32		float32
+	var u64		uint64
+	var regs	[]uint16
+	var coil	bool
+	var err		error
+
+	ds, err	= NewDataStore(&DataStoreConfiguration{
+		UnitIds:		[]uint8{1, 2},
+		HoldingRegisters:	16,
+		WordOrder:		LOW_WORD_FIRST,
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+
+	err	= ds.SetFloat32(1, HOLDING_REGISTER, 0, 3.1415927)
+	if err != nil {
+		t.Errorf("SetFloat32() should have succeeded, got: %v", err)
+	}
+
+	regs, _	= ds.Registers(1, HOLDING_REGISTER, 0, 2)
+	if regs[0] != 0x0fdb || regs[1] != 0x4049 {
+		t.Errorf("unexpected register values: %04x", regs)
+	}
+
+	f32, err	= ds.Float32(1, HOLDING_REGISTER, 0)
+	if err != nil || f32 != 3.1415927 {
+		t.Errorf("expected 3.1415927, got: %v (%v)", f32, err)
+	}
+
+	err	= ds.SetUint64(2, INPUT_REGISTER, 100, 0x0102030405060708)
+	if err != nil {
+		t.Errorf("SetUint64() should have succeeded, got: %v", err)
+	}
+
+	u64, _	= ds.Uint64(2, INPUT_REGISTER, 100)
+	if u64 != 0x0102030405060708 {
+		t.Errorf("expected 0x0102030405060708, got: 0x%016x", u64)
+	}
+
+	// units are independent
+	u64, _	= ds.Uint64(1, INPUT_REGISTER, 100)
+	if u64 != 0 {
+		t.Errorf("expected 0, got: 0x%016x", u64)
+	}
+
+	err	= ds.SetCoil(2, 7, true)
+	if err != nil {
+		t.Errorf("SetCoil() should have succeeded, got: %v", err)
+	}
+
+	coil, _	= ds.Coil(2, 7)
+	if !coil {
+		t.Errorf("expected true, got: %v", coil)
+	}
+
+	// out of bounds and unknown units
+	err	= ds.SetUint32(1, HOLDING_REGISTER, 15, 1)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	_, err	= ds.Coil(3, 0)
+	if err != ErrBadUnitId {
+		t.Errorf("expected ErrBadUnitId, got: %v", err)
+	}
+
+	ds.AddUnit(3)
+	_, err	= ds.Coil(3, 0)
+	if err != nil {
+		t.Errorf("Coil() should have succeeded, got: %v", err)
+	}
+
+	if units := ds.UnitIds(); len(units) != 3 || units[2] != 3 {
+		t.Errorf("unexpected unit ids: %v", units)
+	}
+
+	return
+}
+
+func TestDataStoreSnapshot(t *testing.T) {
+	var ds		*DataStore
+	var ds2		*DataStore
+	var path	string
+	var value	uint16
+	var di		bool
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{UnitIds: []uint8{1, 5}})
+	ds.SetRegister(1, HOLDING_REGISTER, 0x10, 0x1234)
+	ds.SetRegister(5, INPUT_REGISTER, 0xffff, 0xabcd)
+	ds.SetDiscreteInput(5, 3, true)
+
+	path	= filepath.Join(t.TempDir(), "snapshot.json")
+	err	= ds.SaveSnapshot(path)
+	if err != nil {
+		t.Fatalf("SaveSnapshot() should have succeeded, got: %v", err)
+	}
+
+	ds2, _	= NewDataStore(&DataStoreConfiguration{})
+	ds2.SetRegister(1, HOLDING_REGISTER, 0x20, 1)
+
+	err	= ds2.LoadSnapshot(path)
+	if err != nil {
+		t.Fatalf("LoadSnapshot() should have succeeded, got: %v", err)
+	}
+
+	// loaded units are reset first
+	value, _	= ds2.Register(1, HOLDING_REGISTER, 0x20)
+	if value != 0 {
+		t.Errorf("expected 0, got: 0x%04x", value)
+	}
+
+	value, _	= ds2.Register(1, HOLDING_REGISTER, 0x10)
+	if value != 0x1234 {
+		t.Errorf("expected 0x1234, got: 0x%04x", value)
+	}
+
+	value, _	= ds2.Register(5, INPUT_REGISTER, 0xffff)
+	if value != 0xabcd {
+		t.Errorf("expected 0xabcd, got: 0x%04x", value)
+	}
+
+	di, _	= ds2.DiscreteInput(5, 3)
+	if !di {
+		t.Errorf("expected true, got: %v", di)
+	}
+
+	// hand-written snapshots may use hex addresses
+	err	= ds2.Restore([]byte(`{"units": {"7": {"coils": {"0x0a": true}}}}`))
+	if err != nil {
+		t.Errorf("Restore() should have succeeded, got: %v", err)
+	}
+
+	if coil, _ := ds2.Coil(7, 10); !coil {
+		t.Errorf("expected true, got: %v", coil)
+	}
+
+	for _, snap := range []string{
+		`{"units": {"300": {}}}`,
+		`{"units": {"1": {"coils": {"65536": true}}}}`,
+		`{"units": {"1": {"holding_registers": {"a": 1}}}}`,
+	} {
+		err	= ds2.Restore([]byte(snap))
+		if !errors.Is(err, ErrConfigurationError) {
+			t.Errorf("expected ErrConfigurationError for %s, got: %v", snap, err)
+		}
+	}
+
+	return
+}
+
+func TestDataStoreServer(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var lock	sync.Mutex
+	var changes	[]DataChange
+	var f32		float32
+	var value	uint16
+	var err		error
+
+	ds, err	= NewDataStore(&DataStoreConfiguration{
+		UnitIds:	[]uint8{1},
+		Coils:		16,
+		Rules:		[]ACLRule{
+			// operators only may write 0x200
+			{Effect: ACL_ALLOW, Role: "operator", Table: TABLE_HOLDING_REGISTERS,
+			 Addr: 0x200, Quantity: 1, Access: ACCESS_WRITE},
+			{Effect: ACL_ALLOW, Table: TABLE_HOLDING_REGISTERS, Access: ACCESS_READ},
+			{Effect: ACL_ALLOW, Table: TABLE_HOLDING_REGISTERS, Addr: 0x100, Quantity: 0x10,
+			 Access: ACCESS_WRITE},
+			{Effect: ACL_ALLOW, Table: TABLE_COILS},
+		},
+		OnChange:	func(change *DataChange) {
+			lock.Lock()
+			changes	= append(changes, *change)
+			lock.Unlock()
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5514",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5514",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	ds.SetFloat32(1, HOLDING_REGISTER, 0x10, 1.5)
+	f32, err	= client.ReadFloat32(0x10, HOLDING_REGISTER)
+	if err != nil || f32 != 1.5 {
+		t.Errorf("expected 1.5, got: %v (%v)", f32, err)
+	}
+
+	err	= client.WriteRegisters(0x100, []uint16{1, 2, 3})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	value, _	= ds.Register(1, HOLDING_REGISTER, 0x102)
+	if value != 3 {
+		t.Errorf("expected 3, got: %v", value)
+	}
+
+	// identical values do not trigger the change hook
+	err	= client.WriteRegister(0x100, 1)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	// partially outside of the writable range
+	err	= client.WriteRegisters(0x10e, []uint16{1, 2, 3})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// per-role rules (tcp clients have no role)
+	err	= client.WriteRegister(0x200, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	_, err	= ds.HandleHoldingRegisters(&HoldingRegistersRequest{
+		ClientAddr:	"10.0.0.1:1234",
+		ClientRole:	"operator",
+		UnitId:		1,
+		Addr:		0x200,
+		Quantity:	1,
+		IsWrite:	true,
+		Args:		[]uint16{1},
+	})
+	if err != nil {
+		t.Errorf("operators should be allowed to write 0x200, got: %v", err)
+	}
+
+	// no rule for input registers
+	_, err	= client.ReadRegister(0x00, INPUT_REGISTER)
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	err	= client.WriteCoil(0x05, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+
+	_, err	= client.ReadCoils(0x0a, 0x08)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.SetUnitId(2)
+	_, err	= client.ReadCoils(0x00, 1)
+	if err == nil {
+		t.Errorf("unit #2 should not be served")
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// local float32 write, client register write, operator write, client
+	// coil write
+	if len(changes) != 4 {
+		t.Fatalf("expected 4 changes, got: %+v", changes)
+	}
+
+	if changes[0].ClientAddr != "" || changes[0].Quantity != 2 {
+		t.Errorf("unexpected change: %+v", changes[0])
+	}
+
+	if changes[1].Table != TABLE_HOLDING_REGISTERS || changes[1].Addr != 0x100 ||
+	   changes[1].Quantity != 3 || changes[1].ClientAddr == "" {
+		t.Errorf("unexpected change: %+v", changes[1])
+	}
+
+	if changes[2].ClientRole != "operator" || changes[2].Addr != 0x200 {
+		t.Errorf("unexpected change: %+v", changes[2])
+	}
+
+	if changes[3].Table != TABLE_COILS || changes[3].Addr != 0x05 {
+		t.Errorf("unexpected change: %+v", changes[3])
+	}
+
+	return
+}
+
+func TestDataStoreBroadcast(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var value	uint16
+	var coil	bool
+	var err		error
+
+	ds, err	= NewDataStore(&DataStoreConfiguration{
+		UnitIds:	[]uint8{1, 2},
+	})
+	if err != nil {
+		t.Fatalf("NewDataStore() should have succeeded, got: %v", err)
+	}
+
+	// broadcast reads are rejected
+	_, err	= ds.HandleHoldingRegisters(&HoldingRegistersRequest{
+		UnitId:		0,
+		Addr:		0x10,
+		Quantity:	1,
+	})
+	if err != ErrIllegalFunction {
+		t.Errorf("expected ErrIllegalFunction, got: %v", err)
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuoverudp://localhost:5531",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:			"rtuoverudp://localhost:5531",
+		TurnaroundDelay:	10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	// broadcast writes reach every unit
+	client.SetUnitId(0)
+	err	= client.WriteRegister(0x10, 0x1234)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	err	= client.WriteCoil(0x03, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+
+	for _, unitId := range []uint8{1, 2} {
+		value, _	= ds.Register(unitId, HOLDING_REGISTER, 0x10)
+		coil, _		= ds.Coil(unitId, 0x03)
+		if value != 0x1234 || !coil {
+			t.Errorf("unit #%v: broadcast not applied (0x%04x, %v)", unitId, value, coil)
+		}
+	}
+
+	return
+}
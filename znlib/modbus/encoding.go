@@ -207,3 +207,52 @@ func decodeBools(quantity uint16, in []byte) (out []bool) {
 
 	return
 }
+
+// binary.ByteOrder of multi-byte values spanning registers, as per register
+// endianness and word order (e.g. to encode and decode structs with restruct).
+type registerOrder struct {
+	endianness	Endianness
+	wordOrder	WordOrder
+}
+
+func (ro registerOrder) Uint16(in []byte) (out uint16) {
+	out	= bytesToUint16(ro.endianness, in[0:2])
+
+	return
+}
+
+func (ro registerOrder) Uint32(in []byte) (out uint32) {
+	out	= bytesToUint32s(ro.endianness, ro.wordOrder, in[0:4])[0]
+
+	return
+}
+
+func (ro registerOrder) Uint64(in []byte) (out uint64) {
+	out	= bytesToUint64s(ro.endianness, ro.wordOrder, in[0:8])[0]
+
+	return
+}
+
+func (ro registerOrder) PutUint16(out []byte, in uint16) {
+	copy(out[0:2], uint16ToBytes(ro.endianness, in))
+
+	return
+}
+
+func (ro registerOrder) PutUint32(out []byte, in uint32) {
+	copy(out[0:4], uint32ToBytes(ro.endianness, ro.wordOrder, in))
+
+	return
+}
+
+func (ro registerOrder) PutUint64(out []byte, in uint64) {
+	copy(out[0:8], uint64ToBytes(ro.endianness, ro.wordOrder, in))
+
+	return
+}
+
+func (ro registerOrder) String() (str string) {
+	str	= "registerOrder"
+
+	return
+}
@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"bytes"
 	"testing"
 )
 
@@ -608,3 +609,48 @@ func TestEncodeBools(t *testing.T) {
 
 	return
 }
+
+func TestRegisterOrder(t *testing.T) {
+	var buf	[]byte
+	var ro	registerOrder
+
+	buf	= make([]byte, 8)
+
+	// big endian, high word first: plain big endian
+	ro	= registerOrder{BIG_ENDIAN, HIGH_WORD_FIRST}
+	ro.PutUint32(buf, 0x11223344)
+	if !bytes.Equal(buf[0:4], []byte{0x11, 0x22, 0x33, 0x44}) {
+		t.Errorf("unexpected bytes: % x", buf[0:4])
+	}
+
+	// little endian, low word first: plain little endian
+	ro	= registerOrder{LITTLE_ENDIAN, LOW_WORD_FIRST}
+	ro.PutUint64(buf, 0x1122334455667788)
+	if !bytes.Equal(buf, []byte{0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11}) {
+		t.Errorf("unexpected bytes: % x", buf)
+	}
+
+	if ro.Uint64(buf) != 0x1122334455667788 {
+		t.Errorf("unexpected value: 0x%016x", ro.Uint64(buf))
+	}
+
+	// big endian, low word first
+	ro	= registerOrder{BIG_ENDIAN, LOW_WORD_FIRST}
+	ro.PutUint32(buf, 0x11223344)
+	if !bytes.Equal(buf[0:4], []byte{0x33, 0x44, 0x11, 0x22}) {
+		t.Errorf("unexpected bytes: % x", buf[0:4])
+	}
+
+	if ro.Uint32(buf) != 0x11223344 {
+		t.Errorf("unexpected value: 0x%08x", ro.Uint32(buf))
+	}
+
+	// little endian, high word first
+	ro	= registerOrder{LITTLE_ENDIAN, HIGH_WORD_FIRST}
+	ro.PutUint16(buf, 0x1122)
+	if !bytes.Equal(buf[0:2], []byte{0x22, 0x11}) || ro.Uint16(buf) != 0x1122 {
+		t.Errorf("unexpected bytes: % x", buf[0:2])
+	}
+
+	return
+}
@@ -0,0 +1,181 @@
+package modbus
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+// Gateway configuration object.
+type GatewayConfiguration struct {
+	// Routes maps the unit ids requested by gateway clients to target
+	// devices (see GatewayRoute)
+	Routes        []GatewayRoute
+	// Logger provides a custom sink for log messages.
+	// If nil, messages will be written to stdout.
+	Logger        *log.Logger
+}
+
+// Gateway route, mapping a unit id to a device behind a client.
+type GatewayRoute struct {
+	// UnitId is the unit id requested by gateway clients
+	UnitId        uint8
+	// SlaveId is the unit id of the target device on the downstream link
+	// (0 means the same as UnitId)
+	SlaveId       uint8
+	// Client is the (open) client used to reach the target device, e.g. an
+	// rtu client. Several routes may share the same client (i.e. the same
+	// serial bus), in which case requests are serialized.
+	Client        *ModbusClient
+}
+
+// Modbus gateway object, to be passed as request handler to NewServer.
+// Requests received by the server are forwarded as-is (whatever the function
+// code) to the device routed to their unit id, and responses (including
+// exceptions) relayed back to the requester.
+// Requests to unit ids without route are answered with a gateway path
+// unavailable exception, requests left unanswered by the target device with
+// a gateway target device failed to respond exception.
+type Gateway struct {
+	logger        *logger
+	lock          sync.RWMutex
+	routes        map[uint8]GatewayRoute
+}
+
+// Requests are passed as-is to handlers implementing this interface and
+// forwarding requests (see ModbusServer.handleRequest()).
+type requestForwarder interface {
+	forwardRequest(req *pdu, clientAddr string, clientRole string) (res *pdu, err error)
+	forwards() bool
+}
+
+// NewGateway returns a new gateway, to be passed to NewServer.
+func NewGateway(conf *GatewayConfiguration) (gw *Gateway, err error) {
+	gw	= &Gateway{
+		logger:	newLogger("modbus-gateway", conf.Logger),
+		routes:	map[uint8]GatewayRoute{},
+	}
+
+	for _, route := range conf.Routes {
+		err	= gw.SetRoute(route)
+		if err != nil {
+			gw	= nil
+			return
+		}
+	}
+
+	return
+}
+
+// Adds or replaces the route of route.UnitId.
+func (gw *Gateway) SetRoute(route GatewayRoute) (err error) {
+	if route.Client == nil {
+		err	= ErrUnexpectedParameters
+		gw.logger.Errorf("no client for unit id %v", route.UnitId)
+		return
+	}
+
+	if route.SlaveId == 0 {
+		route.SlaveId	= route.UnitId
+	}
+
+	gw.lock.Lock()
+	gw.routes[route.UnitId]	= route
+	gw.lock.Unlock()
+
+	return
+}
+
+// Removes the route of unitId, if any.
+func (gw *Gateway) RemoveRoute(unitId uint8) {
+	gw.lock.Lock()
+	delete(gw.routes, unitId)
+	gw.lock.Unlock()
+
+	return
+}
+
+// Returns the route of unitId.
+func (gw *Gateway) Route(unitId uint8) (route GatewayRoute, ok bool) {
+	gw.lock.RLock()
+	route, ok	= gw.routes[unitId]
+	gw.lock.RUnlock()
+
+	return
+}
+
+// The RequestHandler methods are never called by ModbusServer, as requests
+// are forwarded before being decoded.
+func (gw *Gateway) HandleCoils(req *CoilsRequest) (res []bool, err error) {
+	err	= ErrGWPathUnavailable
+
+	return
+}
+
+func (gw *Gateway) HandleDiscreteInputs(req *DiscreteInputsRequest) (res []bool, err error) {
+	err	= ErrGWPathUnavailable
+
+	return
+}
+
+func (gw *Gateway) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	err	= ErrGWPathUnavailable
+
+	return
+}
+
+func (gw *Gateway) HandleInputRegisters(req *InputRegistersRequest) (res []uint16, err error) {
+	err	= ErrGWPathUnavailable
+
+	return
+}
+
+// Gateways always forward requests.
+func (gw *Gateway) forwards() (ok bool) {
+	ok	= true
+
+	return
+}
+
+// Forwards a request to the device routed to its unit id and returns the
+// device's response.
+func (gw *Gateway) forwardRequest(req *pdu, clientAddr string, clientRole string) (res *pdu, err error) {
+	var route	GatewayRoute
+	var ok		bool
+
+	route, ok	= gw.Route(req.unitId)
+	if !ok {
+		err	= ErrGWPathUnavailable
+		return
+	}
+
+	// hold the client lock for the whole exchange so that requests to
+	// devices sharing the same link are serialized
+	route.Client.lock.Lock()
+	res, err	= route.Client.executeRequest(context.Background(), &pdu{
+		unitId:		route.SlaveId,
+		functionCode:	req.functionCode,
+		payload:	req.payload,
+	})
+	route.Client.lock.Unlock()
+
+	if err == ErrTransportNotOpen {
+		gw.logger.Warningf("client of unit id %v is not open", req.unitId)
+		res	= nil
+		err	= ErrGWPathUnavailable
+		return
+	}
+
+	if err != nil {
+		gw.logger.Warningf("unit id %v (slave id %v) failed to respond to %v: %v",
+				   req.unitId, route.SlaveId, clientAddr, err)
+		res	= nil
+		err	= ErrGWTargetFailedToRespond
+		return
+	}
+
+	// reply on behalf of the requested unit id
+	res.unitId	= req.unitId
+
+	return
+}
@@ -0,0 +1,176 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestGateway(t *testing.T) {
+	var bus      *ModbusServer
+	var server   *ModbusServer
+	var rtu      *ModbusClient
+	var dead     *ModbusClient
+	var client   *ModbusClient
+	var gw       *Gateway
+	var sock     net.Listener
+	var err      error
+	var regs     []uint16
+
+	// downstream rtu device (unit #9), reached over rtu over tcp
+	bus, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5512",
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = bus.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer bus.Stop()
+
+	rtu, err = NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5512",
+		Timeout:	200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = rtu.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer rtu.Close()
+
+	// downstream link where nobody answers
+	sock, err = net.Listen("tcp", "localhost:5513")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	dead, err = NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5513",
+		Timeout:	100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = dead.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer dead.Close()
+
+	// unit #1 maps to slave #9, unit #9 is passed through, unit #3 reaches
+	// a device which is not served and unit #4 a dead link
+	gw, err = NewGateway(&GatewayConfiguration{
+		Routes:	[]GatewayRoute{
+			{UnitId: 1, SlaveId: 9, Client: rtu},
+			{UnitId: 9, Client: rtu},
+			{UnitId: 3, Client: rtu},
+			{UnitId: 4, Client: dead},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create gateway: %v", err)
+	}
+
+	_, err = NewGateway(&GatewayConfiguration{
+		Routes:	[]GatewayRoute{{UnitId: 1}},
+	})
+	if err != ErrUnexpectedParameters {
+		t.Errorf("NewGateway() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5511",
+	}, gw)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5511",
+		Timeout:	1 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	client.SetUnitId(1)
+	err = client.WriteRegisters(0x0002, []uint16{0x1122, 0x3344})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	client.SetUnitId(9)
+	regs, err = client.ReadRegisters(0x0002, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1122 || regs[1] != 0x3344 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	// exceptions are relayed as-is
+	_, err = client.ReadRegisters(0x0009, 2, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	client.SetUnitId(3)
+	_, err = client.ReadCoils(0x0000, 1)
+	if err != ErrIllegalFunction {
+		t.Errorf("ReadCoils() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	// gateway exceptions
+	client.SetUnitId(2)
+	_, err = client.ReadCoils(0x0000, 1)
+	if err != ErrGWPathUnavailable {
+		t.Errorf("ReadCoils() should have returned ErrGWPathUnavailable, got: %v", err)
+	}
+
+	client.SetUnitId(4)
+	_, err = client.ReadCoils(0x0000, 1)
+	if err != ErrGWTargetFailedToRespond {
+		t.Errorf("ReadCoils() should have returned ErrGWTargetFailedToRespond, got: %v", err)
+	}
+
+	// routes can be changed at runtime
+	gw.RemoveRoute(1)
+	client.SetUnitId(1)
+	_, err = client.ReadRegisters(0x0002, 2, HOLDING_REGISTER)
+	if err != ErrGWPathUnavailable {
+		t.Errorf("ReadRegisters() should have returned ErrGWPathUnavailable, got: %v", err)
+	}
+
+	return
+}
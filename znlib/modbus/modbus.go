@@ -40,6 +40,13 @@ const (
 	fcReadFileRecord             uint8 = 0x14
 	fcWriteFileRecord            uint8 = 0x15
 
+	// diagnostics
+	fcDiagnostics                uint8 = 0x08
+
+	// encapsulated interface transport (read device identification)
+	fcEncapsulatedInterface      uint8 = 0x2b
+	meiReadDeviceId              uint8 = 0x0e
+
 	// exception codes
 	exIllegalFunction            uint8 = 0x01
 	exIllegalDataAddress         uint8 = 0x02
@@ -70,6 +77,7 @@ const (
 	ErrBadTransactionId          Error = "bad transaction id"
 	ErrUnknownProtocolId         Error = "unknown protocol identifier"
 	ErrUnexpectedParameters      Error = "unexpected parameters"
+	ErrTransportNotOpen          Error = "transport not open"
 )
 
 // mapExceptionCodeToError turns a modbus exception code into a higher level Error object.
@@ -0,0 +1,414 @@
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+type FrameDirection uint
+const (
+	// frame directions
+	FRAME_RX            FrameDirection = 1
+	FRAME_TX            FrameDirection = 2
+
+	// pcap link type of captured frames (DLT_USER0)
+	PCAP_LINKTYPE_USER0 uint32 = 147
+)
+
+// Upper bounds of the latency histogram buckets. Latencies above the last
+// bound are counted in an extra, last bucket.
+var LatencyBuckets	= []time.Duration{
+	1 * time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	1 * time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+}
+
+// Traffic counters of a link (client) or connection (server).
+type Stats struct {
+	// Requests is the number of requests sent (client) or received (server)
+	Requests      uint64
+	// Responses is the number of responses received (client) or sent
+	// (server), exceptions included
+	Responses     uint64
+	// Exceptions counts exception responses by exception code
+	Exceptions    map[uint8]uint64
+	// Timeouts is the number of requests left unanswered (client only)
+	Timeouts      uint64
+	// CRCErrors is the number of frames dropped because of a bad CRC
+	CRCErrors     uint64
+	// Errors is the number of other transport/framing errors
+	Errors        uint64
+	// BytesSent and BytesReceived count the bytes of the frames sent and
+	// received (including garbage on rtu links)
+	BytesSent     uint64
+	BytesReceived uint64
+	// Latency is the histogram of request latencies: round-trip time on
+	// clients, request handling time on servers
+	Latency       LatencyHistogram
+}
+
+// Latency histogram.
+type LatencyHistogram struct {
+	// Counts holds the number of requests per bucket: Counts[i] counts
+	// latencies up to LatencyBuckets[i], the last element those above the
+	// last bucket
+	Counts        []uint64
+	// Count and Sum are the number and sum of all latencies
+	Count         uint64
+	Sum           time.Duration
+}
+
+// Server traffic counters.
+type ServerStats struct {
+	// Total sums the counters of all connections, past and active
+	Total         Stats
+	// Connections holds the counters of active connections, by client
+	// address (or device/listen address on serial links and udp)
+	Connections   map[string]Stats
+}
+
+// Raw frame (ADU), as passed to frame capture hooks.
+type Frame struct {
+	Time          time.Time
+	Direction     FrameDirection
+	// Link identifies the link the frame was seen on: client URL on
+	// clients, client address on servers
+	Link          string
+	// ADU holds the frame bytes as sent or received, i.e. MBAP header +
+	// PDU on tcp links or PDU + CRC on rtu links (possibly garbled)
+	ADU           []byte
+}
+
+// Frame capture hook, passed to clients and servers (see Capture in
+// ClientConfiguration and ServerConfiguration). CaptureFrame may be called
+// concurrently and should not retain the frame.
+type FrameCapture interface {
+	CaptureFrame(frame *Frame)
+}
+
+// Traffic monitor of a link, keeping counters and passing frames to the
+// capture hook. All methods are no-ops on nil monitors.
+type linkMonitor struct {
+	link          string
+	capture       FrameCapture
+	lock          sync.Mutex
+	stats         Stats
+}
+
+// Returns a new link monitor.
+func newLinkMonitor(link string, capture FrameCapture) (lm *linkMonitor) {
+	lm	= &linkMonitor{
+		link:		link,
+		capture:	capture,
+		stats:		newStats(),
+	}
+
+	return
+}
+
+// Records a frame sent on the link.
+func (lm *linkMonitor) sent(adu []byte) {
+	if lm == nil || len(adu) == 0 {
+		return
+	}
+
+	lm.lock.Lock()
+	lm.stats.BytesSent	+= uint64(len(adu))
+	lm.lock.Unlock()
+
+	lm.captureFrame(FRAME_TX, adu)
+
+	return
+}
+
+// Records a frame (or garbage) received on the link.
+func (lm *linkMonitor) received(adu []byte) {
+	if lm == nil || len(adu) == 0 {
+		return
+	}
+
+	lm.lock.Lock()
+	lm.stats.BytesReceived	+= uint64(len(adu))
+	lm.lock.Unlock()
+
+	lm.captureFrame(FRAME_RX, adu)
+
+	return
+}
+
+// Counts a request.
+func (lm *linkMonitor) request() {
+	if lm == nil {
+		return
+	}
+
+	lm.lock.Lock()
+	lm.stats.Requests++
+	lm.lock.Unlock()
+
+	return
+}
+
+// Counts a response and its latency.
+func (lm *linkMonitor) response(res *pdu, latency time.Duration) {
+	var bucket	int
+
+	if lm == nil {
+		return
+	}
+
+	for bucket = 0; bucket < len(LatencyBuckets); bucket++ {
+		if latency <= LatencyBuckets[bucket] {
+			break
+		}
+	}
+
+	lm.lock.Lock()
+	lm.stats.Responses++
+	if res.functionCode & 0x80 != 0 && len(res.payload) == 1 {
+		lm.stats.Exceptions[res.payload[0]]++
+	}
+	lm.stats.Latency.Counts[bucket]++
+	lm.stats.Latency.Count++
+	lm.stats.Latency.Sum	+= latency
+	lm.lock.Unlock()
+
+	return
+}
+
+// Counts a transport error.
+func (lm *linkMonitor) failure(err error) {
+	if lm == nil {
+		return
+	}
+
+	lm.lock.Lock()
+	switch err {
+	case ErrRequestTimedOut:	lm.stats.Timeouts++
+	case ErrBadCRC:			lm.stats.CRCErrors++
+	default:			lm.stats.Errors++
+	}
+	lm.lock.Unlock()
+
+	return
+}
+
+// Counts a server-side read error, ignoring those which merely tell that
+// the link is idle or gone (timeouts, closed connections).
+func (lm *linkMonitor) readFailure(err error) {
+	switch err {
+	case ErrBadCRC, ErrShortFrame, ErrProtocolError, ErrUnknownProtocolId:
+		lm.failure(err)
+	}
+
+	return
+}
+
+// Returns a copy of the counters.
+func (lm *linkMonitor) snapshot() (stats Stats) {
+	stats	= newStats()
+	if lm == nil {
+		return
+	}
+
+	lm.lock.Lock()
+	stats.add(&lm.stats)
+	lm.lock.Unlock()
+
+	return
+}
+
+// Passes a frame to the capture hook, if any.
+func (lm *linkMonitor) captureFrame(direction FrameDirection, adu []byte) {
+	if lm.capture == nil {
+		return
+	}
+
+	lm.capture.CaptureFrame(&Frame{
+		Time:		time.Now(),
+		Direction:	direction,
+		Link:		lm.link,
+		ADU:		adu,
+	})
+
+	return
+}
+
+// Attaches a monitor to a transport (pipelined transports get theirs at
+// creation time, before their reader starts).
+func monitorTransport(t transport, lm *linkMonitor) {
+	switch tt := t.(type) {
+	case *tcpTransport:
+		tt.monitor	= lm
+	case *rtuTransport:
+		tt.setMonitor(lm)
+	case *asciiTransport:
+		tt.setMonitor(lm)
+	}
+
+	return
+}
+
+// Returns zeroed counters.
+func newStats() (stats Stats) {
+	stats.Exceptions	= map[uint8]uint64{}
+	stats.Latency.Counts	= make([]uint64, len(LatencyBuckets) + 1)
+
+	return
+}
+
+// Adds other to the counters.
+func (stats *Stats) add(other *Stats) {
+	stats.Requests		+= other.Requests
+	stats.Responses		+= other.Responses
+	stats.Timeouts		+= other.Timeouts
+	stats.CRCErrors		+= other.CRCErrors
+	stats.Errors		+= other.Errors
+	stats.BytesSent		+= other.BytesSent
+	stats.BytesReceived	+= other.BytesReceived
+
+	for code, count := range other.Exceptions {
+		stats.Exceptions[code]	+= count
+	}
+
+	for i, count := range other.Latency.Counts {
+		stats.Latency.Counts[i]	+= count
+	}
+	stats.Latency.Count	+= other.Latency.Count
+	stats.Latency.Sum	+= other.Latency.Sum
+
+	return
+}
+
+// Returns the mean latency.
+func (lh *LatencyHistogram) Mean() (mean time.Duration) {
+	if lh.Count > 0 {
+		mean	= lh.Sum / time.Duration(lh.Count)
+	}
+
+	return
+}
+
+// Tees the bytes read off an rtu link, so that whatever was received
+// (including garbage and bad frames) can be accounted for and captured.
+type rtuRecorder struct {
+	rtuLink
+	rx            []byte
+}
+
+func (rr *rtuRecorder) Read(buf []byte) (rlen int, err error) {
+	rlen, err	= rr.rtuLink.Read(buf)
+	if rlen > 0 {
+		rr.rx	= append(rr.rx, buf[0:rlen]...)
+	}
+
+	return
+}
+
+// Returns and forgets the bytes read so far.
+func (rr *rtuRecorder) take() (rx []byte) {
+	rx	= rr.rx
+	rr.rx	= nil
+
+	return
+}
+
+// Frame capture hook writing frames as hex dump lines, e.g.
+// 2026-10-19T12:00:00.000123Z rtu:///dev/ttyUSB0 tx 01 03 00 00 00 02 c4 0b
+type HexDumpCapture struct {
+	lock          sync.Mutex
+	w             io.Writer
+}
+
+// Returns a hex dump capture hook writing to w (e.g. an *os.File).
+func NewHexDumpCapture(w io.Writer) (hdc *HexDumpCapture) {
+	hdc	= &HexDumpCapture{
+		w:	w,
+	}
+
+	return
+}
+
+func (hdc *HexDumpCapture) CaptureFrame(frame *Frame) {
+	var sb	strings.Builder
+
+	sb.WriteString(frame.Time.UTC().Format("2006-01-02T15:04:05.000000Z"))
+	sb.WriteString(" " + frame.Link)
+	if frame.Direction == FRAME_TX {
+		sb.WriteString(" tx")
+	} else {
+		sb.WriteString(" rx")
+	}
+
+	for _, b := range frame.ADU {
+		fmt.Fprintf(&sb, " %02x", b)
+	}
+	sb.WriteString("\n")
+
+	hdc.lock.Lock()
+	io.WriteString(hdc.w, sb.String())
+	hdc.lock.Unlock()
+
+	return
+}
+
+// Frame capture hook writing frames to a pcap file, with link type
+// PCAP_LINKTYPE_USER0. To have Wireshark decode them, map DLT_USER 147 to
+// the mbrtu (rtu links) or mbtcp (tcp links) protocol in the DLT_USER
+// protocol preferences. Frame directions are not recorded.
+type PcapCapture struct {
+	lock          sync.Mutex
+	w             io.Writer
+}
+
+// Returns a pcap capture hook writing to w (e.g. an *os.File), after
+// writing the pcap file header.
+func NewPcapCapture(w io.Writer) (pc *PcapCapture, err error) {
+	var header	[24]byte
+
+	binary.LittleEndian.PutUint32(header[0:4], 0xa1b2c3d4)	// magic (us)
+	binary.LittleEndian.PutUint16(header[4:6], 2)		// version major
+	binary.LittleEndian.PutUint16(header[6:8], 4)		// version minor
+	binary.LittleEndian.PutUint32(header[16:20], 65535)	// snap length
+	binary.LittleEndian.PutUint32(header[20:24], PCAP_LINKTYPE_USER0)
+
+	_, err	= w.Write(header[:])
+	if err != nil {
+		return
+	}
+
+	pc	= &PcapCapture{
+		w:	w,
+	}
+
+	return
+}
+
+func (pc *PcapCapture) CaptureFrame(frame *Frame) {
+	var record	[]byte
+
+	record	= make([]byte, 16, 16 + len(frame.ADU))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(frame.Time.Unix()))
+	binary.LittleEndian.PutUint32(record[4:8], uint32(frame.Time.Nanosecond() / 1000))
+	binary.LittleEndian.PutUint32(record[8:12], uint32(len(frame.ADU)))
+	binary.LittleEndian.PutUint32(record[12:16], uint32(len(frame.ADU)))
+	record	= append(record, frame.ADU...)
+
+	pc.lock.Lock()
+	pc.w.Write(record)
+	pc.lock.Unlock()
+
+	return
+}
@@ -0,0 +1,229 @@
+package modbus
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Returns the server stats once total responses reach count, as they are
+// only accounted for once written to the client.
+func waitForResponses(server *ModbusServer, count uint64) (stats ServerStats) {
+	for i := 0; i < 100; i++ {
+		stats	= server.Stats()
+		if stats.Total.Responses >= count {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return
+}
+
+func TestMonitorCounters(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var hexDump	bytes.Buffer
+	var pcap	bytes.Buffer
+	var pc		*PcapCapture
+	var cs		Stats
+	var ss		ServerStats
+	var lines	[]string
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{HoldingRegisters: 16})
+	ds.SetRegister(1, HOLDING_REGISTER, 0, 0x1234)
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5520",
+		Capture:	NewHexDumpCapture(&hexDump),
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	pc, err	= NewPcapCapture(&pcap)
+	if err != nil {
+		t.Fatalf("NewPcapCapture() should have succeeded, got: %v", err)
+	}
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5520",
+		Capture:	pc,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	_, err	= client.ReadRegister(0, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegister() should have succeeded, got: %v", err)
+	}
+
+	_, err	= client.ReadRegister(20, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// requests: 2 x 12 bytes, responses: 11 bytes + 9 bytes (exception)
+	cs	= client.Stats()
+	if cs.Requests != 2 || cs.Responses != 2 || cs.Exceptions[0x02] != 1 ||
+	   cs.BytesSent != 24 || cs.BytesReceived != 20 ||
+	   cs.Timeouts != 0 || cs.Errors != 0 || cs.Latency.Count != 2 {
+		t.Errorf("unexpected client stats: %+v", cs)
+	}
+
+	ss	= waitForResponses(server, 2)
+	if ss.Total.Requests != 2 || ss.Total.Responses != 2 ||
+	   ss.Total.Exceptions[0x02] != 1 ||
+	   ss.Total.BytesSent != 20 || ss.Total.BytesReceived != 24 {
+		t.Errorf("unexpected server stats: %+v", ss.Total)
+	}
+
+	if len(ss.Connections) != 1 {
+		t.Errorf("expected 1 connection, got: %+v", ss.Connections)
+	}
+
+	// hex dump: one line per frame
+	lines	= strings.Split(strings.TrimSpace(hexDump.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got: %q", hexDump.String())
+	}
+
+	if !strings.HasSuffix(lines[0], " rx 00 01 00 00 00 06 01 03 00 00 00 01") ||
+	   !strings.HasSuffix(lines[1], " tx 00 01 00 00 00 05 01 03 02 12 34") {
+		t.Errorf("unexpected hex dump: %q", lines)
+	}
+
+	// pcap: file header followed by one record per frame
+	if pcap.Len() != 24 + 4 * 16 + 24 + 20 {
+		t.Fatalf("unexpected pcap length: %v", pcap.Len())
+	}
+
+	if binary.LittleEndian.Uint32(pcap.Bytes()[0:4]) != 0xa1b2c3d4 ||
+	   binary.LittleEndian.Uint32(pcap.Bytes()[20:24]) != PCAP_LINKTYPE_USER0 {
+		t.Errorf("unexpected pcap header: % x", pcap.Bytes()[0:24])
+	}
+
+	if binary.LittleEndian.Uint32(pcap.Bytes()[32:36]) != 12 ||
+	   !bytes.Equal(pcap.Bytes()[40:52],
+			[]byte{0x00, 0x01, 0x00, 0x00, 0x00, 0x06, 0x01, 0x03, 0x00, 0x00, 0x00, 0x01}) {
+		t.Errorf("unexpected pcap record: % x", pcap.Bytes()[24:52])
+	}
+
+	// counters of closed connections are kept in the totals
+	client.Close()
+	for i := 0; i < 100 && len(ss.Connections) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		ss	= server.Stats()
+	}
+
+	if len(ss.Connections) != 0 || ss.Total.Requests != 2 {
+		t.Errorf("unexpected server stats: %+v", ss)
+	}
+
+	return
+}
+
+func TestMonitorRTUCRCErrors(t *testing.T) {
+	var server	*ModbusServer
+	var ds		*DataStore
+	var sock	net.Conn
+	var lock	sync.Mutex
+	var frames	[]*Frame
+	var ss		ServerStats
+	var res		[]byte
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5521",
+		Capture:	captureFunc(func(frame *Frame) {
+			lock.Lock()
+			frames	= append(frames, frame)
+			lock.Unlock()
+		}),
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	sock, err	= net.Dial("tcp", "localhost:5521")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer sock.Close()
+
+	// a read holding registers request with a bad CRC (closing the
+	// connection), then a good one over a new connection
+	sock.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00})
+	sock.SetDeadline(time.Now().Add(time.Second))
+	_, err	= sock.Read(make([]byte, 1))
+	if err != io.EOF {
+		t.Errorf("expected io.EOF, got: %v", err)
+	}
+	sock.Close()
+
+	sock, err	= net.Dial("tcp", "localhost:5521")
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer sock.Close()
+
+	sock.Write([]byte{0x01, 0x03, 0x00, 0x00, 0x00, 0x01, 0x84, 0x0a})
+
+	res	= make([]byte, 7)
+	sock.SetDeadline(time.Now().Add(time.Second))
+	_, err	= io.ReadFull(sock, res)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+
+	ss	= waitForResponses(server, 1)
+	if ss.Total.CRCErrors != 1 || ss.Total.Requests != 1 ||
+	   ss.Total.BytesReceived != 16 || ss.Total.BytesSent != 7 {
+		t.Errorf("unexpected server stats: %+v", ss.Total)
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	// garbled frames are captured as well
+	if len(frames) != 3 || frames[0].Direction != FRAME_RX ||
+	   frames[2].Direction != FRAME_TX || !bytes.Equal(frames[2].ADU, res) {
+		t.Errorf("unexpected frames: %+v", frames)
+	}
+
+	return
+}
+
+type captureFunc func(frame *Frame)
+
+func (cf captureFunc) CaptureFrame(frame *Frame) {
+	cf(frame)
+}
@@ -18,6 +18,8 @@ type rtuTransport struct {
 	lastActivity time.Time
 	t35          time.Duration
 	t1           time.Duration
+	monitor      *linkMonitor
+	recorder     *rtuRecorder
 }
 
 type rtuLink interface {
@@ -48,6 +50,26 @@ func newRTUTransport(link rtuLink, addr string, speed uint, timeout time.Duratio
 	return
 }
 
+// Attaches a traffic monitor to the transport.
+func (rt *rtuTransport) setMonitor(lm *linkMonitor) {
+	rt.monitor	= lm
+	if rt.recorder == nil {
+		rt.recorder	= &rtuRecorder{rtuLink: rt.link}
+		rt.link		= rt.recorder
+	}
+
+	return
+}
+
+// Reports the bytes received since the last call to the monitor, if any.
+func (rt *rtuTransport) flushRecorder() {
+	if rt.recorder != nil {
+		rt.monitor.received(rt.recorder.take())
+	}
+
+	return
+}
+
 // Closes the rtu link.
 func (rt *rtuTransport) Close() (err error) {
 	err = rt.link.Close()
@@ -57,9 +79,37 @@ func (rt *rtuTransport) Close() (err error) {
 
 // Runs a request across the rtu link and returns a response.
 func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
-	var ts time.Time
-	var t  time.Duration
-	var n  int
+	err	= rt.writeRequest(req)
+	if err != nil {
+		return
+	}
+
+	// read the response back from the wire
+	res, err = rt.readRTUFrame()
+
+	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
+		// wait for and flush any data coming off the link to allow
+		// devices to re-sync
+		time.Sleep(time.Duration(maxRTUFrameLength) * rt.t1)
+		discard(rt.link)
+	}
+	rt.flushRecorder()
+
+	// mark the time if we heard anything back
+	if err != ErrRequestTimedOut {
+		rt.lastActivity = time.Now()
+	}
+
+	return
+}
+
+// Sends a request across the rtu link, observing inter-frame delays, without
+// waiting for a response.
+func (rt *rtuTransport) writeRequest(req *pdu) (err error) {
+	var ts  time.Time
+	var t   time.Duration
+	var n   int
+	var adu []byte
 
 	// set an i/o deadline on the link
 	err	= rt.link.SetDeadline(time.Now().Add(rt.timeout))
@@ -78,10 +128,12 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
-	n, err	= rt.link.Write(rt.assembleRTUFrame(req))
+	adu	= rt.assembleRTUFrame(req)
+	n, err	= rt.link.Write(adu)
 	if err != nil {
 		return
 	}
+	rt.monitor.sent(adu)
 
 	// estimate how long the serial line was busy for.
 	// note that on most platforms, Write() will be buffered and return
@@ -91,17 +143,28 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	// observe inter-frame delays
 	time.Sleep(rt.lastActivity.Add(rt.t35).Sub(time.Now()))
 
-	// read the response back from the wire
-	res, err = rt.readRTUFrame()
+	return
+}
+
+// Reads a request from the rtu link.
+func (rt *rtuTransport) ReadRequest() (req *pdu, err error) {
+	// wait for up to timeout for the first byte of a request
+	err	= rt.link.SetDeadline(time.Now().Add(rt.timeout))
+	if err != nil {
+		return
+	}
+
+	req, err = rt.readRTURequest()
 
 	if err == ErrBadCRC || err == ErrProtocolError || err == ErrShortFrame {
 		// wait for and flush any data coming off the link to allow
-		// devices to re-sync
+		// the master to re-sync
 		time.Sleep(time.Duration(maxRTUFrameLength) * rt.t1)
 		discard(rt.link)
 	}
+	rt.flushRecorder()
 
-	// mark the time if we heard anything back
+	// mark the time if we heard anything
 	if err != ErrRequestTimedOut {
 		rt.lastActivity = time.Now()
 	}
@@ -109,14 +172,6 @@ func (rt *rtuTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 	return
 }
 
-// Reads a request from the rtu link.
-func (rt *rtuTransport) ReadRequest() (req *pdu, err error) {
-	// reading requests from RTU links is currently unsupported
-	err	= fmt.Errorf("unimplemented")
-
-	return
-}
-
 // ReadRawData 2026-02-09 12:35:35 +dmzn
 /*
  参数: len,待读取数据长度
@@ -173,14 +228,17 @@ func (rt *rtuTransport) WriteRawData(data []byte) error {
 
 // Writes a response to the rtu link.
 func (rt *rtuTransport) WriteResponse(res *pdu) (err error) {
-	var n int
+	var n   int
+	var adu []byte
 
 	// build an RTU ADU out of the request object and
 	// send the final ADU+CRC on the wire
-	n, err	= rt.link.Write(rt.assembleRTUFrame(res))
+	adu	= rt.assembleRTUFrame(res)
+	n, err	= rt.link.Write(adu)
 	if err != nil {
 		return
 	}
+	rt.monitor.sent(adu)
 
 	rt.lastActivity = time.Now().Add(rt.t1 * time.Duration(n))
 
@@ -191,6 +249,7 @@ func (rt *rtuTransport) WriteResponse(res *pdu) (err error) {
 func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 	var rxbuf	[]byte
 	var byteCount	int
+	var headerLen	int
 	var bytesNeeded	int
 	var crc		crc
 
@@ -208,21 +267,44 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 	}
 
 	// figure out how many further bytes to read
-	bytesNeeded, err = expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
-	if err != nil {
-		return
+	switch rxbuf[1] {
+	case fcReadFifoQueue:
+		// the byte count is a 2-byte field: fetch its second byte
+		byteCount, err	= io.ReadFull(rt.link, rxbuf[3:4])
+		if byteCount != 1 {
+			err = ErrShortFrame
+			return
+		}
+		headerLen	= 4
+		bytesNeeded	= int(bytesToUint16(BIG_ENDIAN, rxbuf[2:4]))
+
+	case fcEncapsulatedInterface:
+		// device identification objects are variable in number and size:
+		// walk them one by one
+		headerLen, err	= rt.readDeviceIdObjects(rxbuf)
+		if err != nil {
+			return
+		}
+		bytesNeeded	= 0
+
+	default:
+		headerLen	= 3
+		bytesNeeded, err = expectedResponseLenth(uint8(rxbuf[1]), uint8(rxbuf[2]))
+		if err != nil {
+			return
+		}
 	}
 
 	// we need to read 2 additional bytes of CRC after the payload
 	bytesNeeded	+= 2
 
 	// never read more than the max allowed frame length
-	if byteCount + bytesNeeded > maxRTUFrameLength {
+	if headerLen + bytesNeeded > maxRTUFrameLength {
 		err	= ErrProtocolError
 		return
 	}
 
-	byteCount, err	= io.ReadFull(rt.link, rxbuf[3:3 + bytesNeeded])
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[headerLen:headerLen + bytesNeeded])
 	if err != nil && err != io.ErrUnexpectedEOF {
 		return
 	}
@@ -234,10 +316,10 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 
 	// compute the CRC on the entire frame, excluding the CRC
 	crc.init()
-	crc.add(rxbuf[0:3 + bytesNeeded - 2])
+	crc.add(rxbuf[0:headerLen + bytesNeeded - 2])
 
 	// compare CRC values
-	if !crc.isEqual(rxbuf[3 + bytesNeeded - 2], rxbuf[3 + bytesNeeded - 1]) {
+	if !crc.isEqual(rxbuf[headerLen + bytesNeeded - 2], rxbuf[headerLen + bytesNeeded - 1]) {
 		err = ErrBadCRC
 		return
 	}
@@ -246,7 +328,151 @@ func (rt *rtuTransport) readRTUFrame() (res *pdu, err error) {
 		unitId:		rxbuf[0],
 		functionCode:	rxbuf[1],
 		// pass the byte count + trailing data as payload, withtout the CRC
-		payload:	rxbuf[2:3 + bytesNeeded  - 2],
+		payload:	rxbuf[2:headerLen + bytesNeeded  - 2],
+	}
+
+	return
+}
+
+// Reads the body of a read device identification response, following the
+// 3-byte ADU header (whose last byte is the MEI type). Returns the number of
+// bytes of the frame read so far, excluding the CRC.
+func (rt *rtuTransport) readDeviceIdObjects(rxbuf []byte) (frameLen int, err error) {
+	var byteCount	int
+	var objCount	int
+
+	// only the read device identification MEI type is supported
+	if rxbuf[2] != meiReadDeviceId {
+		err	= ErrProtocolError
+		return
+	}
+
+	// read device id code, conformity level, more follows, next object id
+	// and number of objects (1 byte each)
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[3:8])
+	if byteCount != 5 {
+		err = ErrShortFrame
+		return
+	}
+	frameLen	= 8
+	objCount	= int(rxbuf[7])
+
+	for i := 0; i < objCount; i++ {
+		// object id and object length (1 byte each)
+		if frameLen + 2 + 2 > maxRTUFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+		byteCount, err	= io.ReadFull(rt.link, rxbuf[frameLen:frameLen + 2])
+		if byteCount != 2 {
+			err = ErrShortFrame
+			return
+		}
+		byteCount	= int(rxbuf[frameLen + 1])
+		frameLen	+= 2
+
+		// object value
+		if frameLen + byteCount + 2 > maxRTUFrameLength {
+			err	= ErrProtocolError
+			return
+		}
+		if byteCount > 0 {
+			var n	int
+
+			n, err	= io.ReadFull(rt.link, rxbuf[frameLen:frameLen + byteCount])
+			if n != byteCount {
+				err = ErrShortFrame
+				return
+			}
+		}
+		frameLen	+= byteCount
+	}
+
+	return
+}
+
+// Waits for, reads and decodes a request frame from the rtu link.
+func (rt *rtuTransport) readRTURequest() (req *pdu, err error) {
+	var rxbuf	[]byte
+	var byteCount	int
+	var headerLen	int
+	var countAt	int
+	var dataLen	int
+	var frameLen	int
+	var crc		crc
+
+	rxbuf		= make([]byte, maxRTUFrameLength)
+
+	// wait for the unit id (1 byte)
+	_, err		= io.ReadFull(rt.link, rxbuf[0:1])
+	if err != nil {
+		return
+	}
+
+	// once the frame has started, the rest of it is expected to follow
+	// without delay
+	err	= rt.link.SetDeadline(
+		time.Now().Add(time.Duration(maxRTUFrameLength) * rt.t1 + 100 * time.Millisecond))
+	if err != nil {
+		return
+	}
+
+	// read the function code (1 byte)
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[1:2])
+	if byteCount != 1 {
+		err = ErrShortFrame
+		return
+	}
+
+	// figure out how many bytes make up the fixed part of the request
+	headerLen, countAt, err = expectedRequestLength(rxbuf[1])
+	if err != nil {
+		return
+	}
+
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[2:2 + headerLen])
+	if byteCount != headerLen {
+		err = ErrShortFrame
+		return
+	}
+
+	// add the variable part of the request, if any
+	dataLen		= 0
+	if countAt >= 0 {
+		dataLen	= int(rxbuf[2 + countAt])
+	}
+
+	// frame length, excluding the CRC
+	frameLen	= 2 + headerLen + dataLen
+
+	// never read more than the max allowed frame length
+	if frameLen + 2 > maxRTUFrameLength {
+		err	= ErrProtocolError
+		return
+	}
+
+	// read the data bytes, followed by 2 bytes of CRC
+	byteCount, err	= io.ReadFull(rt.link, rxbuf[2 + headerLen:frameLen + 2])
+	if byteCount != dataLen + 2 {
+		err = ErrShortFrame
+		return
+	}
+
+	// compute the CRC on the entire frame, excluding the CRC
+	crc.init()
+	crc.add(rxbuf[0:frameLen])
+
+	// compare CRC values
+	if !crc.isEqual(rxbuf[frameLen], rxbuf[frameLen + 1]) {
+		err = ErrBadCRC
+		return
+	}
+
+	req	= &pdu{
+		unitId:		rxbuf[0],
+		functionCode:	rxbuf[1],
+		// pass the request fields and data as payload, without the CRC
+		payload:	rxbuf[2:frameLen],
 	}
 
 	return
@@ -282,6 +508,10 @@ func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount
 	     fcWriteSingleCoil,
 	     fcWriteMultipleCoils:            byteCount = 3
 	case fcMaskWriteRegister:             byteCount = 5
+	case fcReadWriteMultipleRegisters,
+	     fcReadFileRecord,
+	     fcWriteFileRecord:               byteCount = int(responseLength)
+	case fcDiagnostics:                   byteCount = 3
 	case fcReadHoldingRegisters | 0x80,
 	     fcReadInputRegisters | 0x80,
 	     fcReadCoils | 0x80,
@@ -290,7 +520,42 @@ func expectedResponseLenth(responseCode uint8, responseLength uint8) (byteCount
 	     fcWriteMultipleRegisters | 0x80,
 	     fcWriteSingleCoil | 0x80,
 	     fcWriteMultipleCoils | 0x80,
-	     fcMaskWriteRegister | 0x80:      byteCount = 0
+	     fcMaskWriteRegister | 0x80,
+	     fcReadWriteMultipleRegisters | 0x80,
+	     fcReadFifoQueue | 0x80,
+	     fcReadFileRecord | 0x80,
+	     fcWriteFileRecord | 0x80,
+	     fcDiagnostics | 0x80,
+	     fcEncapsulatedInterface | 0x80:  byteCount = 0
+	default: err = ErrProtocolError
+	}
+
+	return
+}
+
+// Computes the expected length of a modbus RTU request: headerLen is the
+// number of fixed bytes following the function code, countAt the offset
+// (within those bytes) of the byte count field giving the number of extra
+// data bytes, or -1 if the request has no variable part.
+func expectedRequestLength(functionCode uint8) (headerLen int, countAt int, err error) {
+	countAt	= -1
+
+	switch functionCode {
+	case fcReadCoils,
+	     fcReadDiscreteInputs,
+	     fcReadHoldingRegisters,
+	     fcReadInputRegisters,
+	     fcWriteSingleCoil,
+	     fcWriteSingleRegister:           headerLen = 4
+	case fcWriteMultipleCoils,
+	     fcWriteMultipleRegisters:        headerLen, countAt = 5, 4
+	case fcMaskWriteRegister:             headerLen = 6
+	case fcReadWriteMultipleRegisters:    headerLen, countAt = 9, 8
+	case fcReadFifoQueue:                 headerLen = 2
+	case fcReadFileRecord,
+	     fcWriteFileRecord:               headerLen, countAt = 1, 0
+	case fcDiagnostics:                   headerLen = 4
+	case fcEncapsulatedInterface:         headerLen = 3
 	default: err = ErrProtocolError
 	}
 
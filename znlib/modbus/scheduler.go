@@ -0,0 +1,321 @@
+package modbus
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSchedulerInterval    = 1 * time.Second
+	defaultSchedulerMaxFailures = 3
+	defaultSchedulerBackoff     = 5 * time.Second
+	defaultSchedulerMaxBackoff  = 1 * time.Minute
+)
+
+// Bus scheduler configuration object (+dmzn).
+type BusSchedulerConfiguration struct {
+	// UnitIds lists the units to poll, in round-robin order
+	UnitIds       []uint8
+	// Poll is called for each unit in turn, with the unit id of the client
+	// set accordingly, and reads or writes whatever the unit is polled for
+	Poll          func(client *ModbusClient, unitId uint8) (err error)
+	// Interval sets the delay between the start of two rounds (defaults
+	// to 1s)
+	Interval      time.Duration
+	// Timeout sets the request timeout used with each unit (defaults to
+	// the timeout of the client), Timeouts overrides it by unit id
+	Timeout       time.Duration
+	Timeouts      map[uint8]time.Duration
+	// MaxFailures sets the number of consecutive failed polls after which
+	// a unit is considered offline (defaults to 3)
+	MaxFailures   uint
+	// Backoff sets how long an offline unit is skipped before being probed
+	// again, doubled after each failed probe up to MaxBackoff (defaults to
+	// 5s and 1 minute)
+	Backoff       time.Duration
+	MaxBackoff    time.Duration
+	// OnStateChange is called whenever a unit goes offline or back online
+	OnStateChange func(state *UnitState)
+}
+
+// Poll state of a unit, as tracked by the bus scheduler.
+type UnitState struct {
+	UnitId    uint8
+	Online    bool		// units are assumed online until they fail
+	Failures  uint		// number of consecutive failed polls
+	LastError error		// error of the last poll, if any
+	LastPoll  time.Time	// time of the last poll
+	NextProbe time.Time	// time of the next probe (offline units only)
+}
+
+// Bus scheduler object, polling the units of a shared bus (e.g. RS-485) in
+// round-robin order so that dead slaves do not stall the bus: once offline,
+// a unit is only probed now and then, with an increasing back-off.
+type BusScheduler struct {
+	conf	BusSchedulerConfiguration
+	client	*ModbusClient
+	lock	sync.Mutex	// protects stop
+	slock	sync.Mutex	// protects units
+	units	[]*busUnit
+	stop	chan struct{}
+	wg	sync.WaitGroup
+}
+
+// Unit polled by the bus scheduler.
+type busUnit struct {
+	state	UnitState
+	timeout	time.Duration
+	backoff	time.Duration
+}
+
+// NewBusScheduler returns a scheduler polling the units of conf through
+// client, which is expected to be open and dedicated to the scheduler while
+// it runs (its unit id and timeout are set before polling each unit).
+func NewBusScheduler(client *ModbusClient, conf *BusSchedulerConfiguration) (bs *BusScheduler, err error) {
+	var timeout	time.Duration
+	var seen	map[uint8]bool
+
+	if client == nil || conf == nil || conf.Poll == nil || len(conf.UnitIds) == 0 {
+		err	= ErrUnexpectedParameters
+		return
+	}
+
+	bs	= &BusScheduler{
+		conf:	*conf,
+		client:	client,
+	}
+
+	if bs.conf.Interval == 0 {
+		bs.conf.Interval	= defaultSchedulerInterval
+	}
+
+	if bs.conf.Timeout == 0 {
+		client.lock.Lock()
+		bs.conf.Timeout	= client.conf.Timeout
+		client.lock.Unlock()
+	}
+
+	if bs.conf.MaxFailures == 0 {
+		bs.conf.MaxFailures	= defaultSchedulerMaxFailures
+	}
+
+	if bs.conf.Backoff == 0 {
+		bs.conf.Backoff		= defaultSchedulerBackoff
+	}
+
+	if bs.conf.MaxBackoff == 0 {
+		bs.conf.MaxBackoff	= defaultSchedulerMaxBackoff
+	}
+
+	if bs.conf.MaxBackoff < bs.conf.Backoff {
+		bs.conf.MaxBackoff	= bs.conf.Backoff
+	}
+
+	seen	= map[uint8]bool{}
+	for _, unitId := range bs.conf.UnitIds {
+		if seen[unitId] {
+			bs	= nil
+			err	= ErrUnexpectedParameters
+			return
+		}
+		seen[unitId]	= true
+
+		timeout	= bs.conf.Timeouts[unitId]
+		if timeout == 0 {
+			timeout	= bs.conf.Timeout
+		}
+
+		bs.units	= append(bs.units, &busUnit{
+			state:		UnitState{UnitId: unitId, Online: true},
+			timeout:	timeout,
+		})
+	}
+
+	return
+}
+
+// Starts polling units in the background.
+func (bs *BusScheduler) Start() (err error) {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+
+	if bs.stop != nil {
+		return
+	}
+
+	bs.stop	= make(chan struct{})
+	bs.wg.Add(1)
+	go bs.run(bs.stop)
+
+	return
+}
+
+// Stops polling and waits for the current poll to complete.
+func (bs *BusScheduler) Stop() (err error) {
+	bs.lock.Lock()
+	if bs.stop == nil {
+		bs.lock.Unlock()
+		return
+	}
+
+	close(bs.stop)
+	bs.stop	= nil
+	bs.lock.Unlock()
+
+	bs.wg.Wait()
+
+	return
+}
+
+// Polls all units due once (offline units are skipped until their next
+// probe), returning the first poll error if any.
+func (bs *BusScheduler) Poll() (err error) {
+	err	= bs.pollRound(nil)
+
+	return
+}
+
+// Returns the poll state of a unit.
+func (bs *BusScheduler) State(unitId uint8) (state UnitState, ok bool) {
+	bs.slock.Lock()
+	defer bs.slock.Unlock()
+
+	for _, u := range bs.units {
+		if u.state.UnitId == unitId {
+			state, ok	= u.state, true
+			break
+		}
+	}
+
+	return
+}
+
+// Returns the poll state of all units, in polling order.
+func (bs *BusScheduler) States() (states []UnitState) {
+	bs.slock.Lock()
+	defer bs.slock.Unlock()
+
+	for _, u := range bs.units {
+		states	= append(states, u.state)
+	}
+
+	return
+}
+
+// Polls units every interval until stop is closed.
+func (bs *BusScheduler) run(stop chan struct{}) {
+	var ticker	*time.Ticker
+
+	defer bs.wg.Done()
+
+	ticker	= time.NewTicker(bs.conf.Interval)
+	defer ticker.Stop()
+
+	for {
+		bs.pollRound(stop)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Polls each unit due in turn, returning the first poll error if any. Stops
+// early once stop is closed.
+func (bs *BusScheduler) pollRound(stop chan struct{}) (err error) {
+	var previous	time.Duration
+	var now		time.Time
+	var due		bool
+	var e		error
+
+	previous	= bs.client.setTimeout(bs.conf.Timeout)
+	defer bs.client.setTimeout(previous)
+
+	for _, u := range bs.units {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
+		now	= time.Now()
+		bs.slock.Lock()
+		due	= u.state.Online || !now.Before(u.state.NextProbe)
+		bs.slock.Unlock()
+		if !due {
+			continue
+		}
+
+		bs.client.setTimeout(u.timeout)
+		bs.client.SetUnitId(u.state.UnitId)
+		e	= bs.conf.Poll(bs.client, u.state.UnitId)
+		bs.update(u, e)
+
+		if e != nil && err == nil {
+			err	= e
+		}
+	}
+
+	return
+}
+
+// Updates the state of a unit after a poll, notifying state changes.
+func (bs *BusScheduler) update(u *busUnit, err error) {
+	var state	UnitState
+	var changed	bool
+
+	bs.slock.Lock()
+	u.state.LastPoll	= time.Now()
+	u.state.LastError	= err
+
+	switch {
+	case !isUnresponsive(err):
+		// the unit answered, if only with an exception
+		u.state.Failures	= 0
+		u.state.NextProbe	= time.Time{}
+		u.backoff		= 0
+		if !u.state.Online {
+			u.state.Online	= true
+			changed		= true
+		}
+
+	case u.state.Online:
+		u.state.Failures++
+		if u.state.Failures >= bs.conf.MaxFailures {
+			u.state.Online		= false
+			u.state.NextProbe	= u.state.LastPoll.Add(bs.conf.Backoff)
+			u.backoff		= bs.conf.Backoff
+			changed			= true
+		}
+
+	default:
+		// failed probe: back off further
+		u.state.Failures++
+		u.backoff	*= 2
+		if u.backoff > bs.conf.MaxBackoff {
+			u.backoff	= bs.conf.MaxBackoff
+		}
+		u.state.NextProbe	= u.state.LastPoll.Add(u.backoff)
+	}
+
+	state	= u.state
+	bs.slock.Unlock()
+
+	if changed && bs.conf.OnStateChange != nil {
+		bs.conf.OnStateChange(&state)
+	}
+
+	return
+}
+
+// Returns true if err means that the unit did not answer (as opposed to
+// answering with an exception, busy devices included).
+func isUnresponsive(err error) (yes bool) {
+	yes	= err != nil && !errors.Is(err, ErrServerDeviceBusy) &&
+		  (IsRetriable(err) || errors.Is(err, ErrTransportNotOpen))
+
+	return
+}
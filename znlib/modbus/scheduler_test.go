@@ -0,0 +1,227 @@
+package modbus
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBroadcast(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var start	time.Time
+	var regs	[]uint16
+	var err		error
+
+	// unit #0 receives broadcasts
+	ds, _	= NewDataStore(&DataStoreConfiguration{
+		UnitIds:		[]uint8{0, 1},
+		HoldingRegisters:	16,
+	})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5524",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:			"rtuovertcp://localhost:5524",
+		TurnaroundDelay:	50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	client.SetUnitId(0)
+
+	// writes do not wait for a response but observe the turnaround delay
+	start	= time.Now()
+	err	= client.WriteRegisters(2, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	if time.Since(start) < 50 * time.Millisecond {
+		t.Errorf("turnaround delay not observed: %v", time.Since(start))
+	}
+
+	err	= client.WriteCoil(1, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+
+	// reads cannot be broadcast
+	_, err	= client.ReadRegisters(2, 2, HOLDING_REGISTER)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("expected ErrUnexpectedParameters, got: %v", err)
+	}
+
+	regs, _	= ds.Registers(0, HOLDING_REGISTER, 2, 2)
+	if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+		t.Errorf("unexpected registers: %v", regs)
+	}
+
+	// the server did not respond to broadcasts: nothing stale is left on the
+	// link for the next request
+	client.SetUnitId(1)
+	regs, err	= client.ReadRegisters(0, 2, HOLDING_REGISTER)
+	if err != nil || len(regs) != 2 {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v, %v", regs, err)
+	}
+
+	if client.Stats().Requests != 3 || client.Stats().Responses != 1 {
+		t.Errorf("unexpected client stats: %+v", client.Stats())
+	}
+
+	return
+}
+
+func TestBusScheduler(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var bs		*BusScheduler
+	var polls	map[uint8]int
+	var changes	[]UnitState
+	var state	UnitState
+	var start	time.Time
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{
+		UnitIds:		[]uint8{1, 2},
+		HoldingRegisters:	16,
+	})
+
+	// unit #3 is not served and never answers
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"rtuovertcp://localhost:5525",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5525",
+		Timeout:	2 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	polls	= map[uint8]int{}
+	bs, err	= NewBusScheduler(client, &BusSchedulerConfiguration{
+		UnitIds:	[]uint8{1, 2, 3},
+		Poll:		func(client *ModbusClient, unitId uint8) (err error) {
+			var addr	uint16
+
+			polls[unitId]++
+			// unit #2 answers with an exception
+			if unitId == 2 {
+				addr	= 100
+			}
+			_, err	= client.ReadRegister(addr, HOLDING_REGISTER)
+
+			return
+		},
+		Timeout:	500 * time.Millisecond,
+		Timeouts:	map[uint8]time.Duration{3: 50 * time.Millisecond},
+		MaxFailures:	2,
+		Backoff:	100 * time.Millisecond,
+		MaxBackoff:	150 * time.Millisecond,
+		OnStateChange:	func(state *UnitState) {
+			changes	= append(changes, *state)
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewBusScheduler() should have succeeded, got: %v", err)
+	}
+
+	// the dead unit only costs its own timeout
+	start	= time.Now()
+	err	= bs.Poll()
+	if err != ErrIllegalDataAddress {
+		t.Errorf("expected ErrIllegalDataAddress, got: %v", err)
+	}
+
+	if time.Since(start) > 400 * time.Millisecond {
+		t.Errorf("round took too long: %v", time.Since(start))
+	}
+
+	state, _	= bs.State(3)
+	if !state.Online || state.Failures != 1 || state.LastError != ErrRequestTimedOut {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	// second failure: unit #3 goes offline and is skipped by the next round
+	bs.Poll()
+	bs.Poll()
+
+	if polls[1] != 3 || polls[2] != 3 || polls[3] != 2 {
+		t.Errorf("unexpected poll counts: %v", polls)
+	}
+
+	if len(changes) != 1 || changes[0].UnitId != 3 || changes[0].Online {
+		t.Errorf("unexpected state changes: %+v", changes)
+	}
+
+	state, _	= bs.State(2)
+	if !state.Online || state.Failures != 0 {
+		t.Errorf("unit #2 should be online, got: %+v", state)
+	}
+
+	// the back-off doubles after each failed probe, up to MaxBackoff
+	time.Sleep(110 * time.Millisecond)
+	bs.Poll()
+
+	state, _	= bs.State(3)
+	if polls[3] != 3 || state.Online || state.Failures != 3 ||
+	   state.NextProbe.Sub(state.LastPoll) != 150 * time.Millisecond {
+		t.Errorf("unexpected state: %+v (polls: %v)", state, polls)
+	}
+
+	// the client timeout is restored after each round
+	if client.conf.Timeout != 2 * time.Second {
+		t.Errorf("unexpected client timeout: %v", client.conf.Timeout)
+	}
+
+	// back online
+	ds.AddUnit(3)
+	time.Sleep(160 * time.Millisecond)
+	bs.Poll()
+
+	if len(changes) != 2 || changes[1].UnitId != 3 || !changes[1].Online {
+		t.Errorf("unexpected state changes: %+v", changes)
+	}
+
+	if len(bs.States()) != 3 {
+		t.Errorf("unexpected states: %+v", bs.States())
+	}
+
+	return
+}
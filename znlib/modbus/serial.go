@@ -21,6 +21,26 @@ type serialPortConfig struct {
 	DataBits	uint
 	Parity		uint
 	StopBits	uint
+	RS485		RS485Config
+}
+
+// RS-485 direction control settings (+dmzn). When enabled, the kernel driver
+// toggles RTS around transmissions to drive the line transceiver. Only
+// supported on linux, by drivers implementing the TIOCSRS485 ioctl: opening
+// the port fails otherwise.
+type RS485Config struct {
+	// Enabled turns RS-485 mode on
+	Enabled			bool
+	// DelayRtsBeforeSend and DelayRtsAfterSend set how long RTS is
+	// asserted before and kept after a transmission (millisecond resolution)
+	DelayRtsBeforeSend	time.Duration
+	DelayRtsAfterSend	time.Duration
+	// RtsHighDuringSend and RtsHighAfterSend set the logical level of RTS
+	// during and after transmissions
+	RtsHighDuringSend	bool
+	RtsHighAfterSend	bool
+	// RxDuringTx keeps the receiver enabled while transmitting
+	RxDuringTx		bool
 }
 
 func newSerialPortWrapper(conf *serialPortConfig) (spw *serialPortWrapper) {
@@ -47,6 +67,14 @@ func (spw *serialPortWrapper) Open() (err error) {
 		Parity:		parity,
 		StopBits:	int(spw.conf.StopBits),
 		Timeout:	10 * time.Millisecond,
+		RS485:		serial.RS485Config{
+			Enabled:		spw.conf.RS485.Enabled,
+			DelayRtsBeforeSend:	spw.conf.RS485.DelayRtsBeforeSend,
+			DelayRtsAfterSend:	spw.conf.RS485.DelayRtsAfterSend,
+			RtsHighDuringSend:	spw.conf.RS485.RtsHighDuringSend,
+			RtsHighAfterSend:	spw.conf.RS485.RtsHighAfterSend,
+			RxDuringTx:		spw.conf.RS485.RxDuringTx,
+		},
 	})
 
 	return
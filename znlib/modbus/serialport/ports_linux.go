@@ -0,0 +1,114 @@
+package serialport
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	// where to look for tty devices (replaced by tests)
+	sysClassTTY	= "/sys/class/tty"
+	devDir		= "/dev"
+)
+
+// Lists the serial ports of the system, i.e. /dev/tty* devices backed by
+// hardware (USB adapters, on-board and PCI UARTs...), sorted by path.
+// Virtual terminals, pseudo terminals and unpopulated legacy ports are left
+// out.
+func ListPorts() (ports []*PortInfo, err error) {
+	var entries	[]os.DirEntry
+	var ids		map[string]string
+	var port	*PortInfo
+	var name	string
+	var target	string
+	var e		error
+
+	entries, err	= os.ReadDir(sysClassTTY)
+	if err != nil {
+		return
+	}
+
+	ids	= byIdLinks()
+
+	for _, entry := range entries {
+		name	= entry.Name()
+		if !strings.HasPrefix(name, "tty") {
+			continue
+		}
+
+		// virtual terminals have no device
+		_, e	= os.Stat(filepath.Join(sysClassTTY, name, "device"))
+		if e != nil {
+			continue
+		}
+
+		// the 8250 driver registers ports whether populated or not: skip
+		// those of unknown type
+		if readTrimmed(filepath.Join(sysClassTTY, name, "type")) == "0" {
+			continue
+		}
+
+		port	= &PortInfo{
+			Path:	filepath.Join(devDir, name),
+			Name:	name,
+			ID:	ids[name],
+		}
+
+		_, e	= os.Stat(port.Path)
+		if e != nil {
+			continue
+		}
+
+		target, e	= os.Readlink(filepath.Join(sysClassTTY, name, "device", "driver"))
+		if e == nil {
+			port.Driver	= filepath.Base(target)
+		}
+
+		ports	= append(ports, port)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Path < ports[j].Path })
+
+	return
+}
+
+// Returns the /dev/serial/by-id links, by device name.
+func byIdLinks() (ids map[string]string) {
+	var entries	[]os.DirEntry
+	var dir		string
+	var target	string
+	var err		error
+
+	ids	= map[string]string{}
+	dir	= filepath.Join(devDir, "serial", "by-id")
+
+	entries, err	= os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		target, err	= os.Readlink(filepath.Join(dir, entry.Name()))
+		if err == nil {
+			ids[filepath.Base(target)]	= filepath.Join(dir, entry.Name())
+		}
+	}
+
+	return
+}
+
+// Returns the content of a sysfs attribute, without surrounding whitespace
+// (empty if missing).
+func readTrimmed(path string) (value string) {
+	var data	[]byte
+	var err		error
+
+	data, err	= os.ReadFile(path)
+	if err == nil {
+		value	= strings.TrimSpace(string(data))
+	}
+
+	return
+}
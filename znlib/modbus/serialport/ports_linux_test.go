@@ -0,0 +1,82 @@
+package serialport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestListPorts(t *testing.T) {
+	var root	string
+	var savedSys	string
+	var savedDev	string
+	var ports	[]*PortInfo
+	var err		error
+
+	root	= t.TempDir()
+
+	// fake sysfs and /dev trees
+	savedSys, savedDev	= sysClassTTY, devDir
+	defer func() {
+		sysClassTTY, devDir	= savedSys, savedDev
+	}()
+	sysClassTTY	= filepath.Join(root, "sys")
+	devDir		= filepath.Join(root, "dev")
+
+	for _, dir := range []string{
+		"sys/ttyUSB0/device", "sys/drivers/ftdi_sio",
+		"sys/ttyS0/device", "sys/ttyS1/device",
+		"sys/tty0", "sys/console",
+		// no device node (e.g. not yet created by udev)
+		"sys/ttyACM0/device",
+		"dev/serial/by-id",
+	} {
+		err	= os.MkdirAll(filepath.Join(root, dir), 0755)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", dir, err)
+		}
+	}
+
+	for _, link := range [][2]string{
+		{"../../drivers/ftdi_sio", "sys/ttyUSB0/device/driver"},
+		{"../../ttyUSB0", "dev/serial/by-id/usb-FTDI_FT232R-if00-port0"},
+	} {
+		err	= os.Symlink(link[0], filepath.Join(root, link[1]))
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", link[1], err)
+		}
+	}
+
+	for _, file := range [][2]string{
+		{"sys/ttyS0/type", "4\n"},
+		// unpopulated legacy port
+		{"sys/ttyS1/type", "0\n"},
+		{"dev/ttyUSB0", ""}, {"dev/ttyS0", ""}, {"dev/ttyS1", ""}, {"dev/tty0", ""},
+	} {
+		err	= os.WriteFile(filepath.Join(root, file[0]), []byte(file[1]), 0644)
+		if err != nil {
+			t.Fatalf("failed to create %s: %v", file[0], err)
+		}
+	}
+
+	ports, err	= ListPorts()
+	if err != nil {
+		t.Fatalf("ListPorts() should have succeeded, got: %v", err)
+	}
+
+	if len(ports) != 2 {
+		t.Fatalf("expected 2 ports, got: %v", len(ports))
+	}
+
+	if ports[0].Name != "ttyS0" || ports[0].Path != filepath.Join(devDir, "ttyS0") ||
+	   ports[0].Driver != "" || ports[0].ID != "" {
+		t.Errorf("unexpected port: %+v", ports[0])
+	}
+
+	if ports[1].Name != "ttyUSB0" || ports[1].Driver != "ftdi_sio" ||
+	   ports[1].ID != filepath.Join(devDir, "serial/by-id/usb-FTDI_FT232R-if00-port0") {
+		t.Errorf("unexpected port: %+v", ports[1])
+	}
+
+	return
+}
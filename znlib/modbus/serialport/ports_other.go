@@ -0,0 +1,10 @@
+//go:build !linux
+
+package serialport
+
+// Lists the serial ports of the system (linux only).
+func ListPorts() (ports []*PortInfo, err error) {
+	err	= ErrNotSupported
+
+	return
+}
@@ -0,0 +1,265 @@
+// Package serialport discovers serial ports and detects the line settings
+// (speed, parity and stop bits) of modbus RTU devices attached to them, by
+// probing a known unit id with a cheap request.
+package serialport
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dmznlin/znlib-go/znlib/modbus"
+)
+
+var (
+	// ErrNotSupported is returned by ListPorts() on platforms where serial
+	// ports cannot be enumerated.
+	ErrNotSupported	= errors.New("serialport: not supported on this platform")
+	// ErrNotDetected is returned by Detect() when the probed unit did not
+	// answer with any of the candidate settings.
+	ErrNotDetected	= errors.New("serialport: no response with any candidate setting")
+)
+
+var (
+	// candidate speeds, most common first
+	defaultSpeeds	= []uint{19200, 9600, 38400, 57600, 115200, 4800, 2400}
+	// candidate framings: even parity is the modbus default, no parity
+	// requires 2 stop bits as per the spec but 1 stop bit is common as well
+	defaultFramings	= []Framing{
+		{DataBits: 8, Parity: modbus.PARITY_EVEN, StopBits: 1},
+		{DataBits: 8, Parity: modbus.PARITY_NONE, StopBits: 2},
+		{DataBits: 8, Parity: modbus.PARITY_NONE, StopBits: 1},
+		{DataBits: 8, Parity: modbus.PARITY_ODD,  StopBits: 1},
+	}
+	defaultProbeTimeout	= 200 * time.Millisecond
+
+	// runs a probe with the given settings (replaced by tests)
+	probeFunc	= probe
+)
+
+// Serial port, as returned by ListPorts().
+type PortInfo struct {
+	Path	string	// device path, e.g. /dev/ttyUSB0
+	Name	string	// device name, e.g. ttyUSB0
+	Driver	string	// kernel driver, e.g. ftdi_sio (if known)
+	ID	string	// stable /dev/serial/by-id path (if any)
+}
+
+// Character framing of a serial line.
+type Framing struct {
+	DataBits	uint
+	Parity		uint
+	StopBits	uint
+}
+
+// Serial line settings, as returned by Detect().
+type Settings struct {
+	Device		string
+	Speed		uint
+	Framing
+	RS485		modbus.RS485Config
+}
+
+// Auto-detection configuration object.
+type DetectConfiguration struct {
+	// UnitId sets the unit id to probe (defaults to 1)
+	UnitId		uint8
+	// Speeds lists the candidate speeds, in probing order (defaults to
+	// 19200, 9600, 38400, 57600, 115200, 4800 and 2400 bps)
+	Speeds		[]uint
+	// Framings lists the candidate framings, tried for each speed in order
+	// (defaults to 8E1, 8N2, 8N1 and 8O1)
+	Framings	[]Framing
+	// Timeout sets how long to wait for a response to each probe (defaults
+	// to 200ms)
+	Timeout		time.Duration
+	// Probe sends the probe request through client, whose unit id is set
+	// to UnitId (defaults to reading holding register 0). Exception
+	// responses count as an answer: only the line settings matter.
+	Probe		func(client *modbus.ModbusClient) (err error)
+	// RS485 sets the RS-485 direction control settings of the port
+	RS485		modbus.RS485Config
+	// Logger provides a custom sink for log messages of probing clients
+	Logger		*log.Logger
+}
+
+// Returns the URL of the settings, for use with modbus.NewClient().
+func (s *Settings) URL() (url string) {
+	url	= "rtu://" + s.Device
+
+	return
+}
+
+// Returns a client configuration using the settings.
+func (s *Settings) ClientConfiguration() (conf *modbus.ClientConfiguration) {
+	conf	= &modbus.ClientConfiguration{
+		URL:		s.URL(),
+		Speed:		s.Speed,
+		DataBits:	s.DataBits,
+		Parity:		s.Parity,
+		StopBits:	s.StopBits,
+		RS485:		s.RS485,
+	}
+
+	return
+}
+
+// Returns the settings in the usual notation, e.g. "/dev/ttyUSB0 19200 8E1".
+func (s *Settings) String() (str string) {
+	var parity	string
+
+	switch s.Parity {
+	case modbus.PARITY_EVEN:	parity	= "E"
+	case modbus.PARITY_ODD:		parity	= "O"
+	default:			parity	= "N"
+	}
+
+	str	= fmt.Sprintf("%s %d %d%s%d", s.Device, s.Speed, s.DataBits, parity, s.StopBits)
+
+	return
+}
+
+// Detects the line settings of device by probing unit conf.UnitId with each
+// candidate speed and framing in turn, until it answers. Returns
+// ErrNotDetected if it never does, or the error of the first probe if the
+// device cannot be opened at all.
+func Detect(device string, conf *DetectConfiguration) (settings *Settings, err error) {
+	var dc		DetectConfiguration
+	var s		*Settings
+	var opened	bool
+
+	if conf != nil {
+		dc	= *conf
+	}
+	dc.setDefaults()
+
+	for _, speed := range dc.Speeds {
+		for _, framing := range dc.Framings {
+			s	= &Settings{
+				Device:		device,
+				Speed:		speed,
+				Framing:	framing,
+				RS485:		dc.RS485,
+			}
+
+			opened, err	= probeFunc(s, &dc)
+			if !opened {
+				// no point in trying other settings
+				return
+			}
+
+			if answered(err) {
+				settings	= s
+				err		= nil
+				return
+			}
+		}
+	}
+
+	err	= ErrNotDetected
+
+	return
+}
+
+// Lists serial ports (see ListPorts()) and runs Detect() on each of them,
+// returning the settings of ports where unit conf.UnitId answered.
+func Scan(conf *DetectConfiguration) (found []*Settings, err error) {
+	var ports	[]*PortInfo
+	var s		*Settings
+
+	ports, err	= ListPorts()
+	if err != nil {
+		return
+	}
+
+	for _, port := range ports {
+		s, err	= Detect(port.Path, conf)
+		if err == nil {
+			found	= append(found, s)
+		}
+	}
+	err	= nil
+
+	return
+}
+
+// Fills in defaults.
+func (dc *DetectConfiguration) setDefaults() {
+	if dc.UnitId == 0 {
+		dc.UnitId	= 1
+	}
+
+	if len(dc.Speeds) == 0 {
+		dc.Speeds	= defaultSpeeds
+	}
+
+	if len(dc.Framings) == 0 {
+		dc.Framings	= defaultFramings
+	}
+
+	if dc.Timeout == 0 {
+		dc.Timeout	= defaultProbeTimeout
+	}
+
+	if dc.Probe == nil {
+		dc.Probe	= func(client *modbus.ModbusClient) (err error) {
+			_, err	= client.ReadRegister(0, modbus.HOLDING_REGISTER)
+
+			return
+		}
+	}
+
+	return
+}
+
+// Opens the device with settings s and runs the probe request, returning
+// whether the device could be opened along with the probe error.
+func probe(s *Settings, dc *DetectConfiguration) (opened bool, err error) {
+	var client	*modbus.ModbusClient
+	var conf	*modbus.ClientConfiguration
+
+	conf		= s.ClientConfiguration()
+	conf.Timeout	= dc.Timeout
+	conf.Logger	= dc.Logger
+
+	client, err	= modbus.NewClient(conf)
+	if err != nil {
+		return
+	}
+
+	err	= client.Open()
+	if err != nil {
+		return
+	}
+	defer client.Close()
+	opened	= true
+
+	client.SetUnitId(dc.UnitId)
+	err	= dc.Probe(client)
+
+	return
+}
+
+// Returns true if err means that the unit answered the probe, albeit with
+// an exception or from another unit id: the line settings are right.
+func answered(err error) (yes bool) {
+	switch {
+	case err == nil:
+		yes	= true
+
+	case errors.Is(err, modbus.ErrIllegalFunction),
+	     errors.Is(err, modbus.ErrIllegalDataAddress),
+	     errors.Is(err, modbus.ErrIllegalDataValue),
+	     errors.Is(err, modbus.ErrServerDeviceFailure),
+	     errors.Is(err, modbus.ErrAcknowledge),
+	     errors.Is(err, modbus.ErrServerDeviceBusy),
+	     errors.Is(err, modbus.ErrMemoryParityError),
+	     errors.Is(err, modbus.ErrGWPathUnavailable),
+	     errors.Is(err, modbus.ErrGWTargetFailedToRespond),
+	     errors.Is(err, modbus.ErrBadUnitId):
+		yes	= true
+	}
+
+	return
+}
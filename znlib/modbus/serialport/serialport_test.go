@@ -0,0 +1,133 @@
+package serialport
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dmznlin/znlib-go/znlib/modbus"
+)
+
+// Replaces the probe function for the duration of a test.
+func stubProbe(t *testing.T, fn func(s *Settings, dc *DetectConfiguration) (bool, error)) {
+	var saved	= probeFunc
+
+	probeFunc	= fn
+	t.Cleanup(func() { probeFunc = saved })
+
+	return
+}
+
+func TestDetect(t *testing.T) {
+	var tried	[]string
+	var settings	*Settings
+	var err		error
+
+	// the device answers at 9600 bps, 8N1, with an exception
+	stubProbe(t, func(s *Settings, dc *DetectConfiguration) (bool, error) {
+		tried	= append(tried, s.String())
+
+		if dc.UnitId != 1 || dc.Timeout != defaultProbeTimeout || dc.Probe == nil {
+			t.Errorf("unexpected configuration: %+v", dc)
+		}
+
+		if s.Speed == 9600 && s.Parity == modbus.PARITY_NONE && s.StopBits == 1 {
+			return true, modbus.ErrIllegalDataAddress
+		}
+
+		return true, modbus.ErrRequestTimedOut
+	})
+
+	settings, err	= Detect("/dev/ttyUSB0", nil)
+	if err != nil {
+		t.Fatalf("Detect() should have succeeded, got: %v", err)
+	}
+
+	if settings.String() != "/dev/ttyUSB0 9600 8N1" {
+		t.Errorf("unexpected settings: %v", settings)
+	}
+
+	// speeds first, then framings
+	if len(tried) != 7 || tried[0] != "/dev/ttyUSB0 19200 8E1" ||
+	   tried[3] != "/dev/ttyUSB0 19200 8O1" || tried[4] != "/dev/ttyUSB0 9600 8E1" {
+		t.Errorf("unexpected probes: %q", tried)
+	}
+
+	// candidates and RS-485 settings come from the configuration
+	tried	= nil
+	stubProbe(t, func(s *Settings, dc *DetectConfiguration) (bool, error) {
+		tried	= append(tried, s.String())
+
+		if dc.UnitId != 5 || !s.RS485.Enabled {
+			t.Errorf("unexpected configuration: %+v, %+v", dc, s)
+		}
+
+		return true, modbus.ErrBadCRC
+	})
+
+	_, err	= Detect("/dev/ttyS1", &DetectConfiguration{
+		UnitId:		5,
+		Speeds:		[]uint{4800, 1200},
+		Framings:	[]Framing{{DataBits: 7, Parity: modbus.PARITY_EVEN, StopBits: 1}},
+		RS485:		modbus.RS485Config{Enabled: true},
+	})
+	if err != ErrNotDetected {
+		t.Errorf("expected ErrNotDetected, got: %v", err)
+	}
+
+	if len(tried) != 2 || tried[0] != "/dev/ttyS1 4800 7E1" || tried[1] != "/dev/ttyS1 1200 7E1" {
+		t.Errorf("unexpected probes: %q", tried)
+	}
+
+	// ports which cannot be opened are not probed any further
+	tried	= nil
+	stubProbe(t, func(s *Settings, dc *DetectConfiguration) (bool, error) {
+		tried	= append(tried, s.String())
+
+		return false, errors.New("permission denied")
+	})
+
+	_, err	= Detect("/dev/ttyS2", nil)
+	if err == nil || err.Error() != "permission denied" || len(tried) != 1 {
+		t.Errorf("unexpected result: %v (probes: %q)", err, tried)
+	}
+
+	return
+}
+
+func TestDetectMissingDevice(t *testing.T) {
+	var err	error
+
+	_, err	= Detect("/dev/this-device-does-not-exist", &DetectConfiguration{
+		Speeds:	[]uint{9600},
+	})
+	if err == nil || err == ErrNotDetected {
+		t.Errorf("expected an open error, got: %v", err)
+	}
+
+	return
+}
+
+func TestSettings(t *testing.T) {
+	var s		*Settings
+	var conf	*modbus.ClientConfiguration
+
+	s	= &Settings{
+		Device:		"/dev/ttyUSB1",
+		Speed:		38400,
+		Framing:	Framing{DataBits: 8, Parity: modbus.PARITY_ODD, StopBits: 1},
+		RS485:		modbus.RS485Config{Enabled: true, RtsHighDuringSend: true},
+	}
+
+	if s.String() != "/dev/ttyUSB1 38400 8O1" {
+		t.Errorf("unexpected string: %v", s.String())
+	}
+
+	conf	= s.ClientConfiguration()
+	if conf.URL != "rtu:///dev/ttyUSB1" || conf.Speed != 38400 ||
+	   conf.DataBits != 8 || conf.Parity != modbus.PARITY_ODD ||
+	   conf.StopBits != 1 || !conf.RS485.RtsHighDuringSend {
+		t.Errorf("unexpected client configuration: %+v", conf)
+	}
+
+	return
+}
@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -20,8 +21,21 @@ var modbusRoleOID asn1.ObjectIdentifier = asn1.ObjectIdentifier{
 
 // Server configuration object.
 type ServerConfiguration struct {
-	// URL defines where to listen at e.g. tcp://[::]:502
+	// URL defines where to listen at e.g. tcp://[::]:502,
+	// rtu:///dev/ttyUSB0, rtuovertcp://[::]:502, rtuoverudp://[::]:502,
+	// ascii:///dev/ttyUSB0, asciiovertcp://[::]:502 or udp://[::]:502
 	URL           string
+	// Speed sets the serial link speed (in bps, rtu only)
+	Speed         uint
+	// DataBits sets the number of bits per serial character (rtu only)
+	DataBits      uint
+	// Parity sets the serial link parity mode (rtu only)
+	Parity        uint
+	// StopBits sets the number of serial stop bits (rtu only)
+	StopBits      uint
+	// RS485 enables RS-485 direction control (RTS toggling) by the kernel
+	// driver (rtu and ascii only, linux only) (+dmzn)
+	RS485         RS485Config
 	// Timeout sets the idle session timeout (client connections will
 	// be closed if idle for this long)
 	Timeout	      time.Duration
@@ -36,6 +50,9 @@ type ServerConfiguration struct {
 	// Logger provides a custom sink for log messages.
 	// If nil, messages will be written to stdout.
 	Logger        *log.Logger
+	// Capture receives a copy of every frame sent or received (optional),
+	// e.g. a HexDumpCapture or PcapCapture (+dmzn)
+	Capture       FrameCapture
 }
 
 // Request object passed to the coil handler.
@@ -81,6 +98,42 @@ type InputRegistersRequest struct {
 	Quantity   uint16   // the number of consecutive registers covered by this request
 }
 
+// Request object passed to the fifo queue handler.
+type FifoQueueRequest struct {
+	ClientAddr string   // the source (client) IP address
+	ClientRole string   // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8    // the requested unit id (slave id)
+	Addr       uint16   // the fifo pointer address requested
+}
+
+// Request object passed to the file record handler.
+type FileRecordsRequest struct {
+	ClientAddr string        // the source (client) IP address
+	ClientRole string        // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8         // the requested unit id (slave id)
+	IsWrite    bool          // true if the request is a write, false if a read
+	Records    []*FileRecord // the file records covered by this request (Length
+	                         // is set for reads, Values for writes)
+}
+
+// Request object passed to the diagnostics handler.
+type DiagnosticsRequest struct {
+	ClientAddr  string  // the source (client) IP address
+	ClientRole  string  // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId      uint8   // the requested unit id (slave id)
+	SubFunction uint16  // the diagnostics sub-function code (see DIAG_* in client.go)
+	Data        []byte  // the request data field
+}
+
+// Request object passed to the device identification handler.
+type DeviceIdentificationRequest struct {
+	ClientAddr string  // the source (client) IP address
+	ClientRole string  // the client role as encoded in the client certificate (tcp+tls only)
+	UnitId     uint8   // the requested unit id (slave id)
+	ReadCode   uint8   // the read device id code (see DEVID_* in client.go)
+	ObjectId   uint8   // the first (or only) object id requested
+}
+
 // The RequestHandler interface should be implemented by the handler
 // object passed to NewServer (see reqHandler in NewServer()).
 // After decoding and validating an incoming request, the server will
@@ -139,6 +192,71 @@ type RequestHandler interface {
 	HandleInputRegisters	(req *InputRegistersRequest) (res []uint16, err error)
 }
 
+// The following interfaces may optionally be implemented by the handler
+// object passed to NewServer, to serve the function codes not covered by
+// RequestHandler. Requests for those function codes are answered with an
+// illegal function exception when the handler does not implement the
+// matching interface.
+// Note that mask write register (0x16) and read/write multiple registers
+// (0x17) requests are served through HandleHoldingRegisters.
+
+// FifoQueueHandler handles the read fifo queue (0x18) function code.
+type FifoQueueHandler interface {
+	// HandleFifoQueue is passed a FifoQueueRequest object (see above).
+	//
+	// Expected return values:
+	// - res:	a slice of uint16 containing the queued register values
+	//		(31 at most) to be sent back to the client,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFifoQueue		(req *FifoQueueRequest) (res []uint16, err error)
+}
+
+// FileRecordHandler handles the read file record (0x14) and write file
+// record (0x15) function codes.
+type FileRecordHandler interface {
+	// HandleFileRecords is passed a FileRecordsRequest object (see above).
+	//
+	// Expected return values:
+	// - res:	one slice of uint16 per requested record, in request order,
+	//		each holding Length register values (only sent for reads),
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleFileRecords	(req *FileRecordsRequest) (res [][]uint16, err error)
+}
+
+// DiagnosticsHandler handles the diagnostics (0x08) function code.
+// When not implemented, the server still echoes DIAG_RETURN_QUERY_DATA
+// requests.
+type DiagnosticsHandler interface {
+	// HandleDiagnostics is passed a DiagnosticsRequest object (see above).
+	//
+	// Expected return values:
+	// - res:	the data field to be sent back to the client,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleDiagnostics	(req *DiagnosticsRequest) (res []byte, err error)
+}
+
+// DeviceIdentificationHandler handles the read device identification
+// (0x2b/0x0e) function code.
+type DeviceIdentificationHandler interface {
+	// HandleDeviceIdentification is passed a DeviceIdentificationRequest
+	// object (see above).
+	//
+	// Expected return values:
+	// - res:	the device identification objects. The server picks the
+	//		objects matching the read code and splits them over multiple
+	//		responses if needed,
+	// - err:	either nil if no error occurred, a modbus error (see
+	//		mapErrorToExceptionCode() in modbus.go for a complete list),
+	//		or any other error.
+	HandleDeviceIdentification	(req *DeviceIdentificationRequest) (res *DeviceIdentification, err error)
+}
+
 // Modbus server object.
 type ModbusServer struct {
 	conf		ServerConfiguration
@@ -148,7 +266,12 @@ type ModbusServer struct {
 	handler		RequestHandler
 	tcpListener	net.Listener
 	tcpClients	[]net.Conn
+	udpSock		*net.UDPConn
+	rtuLink		transport
 	transportType	transportType
+	// traffic counters of active connections and of closed ones (+dmzn)
+	monitors	map[*linkMonitor]bool
+	closedStats	Stats
 }
 
 // Returns a new modbus server.
@@ -162,6 +285,8 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 	ms = &ModbusServer{
 		conf:		*conf,
 		handler:	reqHandler,
+		monitors:	map[*linkMonitor]bool{},
+		closedStats:	newStats(),
 	}
 
 	splitURL = strings.SplitN(ms.conf.URL, "://", 2)
@@ -217,6 +342,105 @@ func NewServer(conf *ServerConfiguration, reqHandler RequestHandler) (
 
 		ms.transportType	= modbusTCPOverTLS
 
+	case "rtu":
+		// same defaults as the client (see NewClient())
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed	= 19200
+		}
+
+		if ms.conf.DataBits == 0 {
+			ms.conf.DataBits = 8
+		}
+
+		if ms.conf.StopBits == 0 {
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.StopBits = 2
+			} else {
+				ms.conf.StopBits = 1
+			}
+		}
+
+		// how long to wait for a request before polling the line again
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType	= modbusRTU
+
+	case "ascii":
+		// same defaults as the client (see NewClient())
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed	= 9600
+		}
+
+		// default to 7E1: parity is only defaulted along with the data
+		// bits, so that e.g. 7N2 can be configured
+		if ms.conf.DataBits == 0 {
+			ms.conf.DataBits = 7
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.Parity = PARITY_EVEN
+			}
+		}
+
+		if ms.conf.StopBits == 0 {
+			if ms.conf.Parity == PARITY_NONE {
+				ms.conf.StopBits = 2
+			} else {
+				ms.conf.StopBits = 1
+			}
+		}
+
+		// how long to wait for a request before polling the line again
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType	= modbusASCII
+
+	case "asciiovertcp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType	= modbusASCIIOverTCP
+
+	case "rtuovertcp":
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed	= 19200
+		}
+
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 120 * time.Second
+		}
+
+		if ms.conf.MaxClients == 0 {
+			ms.conf.MaxClients = 10
+		}
+
+		ms.transportType	= modbusRTUOverTCP
+
+	case "rtuoverudp":
+		if ms.conf.Speed == 0 {
+			ms.conf.Speed	= 19200
+		}
+
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType	= modbusRTUOverUDP
+
+	case "udp":
+		if ms.conf.Timeout == 0 {
+			ms.conf.Timeout = 1 * time.Second
+		}
+
+		ms.transportType	= modbusTCPOverUDP
+
 	default:
 		err	= ErrConfigurationError
 		return
@@ -235,7 +459,7 @@ func (ms *ModbusServer) Start() (err error) {
 	}
 
 	switch ms.transportType {
-	case modbusTCP, modbusTCPOverTLS:
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
 		// bind to a TCP socket
 		ms.tcpListener, err	= net.Listen("tcp", ms.conf.URL)
 		if err != nil {
@@ -245,6 +469,57 @@ func (ms *ModbusServer) Start() (err error) {
 		// accept client connections in a goroutine
 		go ms.acceptTCPClients()
 
+	case modbusRTUOverUDP, modbusTCPOverUDP:
+		var addr	*net.UDPAddr
+
+		// bind to a UDP socket
+		addr, err	= net.ResolveUDPAddr("udp", ms.conf.URL)
+		if err != nil {
+			return
+		}
+
+		ms.udpSock, err	= net.ListenUDP("udp", addr)
+		if err != nil {
+			return
+		}
+
+		// serve incoming datagrams in a goroutine
+		go ms.handleUDPPackets(ms.udpSock, ms.addMonitor(ms.conf.URL))
+
+	case modbusRTU, modbusASCII:
+		var spw		*serialPortWrapper
+
+		// create a serial port wrapper object
+		spw = newSerialPortWrapper(&serialPortConfig{
+			Device:		ms.conf.URL,
+			Speed:		ms.conf.Speed,
+			DataBits:	ms.conf.DataBits,
+			Parity:		ms.conf.Parity,
+			StopBits:	ms.conf.StopBits,
+			RS485:		ms.conf.RS485,
+		})
+
+		// open the serial device
+		err = spw.Open()
+		if err != nil {
+			return
+		}
+
+		// discard potentially stale serial data
+		discard(spw)
+
+		// serve requests off the serial line in a goroutine
+		if ms.transportType == modbusASCII {
+			ms.rtuLink = newASCIITransport(
+				spw, ms.conf.URL, ms.conf.Timeout, ms.conf.Logger)
+		} else {
+			ms.rtuLink = newRTUTransport(
+				spw, ms.conf.URL, ms.conf.Speed, ms.conf.Timeout, ms.conf.Logger)
+		}
+		lm := ms.addMonitor(ms.conf.URL)
+		monitorTransport(ms.rtuLink, lm)
+		go ms.handleSharedLink(ms.rtuLink, ms.conf.URL, lm)
+
 	default:
 		err = ErrConfigurationError
 		return
@@ -266,7 +541,8 @@ func (ms *ModbusServer) Stop() (err error) {
 
 	ms.started = false
 
-	if ms.transportType == modbusTCP || ms.transportType == modbusTCPOverTLS {
+	switch ms.transportType {
+	case modbusTCP, modbusTCPOverTLS, modbusRTUOverTCP, modbusASCIIOverTCP:
 		// close the server socket if we're listening over TCP
 		err	= ms.tcpListener.Close()
 
@@ -274,6 +550,14 @@ func (ms *ModbusServer) Stop() (err error) {
 		for _, sock := range ms.tcpClients{
 			sock.Close()
 		}
+
+	case modbusRTUOverUDP, modbusTCPOverUDP:
+		// close the UDP socket
+		err	= ms.udpSock.Close()
+
+	case modbusRTU, modbusASCII:
+		// close the serial port
+		err	= ms.rtuLink.Close()
 	}
 
 	return
@@ -341,6 +625,20 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 			newTCPTransport(sock, ms.conf.Timeout, ms.conf.Logger),
 			sock.RemoteAddr().String(), "")
 
+	case modbusRTUOverTCP:
+		// serve RTU framed requests over the raw TCP connection
+		ms.handleTransport(
+			newRTUTransport(sock, sock.RemoteAddr().String(),
+					ms.conf.Speed, ms.conf.Timeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
+	case modbusASCIIOverTCP:
+		// serve ASCII framed requests over the raw TCP connection
+		ms.handleTransport(
+			newASCIITransport(sock, sock.RemoteAddr().String(),
+					  ms.conf.Timeout, ms.conf.Logger),
+			sock.RemoteAddr().String(), "")
+
 	case modbusTCPOverTLS:
 		// start TLS negotiation over the raw TCP connection
 		tlsSock, clientRole, err = ms.startTLS(sock)
@@ -375,437 +673,1207 @@ func (ms *ModbusServer) handleTCPClient(sock net.Conn) {
 	return
 }
 
-// For each request read from the transport, performs decoding and validation,
-// calls the user-provided handler, then encodes and writes the response
-// to the transport.
+// For each request read from the transport, calls handleRequest() to decode,
+// validate and serve it, then writes the response to the transport.
 func (ms *ModbusServer) handleTransport(t transport, clientAddr string, clientRole string) {
 	var req		*pdu
 	var res		*pdu
 	var err		error
-	var addr	uint16
-	var quantity	uint16
+	var lm		*linkMonitor
+	var start	time.Time
+
+	// +dmzn:count and capture traffic
+	lm	= ms.monitorLink(t, clientAddr)
+	defer ms.releaseMonitor(lm)
 
 	for {
 		req, err = t.ReadRequest()
 		if err != nil {
+			lm.readFailure(err)
 			return
 		}
+		start	= time.Now()
+		lm.request()
 
-		switch req.functionCode {
-		case fcReadCoils, fcReadDiscreteInputs:
-			var coils	[]bool
-			var resCount	int
+		res, err = ms.handleRequest(req, clientAddr, clientRole)
 
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+		// close the transport and return on protocol errors
+		if err == ErrProtocolError {
+			ms.logger.Warningf(
+				"protocol error, closing link (client address: '%s')",
+				clientAddr)
+			t.Close()
+			return
+		}
 
-			// decode address and quantity fields
-			addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		// some requests do not call for a response (see handleRequest())
+		if res == nil {
+			continue
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 2000 || quantity == 0 {
-				err	= ErrProtocolError
-				break
-			}
-			if uint32(addr) + uint32(quantity) - 1 > 0xffff {
-				err	= ErrIllegalDataAddress
-				break
-			}
+		// write the response to the transport
+		err	= t.WriteResponse(res)
+		if err != nil {
+			ms.logger.Warningf("failed to write response: %v", err)
+		} else {
+			lm.response(res, time.Since(start))
+		}
 
-			// invoke the appropriate handler
-			if req.functionCode == fcReadCoils {
-				coils, err	= ms.handler.HandleCoils(&CoilsRequest{
-					ClientAddr: clientAddr,
-					ClientRole: clientRole,
-					UnitId:     req.unitId,
-					Addr:       addr,
-					Quantity:   quantity,
-					IsWrite:    false,
-					Args:       nil,
-				})
-			} else {
-				coils, err	= ms.handler.HandleDiscreteInputs(
-					&DiscreteInputsRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-					})
-			}
-			resCount	= len(coils)
+		// avoid holding on to stale data
+		req	= nil
+		res	= nil
+	}
 
-			// make sure the handler returned the expected number of items
-			if err == nil && resCount != int(quantity) {
-				ms.logger.Errorf("handler returned %v bools, " +
-					         "expected %v", resCount, quantity)
-				err = ErrServerDeviceFailure
-				break
-			}
+	// never reached
+	return
+}
 
-			if err != nil {
-				break
+// Performs decoding and validation of a request, calls the user-provided
+// handler, then encodes the response (or exception) to send back.
+// ErrProtocolError is returned if the request is malformed, in which case
+// res is nil. A nil res along with a nil error means that no response should
+// be sent (e.g. unit ids not served on shared serial lines).
+func (ms *ModbusServer) handleRequest(req *pdu, clientAddr string, clientRole string) (
+	res *pdu, err error) {
+	var addr	uint16
+	var quantity	uint16
+	var fwd		requestForwarder
+	var ok		bool
+
+	// +dmzn: gateways forward requests as-is, whatever the function code
+	fwd, ok	= ms.handler.(requestForwarder)
+	if ok && fwd.forwards() {
+		res, err	= fwd.forwardRequest(req, clientAddr, clientRole)
+		if err != nil {
+			// other gateways may share the bus, stay silent for unit ids
+			// not routed
+			if err == ErrGWPathUnavailable && ms.isSharedLink() {
+				res	= nil
+				err	= nil
+				return
 			}
 
-			// assemble a response PDU
 			res = &pdu{
 				unitId:		req.unitId,
-				functionCode:	req.functionCode,
-				payload:	[]byte{0},
+				functionCode:	(0x80 | req.functionCode),
+				payload:	[]byte{mapErrorToExceptionCode(err)},
 			}
+			err	= nil
+		}
 
-			// byte count (1 byte for 8 coils)
-			res.payload[0]	= uint8(resCount / 8)
-			if resCount % 8 != 0 {
-				res.payload[0]++
-			}
+		if req.unitId == 0 && ms.isSharedLink() {
+			res	= nil
+		}
 
-			// coil values
-			res.payload	= append(res.payload, encodeBools(coils)...)
+		return
+	}
 
-		case fcWriteSingleCoil:
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+	switch req.functionCode {
+	case fcReadCoils, fcReadDiscreteInputs:
+		var coils	[]bool
+		var resCount	int
 
-			// decode the address field
-			addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			// validate the value field (should be either 0xff00 or 0x0000)
-			if ((req.payload[2] != 0xff && req.payload[2] != 0x00) ||
-			    req.payload[3] != 0x00) {
-				err = ErrProtocolError
-				break
-			}
+		// decode address and quantity fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 2000 || quantity == 0 {
+			err	= ErrProtocolError
+			break
+		}
+		if uint32(addr) + uint32(quantity) - 1 > 0xffff {
+			err	= ErrIllegalDataAddress
+			break
+		}
 
-			// invoke the coil handler
-			_, err	= ms.handler.HandleCoils(&CoilsRequest{
+		// invoke the appropriate handler
+		if req.functionCode == fcReadCoils {
+			coils, err	= ms.handler.HandleCoils(&CoilsRequest{
 				ClientAddr: clientAddr,
 				ClientRole: clientRole,
 				UnitId:     req.unitId,
 				Addr:       addr,
-				Quantity:   1, // request for a single coil
-				IsWrite:    true, // this is a write request
-				Args:       []bool{(req.payload[2] == 0xff)},
+				Quantity:   quantity,
+				IsWrite:    false,
+				Args:       nil,
 			})
+		} else {
+			coils, err	= ms.handler.HandleDiscreteInputs(
+				&DiscreteInputsRequest{
+					ClientAddr: clientAddr,
+					ClientRole: clientRole,
+					UnitId:     req.unitId,
+					Addr:       addr,
+					Quantity:   quantity,
+				})
+		}
+		resCount	= len(coils)
 
-			if err != nil {
-				break
-			}
+		// make sure the handler returned the expected number of items
+		if err == nil && resCount != int(quantity) {
+			ms.logger.Errorf("handler returned %v bools, " +
+				         "expected %v", resCount, quantity)
+			err = ErrServerDeviceFailure
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:		req.unitId,
-				functionCode:	req.functionCode,
-			}
+		if err != nil {
+			break
+		}
 
-			// echo the address and value in the response
-			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload	= append(res.payload,
-						 req.payload[2], req.payload[3])
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+			payload:	[]byte{0},
+		}
 
-		case fcWriteMultipleCoils:
-			var expectedLen	int
+		// byte count (1 byte for 8 coils)
+		res.payload[0]	= uint8(resCount / 8)
+		if resCount % 8 != 0 {
+			res.payload[0]++
+		}
 
-			if len(req.payload) < 6 {
-				err = ErrProtocolError
-				break
-			}
+		// coil values
+		res.payload	= append(res.payload, encodeBools(coils)...)
 
-			// decode address and quantity fields
-			addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+	case fcWriteSingleCoil:
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x7b0 || quantity == 0 {
-				err	= ErrProtocolError
-				break
-			}
-			if uint32(addr) + uint32(quantity) - 1 > 0xffff {
-				err	= ErrIllegalDataAddress
-				break
-			}
+		// decode the address field
+		addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
 
-			// validate the byte count field (1 byte for 8 coils)
-			expectedLen	= int(quantity) / 8
-			if quantity % 8 != 0 {
-				expectedLen++
-			}
+		// validate the value field (should be either 0xff00 or 0x0000)
+		if ((req.payload[2] != 0xff && req.payload[2] != 0x00) ||
+		    req.payload[3] != 0x00) {
+			err = ErrProtocolError
+			break
+		}
 
-			if req.payload[4] != uint8(expectedLen) {
-				err	= ErrProtocolError
-				break
-			}
+		// invoke the coil handler
+		_, err	= ms.handler.HandleCoils(&CoilsRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+			Addr:       addr,
+			Quantity:   1, // request for a single coil
+			IsWrite:    true, // this is a write request
+			Args:       []bool{(req.payload[2] == 0xff)},
+		})
 
-			// make sure we have enough bytes
-			if len(req.payload) - 5 != expectedLen {
-				err	= ErrProtocolError
-				break
-			}
+		if err != nil {
+			break
+		}
 
-			// invoke the coil handler
-			_, err	= ms.handler.HandleCoils(&CoilsRequest{
-				ClientAddr: clientAddr,
-				ClientRole: clientRole,
-				UnitId:     req.unitId,
-				Addr:       addr,
-				Quantity:   quantity,
-				IsWrite:    true, // this is a write request
-				Args:       decodeBools(quantity, req.payload[5:]),
-			})
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
 
-			if err != nil {
-				break
-			}
+		// echo the address and value in the response
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload	= append(res.payload,
+					 req.payload[2], req.payload[3])
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:		req.unitId,
-				functionCode:	req.functionCode,
-			}
+	case fcWriteMultipleCoils:
+		var expectedLen	int
 
-			// echo the address and quantity in the response
-			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, quantity)...)
+		if len(req.payload) < 6 {
+			err = ErrProtocolError
+			break
+		}
 
-		case fcReadHoldingRegisters, fcReadInputRegisters:
-			var regs	[]uint16
-			var resCount	int
+		// decode address and quantity fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
 
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x7b0 || quantity == 0 {
+			err	= ErrProtocolError
+			break
+		}
+		if uint32(addr) + uint32(quantity) - 1 > 0xffff {
+			err	= ErrIllegalDataAddress
+			break
+		}
 
-			// decode address and quantity fields
-			addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		// validate the byte count field (1 byte for 8 coils)
+		expectedLen	= int(quantity) / 8
+		if quantity % 8 != 0 {
+			expectedLen++
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x007d || quantity == 0 {
-				err	= ErrProtocolError
-				break
-			}
-			if uint32(addr) + uint32(quantity) - 1 > 0xffff {
-				err	= ErrIllegalDataAddress
-				break
-			}
+		if req.payload[4] != uint8(expectedLen) {
+			err	= ErrProtocolError
+			break
+		}
 
-			// invoke the appropriate handler
-			if req.functionCode == fcReadHoldingRegisters {
-				regs, err	= ms.handler.HandleHoldingRegisters(
-					&HoldingRegistersRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-						IsWrite:    false,
-						Args:       nil,
-					})
-			} else {
-				regs, err	= ms.handler.HandleInputRegisters(
-					&InputRegistersRequest{
-						ClientAddr: clientAddr,
-						ClientRole: clientRole,
-						UnitId:     req.unitId,
-						Addr:       addr,
-						Quantity:   quantity,
-					})
-			}
-			resCount	= len(regs)
+		// make sure we have enough bytes
+		if len(req.payload) - 5 != expectedLen {
+			err	= ErrProtocolError
+			break
+		}
 
-			// make sure the handler returned the expected number of items
-			if err == nil && resCount != int(quantity) {
-				ms.logger.Errorf("handler returned %v 16-bit values, " +
-					         "expected %v", resCount, quantity)
-				err = ErrServerDeviceFailure
-				break
-			}
+		// invoke the coil handler
+		_, err	= ms.handler.HandleCoils(&CoilsRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+			Addr:       addr,
+			Quantity:   quantity,
+			IsWrite:    true, // this is a write request
+			Args:       decodeBools(quantity, req.payload[5:]),
+		})
 
-			if err != nil {
-				break
-			}
+		if err != nil {
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:		req.unitId,
-				functionCode:	req.functionCode,
-				payload:	[]byte{0},
-			}
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
 
-			// byte count (2 bytes per register)
-			res.payload[0]	= uint8(resCount * 2)
+		// echo the address and quantity in the response
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, quantity)...)
 
-			// register values
-			res.payload	= append(res.payload,
-						 uint16sToBytes(BIG_ENDIAN, regs)...)
+	case fcReadHoldingRegisters, fcReadInputRegisters:
+		var regs	[]uint16
+		var resCount	int
 
-		case fcWriteSingleRegister:
-			var value	uint16
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
 
-			if len(req.payload) != 4 {
-				err = ErrProtocolError
-				break
-			}
+		// decode address and quantity fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
 
-			// decode address and value fields
-			addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			value	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x007d || quantity == 0 {
+			err	= ErrProtocolError
+			break
+		}
+		if uint32(addr) + uint32(quantity) - 1 > 0xffff {
+			err	= ErrIllegalDataAddress
+			break
+		}
 
-			// invoke the handler
-			_, err	= ms.handler.HandleHoldingRegisters(
+		// invoke the appropriate handler
+		if req.functionCode == fcReadHoldingRegisters {
+			regs, err	= ms.handler.HandleHoldingRegisters(
 				&HoldingRegistersRequest{
 					ClientAddr: clientAddr,
 					ClientRole: clientRole,
 					UnitId:     req.unitId,
 					Addr:       addr,
-					Quantity:   1, // request for a single register
-					IsWrite:    true, // request is a write
-					Args:       []uint16{value},
+					Quantity:   quantity,
+					IsWrite:    false,
+					Args:       nil,
+				})
+		} else {
+			regs, err	= ms.handler.HandleInputRegisters(
+				&InputRegistersRequest{
+					ClientAddr: clientAddr,
+					ClientRole: clientRole,
+					UnitId:     req.unitId,
+					Addr:       addr,
+					Quantity:   quantity,
 				})
+		}
+		resCount	= len(regs)
 
-			if err != nil {
-				break
-			}
+		// make sure the handler returned the expected number of items
+		if err == nil && resCount != int(quantity) {
+			ms.logger.Errorf("handler returned %v 16-bit values, " +
+				         "expected %v", resCount, quantity)
+			err = ErrServerDeviceFailure
+			break
+		}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:		req.unitId,
-				functionCode:	req.functionCode,
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+			payload:	[]byte{0},
+		}
+
+		// byte count (2 bytes per register)
+		res.payload[0]	= uint8(resCount * 2)
+
+		// register values
+		res.payload	= append(res.payload,
+					 uint16sToBytes(BIG_ENDIAN, regs)...)
+
+	case fcWriteSingleRegister:
+		var value	uint16
+
+		if len(req.payload) != 4 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode address and value fields
+		addr	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		value	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// invoke the handler
+		_, err	= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				Quantity:   1, // request for a single register
+				IsWrite:    true, // request is a write
+				Args:       []uint16{value},
+			})
+
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		// echo the address and value in the response
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, value)...)
+
+	case fcWriteMultipleRegisters:
+		var expectedLen	int
+
+		if len(req.payload) < 6 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode address and quantity fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read past 0xffff
+		if quantity > 0x007b || quantity == 0 {
+			err	= ErrProtocolError
+			break
+		}
+		if uint32(addr) + uint32(quantity) - 1 > 0xffff {
+			err	= ErrIllegalDataAddress
+			break
+		}
+
+		// validate the byte count field (2 bytes per register)
+		expectedLen	= int(quantity) * 2
+
+		if req.payload[4] != uint8(expectedLen) {
+			err	= ErrProtocolError
+			break
+		}
+
+		// make sure we have enough bytes
+		if len(req.payload) - 5 != expectedLen {
+			err	= ErrProtocolError
+			break
+		}
+
+		// invoke the holding register handler
+		_, err		= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				Quantity:   quantity,
+				IsWrite:    true, // this is a write request
+				Args:       bytesToUint16s(BIG_ENDIAN, req.payload[5:]),
+			})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		// echo the address and quantity in the response
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, addr)...)
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, quantity)...)
+
+	case fcMaskWriteRegister:
+		var regs	[]uint16
+		var andMask	uint16
+		var orMask	uint16
+
+		if len(req.payload) != 6 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode address and mask fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		andMask		= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		orMask		= bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+
+		// read the current register value...
+		regs, err	= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				Quantity:   1,
+				IsWrite:    false,
+				Args:       nil,
+			})
+		if err == nil && len(regs) != 1 {
+			ms.logger.Errorf("handler returned %v 16-bit values, " +
+					 "expected 1", len(regs))
+			err = ErrServerDeviceFailure
+		}
+		if err != nil {
+			break
+		}
+
+		// ...then apply the masks and write it back
+		_, err		= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				Quantity:   1,
+				IsWrite:    true, // this is a write request
+				Args:       []uint16{(regs[0] & andMask) | (orMask &^ andMask)},
+			})
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU, echoing the request
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+			payload:	req.payload,
+		}
+
+	case fcReadWriteMultipleRegisters:
+		var regs	[]uint16
+		var writeAddr	uint16
+		var writeQty	uint16
+
+		if len(req.payload) < 9 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode read and write address and quantity fields
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+		quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		writeAddr	= bytesToUint16(BIG_ENDIAN, req.payload[4:6])
+		writeQty	= bytesToUint16(BIG_ENDIAN, req.payload[6:8])
+
+		// ensure the reply never exceeds the maximum PDU length and we
+		// never read or write past 0xffff
+		if quantity > 125 || quantity == 0 || writeQty > 121 || writeQty == 0 {
+			err	= ErrProtocolError
+			break
+		}
+		if uint32(addr) + uint32(quantity) - 1 > 0xffff ||
+		   uint32(writeAddr) + uint32(writeQty) - 1 > 0xffff {
+			err	= ErrIllegalDataAddress
+			break
+		}
+
+		// validate the byte count field (2 bytes per register) and make
+		// sure we have enough bytes
+		if int(req.payload[8]) != int(writeQty) * 2 ||
+		   len(req.payload) - 9 != int(writeQty) * 2 {
+			err	= ErrProtocolError
+			break
+		}
+
+		// the write operation is performed before the read
+		_, err		= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       writeAddr,
+				Quantity:   writeQty,
+				IsWrite:    true, // this is a write request
+				Args:       bytesToUint16s(BIG_ENDIAN, req.payload[9:]),
+			})
+		if err != nil {
+			break
+		}
+
+		regs, err	= ms.handler.HandleHoldingRegisters(
+			&HoldingRegistersRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				Addr:       addr,
+				Quantity:   quantity,
+				IsWrite:    false,
+				Args:       nil,
+			})
+
+		// make sure the handler returned the expected number of items
+		if err == nil && len(regs) != int(quantity) {
+			ms.logger.Errorf("handler returned %v 16-bit values, " +
+					 "expected %v", len(regs), quantity)
+			err = ErrServerDeviceFailure
+			break
+		}
+
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+			payload:	[]byte{uint8(len(regs) * 2)},
+		}
+
+		// register values
+		res.payload	= append(res.payload,
+					 uint16sToBytes(BIG_ENDIAN, regs)...)
+
+	case fcReadFifoQueue:
+		var handler	FifoQueueHandler
+		var regs	[]uint16
+		var ok		bool
+
+		if len(req.payload) != 2 {
+			err = ErrProtocolError
+			break
+		}
+
+		handler, ok	= ms.handler.(FifoQueueHandler)
+		if !ok {
+			err	= ErrIllegalFunction
+			break
+		}
+
+		// decode the fifo pointer address
+		addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+
+		regs, err	= handler.HandleFifoQueue(&FifoQueueRequest{
+			ClientAddr: clientAddr,
+			ClientRole: clientRole,
+			UnitId:     req.unitId,
+			Addr:       addr,
+		})
+		if err != nil {
+			break
+		}
+
+		// the queue count must not exceed 31 registers
+		if len(regs) > 31 {
+			err	= ErrIllegalDataValue
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		// byte count (fifo count + values) and fifo count
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, uint16(2 + len(regs) * 2))...)
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, uint16(len(regs)))...)
+		// queued register values
+		res.payload	= append(res.payload,
+					 uint16sToBytes(BIG_ENDIAN, regs)...)
+
+	case fcReadFileRecord, fcWriteFileRecord:
+		var handler	FileRecordHandler
+		var frr		*FileRecordsRequest
+		var values	[][]uint16
+		var ok		bool
+
+		frr, err	= decodeFileRecordsRequest(req)
+		if err != nil {
+			break
+		}
+
+		handler, ok	= ms.handler.(FileRecordHandler)
+		if !ok {
+			err	= ErrIllegalFunction
+			break
+		}
+
+		frr.ClientAddr	= clientAddr
+		frr.ClientRole	= clientRole
+		frr.UnitId	= req.unitId
+
+		values, err	= handler.HandleFileRecords(frr)
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		// write responses are an echo of the request
+		if frr.IsWrite {
+			res.payload	= req.payload
+			break
+		}
+
+		// make sure the handler returned the expected number of items
+		if len(values) != len(frr.Records) {
+			ms.logger.Errorf("handler returned %v records, " +
+					 "expected %v", len(values), len(frr.Records))
+			err = ErrServerDeviceFailure
+			break
+		}
+
+		// response data length, then one sub-response per record
+		res.payload	= []byte{0}
+		for i, record := range frr.Records {
+			if len(values[i]) != int(record.Length) {
+				ms.logger.Errorf("handler returned %v 16-bit values, " +
+						 "expected %v", len(values[i]), record.Length)
+				err = ErrServerDeviceFailure
+				break
 			}
 
-			// echo the address and value in the response
+			// file response length (reference type + data) and
+			// reference type
 			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, addr)...)
+						 uint8(1 + len(values[i]) * 2), 0x06)
 			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, value)...)
+						 uint16sToBytes(BIG_ENDIAN, values[i])...)
+		}
+		res.payload[0]	= uint8(len(res.payload) - 1)
 
-		case fcWriteMultipleRegisters:
-			var expectedLen	int
+	case fcDiagnostics:
+		var handler	DiagnosticsHandler
+		var subFunction	uint16
+		var data	[]byte
+		var ok		bool
 
-			if len(req.payload) < 6 {
-				err = ErrProtocolError
-				break
-			}
+		if len(req.payload) < 2 {
+			err = ErrProtocolError
+			break
+		}
+
+		// decode the sub-function code
+		subFunction	= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
+
+		handler, ok	= ms.handler.(DiagnosticsHandler)
+		switch {
+		case ok:
+			data, err	= handler.HandleDiagnostics(&DiagnosticsRequest{
+				ClientAddr:  clientAddr,
+				ClientRole:  clientRole,
+				UnitId:      req.unitId,
+				SubFunction: subFunction,
+				Data:        req.payload[2:],
+			})
+		case subFunction == DIAG_RETURN_QUERY_DATA:
+			// echo the request data
+			data	= req.payload[2:]
+		default:
+			err	= ErrIllegalFunction
+		}
+
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		// sub-function code and data
+		res.payload	= append(res.payload,
+					 uint16ToBytes(BIG_ENDIAN, subFunction)...)
+		res.payload	= append(res.payload, data...)
+
+	case fcEncapsulatedInterface:
+		var handler	DeviceIdentificationHandler
+		var devId	*DeviceIdentification
+		var ok		bool
+
+		if len(req.payload) != 3 {
+			err = ErrProtocolError
+			break
+		}
+
+		handler, ok	= ms.handler.(DeviceIdentificationHandler)
+		if !ok || req.payload[0] != meiReadDeviceId {
+			err	= ErrIllegalFunction
+			break
+		}
+
+		if req.payload[1] < DEVID_BASIC || req.payload[1] > DEVID_SPECIFIC {
+			err	= ErrIllegalDataValue
+			break
+		}
+
+		devId, err	= handler.HandleDeviceIdentification(
+			&DeviceIdentificationRequest{
+				ClientAddr: clientAddr,
+				ClientRole: clientRole,
+				UnitId:     req.unitId,
+				ReadCode:   req.payload[1],
+				ObjectId:   req.payload[2],
+			})
+		if err == nil && devId == nil {
+			ms.logger.Error("handler returned no device identification")
+			err = ErrServerDeviceFailure
+		}
+		if err != nil {
+			break
+		}
+
+		// assemble a response PDU
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	req.functionCode,
+		}
+
+		res.payload, err = encodeDeviceIdentification(req.payload[1], req.payload[2], devId)
+		if err == ErrServerDeviceFailure {
+			ms.logger.Error("device identification object too large")
+		}
+
+	default:
+		res = &pdu{
+			// reply with the request target unit ID
+			unitId:		req.unitId,
+			// set the error bit
+			functionCode:	(0x80 | req.functionCode),
+			// set the exception code to illegal function to indicate that
+			// the server does not know how to handle this function code.
+			payload:	[]byte{exIllegalFunction},
+		}
+	}
+
+	// if there was no error processing the request but the response is nil
+	// (which should never happen), emit a server failure exception code
+	// and log an error
+	if err == nil && res == nil {
+		err = ErrServerDeviceFailure
+		ms.logger.Errorf("internal server error (req: %v, res: %v, err: %v)",
+				 req, res, err)
+	}
+
+	// map go errors to modbus errors, unless the error is a protocol error,
+	// in which case the caller decides what to do with the link.
+	if err != nil {
+		if err == ErrProtocolError {
+			res	= nil
+			return
+		}
+
+		// +dmzn: other slaves may share the bus, stay silent for unit ids
+		// the handler does not serve
+		if err == ErrBadUnitId && ms.isSharedLink() {
+			res	= nil
+			err	= nil
+			return
+		}
+
+		res = &pdu{
+			unitId:		req.unitId,
+			functionCode:	(0x80 | req.functionCode),
+			payload:	[]byte{mapErrorToExceptionCode(err)},
+		}
+		err	= nil
+	}
+
+	// +dmzn: broadcasts (unit id 0) are processed but never answered on
+	// serial lines
+	if req.unitId == 0 && ms.isSharedLink() {
+		res	= nil
+	}
+
+	return
+}
+
+// Decodes and validates a read (0x14) or write (0x15) file record request.
+func decodeFileRecordsRequest(req *pdu) (frr *FileRecordsRequest, err error) {
+	var offset	int
+	var resLen	int
+	var record	*FileRecord
+
+	// byte count, followed by one or more 7-byte sub-requests (plus data,
+	// for writes)
+	if len(req.payload) < 8 || int(req.payload[0]) != len(req.payload) - 1 ||
+	   req.payload[0] > 0xf5 {
+		err	= ErrProtocolError
+		return
+	}
+
+	frr	= &FileRecordsRequest{
+		IsWrite: req.functionCode == fcWriteFileRecord,
+	}
+
+	for offset = 1; offset < len(req.payload); {
+		if offset + 7 > len(req.payload) {
+			err	= ErrProtocolError
+			return
+		}
+
+		// the reference type must be 6
+		if req.payload[offset] != 0x06 {
+			err	= ErrIllegalDataValue
+			return
+		}
 
-			// decode address and quantity fields
-			addr		= bytesToUint16(BIG_ENDIAN, req.payload[0:2])
-			quantity	= bytesToUint16(BIG_ENDIAN, req.payload[2:4])
+		record	= &FileRecord{
+			FileNumber:	bytesToUint16(BIG_ENDIAN, req.payload[offset + 1:offset + 3]),
+			RecordNumber:	bytesToUint16(BIG_ENDIAN, req.payload[offset + 3:offset + 5]),
+			Length:		bytesToUint16(BIG_ENDIAN, req.payload[offset + 5:offset + 7]),
+		}
+		offset	+= 7
+
+		if checkFileRecord(record, record.Length) != nil {
+			err	= ErrIllegalDataAddress
+			return
+		}
 
-			// ensure the reply never exceeds the maximum PDU length and we
-			// never read past 0xffff
-			if quantity > 0x007b || quantity == 0 {
+		if frr.IsWrite {
+			if offset + 2 * int(record.Length) > len(req.payload) {
 				err	= ErrProtocolError
-				break
-			}
-			if uint32(addr) + uint32(quantity) - 1 > 0xffff {
-				err	= ErrIllegalDataAddress
-				break
+				return
 			}
 
-			// validate the byte count field (2 bytes per register)
-			expectedLen	= int(quantity) * 2
+			record.Values	= bytesToUint16s(BIG_ENDIAN,
+				req.payload[offset:offset + 2 * int(record.Length)])
+			offset	+= 2 * int(record.Length)
+		}
 
-			if req.payload[4] != uint8(expectedLen) {
-				err	= ErrProtocolError
-				break
+		resLen	+= 2 + 2 * int(record.Length)
+		frr.Records	= append(frr.Records, record)
+	}
+
+	// make sure the reply never exceeds the maximum PDU length
+	if !frr.IsWrite && resLen > 0xf5 {
+		err	= ErrIllegalDataValue
+		return
+	}
+
+	return
+}
+
+// Encodes the objects of devId matching readCode into a read device
+// identification response payload, starting at objectId. Objects which do
+// not fit in a single PDU are left for a subsequent request (more follows).
+func encodeDeviceIdentification(readCode uint8, objectId uint8,
+	devId *DeviceIdentification) (payload []byte, err error) {
+	var ids		[]int
+	var lastId	int
+	var value	string
+	var ok		bool
+
+	_, ok	= devId.Objects[objectId]
+
+	switch readCode {
+	case DEVID_BASIC:	lastId = int(DEVID_MAJOR_MINOR_REVISION)
+	case DEVID_REGULAR:	lastId = 0x7f
+	case DEVID_EXTENDED:	lastId = 0xff
+	case DEVID_SPECIFIC:
+		if !ok {
+			err	= ErrIllegalDataAddress
+			return
+		}
+	}
+
+	if readCode == DEVID_SPECIFIC {
+		ids	= []int{int(objectId)}
+	} else {
+		// restart from the first object if the requested one is unknown
+		// or out of the read code range
+		if !ok || int(objectId) > lastId {
+			objectId	= 0
+		}
+
+		for id := range devId.Objects {
+			if int(id) >= int(objectId) && int(id) <= lastId {
+				ids	= append(ids, int(id))
 			}
+		}
+		sort.Ints(ids)
+	}
 
-			// make sure we have enough bytes
-			if len(req.payload) - 5 != expectedLen {
-				err	= ErrProtocolError
-				break
+	// MEI type, read device id code, conformity level, more follows,
+	// next object id and number of objects
+	payload	= []byte{meiReadDeviceId, readCode, devId.ConformityLevel, 0x00, 0x00, 0x00}
+
+	for _, id := range ids {
+		value	= devId.Objects[uint8(id)]
+
+		// the response PDU, function code included, must not exceed
+		// 253 bytes
+		if len(payload) + 2 + len(value) > 252 {
+			if payload[5] == 0 {
+				err	= ErrServerDeviceFailure
+				return
 			}
 
-			// invoke the holding register handler
-			_, err		= ms.handler.HandleHoldingRegisters(
-				&HoldingRegistersRequest{
-					ClientAddr: clientAddr,
-					ClientRole: clientRole,
-					UnitId:     req.unitId,
-					Addr:       addr,
-					Quantity:   quantity,
-					IsWrite:    true, // this is a write request
-					Args:       bytesToUint16s(BIG_ENDIAN, req.payload[5:]),
-				})
-			if err != nil {
-				break
+			payload[3]	= 0xff
+			payload[4]	= uint8(id)
+			break
+		}
+
+		payload		= append(payload, uint8(id), uint8(len(value)))
+		payload		= append(payload, value...)
+		payload[5]++
+	}
+
+	return
+}
+
+// Serves requests off a link shared by several devices or clients (serial
+// line). Framing errors and timeouts are not fatal: the frame is dropped and
+// the server keeps listening until the link is closed.
+func (ms *ModbusServer) handleSharedLink(t transport, clientAddr string, lm *linkMonitor) {
+	var req		*pdu
+	var res		*pdu
+	var err		error
+	var start	time.Time
+
+	defer ms.releaseMonitor(lm)
+
+	for {
+		req, err = t.ReadRequest()
+		if err != nil {
+			if isRecoverableLinkError(err) {
+				lm.readFailure(err)
+				continue
 			}
 
-			// assemble a response PDU
-			res = &pdu{
-				unitId:		req.unitId,
-				functionCode:	req.functionCode,
+			// the link was closed (see Stop()) or failed
+			if ms.isStarted() {
+				ms.logger.Errorf("failed to read request: %v", err)
 			}
+			return
+		}
 
-			// echo the address and quantity in the response
-			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, addr)...)
-			res.payload	= append(res.payload,
-						 uint16ToBytes(BIG_ENDIAN, quantity)...)
+		start	= time.Now()
+		lm.request()
 
-		default:
-			res = &pdu{
-				// reply with the request target unit ID
-				unitId:		req.unitId,
-				// set the error bit
-				functionCode:	(0x80 | req.functionCode),
-				// set the exception code to illegal function to indicate that
-				// the server does not know how to handle this function code.
-				payload:	[]byte{exIllegalFunction},
-			}
+		res, err = ms.handleRequest(req, clientAddr, "")
+		if err == ErrProtocolError {
+			ms.logger.Warningf("protocol error, dropping request from '%s'",
+					   clientAddr)
+			continue
 		}
 
-		// if there was no error processing the request but the response is nil
-		// (which should never happen), emit a server failure exception code
-		// and log an error
-		if err == nil && res == nil {
-			err = ErrServerDeviceFailure
-			ms.logger.Errorf("internal server error (req: %v, res: %v, err: %v)",
-					 req, res, err)
+		if res == nil {
+			continue
+		}
+
+		err	= t.WriteResponse(res)
+		if err != nil {
+			ms.logger.Warningf("failed to write response: %v", err)
+		} else {
+			lm.response(res, time.Since(start))
 		}
+	}
+}
+
+// Serves requests received over UDP, one request per datagram. Responses are
+// sent back to the source address of each datagram.
+func (ms *ModbusServer) handleUDPPackets(sock *net.UDPConn, lm *linkMonitor) {
+	var start	time.Time
+	var rxbuf	[]byte
+	var n		int
+	var peer	*net.UDPAddr
+	var dg		*udpDatagram
+	var t		transport
+	var req		*pdu
+	var res		*pdu
+	var err		error
+
+	defer ms.releaseMonitor(lm)
 
-		// map go errors to modbus errors, unless the error is a protocol error,
-		// in which case close the transport and return.
+	rxbuf	= make([]byte, maxTCPFrameLength)
+
+	for {
+		n, peer, err = sock.ReadFromUDP(rxbuf)
 		if err != nil {
-			if err == ErrProtocolError {
-				ms.logger.Warningf(
-					"protocol error, closing link (client address: '%s')",
-					clientAddr)
-				t.Close()
+			// the socket has been closed (see Stop())
+			if errors.Is(err, net.ErrClosed) {
 				return
-			} else {
-				res = &pdu{
-					unitId:		req.unitId,
-					functionCode:	(0x80 | req.functionCode),
-					payload:	[]byte{mapErrorToExceptionCode(err)},
-				}
 			}
+			ms.logger.Warningf("failed to read datagram: %v", err)
+			continue
+		}
+
+		dg	= newUDPDatagram(sock, peer, rxbuf[0:n])
+		if ms.transportType == modbusRTUOverUDP {
+			t = newRTUTransport(dg, peer.String(),
+					    ms.conf.Speed, ms.conf.Timeout, ms.conf.Logger)
+		} else {
+			t = newTCPTransport(dg, ms.conf.Timeout, ms.conf.Logger)
+		}
+		monitorTransport(t, lm)
+
+		req, err = t.ReadRequest()
+		if err != nil {
+			ms.logger.Warningf("malformed datagram from %v: %v", peer, err)
+			lm.readFailure(err)
+			continue
+		}
+		start	= time.Now()
+		lm.request()
+
+		res, err = ms.handleRequest(req, peer.String(), "")
+		if err == ErrProtocolError {
+			ms.logger.Warningf("protocol error, dropping request from '%v'", peer)
+			continue
+		}
+
+		if res == nil {
+			continue
 		}
 
-		// write the response to the transport
 		err	= t.WriteResponse(res)
 		if err != nil {
 			ms.logger.Warningf("failed to write response: %v", err)
+		} else {
+			lm.response(res, time.Since(start))
 		}
+	}
+}
 
-		// avoid holding on to stale data
-		req	= nil
-		res	= nil
+// Returns the traffic counters of the server: totals since its creation and
+// per active connection (+dmzn).
+func (ms *ModbusServer) Stats() (stats ServerStats) {
+	var cs	Stats
+
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+
+	stats.Total		= newStats()
+	stats.Connections	= map[string]Stats{}
+	stats.Total.add(&ms.closedStats)
+
+	for lm := range ms.monitors {
+		cs	= lm.snapshot()
+		stats.Total.add(&cs)
+		stats.Connections[lm.link]	= cs
+	}
+
+	return
+}
+
+// Returns a new, registered traffic monitor for link.
+// Must be called with the lock held.
+func (ms *ModbusServer) addMonitor(link string) (lm *linkMonitor) {
+	lm		= newLinkMonitor(link, ms.conf.Capture)
+	ms.monitors[lm]	= true
+
+	return
+}
+
+// Returns a new, registered traffic monitor attached to transport t.
+func (ms *ModbusServer) monitorLink(t transport, link string) (lm *linkMonitor) {
+	ms.lock.Lock()
+	lm	= ms.addMonitor(link)
+	ms.lock.Unlock()
+
+	monitorTransport(t, lm)
+
+	return
+}
+
+// Unregisters a monitor once its link is closed, keeping its counters.
+func (ms *ModbusServer) releaseMonitor(lm *linkMonitor) {
+	var cs	Stats
+
+	cs	= lm.snapshot()
+
+	ms.lock.Lock()
+	delete(ms.monitors, lm)
+	ms.closedStats.add(&cs)
+	ms.lock.Unlock()
+
+	return
+}
+
+// Returns true if the server is started.
+func (ms *ModbusServer) isStarted() (started bool) {
+	ms.lock.Lock()
+	started	= ms.started
+	ms.lock.Unlock()
+
+	return
+}
+
+// Returns true if the server link is a bus shared with other slaves
+// (serial lines and RTU tunnels).
+func (ms *ModbusServer) isSharedLink() (shared bool) {
+	shared	= ms.transportType == modbusRTU ||
+		  ms.transportType == modbusRTUOverTCP ||
+		  ms.transportType == modbusRTUOverUDP ||
+		  ms.transportType == modbusASCII ||
+		  ms.transportType == modbusASCIIOverTCP
+
+	return
+}
+
+// Returns true if err only affects the current frame, i.e. the link is still
+// usable.
+func isRecoverableLinkError(err error) (recoverable bool) {
+	var netErr	net.Error
+
+	switch {
+	case err == ErrRequestTimedOut, err == ErrBadCRC,
+	     err == ErrShortFrame, err == ErrProtocolError:
+		recoverable	= true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		recoverable	= true
 	}
 
-	// never reached
 	return
 }
 
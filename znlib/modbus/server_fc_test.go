@@ -0,0 +1,348 @@
+package modbus
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFunctionCodesOverTCP(t *testing.T) {
+	testFunctionCodes(t, "tcp://localhost:5507")
+}
+
+func TestFunctionCodesOverRTU(t *testing.T) {
+	testFunctionCodes(t, "rtuovertcp://localhost:5508")
+}
+
+func TestOptionalHandlersNotImplemented(t *testing.T) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err    error
+	var data   []byte
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5509",
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5509",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	_, err = client.ReadFifoQueue(0x0001)
+	if err != ErrIllegalFunction {
+		t.Errorf("ReadFifoQueue() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	_, err = client.ReadFileRecord(1, 0, 2)
+	if err != ErrIllegalFunction {
+		t.Errorf("ReadFileRecord() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	_, err = client.ReadDeviceIdentification(DEVID_BASIC, 0)
+	if err != ErrIllegalFunction {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	// return query data is echoed even without a diagnostics handler
+	data, err = client.Diagnostics(DIAG_RETURN_QUERY_DATA, []byte{0xa5, 0x37, 0x01})
+	if err != nil {
+		t.Errorf("Diagnostics() should have succeeded, got: %v", err)
+	}
+	if len(data) != 3 || data[0] != 0xa5 || data[2] != 0x01 {
+		t.Errorf("unexpected diagnostics data: %v", data)
+	}
+
+	_, err = client.DiagnosticsValue(DIAG_RETURN_BUS_MESSAGE_COUNT, 0)
+	if err != ErrIllegalFunction {
+		t.Errorf("DiagnosticsValue() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	return
+}
+
+func testFunctionCodes(t *testing.T, url string) {
+	var server  *ModbusServer
+	var client  *ModbusClient
+	var err     error
+	var regs    []uint16
+	var value   uint16
+	var records []*FileRecord
+	var devId   *DeviceIdentification
+	var th      *fcTestHandler
+
+	th = &fcTestHandler{
+		files:	map[uint16][]uint16{},
+	}
+	th.fifo = []uint16{0x0001, 0x0002, 0x0003}
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		url,
+	}, th)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		url,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	// mask write register: (0x0012 & 0x00f2) | (0x0025 & ^0x00f2) = 0x0017
+	err = client.WriteRegister(0x0004, 0x0012)
+	if err != nil {
+		t.Errorf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	err = client.MaskWriteRegister(0x0004, 0x00f2, 0x0025)
+	if err != nil {
+		t.Errorf("MaskWriteRegister() should have succeeded, got: %v", err)
+	}
+
+	value, err = client.ReadRegister(0x0004, HOLDING_REGISTER)
+	if err != nil || value != 0x0017 {
+		t.Errorf("expected 0x0017, got: 0x%04x (%v)", value, err)
+	}
+
+	// read/write multiple registers: the write happens before the read
+	regs, err = client.ReadWriteMultipleRegisters(0x0003, 3, 0x0005, []uint16{0xaaaa, 0xbbbb})
+	if err != nil {
+		t.Errorf("ReadWriteMultipleRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 || regs[0] != 0x0000 || regs[1] != 0x0017 || regs[2] != 0xaaaa {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	_, err = client.ReadWriteMultipleRegisters(0x0009, 2, 0x0000, []uint16{0x0001})
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadWriteMultipleRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// fifo queue
+	regs, err = client.ReadFifoQueue(0x04de)
+	if err != nil {
+		t.Errorf("ReadFifoQueue() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 3 || regs[0] != 0x0001 || regs[2] != 0x0003 {
+		t.Errorf("unexpected fifo values: %v", regs)
+	}
+
+	_, err = client.ReadFifoQueue(0x0001)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadFifoQueue() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	// file records
+	err = client.WriteFileRecords([]*FileRecord{
+		{FileNumber: 4, RecordNumber: 7, Values: []uint16{0x06af, 0x04be, 0x100d}},
+		{FileNumber: 3, RecordNumber: 9, Values: []uint16{0x0102}},
+	})
+	if err != nil {
+		t.Errorf("WriteFileRecords() should have succeeded, got: %v", err)
+	}
+
+	records = []*FileRecord{
+		{FileNumber: 4, RecordNumber: 8, Length: 2},
+		{FileNumber: 3, RecordNumber: 9, Length: 1},
+	}
+	err = client.ReadFileRecords(records)
+	if err != nil {
+		t.Errorf("ReadFileRecords() should have succeeded, got: %v", err)
+	}
+	if len(records[0].Values) != 2 || records[0].Values[0] != 0x04be ||
+	   records[0].Values[1] != 0x100d {
+		t.Errorf("unexpected record values: %v", records[0].Values)
+	}
+	if len(records[1].Values) != 1 || records[1].Values[0] != 0x0102 {
+		t.Errorf("unexpected record values: %v", records[1].Values)
+	}
+
+	_, err = client.ReadFileRecord(5, 0, 1)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadFileRecord() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	_, err = client.ReadFileRecord(0, 0, 1)
+	if err != ErrUnexpectedParameters {
+		t.Errorf("ReadFileRecord() should have returned ErrUnexpectedParameters, got: %v", err)
+	}
+
+	// diagnostics
+	value, err = client.DiagnosticsValue(DIAG_RETURN_QUERY_DATA, 0xa537)
+	if err != nil || value != 0xa537 {
+		t.Errorf("expected 0xa537, got: 0x%04x (%v)", value, err)
+	}
+
+	value, err = client.DiagnosticsValue(DIAG_RETURN_SERVER_MESSAGE_COUNT, 0)
+	if err != nil || value != th.messages {
+		t.Errorf("expected %v, got: %v (%v)", th.messages, value, err)
+	}
+
+	_, err = client.DiagnosticsValue(DIAG_RETURN_BUS_CHAR_OVERRUN_COUNT, 0)
+	if err != ErrIllegalFunction {
+		t.Errorf("DiagnosticsValue() should have returned ErrIllegalFunction, got: %v", err)
+	}
+
+	// device identification
+	devId, err = client.ReadDeviceIdentification(DEVID_BASIC, 0)
+	if err != nil {
+		t.Fatalf("ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(devId.Objects) != 3 || devId.Objects[DEVID_VENDOR_NAME] != "znlib" ||
+	   devId.Objects[DEVID_MAJOR_MINOR_REVISION] != "v1.0" || devId.ConformityLevel != 0x83 {
+		t.Errorf("unexpected device identification: %v", devId)
+	}
+
+	devId, err = client.ReadDeviceIdentification(DEVID_REGULAR, 0)
+	if err != nil {
+		t.Fatalf("ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(devId.Objects) != 4 || devId.Objects[DEVID_PRODUCT_NAME] != "test device" {
+		t.Errorf("unexpected device identification: %v", devId)
+	}
+
+	// extended objects do not fit in a single response
+	devId, err = client.ReadDeviceIdentification(DEVID_EXTENDED, 0)
+	if err != nil {
+		t.Fatalf("ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(devId.Objects) != 8 || len(devId.Objects[0x83]) != 100 {
+		t.Errorf("unexpected device identification: %v objects", len(devId.Objects))
+	}
+
+	devId, err = client.ReadDeviceIdentification(DEVID_SPECIFIC, DEVID_PRODUCT_CODE)
+	if err != nil {
+		t.Fatalf("ReadDeviceIdentification() should have succeeded, got: %v", err)
+	}
+	if len(devId.Objects) != 1 || devId.Objects[DEVID_PRODUCT_CODE] != "ZN-01" {
+		t.Errorf("unexpected device identification: %v", devId)
+	}
+
+	_, err = client.ReadDeviceIdentification(DEVID_SPECIFIC, 0x20)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadDeviceIdentification() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	return
+}
+
+// Test handler implementing all optional handler interfaces.
+type fcTestHandler struct {
+	tcpTestHandler
+	fifo		[]uint16
+	files		map[uint16][]uint16
+	messages	uint16
+}
+
+func (th *fcTestHandler) HandleHoldingRegisters(req *HoldingRegistersRequest) (res []uint16, err error) {
+	th.messages++
+	res, err = th.tcpTestHandler.HandleHoldingRegisters(req)
+
+	return
+}
+
+func (th *fcTestHandler) HandleFifoQueue(req *FifoQueueRequest) (res []uint16, err error) {
+	th.messages++
+	if req.Addr != 0x04de {
+		err	= ErrIllegalDataAddress
+		return
+	}
+
+	res	= th.fifo
+
+	return
+}
+
+func (th *fcTestHandler) HandleFileRecords(req *FileRecordsRequest) (res [][]uint16, err error) {
+	var file	[]uint16
+	var end		int
+
+	th.messages++
+	for _, record := range req.Records {
+		if req.IsWrite {
+			end	= int(record.RecordNumber) + len(record.Values)
+			file	= th.files[record.FileNumber]
+			if len(file) < end {
+				file	= append(file, make([]uint16, end - len(file))...)
+			}
+			copy(file[record.RecordNumber:], record.Values)
+			th.files[record.FileNumber] = file
+			continue
+		}
+
+		file	= th.files[record.FileNumber]
+		end	= int(record.RecordNumber) + int(record.Length)
+		if len(file) < end {
+			err	= ErrIllegalDataAddress
+			return
+		}
+		res	= append(res, file[record.RecordNumber:end])
+	}
+
+	return
+}
+
+func (th *fcTestHandler) HandleDiagnostics(req *DiagnosticsRequest) (res []byte, err error) {
+	th.messages++
+	switch req.SubFunction {
+	case DIAG_RETURN_QUERY_DATA:
+		res	= req.Data
+	case DIAG_RETURN_SERVER_MESSAGE_COUNT:
+		res	= uint16ToBytes(BIG_ENDIAN, th.messages)
+	default:
+		err	= ErrIllegalFunction
+	}
+
+	return
+}
+
+func (th *fcTestHandler) HandleDeviceIdentification(req *DeviceIdentificationRequest) (res *DeviceIdentification, err error) {
+	th.messages++
+	res	= &DeviceIdentification{
+		ConformityLevel: 0x83,
+		Objects:	 map[uint8]string{
+			DEVID_VENDOR_NAME:          "znlib",
+			DEVID_PRODUCT_CODE:         "ZN-01",
+			DEVID_MAJOR_MINOR_REVISION: "v1.0",
+			DEVID_PRODUCT_NAME:         "test device",
+			0x80:                       strings.Repeat("a", 100),
+			0x81:                       strings.Repeat("b", 100),
+			0x82:                       strings.Repeat("c", 100),
+			0x83:                       strings.Repeat("d", 100),
+		},
+	}
+
+	return
+}
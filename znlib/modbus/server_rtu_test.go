@@ -0,0 +1,148 @@
+package modbus
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRTUTransportReadRequest(t *testing.T) {
+	var rt		*rtuTransport
+	var p1, p2	net.Conn
+	var txchan	chan []byte
+	var err		error
+	var req		*pdu
+
+	txchan		= make(chan []byte, 2)
+	p1, p2		= net.Pipe()
+	go feedTestPipe(t, txchan, p1)
+
+	rt		= newRTUTransport(p2, "", 9600, 100 * time.Millisecond, nil)
+
+	// read holding registers request
+	txchan		<- rt.assembleRTUFrame(&pdu{
+		unitId:		0x11,
+		functionCode:	fcReadHoldingRegisters,
+		payload:	[]byte{0x00, 0x6b, 0x00, 0x03},
+	})
+	req, err	= rt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got %v", err)
+	}
+	if req.unitId != 0x11 || req.functionCode != fcReadHoldingRegisters ||
+	   len(req.payload) != 4 || req.payload[1] != 0x6b {
+		t.Errorf("unexpected request: %v", req)
+	}
+
+	// write multiple registers request (variable length)
+	txchan		<- rt.assembleRTUFrame(&pdu{
+		unitId:		0x11,
+		functionCode:	fcWriteMultipleRegisters,
+		payload:	[]byte{
+			0x00, 0x01, 0x00, 0x02, // address and quantity
+			0x04,                   // byte count
+			0x00, 0x0a, 0x01, 0x02, // register values
+		},
+	})
+	req, err	= rt.ReadRequest()
+	if err != nil {
+		t.Fatalf("ReadRequest() should have succeeded, got %v", err)
+	}
+	if len(req.payload) != 9 || req.payload[8] != 0x02 {
+		t.Errorf("unexpected payload: %v", req.payload)
+	}
+
+	// no request on the line
+	req, err	= rt.ReadRequest()
+	if err == nil {
+		t.Errorf("ReadRequest() should have timed out, got %v", req)
+	}
+
+	p1.Close()
+	p2.Close()
+
+	return
+}
+
+func TestRTUOverTCPServer(t *testing.T) {
+	testServerRoundTrip(t, "rtuovertcp://localhost:5504", "rtuovertcp://localhost:5504")
+}
+
+func TestUDPServer(t *testing.T) {
+	testServerRoundTrip(t, "udp://localhost:5505", "udp://localhost:5505")
+}
+
+func TestRTUOverUDPServer(t *testing.T) {
+	testServerRoundTrip(t, "rtuoverudp://localhost:5506", "rtuoverudp://localhost:5506")
+}
+
+func testServerRoundTrip(t *testing.T, serverURL string, clientURL string) {
+	var server *ModbusServer
+	var client *ModbusClient
+	var err    error
+	var regs   []uint16
+	var coils  []bool
+	var th     *tcpTestHandler
+
+	th = &tcpTestHandler{}
+	server, err = NewServer(&ServerConfiguration{
+		URL:		serverURL,
+	}, th)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		clientURL,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(0x0002, []uint16{0x1234, 0x5678})
+	if err != nil {
+		t.Errorf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	regs, err = client.ReadRegisters(0x0002, 2, HOLDING_REGISTER)
+	if err != nil {
+		t.Errorf("ReadRegisters() should have succeeded, got: %v", err)
+	}
+	if len(regs) != 2 || regs[0] != 0x1234 || regs[1] != 0x5678 {
+		t.Errorf("unexpected register values: %v", regs)
+	}
+
+	err = client.WriteCoil(0x0001, true)
+	if err != nil {
+		t.Errorf("WriteCoil() should have succeeded, got: %v", err)
+	}
+
+	coils, err = client.ReadCoils(0x0000, 3)
+	if err != nil {
+		t.Errorf("ReadCoils() should have succeeded, got: %v", err)
+	}
+	if len(coils) != 3 || coils[0] || !coils[1] || coils[2] {
+		t.Errorf("unexpected coil values: %v", coils)
+	}
+
+	// exceptions are sent back to the client
+	_, err = client.ReadRegisters(0x0009, 2, HOLDING_REGISTER)
+	if err != ErrIllegalDataAddress {
+		t.Errorf("ReadRegisters() should have returned ErrIllegalDataAddress, got: %v", err)
+	}
+
+	return
+}
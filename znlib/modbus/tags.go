@@ -0,0 +1,765 @@
+package modbus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type DataType string
+const (
+	// tag data types
+	TAG_UINT16          DataType = "uint16"
+	TAG_INT16           DataType = "int16"
+	TAG_UINT32          DataType = "uint32"
+	TAG_INT32           DataType = "int32"
+	TAG_FLOAT32         DataType = "float32"
+	TAG_UINT64          DataType = "uint64"
+	TAG_INT64           DataType = "int64"
+	TAG_FLOAT64         DataType = "float64"
+
+	// maximum number of registers covered by a single read request
+	maxPollQuantity     uint16 = 125
+	defaultPollInterval        = 1 * time.Second
+	defaultTagTopic            = "modbus.tag."
+)
+
+// Register map tag, i.e. a named value held in one or more registers.
+type Tag struct {
+	// Name uniquely identifies the tag within its register map
+	Name       string
+	// UnitId sets the unit id to poll the tag from (0 keeps the unit id
+	// of the client)
+	UnitId     uint8
+	// Addr sets the address of the first register of the tag
+	Addr       uint16
+	// RegType sets the register type (holding or input)
+	RegType    RegType
+	// Type sets the data type of the tag, which in turn sets the number of
+	// registers it spans (1 for 16-bit, 2 for 32-bit and 4 for 64-bit types)
+	Type       DataType
+	// Scale and Offset turn raw values into engineering values, as in
+	// value = raw * Scale + Offset
+	Scale      float64
+	Offset     float64
+	// Endianness and WordOrder set the encoding of the tag
+	Endianness Endianness
+	WordOrder  WordOrder
+	// Interval sets the poll interval of the tag
+	Interval   time.Duration
+}
+
+// Register map object, holding a set of tags.
+type RegisterMap struct {
+	Tags	[]*Tag
+}
+
+// Tag value, as returned and published by the poller.
+type TagValue struct {
+	Tag	*Tag		// the tag definition
+	Value	float64		// the scaled value of the tag
+	Time	time.Time	// the time the value was read at
+	Err	error		// the read error, if any (Value is then irrelevant)
+}
+
+// Register map as defined in JSON/YAML. Map-level unit id, endianness,
+// word order and interval apply to tags which do not set their own.
+type registerMapConf struct {
+	UnitId     uint8     `json:"unit" yaml:"unit"`
+	Endianness string    `json:"endianness" yaml:"endianness"`
+	WordOrder  string    `json:"word_order" yaml:"word_order"`
+	Interval   string    `json:"interval" yaml:"interval"`
+	Tags       []tagConf `json:"tags" yaml:"tags"`
+}
+
+// Tag as defined in JSON/YAML or in struct tags.
+type tagConf struct {
+	Name       string  `json:"name" yaml:"name"`
+	UnitId     uint8   `json:"unit" yaml:"unit"`
+	Addr       uint16  `json:"addr" yaml:"addr"`
+	RegType    string  `json:"reg" yaml:"reg"`               // holding (default) or input
+	Type       string  `json:"type" yaml:"type"`             // uint16 (default), int16, ..., float64
+	Scale      float64 `json:"scale" yaml:"scale"`           // defaults to 1
+	Offset     float64 `json:"offset" yaml:"offset"`
+	Endianness string  `json:"endianness" yaml:"endianness"` // big (default) or little
+	WordOrder  string  `json:"word_order" yaml:"word_order"` // high (default) or low
+	Interval   string  `json:"interval" yaml:"interval"`     // e.g. 500ms, defaults to 1s
+}
+
+// LoadRegisterMap parses a register map defined in JSON, e.g.
+// {"unit": 1, "interval": "1s", "tags": [
+//     {"name": "temp", "addr": 16, "type": "float32", "reg": "input"},
+//     {"name": "speed", "addr": 20, "type": "int16", "scale": 0.1}]}
+func LoadRegisterMap(data []byte) (rm *RegisterMap, err error) {
+	var conf	registerMapConf
+
+	err	= json.Unmarshal(data, &conf)
+	if err != nil {
+		return
+	}
+
+	rm, err	= conf.build()
+
+	return
+}
+
+// LoadRegisterMapYAML parses a register map defined in YAML, using the same
+// keys as LoadRegisterMap.
+func LoadRegisterMapYAML(data []byte) (rm *RegisterMap, err error) {
+	var conf	registerMapConf
+
+	err	= yaml.Unmarshal(data, &conf)
+	if err != nil {
+		return
+	}
+
+	rm, err	= conf.build()
+
+	return
+}
+
+// RegisterMapOf builds a register map out of the `modbus` struct tags of
+// v (a struct or a pointer to a struct), e.g.
+//   type Drive struct {
+//       Temp  float32 `modbus:"addr=0x10,reg=input,interval=5s"`
+//       Speed int16   `modbus:"addr=0x14,scale=0.1,unit=2"`
+//   }
+// Tags are named after their field unless name= is given. The data type
+// defaults to that of (u)int16/32/64 and float32/64 fields, and must be set
+// with type= for fields of other kinds.
+func RegisterMapOf(v interface{}) (rm *RegisterMap, err error) {
+	var conf	registerMapConf
+	var rt		reflect.Type
+	var tc		tagConf
+	var spec	string
+	var ok		bool
+
+	rt	= reflect.TypeOf(v)
+	if rt != nil && rt.Kind() == reflect.Ptr {
+		rt	= rt.Elem()
+	}
+
+	if rt == nil || rt.Kind() != reflect.Struct {
+		err	= fmt.Errorf("%w: struct expected, got %v", ErrConfigurationError, rt)
+		return
+	}
+
+	for i := 0; i < rt.NumField(); i++ {
+		spec, ok	= rt.Field(i).Tag.Lookup("modbus")
+		if !ok || spec == "-" {
+			continue
+		}
+
+		tc, err		= parseStructTag(rt.Field(i), spec)
+		if err != nil {
+			return
+		}
+
+		conf.Tags	= append(conf.Tags, tc)
+	}
+
+	rm, err	= conf.build()
+
+	return
+}
+
+// Returns the number of registers spanned by the tag.
+func (t *Tag) Quantity() (quantity uint16) {
+	switch t.Type {
+	case TAG_UINT32, TAG_INT32, TAG_FLOAT32:	quantity = 2
+	case TAG_UINT64, TAG_INT64, TAG_FLOAT64:	quantity = 4
+	default:					quantity = 1
+	}
+
+	return
+}
+
+// Decodes the raw (wire) register bytes of the tag into a scaled value.
+func (t *Tag) decode(raw []byte) (value float64) {
+	switch t.Type {
+	case TAG_UINT16:	value = float64(bytesToUint16(t.Endianness, raw))
+	case TAG_INT16:		value = float64(int16(bytesToUint16(t.Endianness, raw)))
+	case TAG_UINT32:	value = float64(bytesToUint32s(t.Endianness, t.WordOrder, raw)[0])
+	case TAG_INT32:		value = float64(int32(bytesToUint32s(t.Endianness, t.WordOrder, raw)[0]))
+	case TAG_FLOAT32:	value = float64(bytesToFloat32s(t.Endianness, t.WordOrder, raw)[0])
+	case TAG_UINT64:	value = float64(bytesToUint64s(t.Endianness, t.WordOrder, raw)[0])
+	case TAG_INT64:		value = float64(int64(bytesToUint64s(t.Endianness, t.WordOrder, raw)[0]))
+	case TAG_FLOAT64:	value = bytesToFloat64s(t.Endianness, t.WordOrder, raw)[0]
+	}
+
+	value	= value * t.Scale + t.Offset
+
+	return
+}
+
+// Validates the register map definition and turns it into tags.
+func (conf *registerMapConf) build() (rm *RegisterMap, err error) {
+	var tag		*Tag
+	var names	map[string]bool
+
+	if len(conf.Tags) == 0 {
+		err	= fmt.Errorf("%w: register map has no tags", ErrConfigurationError)
+		return
+	}
+
+	rm	= &RegisterMap{}
+	names	= map[string]bool{}
+
+	for i := range conf.Tags {
+		tag, err	= conf.buildTag(&conf.Tags[i])
+		if err != nil {
+			rm	= nil
+			return
+		}
+
+		if names[tag.Name] {
+			rm	= nil
+			err	= fmt.Errorf("%w: duplicate tag name '%s'", ErrConfigurationError, tag.Name)
+			return
+		}
+		names[tag.Name]	= true
+
+		rm.Tags	= append(rm.Tags, tag)
+	}
+
+	return
+}
+
+// Turns a tag definition into a tag, applying map-level defaults.
+func (conf *registerMapConf) buildTag(tc *tagConf) (tag *Tag, err error) {
+	var interval	string
+
+	if tc.Name == "" {
+		err	= fmt.Errorf("%w: tag at address %v has no name", ErrConfigurationError, tc.Addr)
+		return
+	}
+
+	tag	= &Tag{
+		Name:	tc.Name,
+		UnitId:	tc.UnitId,
+		Addr:	tc.Addr,
+		Scale:	tc.Scale,
+		Offset:	tc.Offset,
+	}
+
+	if tag.UnitId == 0 {
+		tag.UnitId	= conf.UnitId
+	}
+
+	if tag.Scale == 0 {
+		tag.Scale	= 1
+	}
+
+	switch strings.ToLower(tc.RegType) {
+	case "", "holding":	tag.RegType = HOLDING_REGISTER
+	case "input":		tag.RegType = INPUT_REGISTER
+	default:
+		err	= fmt.Errorf("%w: tag '%s': unknown register type '%s'",
+				     ErrConfigurationError, tc.Name, tc.RegType)
+		return
+	}
+
+	tag.Type	= DataType(strings.ToLower(tc.Type))
+	switch tag.Type {
+	case "":		tag.Type = TAG_UINT16
+	case TAG_UINT16, TAG_INT16, TAG_UINT32, TAG_INT32,
+	     TAG_FLOAT32, TAG_UINT64, TAG_INT64, TAG_FLOAT64:
+	default:
+		err	= fmt.Errorf("%w: tag '%s': unknown data type '%s'",
+				     ErrConfigurationError, tc.Name, tc.Type)
+		return
+	}
+
+	switch strings.ToLower(pick(tc.Endianness, conf.Endianness)) {
+	case "", "big":		tag.Endianness = BIG_ENDIAN
+	case "little":		tag.Endianness = LITTLE_ENDIAN
+	default:
+		err	= fmt.Errorf("%w: tag '%s': unknown endianness '%s'",
+				     ErrConfigurationError, tc.Name, tc.Endianness)
+		return
+	}
+
+	switch strings.ToLower(pick(tc.WordOrder, conf.WordOrder)) {
+	case "", "high":	tag.WordOrder = HIGH_WORD_FIRST
+	case "low":		tag.WordOrder = LOW_WORD_FIRST
+	default:
+		err	= fmt.Errorf("%w: tag '%s': unknown word order '%s'",
+				     ErrConfigurationError, tc.Name, tc.WordOrder)
+		return
+	}
+
+	interval	= pick(tc.Interval, conf.Interval)
+	tag.Interval	= defaultPollInterval
+	if interval != "" {
+		tag.Interval, err	= time.ParseDuration(interval)
+		if err == nil && tag.Interval <= 0 {
+			err	= fmt.Errorf("interval must be positive")
+		}
+		if err != nil {
+			err	= fmt.Errorf("%w: tag '%s': bad interval '%s': %v",
+					     ErrConfigurationError, tc.Name, interval, err)
+			return
+		}
+	}
+
+	if uint32(tag.Addr) + uint32(tag.Quantity()) - 1 > 0xffff {
+		err	= fmt.Errorf("%w: tag '%s': end register address is past 0xffff",
+				     ErrConfigurationError, tc.Name)
+		return
+	}
+
+	return
+}
+
+// Parses a `modbus:"key=value,..."` struct tag into a tag definition.
+func parseStructTag(field reflect.StructField, spec string) (tc tagConf, err error) {
+	var kv		[]string
+	var num		uint64
+
+	tc.Name	= field.Name
+
+	// default to the data type of the field
+	switch field.Type.Kind() {
+	case reflect.Uint16:	tc.Type = string(TAG_UINT16)
+	case reflect.Int16:	tc.Type = string(TAG_INT16)
+	case reflect.Uint32:	tc.Type = string(TAG_UINT32)
+	case reflect.Int32:	tc.Type = string(TAG_INT32)
+	case reflect.Float32:	tc.Type = string(TAG_FLOAT32)
+	case reflect.Uint64:	tc.Type = string(TAG_UINT64)
+	case reflect.Int64:	tc.Type = string(TAG_INT64)
+	case reflect.Float64:	tc.Type = string(TAG_FLOAT64)
+	}
+
+	for _, item := range strings.Split(spec, ",") {
+		kv	= strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			err	= fmt.Errorf("%w: field %s: malformed tag item '%s'",
+					     ErrConfigurationError, field.Name, item)
+			return
+		}
+
+		switch kv[0] {
+		case "name":		tc.Name = kv[1]
+		case "reg":		tc.RegType = kv[1]
+		case "type":		tc.Type = kv[1]
+		case "endianness":	tc.Endianness = kv[1]
+		case "word_order":	tc.WordOrder = kv[1]
+		case "interval":	tc.Interval = kv[1]
+		case "unit":
+			num, err	= strconv.ParseUint(kv[1], 0, 8)
+			tc.UnitId	= uint8(num)
+		case "addr":
+			num, err	= strconv.ParseUint(kv[1], 0, 16)
+			tc.Addr		= uint16(num)
+		case "scale":
+			tc.Scale, err	= strconv.ParseFloat(kv[1], 64)
+		case "offset":
+			tc.Offset, err	= strconv.ParseFloat(kv[1], 64)
+		default:
+			err	= fmt.Errorf("unknown key '%s'", kv[0])
+		}
+
+		if err != nil {
+			err	= fmt.Errorf("%w: field %s: %v", ErrConfigurationError, field.Name, err)
+			return
+		}
+	}
+
+	// fields of other kinds (e.g. string, bool, int) need an explicit type
+	if tc.Type == "" {
+		err	= fmt.Errorf("%w: field %s: no data type for %v fields, set type=",
+				     ErrConfigurationError, field.Name, field.Type)
+		return
+	}
+
+	return
+}
+
+// Returns value if set, def otherwise.
+func pick(value string, def string) (res string) {
+	res	= value
+	if res == "" {
+		res	= def
+	}
+
+	return
+}
+
+// EventPublisher is satisfied by znlib.EventBus (+dmzn).
+type EventPublisher interface {
+	Publish(topic string, args ...interface{})
+}
+
+// Tag poller configuration object.
+type PollerConfiguration struct {
+	// OnChange is called whenever the value (or read error) of a tag
+	// changes, including on the first read.
+	OnChange  func(value *TagValue)
+	// Publisher receives value changes as well (e.g. a znlib.EventBus),
+	// published with a *TagValue argument on topic Topic + tag name.
+	Publisher EventPublisher
+	// Topic sets the topic prefix used with Publisher (defaults to
+	// "modbus.tag.").
+	Topic     string
+	// MaxGap sets the largest number of unused registers allowed between
+	// two tags merged into the same read request (defaults to 0, i.e.
+	// only adjacent or overlapping tags are merged).
+	MaxGap    uint16
+}
+
+// Tag poller object, reading the tags of a register map at their interval
+// using as few read requests as possible.
+type Poller struct {
+	conf	PollerConfiguration
+	client	*ModbusClient
+	blocks	map[time.Duration][]*pollBlock
+	lock	sync.Mutex	// protects stop
+	vlock	sync.Mutex
+	values	map[string]*TagValue
+	stop	chan struct{}
+	wg	sync.WaitGroup
+}
+
+// Range of registers read in a single request, covering one or more tags.
+type pollBlock struct {
+	interval	time.Duration
+	unitId		uint8
+	regType		RegType
+	addr		uint16
+	quantity	uint16
+	tags		[]*Tag
+}
+
+// NewPoller returns a poller reading the tags of rm through client, which
+// is expected to be open. Tags without unit id are read from the current unit
+// id of the client. With a pipelined client (see MaxInFlight), the blocks due
+// at the same time are read concurrently.
+func NewPoller(client *ModbusClient, rm *RegisterMap, conf *PollerConfiguration) (p *Poller, err error) {
+	if client == nil || rm == nil || len(rm.Tags) == 0 {
+		err	= ErrUnexpectedParameters
+		return
+	}
+
+	p	= &Poller{
+		client:	client,
+		values:	map[string]*TagValue{},
+	}
+
+	if conf != nil {
+		p.conf	= *conf
+	}
+
+	if p.conf.Topic == "" {
+		p.conf.Topic	= defaultTagTopic
+	}
+
+	p.blocks	= buildPollBlocks(rm.Tags, p.conf.MaxGap)
+
+	return
+}
+
+// Starts polling tags in the background.
+func (p *Poller) Start() (err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.stop != nil {
+		return
+	}
+
+	p.stop	= make(chan struct{})
+	for interval, blocks := range p.blocks {
+		p.wg.Add(1)
+		go p.run(interval, blocks, p.stop)
+	}
+
+	return
+}
+
+// Stops polling and waits for background reads to complete.
+func (p *Poller) Stop() (err error) {
+	p.lock.Lock()
+	if p.stop == nil {
+		p.lock.Unlock()
+		return
+	}
+
+	close(p.stop)
+	p.stop	= nil
+	p.lock.Unlock()
+
+	p.wg.Wait()
+
+	return
+}
+
+// Polls all tags once, returning the first read error if any.
+func (p *Poller) Poll() (err error) {
+	var all	[]*pollBlock
+
+	for _, blocks := range p.blocks {
+		all	= append(all, blocks...)
+	}
+
+	err	= p.pollBlocks(all, nil)
+
+	return
+}
+
+// Returns the last value read for the named tag.
+func (p *Poller) Value(name string) (value TagValue, ok bool) {
+	var v	*TagValue
+
+	p.vlock.Lock()
+	defer p.vlock.Unlock()
+
+	v, ok	= p.values[name]
+	if ok {
+		value	= *v
+	}
+
+	return
+}
+
+// Returns the last values read for all tags, by tag name.
+func (p *Poller) Values() (values map[string]TagValue) {
+	p.vlock.Lock()
+	defer p.vlock.Unlock()
+
+	values	= make(map[string]TagValue, len(p.values))
+	for name, v := range p.values {
+		values[name]	= *v
+	}
+
+	return
+}
+
+// Returns the number of read requests needed to poll all tags once.
+func (p *Poller) Requests() (count int) {
+	for _, blocks := range p.blocks {
+		count	+= len(blocks)
+	}
+
+	return
+}
+
+// Polls blocks every interval until stop is closed.
+func (p *Poller) run(interval time.Duration, blocks []*pollBlock, stop chan struct{}) {
+	var ticker	*time.Ticker
+
+	defer p.wg.Done()
+
+	ticker	= time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		p.pollBlocks(blocks, stop)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Polls blocks, one after the other or concurrently on pipelined clients,
+// returning the first read error if any. Stops early once stop is closed,
+// aborting the requests in flight.
+func (p *Poller) pollBlocks(blocks []*pollBlock, stop chan struct{}) (err error) {
+	var wg		sync.WaitGroup
+	var elock	sync.Mutex
+	var e		error
+	var ctx		context.Context
+	var cancel	context.CancelFunc
+
+	ctx, cancel	= context.WithCancel(context.Background())
+	defer cancel()
+
+	if stop != nil {
+		go func() {
+			select {
+			case <-stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+
+	if p.client.conf.MaxInFlight > 1 {
+		for _, b := range blocks {
+			wg.Add(1)
+			go func(b *pollBlock) {
+				defer wg.Done()
+
+				perr := p.poll(ctx, b)
+				elock.Lock()
+				if perr != nil && err == nil {
+					err	= perr
+				}
+				elock.Unlock()
+			}(b)
+		}
+		wg.Wait()
+
+		return
+	}
+
+	for _, b := range blocks {
+		if ctx.Err() != nil {
+			return
+		}
+
+		e	= p.poll(ctx, b)
+		if e != nil && err == nil {
+			err	= e
+		}
+	}
+
+	return
+}
+
+// Reads a block of registers and updates the value of the tags it covers,
+// unless ctx is done.
+func (p *Poller) poll(ctx context.Context, b *pollBlock) (err error) {
+	var unitId	uint8
+	var raw		[]byte
+	var now		time.Time
+	var offset	int
+	var value	*TagValue
+
+	p.client.lock.Lock()
+	unitId	= b.unitId
+	if unitId == 0 {
+		unitId	= p.client.unitId
+	}
+	raw, err	= p.client.readUnitRegisters(ctx, unitId,
+					       b.addr, b.quantity, b.regType)
+	p.client.lock.Unlock()
+
+	// the poller is being stopped
+	if ctx.Err() != nil {
+		return
+	}
+
+	now	= time.Now()
+	for _, tag := range b.tags {
+		value	= &TagValue{
+			Tag:	tag,
+			Time:	now,
+			Err:	err,
+		}
+
+		if err == nil {
+			offset		= 2 * int(tag.Addr - b.addr)
+			value.Value	= tag.decode(raw[offset:offset + 2 * int(tag.Quantity())])
+		}
+
+		p.update(value)
+	}
+
+	return
+}
+
+// Stores a tag value and notifies subscribers if it changed.
+func (p *Poller) update(value *TagValue) {
+	var old		*TagValue
+	var changed	bool
+
+	p.vlock.Lock()
+	old		= p.values[value.Tag.Name]
+	p.values[value.Tag.Name] = value
+	p.vlock.Unlock()
+
+	changed	= old == nil || !sameError(old.Err, value.Err) ||
+		  math.Float64bits(old.Value) != math.Float64bits(value.Value)
+	if !changed {
+		return
+	}
+
+	if p.conf.OnChange != nil {
+		p.conf.OnChange(value)
+	}
+
+	if p.conf.Publisher != nil {
+		p.conf.Publisher.Publish(p.conf.Topic + value.Tag.Name, value)
+	}
+
+	return
+}
+
+// Returns true if a and b report the same failure. Errors other than
+// sentinels (e.g. *net.OpError) are new instances on every poll: compare
+// them by message.
+func sameError(a error, b error) (same bool) {
+	switch {
+	case a == nil || b == nil:
+		same	= a == b
+	default:
+		same	= errors.Is(a, b) || a.Error() == b.Error()
+	}
+
+	return
+}
+
+// Groups tags by interval, unit id and register type, then merges tags
+// with close addresses into blocks of at most 125 registers.
+func buildPollBlocks(tags []*Tag, maxGap uint16) (blocks map[time.Duration][]*pollBlock) {
+	var sorted	[]*Tag
+	var last	*pollBlock
+	var end		uint32
+
+	// sort tags so that mergeable tags end up next to each other
+	sorted	= make([]*Tag, len(tags))
+	copy(sorted, tags)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		switch {
+		case sorted[i].Interval != sorted[j].Interval:
+			return sorted[i].Interval < sorted[j].Interval
+		case sorted[i].UnitId != sorted[j].UnitId:
+			return sorted[i].UnitId < sorted[j].UnitId
+		case sorted[i].RegType != sorted[j].RegType:
+			return sorted[i].RegType < sorted[j].RegType
+		}
+		return sorted[i].Addr < sorted[j].Addr
+	})
+
+	blocks	= map[time.Duration][]*pollBlock{}
+	for _, tag := range sorted {
+		end	= uint32(tag.Addr) + uint32(tag.Quantity())
+
+		if last != nil && last.interval == tag.Interval &&
+		   last.unitId == tag.UnitId && last.regType == tag.RegType &&
+		   uint32(tag.Addr) <= uint32(last.addr) + uint32(last.quantity) + uint32(maxGap) &&
+		   end - uint32(last.addr) <= uint32(maxPollQuantity) {
+			// extend the current block to cover the tag
+			if end > uint32(last.addr) + uint32(last.quantity) {
+				last.quantity	= uint16(end - uint32(last.addr))
+			}
+			last.tags	= append(last.tags, tag)
+			continue
+		}
+
+		last	= &pollBlock{
+			interval:	tag.Interval,
+			unitId:		tag.UnitId,
+			regType:	tag.RegType,
+			addr:		tag.Addr,
+			quantity:	tag.Quantity(),
+			tags:		[]*Tag{tag},
+		}
+		blocks[tag.Interval]	= append(blocks[tag.Interval], last)
+	}
+
+	return
+}
@@ -0,0 +1,475 @@
+package modbus
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadRegisterMap(t *testing.T) {
+	var rm	*RegisterMap
+	var err	error
+
+	rm, err	= LoadRegisterMap([]byte(`{
+		"unit": 3, "word_order": "low", "interval": "500ms",
+		"tags": [
+			{"name": "temp", "addr": 16, "type": "float32", "reg": "input"},
+			{"name": "speed", "addr": 20, "type": "int16", "scale": 0.1, "unit": 4},
+			{"name": "count", "addr": 21, "type": "uint32", "word_order": "high",
+			 "endianness": "little", "interval": "2s"}
+		]}`))
+	if err != nil {
+		t.Fatalf("LoadRegisterMap() should have succeeded, got: %v", err)
+	}
+
+	if len(rm.Tags) != 3 {
+		t.Fatalf("expected 3 tags, got: %v", len(rm.Tags))
+	}
+
+	if rm.Tags[0].RegType != INPUT_REGISTER || rm.Tags[0].Type != TAG_FLOAT32 ||
+	   rm.Tags[0].UnitId != 3 || rm.Tags[0].WordOrder != LOW_WORD_FIRST ||
+	   rm.Tags[0].Interval != 500 * time.Millisecond || rm.Tags[0].Scale != 1 ||
+	   rm.Tags[0].Quantity() != 2 {
+		t.Errorf("unexpected tag: %+v", rm.Tags[0])
+	}
+
+	if rm.Tags[1].UnitId != 4 || rm.Tags[1].Scale != 0.1 ||
+	   rm.Tags[1].RegType != HOLDING_REGISTER || rm.Tags[1].Endianness != BIG_ENDIAN {
+		t.Errorf("unexpected tag: %+v", rm.Tags[1])
+	}
+
+	if rm.Tags[2].WordOrder != HIGH_WORD_FIRST || rm.Tags[2].Endianness != LITTLE_ENDIAN ||
+	   rm.Tags[2].Interval != 2 * time.Second {
+		t.Errorf("unexpected tag: %+v", rm.Tags[2])
+	}
+
+	// invalid definitions
+	for _, def := range []string{
+		`{"tags": []}`,
+		`{"tags": [{"addr": 1}]}`,
+		`{"tags": [{"name": "a", "type": "int8"}]}`,
+		`{"tags": [{"name": "a", "reg": "coil"}]}`,
+		`{"tags": [{"name": "a", "interval": "-1s"}]}`,
+		`{"tags": [{"name": "a", "addr": 65535, "type": "uint32"}]}`,
+		`{"tags": [{"name": "a"}, {"name": "a", "addr": 1}]}`,
+	} {
+		_, err	= LoadRegisterMap([]byte(def))
+		if !errors.Is(err, ErrConfigurationError) {
+			t.Errorf("expected ErrConfigurationError for %s, got: %v", def, err)
+		}
+	}
+
+	return
+}
+
+func TestLoadRegisterMapYAML(t *testing.T) {
+	var rm	*RegisterMap
+	var err	error
+
+	rm, err	= LoadRegisterMapYAML([]byte(`
+unit: 1
+tags:
+  - name: voltage
+    addr: 0x10
+    type: uint16
+    scale: 0.01
+    offset: -5
+  - name: energy
+    addr: 0x20
+    type: float64
+    reg: input
+`))
+	if err != nil {
+		t.Fatalf("LoadRegisterMapYAML() should have succeeded, got: %v", err)
+	}
+
+	if len(rm.Tags) != 2 || rm.Tags[0].Addr != 0x10 || rm.Tags[0].Offset != -5 ||
+	   rm.Tags[1].Quantity() != 4 || rm.Tags[1].RegType != INPUT_REGISTER {
+		t.Errorf("unexpected tags: %+v, %+v", rm.Tags[0], rm.Tags[1])
+	}
+
+	return
+}
+
+func TestRegisterMapOf(t *testing.T) {
+	var rm	*RegisterMap
+	var err	error
+
+	type drive struct {
+		Temp	float32	`modbus:"addr=0x10,reg=input,interval=5s"`
+		Speed	int16	`modbus:"addr=0x14,scale=0.1,unit=2"`
+		Raw	uint16	`modbus:"name=status,addr=3"`
+		Ignored	string
+	}
+
+	rm, err	= RegisterMapOf(&drive{})
+	if err != nil {
+		t.Fatalf("RegisterMapOf() should have succeeded, got: %v", err)
+	}
+
+	if len(rm.Tags) != 3 {
+		t.Fatalf("expected 3 tags, got: %v", len(rm.Tags))
+	}
+
+	if rm.Tags[0].Name != "Temp" || rm.Tags[0].Type != TAG_FLOAT32 ||
+	   rm.Tags[0].Addr != 0x10 || rm.Tags[0].Interval != 5 * time.Second {
+		t.Errorf("unexpected tag: %+v", rm.Tags[0])
+	}
+
+	if rm.Tags[1].Type != TAG_INT16 || rm.Tags[1].UnitId != 2 || rm.Tags[1].Scale != 0.1 {
+		t.Errorf("unexpected tag: %+v", rm.Tags[1])
+	}
+
+	if rm.Tags[2].Name != "status" || rm.Tags[2].Type != TAG_UINT16 {
+		t.Errorf("unexpected tag: %+v", rm.Tags[2])
+	}
+
+	// fields of unsupported kinds need an explicit type
+	_, err	= RegisterMapOf(struct {
+		On	bool	`modbus:"addr=1"`
+	}{})
+	if !errors.Is(err, ErrConfigurationError) {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	rm, err	= RegisterMapOf(struct {
+		On	bool	`modbus:"addr=1,type=uint16"`
+	}{})
+	if err != nil || rm.Tags[0].Type != TAG_UINT16 {
+		t.Errorf("RegisterMapOf() should have succeeded, got: %v", err)
+	}
+
+	_, err	= RegisterMapOf(struct {
+		A	uint16	`modbus:"addr=1,colour=red"`
+	}{})
+	if !errors.Is(err, ErrConfigurationError) {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	_, err	= RegisterMapOf(12)
+	if !errors.Is(err, ErrConfigurationError) {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	return
+}
+
+func TestBuildPollBlocks(t *testing.T) {
+	var tags	[]*Tag
+	var blocks	map[time.Duration][]*pollBlock
+
+	tags	= []*Tag{
+		{Name: "a", Addr: 0,   Type: TAG_UINT16,  Interval: time.Second},
+		{Name: "b", Addr: 1,   Type: TAG_FLOAT32, Interval: time.Second},
+		{Name: "c", Addr: 5,   Type: TAG_UINT16,  Interval: time.Second},
+		{Name: "d", Addr: 122, Type: TAG_UINT64,  Interval: time.Second},
+		{Name: "e", Addr: 3,   Type: TAG_UINT16,  Interval: time.Second, RegType: INPUT_REGISTER},
+		{Name: "f", Addr: 2,   Type: TAG_UINT16,  Interval: time.Minute},
+	}
+
+	// adjacent tags only: [a b] [c] [d], [e], [f]
+	blocks	= buildPollBlocks(tags, 0)
+	if len(blocks[time.Second]) != 4 || len(blocks[time.Minute]) != 1 {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	if blocks[time.Second][0].addr != 0 || blocks[time.Second][0].quantity != 3 ||
+	   len(blocks[time.Second][0].tags) != 2 {
+		t.Errorf("unexpected first block: %+v", blocks[time.Second][0])
+	}
+
+	// with gaps allowed: [a b c] [d] (d would exceed 125 registers), [e], [f]
+	blocks	= buildPollBlocks(tags, 200)
+	if len(blocks[time.Second]) != 3 {
+		t.Fatalf("unexpected blocks: %v", blocks)
+	}
+	if blocks[time.Second][0].quantity != 6 || blocks[time.Second][1].addr != 122 {
+		t.Errorf("unexpected blocks: %+v, %+v", blocks[time.Second][0], blocks[time.Second][1])
+	}
+
+	// 125 registers fit in a single request
+	tags[3].Addr	= 121
+	blocks		= buildPollBlocks(tags, 200)
+	if len(blocks[time.Second]) != 2 || blocks[time.Second][0].quantity != 125 {
+		t.Errorf("unexpected blocks: %v", blocks[time.Second])
+	}
+
+	return
+}
+
+func TestPoller(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var poller	*Poller
+	var rm		*RegisterMap
+	var pub		*testPublisher
+	var value	TagValue
+	var changes	int
+	var ok		bool
+	var err		error
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5510",
+	}, &tcpTestHandler{})
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err = NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5510",
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err = client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	err = client.WriteRegisters(0x0000, []uint16{0x0102, 0x4049, 0x0fdb, 0xfffe})
+	if err == nil {
+		t.Fatalf("unit #1 should not be served")
+	}
+	client.SetUnitId(9)
+
+	err = client.WriteRegisters(0x0000, []uint16{0x0102, 0x4049, 0x0fdb, 0xfffe})
+	if err != nil {
+		t.Fatalf("WriteRegisters() should have succeeded, got: %v", err)
+	}
+
+	rm, err	= LoadRegisterMap([]byte(`{"unit": 9, "interval": "20ms", "tags": [
+		{"name": "raw", "addr": 0},
+		{"name": "pi", "addr": 1, "type": "float32"},
+		{"name": "neg", "addr": 3, "type": "int16", "scale": 0.5, "offset": 10},
+		{"name": "slow", "addr": 8, "type": "uint32", "unit": 9, "interval": "1h"}
+	]}`))
+	if err != nil {
+		t.Fatalf("LoadRegisterMap() should have succeeded, got: %v", err)
+	}
+
+	pub	= &testPublisher{topics: map[string]int{}}
+	poller, err	= NewPoller(client, rm, &PollerConfiguration{
+		OnChange:	func(v *TagValue) { changes++ },
+		Publisher:	pub,
+		Topic:		"plc/",
+	})
+	if err != nil {
+		t.Fatalf("NewPoller() should have succeeded, got: %v", err)
+	}
+
+	if poller.Requests() != 2 {
+		t.Errorf("expected 2 requests per poll, got: %v", poller.Requests())
+	}
+
+	// the slow tag is polled separately (different interval)
+	err	= poller.Poll()
+	if err != nil {
+		t.Errorf("Poll() should have succeeded, got: %v", err)
+	}
+
+	value, ok	= poller.Value("raw")
+	if !ok || value.Value != 0x0102 || value.Err != nil {
+		t.Errorf("unexpected value: %+v", value)
+	}
+
+	value, _	= poller.Value("pi")
+	if value.Value < 3.1415 || value.Value > 3.1416 {
+		t.Errorf("unexpected value: %+v", value)
+	}
+
+	value, _	= poller.Value("neg")
+	if value.Value != 9 {
+		t.Errorf("expected 9, got: %v", value.Value)
+	}
+
+	if changes != 4 || pub.count("plc/pi") != 1 {
+		t.Errorf("expected 4 changes, got: %v (%v)", changes, pub.topics)
+	}
+
+	// unchanged values are not published again
+	err	= poller.Poll()
+	if err != nil || changes != 4 {
+		t.Errorf("expected no change, got: %v (%v)", changes, err)
+	}
+
+	// background polling picks up changes
+	err	= poller.Start()
+	if err != nil {
+		t.Fatalf("Start() should have succeeded, got: %v", err)
+	}
+
+	err	= client.WriteRegister(0x0003, 0x0004)
+	if err != nil {
+		t.Fatalf("WriteRegister() should have succeeded, got: %v", err)
+	}
+
+	for i := 0; i < 50 && pub.count("plc/neg") < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	poller.Stop()
+
+	value, _	= poller.Value("neg")
+	if value.Value != 12 || pub.count("plc/neg") != 2 {
+		t.Errorf("expected 12, got: %v (%v)", value.Value, pub.topics)
+	}
+
+	if len(poller.Values()) != 4 {
+		t.Errorf("expected 4 values, got: %v", poller.Values())
+	}
+
+	return
+}
+
+func TestPollerErrorChanges(t *testing.T) {
+	var client	*ModbusClient
+	var poller	*Poller
+	var rm		*RegisterMap
+	var tag		*Tag
+	var changes	int
+	var err		error
+
+	client, err	= NewClient(&ClientConfiguration{URL: "tcp://localhost:5527"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	rm, err	= LoadRegisterMap([]byte(`{"tags": [{"name": "raw", "addr": 0}]}`))
+	if err != nil {
+		t.Fatalf("LoadRegisterMap() should have succeeded, got: %v", err)
+	}
+	tag	= rm.Tags[0]
+
+	poller, err	= NewPoller(client, rm, &PollerConfiguration{
+		OnChange:	func(v *TagValue) { changes++ },
+	})
+	if err != nil {
+		t.Fatalf("NewPoller() should have succeeded, got: %v", err)
+	}
+
+	// non-sentinel errors are new instances on every poll
+	for i := 0; i < 3; i++ {
+		poller.update(&TagValue{Tag: tag, Err: fmt.Errorf("dial tcp: connection refused")})
+	}
+	if changes != 1 {
+		t.Errorf("expected 1 change, got: %v", changes)
+	}
+
+	poller.update(&TagValue{Tag: tag, Err: fmt.Errorf("read: %w", ErrRequestTimedOut)})
+	poller.update(&TagValue{Tag: tag, Err: fmt.Errorf("read: %w", ErrRequestTimedOut)})
+	if changes != 2 {
+		t.Errorf("expected 2 changes, got: %v", changes)
+	}
+
+	poller.update(&TagValue{Tag: tag})
+	poller.update(&TagValue{Tag: tag})
+	if changes != 3 {
+		t.Errorf("expected 3 changes, got: %v", changes)
+	}
+
+	return
+}
+
+type testPublisher struct {
+	lock	sync.Mutex
+	topics	map[string]int
+}
+
+func (tp *testPublisher) Publish(topic string, args ...interface{}) {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	if _, ok := args[0].(*TagValue); ok {
+		tp.topics[topic]++
+	}
+}
+
+func (tp *testPublisher) count(topic string) int {
+	tp.lock.Lock()
+	defer tp.lock.Unlock()
+
+	return tp.topics[topic]
+}
+
+func TestPollerStop(t *testing.T) {
+	var sock	net.Listener
+	var client	*ModbusClient
+	var poller	*Poller
+	var rm		*RegisterMap
+	var start	time.Time
+	var err		error
+
+	// a device which never responds
+	sock, err	= net.Listen("tcp", "localhost:5534")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	go func() {
+		for {
+			conn, err := sock.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5534",
+		Timeout:	5 * time.Second,
+		MaxInFlight:	4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	rm, err	= LoadRegisterMap([]byte(`{"tags": [
+		{"name": "a", "addr": 0},
+		{"name": "b", "addr": 200}
+	]}`))
+	if err != nil {
+		t.Fatalf("LoadRegisterMap() should have succeeded, got: %v", err)
+	}
+
+	poller, err	= NewPoller(client, rm, &PollerConfiguration{})
+	if err != nil {
+		t.Fatalf("NewPoller() should have succeeded, got: %v", err)
+	}
+
+	err	= poller.Start()
+	if err != nil {
+		t.Fatalf("Start() should have succeeded, got: %v", err)
+	}
+
+	// in-flight reads are aborted
+	time.Sleep(50 * time.Millisecond)
+	start	= time.Now()
+	poller.Stop()
+	if time.Since(start) > time.Second {
+		t.Errorf("Stop() should not wait for pending reads, took %v", time.Since(start))
+	}
+
+	if _, ok := poller.Value("a"); ok {
+		t.Errorf("aborted reads should not be reported")
+	}
+
+	return
+}
@@ -0,0 +1,229 @@
+package modbus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// Pipelined TCP transport, keeping up to maxInFlight requests outstanding on
+// a single connection and matching responses to requests by transaction id.
+// Requests are written by the calling goroutines while a single reader
+// goroutine dispatches responses (+dmzn).
+type tcpPipeline struct {
+	logger		*logger
+	tt		*tcpTransport	// frame encoding/decoding
+	socket		net.Conn
+	timeout		time.Duration
+	slots		chan struct{}	// one token per in-flight request
+	lock		sync.Mutex	// protects the fields below and writes
+	lastTxnId	uint16
+	pending		map[uint16]chan pipelineResult
+	err		error		// set once the reader has exited
+	done		chan struct{}	// closed once the reader has exited
+}
+
+// Outcome of a pipelined request.
+type pipelineResult struct {
+	res	*pdu
+	err	error
+}
+
+// Returns a new pipelined TCP transport and starts its reader.
+func newTCPPipeline(socket net.Conn, timeout time.Duration, maxInFlight uint,
+	monitor *linkMonitor, customLogger *log.Logger) (tp *tcpPipeline) {
+	tp = &tcpPipeline{
+		logger:		newLogger(fmt.Sprintf("tcp-pipeline(%s)", socket.RemoteAddr()), customLogger),
+		tt:		newTCPTransport(socket, timeout, customLogger),
+		socket:		socket,
+		timeout:	timeout,
+		slots:		make(chan struct{}, maxInFlight),
+		pending:	map[uint16]chan pipelineResult{},
+		done:		make(chan struct{}),
+	}
+	// set before starting the reader
+	tp.tt.monitor	= monitor
+
+	go tp.readResponses()
+
+	return
+}
+
+// Closes the underlying socket and waits for the reader to exit.
+// Pending requests fail with the resulting read error.
+func (tp *tcpPipeline) Close() (err error) {
+	err	= tp.socket.Close()
+	<-tp.done
+
+	return
+}
+
+// Runs a request across the socket and returns a response.
+func (tp *tcpPipeline) ExecuteRequest(req *pdu) (res *pdu, err error) {
+	res, err	= tp.executeRequestCtx(context.Background(), req)
+
+	return
+}
+
+// Runs a request across the socket and waits for its response, giving up
+// when ctx is done or the request times out. Safe for concurrent use.
+func (tp *tcpPipeline) executeRequestCtx(ctx context.Context, req *pdu) (res *pdu, err error) {
+	var timer	*time.Timer
+	var txnId	uint16
+	var adu		[]byte
+	var rc		chan pipelineResult
+	var result	pipelineResult
+
+	// the timeout covers the wait for a free slot as well
+	timer	= time.NewTimer(tp.timeout)
+	defer timer.Stop()
+
+	select {
+	case tp.slots <- struct{}{}:
+	case <-ctx.Done():
+		err	= ctx.Err()
+		return
+	case <-tp.done:
+		err	= tp.err
+		return
+	case <-timer.C:
+		err	= ErrRequestTimedOut
+		return
+	}
+	defer func() { <-tp.slots }()
+
+	rc	= make(chan pipelineResult, 1)
+
+	tp.lock.Lock()
+	if tp.err != nil {
+		err	= tp.err
+		tp.lock.Unlock()
+		return
+	}
+
+	// pick the next transaction id not currently in use
+	for {
+		tp.lastTxnId++
+		if _, inUse := tp.pending[tp.lastTxnId]; !inUse {
+			break
+		}
+	}
+	txnId			= tp.lastTxnId
+	tp.pending[txnId]	= rc
+
+	adu	= tp.tt.assembleMBAPFrame(txnId, req)
+	err	= tp.socket.SetWriteDeadline(time.Now().Add(tp.timeout))
+	if err == nil {
+		_, err	= tp.socket.Write(adu)
+	}
+
+	if err != nil {
+		delete(tp.pending, txnId)
+		tp.lock.Unlock()
+		// a partial write leaves the stream unusable
+		tp.socket.Close()
+		return
+	}
+	tp.tt.monitor.sent(adu)
+	tp.lock.Unlock()
+
+	select {
+	case result = <-rc:
+		res, err	= result.res, result.err
+
+	case <-ctx.Done():
+		err	= ctx.Err()
+		tp.abandon(txnId)
+
+	case <-timer.C:
+		err	= ErrRequestTimedOut
+		tp.abandon(txnId)
+	}
+
+	return
+}
+
+// Not supported by pipelined transports (client side only).
+func (tp *tcpPipeline) ReadRequest() (req *pdu, err error) {
+	err	= ErrUnexpectedParameters
+
+	return
+}
+
+// Not supported by pipelined transports (client side only).
+func (tp *tcpPipeline) WriteResponse(res *pdu) (err error) {
+	err	= ErrUnexpectedParameters
+
+	return
+}
+
+// Raw reads would race with the response reader.
+func (tp *tcpPipeline) ReadRawData(len uint8) ([]byte, error) {
+	return nil, fmt.Errorf("raw reads are not supported by pipelined transports")
+}
+
+// Raw writes would interleave with pipelined requests.
+func (tp *tcpPipeline) WriteRawData(data []byte) error {
+	return fmt.Errorf("raw writes are not supported by pipelined transports")
+}
+
+// Forgets about a request which was given up on: its response, if it ever
+// comes, will be discarded.
+func (tp *tcpPipeline) abandon(txnId uint16) {
+	tp.lock.Lock()
+	delete(tp.pending, txnId)
+	tp.lock.Unlock()
+
+	return
+}
+
+// Reads responses and hands them over to their requester until the socket
+// fails or is closed.
+func (tp *tcpPipeline) readResponses() {
+	var res		*pdu
+	var txnId	uint16
+	var rc		chan pipelineResult
+	var ok		bool
+	var err		error
+
+	for {
+		res, txnId, err	= tp.tt.readMBAPFrame()
+
+		// ignore unknown protocol identifiers
+		if err == ErrUnknownProtocolId {
+			continue
+		}
+
+		if err != nil {
+			break
+		}
+
+		tp.lock.Lock()
+		rc, ok	= tp.pending[txnId]
+		delete(tp.pending, txnId)
+		tp.lock.Unlock()
+
+		if !ok {
+			tp.logger.Warningf("received unexpected transaction id 0x%04x", txnId)
+			continue
+		}
+
+		rc <- pipelineResult{res: res}
+	}
+
+	// fail all pending and future requests
+	tp.socket.Close()
+	tp.lock.Lock()
+	tp.err	= err
+	for txnId, rc = range tp.pending {
+		rc <- pipelineResult{err: err}
+		delete(tp.pending, txnId)
+	}
+	tp.lock.Unlock()
+	close(tp.done)
+
+	return
+}
@@ -0,0 +1,255 @@
+package modbus
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPPipelineOutOfOrder(t *testing.T) {
+	var sock	net.Listener
+	var client	*ModbusClient
+	var wg		sync.WaitGroup
+	var values	[3]uint16
+	var errs	[3]error
+	var value	uint16
+	var err		error
+
+	// a device reading 3 requests before answering them in reverse order,
+	// ignoring the ones addressed to register 0xdead
+	sock, err	= net.Listen("tcp", "localhost:5518")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer sock.Close()
+
+	go func() {
+		conn, err := sock.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		tt := newTCPTransport(conn, 10 * time.Second, nil)
+		for {
+			var reqs	[]*pdu
+			var txnIds	[]uint16
+
+			for len(reqs) < 3 {
+				req, txnId, err := tt.readMBAPFrame()
+				if err != nil {
+					return
+				}
+				reqs	= append(reqs, req)
+				txnIds	= append(txnIds, txnId)
+			}
+
+			for i := len(reqs) - 1; i >= 0; i-- {
+				addr := bytesToUint16(BIG_ENDIAN, reqs[i].payload[0:2])
+				if addr == 0xdead {
+					continue
+				}
+
+				// echo the register address as value
+				res := &pdu{
+					unitId:		reqs[i].unitId,
+					functionCode:	reqs[i].functionCode,
+					payload:	append([]byte{0x02}, uint16ToBytes(BIG_ENDIAN, addr)...),
+				}
+				conn.Write(tt.assembleMBAPFrame(txnIds[i], res))
+			}
+		}
+	}()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5518",
+		Timeout:	500 * time.Millisecond,
+		MaxInFlight:	4,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i]	= client.ReadRegister(uint16(0x100 + i), HOLDING_REGISTER)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		if errs[i] != nil || values[i] != uint16(0x100 + i) {
+			t.Errorf("request #%v: expected 0x%04x, got: 0x%04x (%v)",
+				 i, 0x100 + i, values[i], errs[i])
+		}
+	}
+
+	// unanswered requests time out without affecting the others
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			addr := uint16(0x200 + i)
+			if i == 1 {
+				addr	= 0xdead
+			}
+			values[i], errs[i]	= client.ReadRegister(addr, HOLDING_REGISTER)
+		}(i)
+	}
+	wg.Wait()
+
+	if errs[0] != nil || errs[2] != nil || values[0] != 0x200 || values[2] != 0x202 {
+		t.Errorf("unexpected results: %v, %v", values, errs)
+	}
+
+	if errs[1] != ErrRequestTimedOut {
+		t.Errorf("expected ErrRequestTimedOut, got: %v", errs[1])
+	}
+
+	// the link is still usable
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i]	= client.ReadRegister(uint16(0x300 + i), HOLDING_REGISTER)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		if errs[i] != nil || values[i] != uint16(0x300 + i) {
+			t.Errorf("request #%v: expected 0x%04x, got: 0x%04x (%v)",
+				 i, 0x300 + i, values[i], errs[i])
+		}
+	}
+
+	// raw data access would break the pipeline
+	_, err	= client.ReadRawData(1)
+	if err == nil {
+		t.Errorf("ReadRawData() should have failed")
+	}
+
+	client.Close()
+	value, err	= client.ReadRegister(0x100, HOLDING_REGISTER)
+	if err != ErrTransportNotOpen {
+		t.Errorf("expected ErrTransportNotOpen, got: 0x%04x (%v)", value, err)
+	}
+
+	return
+}
+
+func TestTCPPipelineConcurrentClients(t *testing.T) {
+	var server	*ModbusServer
+	var client	*ModbusClient
+	var ds		*DataStore
+	var wg		sync.WaitGroup
+	var lock	sync.Mutex
+	var failures	[]string
+	var err		error
+
+	ds, _	= NewDataStore(&DataStoreConfiguration{UnitIds: []uint8{1, 3, 4}})
+
+	server, err = NewServer(&ServerConfiguration{
+		URL:		"tcp://localhost:5519",
+	}, ds)
+	if err != nil {
+		t.Fatalf("failed to create server: %v", err)
+	}
+
+	err = server.Start()
+	if err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer server.Stop()
+
+	client, err	= NewClient(&ClientConfiguration{
+		URL:		"tcp://localhost:5519",
+		MaxInFlight:	8,
+	})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	err	= client.Open()
+	if err != nil {
+		t.Fatalf("failed to open client: %v", err)
+	}
+	defer client.Close()
+
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(i int) {
+			var value	uint32
+			var err		error
+
+			defer wg.Done()
+
+			for j := 0; j < 10; j++ {
+				err	= client.WriteUint32(uint16(2 * i), uint32(i * 1000 + j))
+				if err == nil {
+					value, err	= client.ReadUint32(uint16(2 * i), HOLDING_REGISTER)
+				}
+
+				if err != nil || value != uint32(i * 1000 + j) {
+					lock.Lock()
+					failures	= append(failures,
+						fmt.Sprintf("#%v/%v: %v (%v)", i, j, value, err))
+					lock.Unlock()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if len(failures) > 0 {
+		t.Errorf("%v failed requests: %v", len(failures), failures)
+	}
+
+	// pollers read blocks concurrently through pipelined clients
+	ds.SetRegister(3, INPUT_REGISTER, 10, 42)
+	ds.SetRegister(4, INPUT_REGISTER, 20, 43)
+
+	rm, _	:= LoadRegisterMap([]byte(`{"tags": [
+		{"name": "a", "addr": 10, "reg": "input", "unit": 3},
+		{"name": "b", "addr": 20, "reg": "input", "unit": 4},
+		{"name": "c", "addr": 2, "type": "uint32"}
+	]}`))
+	poller, _	:= NewPoller(client, rm, nil)
+
+	err	= poller.Poll()
+	if err != nil {
+		t.Errorf("Poll() should have succeeded, got: %v", err)
+	}
+
+	values	:= poller.Values()
+	if values["a"].Value != 42 || values["b"].Value != 43 || values["c"].Value != 1009 {
+		t.Errorf("unexpected values: %v", values)
+	}
+
+	return
+}
+
+func TestTCPPipelineConfiguration(t *testing.T) {
+	var err	error
+
+	_, err	= NewClient(&ClientConfiguration{
+		URL:		"rtuovertcp://localhost:5502",
+		MaxInFlight:	4,
+	})
+	if err != ErrConfigurationError {
+		t.Errorf("expected ErrConfigurationError, got: %v", err)
+	}
+
+	return
+}
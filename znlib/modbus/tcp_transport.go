@@ -18,6 +18,7 @@ type tcpTransport struct {
 	socket		net.Conn
 	timeout		time.Duration
 	lastTxnId	uint16
+	monitor		*linkMonitor
 }
 
 // Returns a new TCP transport.
@@ -46,13 +47,17 @@ func (tt *tcpTransport) ExecuteRequest(req *pdu) (res *pdu, err error) {
 		return
 	}
 
+	var adu		[]byte
+
 	// increase the transaction ID counter
 	tt.lastTxnId++
 
-	_, err	= tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, req))
+	adu	= tt.assembleMBAPFrame(tt.lastTxnId, req)
+	_, err	= tt.socket.Write(adu)
 	if err != nil {
 		return
 	}
+	tt.monitor.sent(adu)
 
 	res, err = tt.readResponse()
 
@@ -116,10 +121,14 @@ func (tt *tcpTransport) WriteRawData(data []byte) error {
 
 // Writes a response to the socket.
 func (tt *tcpTransport) WriteResponse(res *pdu) (err error) {
-	_, err	= tt.socket.Write(tt.assembleMBAPFrame(tt.lastTxnId, res))
+	var adu		[]byte
+
+	adu	= tt.assembleMBAPFrame(tt.lastTxnId, res)
+	_, err	= tt.socket.Write(adu)
 	if err != nil {
 		return
 	}
+	tt.monitor.sent(adu)
 
 	return
 }
@@ -159,13 +168,15 @@ func (tt *tcpTransport) readResponse() (res *pdu, err error) {
 
 // Reads an entire frame (MBAP header + modbus PDU) from the socket.
 func (tt *tcpTransport) readMBAPFrame() (p *pdu, txnId uint16, err error) {
+	var header	[]byte
 	var rxbuf	[]byte
 	var bytesNeeded	int
 	var protocolId	uint16
 	var unitId	uint8
 
 	// read the MBAP header
-	rxbuf		= make([]byte, mbapHeaderLength)
+	header		= make([]byte, mbapHeaderLength)
+	rxbuf		= header
 	_, err		= io.ReadFull(tt.socket, rxbuf)
 	if err != nil {
 		return
@@ -202,6 +213,7 @@ func (tt *tcpTransport) readMBAPFrame() (p *pdu, txnId uint16, err error) {
 	if err != nil {
 		return
 	}
+	tt.monitor.received(append(header, rxbuf...))
 
 	// validate the protocol identifier
 	if protocolId != 0x0000 {
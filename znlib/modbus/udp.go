@@ -1,6 +1,7 @@
 package modbus
 
 import (
+	"io"
 	"net"
 	"time"
 )
@@ -100,3 +101,73 @@ func (usw *udpSockWrapper) RemoteAddr() (addr net.Addr) {
 
 	return
 }
+
+// udpDatagram wraps a single datagram received by a UDP server socket to
+// allow transports to read the request byte per byte and write the response
+// back to the datagram's source address.
+type udpDatagram struct {
+	sock          *net.UDPConn
+	peer          *net.UDPAddr
+	rxbuf         []byte
+}
+
+func newUDPDatagram(sock *net.UDPConn, peer *net.UDPAddr, data []byte) (dg *udpDatagram) {
+	dg = &udpDatagram{
+		sock:  sock,
+		peer:  peer,
+		rxbuf: data,
+	}
+
+	return
+}
+
+func (dg *udpDatagram) Read(buf []byte) (rlen int, err error) {
+	if len(dg.rxbuf) == 0 {
+		err = io.EOF
+		return
+	}
+
+	rlen     = copy(buf, dg.rxbuf)
+	dg.rxbuf = dg.rxbuf[rlen:]
+
+	return
+}
+
+// Closing a datagram is a no-op: the server socket is shared.
+func (dg *udpDatagram) Close() (err error) {
+	return
+}
+
+func (dg *udpDatagram) Write(buf []byte) (wlen int, err error) {
+	wlen, err = dg.sock.WriteToUDP(buf, dg.peer)
+
+	return
+}
+
+func (dg *udpDatagram) SetDeadline(deadline time.Time) (err error) {
+	err = dg.sock.SetWriteDeadline(deadline)
+
+	return
+}
+
+func (dg *udpDatagram) SetReadDeadline(deadline time.Time) (err error) {
+	return
+}
+
+func (dg *udpDatagram) SetWriteDeadline(deadline time.Time) (err error) {
+	err = dg.sock.SetWriteDeadline(deadline)
+
+	return
+}
+
+func (dg *udpDatagram) LocalAddr() (addr net.Addr) {
+	addr = dg.sock.LocalAddr()
+
+	return
+}
+
+func (dg *udpDatagram) RemoteAddr() (addr net.Addr) {
+	addr = dg.peer
+
+	return
+}
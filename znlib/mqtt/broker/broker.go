@@ -0,0 +1,462 @@
+// Package broker
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 17:40:12
+  描述: 内嵌的轻量级 mqtt 3.1.1 broker
+
+备注:
+*.支持: tcp/websocket 监听,qos 0/1,保留消息,遗嘱消息,用户名密码认证
+*.qos 2 的发布按 qos 1 转发
+*.clean session=false 时,断线后保留订阅和未确认的 qos1 消息(仅内存),重连后重发
+*.离线会话超过 Options.SessionExpiry 后删除;数量超过 Options.MaxOffline 时,
+  删除最早离线的会话
+*.使用方法
+  bk := broker.New(&broker.Options{TcpAddr: "127.0.0.1:1883"})
+  if err := bk.Start(); err != nil {...}
+  defer bk.Stop()
+******************************************************************************/
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// Options broker 参数
+type Options struct {
+	TcpAddr       string            //tcp 监听地址,ex: 0.0.0.0:1883
+	WsAddr        string            //websocket 监听地址,ex: 0.0.0.0:8083
+	WsPath        string            //websocket 路径,默认 /mqtt
+	Users         map[string]string //用户名:密码,为空时允许匿名
+	HintInfo      bool              //打印连接信息
+	KeepAlive     time.Duration     //客户端未指定时的默认心跳
+	MaxInflight   int               //每个会话缓存的未确认 qos1 消息数,默认1000
+	MaxOffline    int               //保留的离线会话数,默认1000
+	SessionExpiry time.Duration     //离线会话保留时长,默认1天
+}
+
+// Broker 内嵌 broker
+type Broker struct {
+	opts     Options
+	lock     sync.RWMutex            //同步锁定
+	sessions map[string]*session     //在线客户端
+	states   map[string]*state       //保留的会话状态(clean session=false)
+	retained map[string]*retainedMsg //保留消息
+	listener net.Listener            //tcp 监听
+	server   *http.Server            //websocket 服务
+	wsAddr   net.Addr                //websocket 监听地址
+	wg       sync.WaitGroup          //等待退出
+	running  bool                    //运行中
+}
+
+// retainedMsg 保留消息
+type retainedMsg struct {
+	topic   string
+	qos     byte
+	payload []byte
+}
+
+// New 2026-10-19 17:46:30
+/*
+ 参数: opts,参数
+ 描述: 创建broker
+*/
+func New(opts *Options) *Broker {
+	bk := &Broker{
+		opts:     *opts,
+		sessions: make(map[string]*session),
+		states:   make(map[string]*state),
+		retained: make(map[string]*retainedMsg),
+	}
+
+	if bk.opts.WsPath == "" {
+		bk.opts.WsPath = "/mqtt"
+	}
+
+	if bk.opts.KeepAlive <= 0 {
+		bk.opts.KeepAlive = 60 * time.Second
+	}
+
+	if bk.opts.MaxInflight <= 0 {
+		bk.opts.MaxInflight = 1000
+	}
+
+	if bk.opts.MaxOffline <= 0 {
+		bk.opts.MaxOffline = 1000
+	}
+
+	if bk.opts.SessionExpiry <= 0 {
+		bk.opts.SessionExpiry = 24 * time.Hour
+	}
+	return bk
+}
+
+// Start 2026-10-19 17:49:55
+/*
+ 描述: 启动监听
+*/
+func (bk *Broker) Start() error {
+	bk.lock.Lock()
+	defer bk.lock.Unlock()
+
+	if bk.running {
+		return nil
+	}
+
+	if bk.opts.TcpAddr == "" && bk.opts.WsAddr == "" {
+		return errors.New("znlib.broker: no listener address")
+	}
+
+	if bk.opts.TcpAddr != "" {
+		ln, err := net.Listen("tcp", bk.opts.TcpAddr)
+		if err != nil {
+			return fmt.Errorf("znlib.broker.Listen: %v", err)
+		}
+
+		bk.listener = ln
+		bk.wg.Add(1)
+		go bk.acceptTCP(ln)
+	}
+
+	if bk.opts.WsAddr != "" {
+		ln, err := net.Listen("tcp", bk.opts.WsAddr)
+		if err != nil {
+			if bk.listener != nil {
+				_ = bk.listener.Close()
+				bk.listener = nil
+			}
+			return fmt.Errorf("znlib.broker.Listen: %v", err)
+		}
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(bk.opts.WsPath, bk.serveWs)
+		bk.server = &http.Server{Handler: mux}
+		bk.wsAddr = ln.Addr()
+
+		bk.wg.Add(1)
+		go func() {
+			defer bk.wg.Done()
+			_ = bk.server.Serve(ln)
+		}()
+	}
+
+	bk.running = true
+	return nil
+}
+
+// Stop 2026-10-19 17:55:21
+/*
+ 描述: 停止监听并断开所有客户端
+*/
+func (bk *Broker) Stop() {
+	bk.lock.Lock()
+	if !bk.running {
+		bk.lock.Unlock()
+		return
+	}
+
+	bk.running = false
+	if bk.listener != nil {
+		_ = bk.listener.Close()
+		bk.listener = nil
+	}
+
+	if bk.server != nil {
+		_ = bk.server.Close()
+		bk.server = nil
+	}
+
+	sessions := make([]*session, 0, len(bk.sessions))
+	for _, v := range bk.sessions {
+		sessions = append(sessions, v)
+	}
+	bk.lock.Unlock()
+
+	for _, v := range sessions {
+		v.close()
+	}
+	bk.wg.Wait()
+}
+
+// TcpAddr 2026-10-19 17:58:40
+/*
+ 描述: 实际的tcp监听地址(端口为0时有效)
+*/
+func (bk *Broker) TcpAddr() string {
+	bk.lock.RLock()
+	defer bk.lock.RUnlock()
+
+	if bk.listener == nil {
+		return ""
+	}
+	return bk.listener.Addr().String()
+}
+
+// WsAddr 2026-10-19 19:47:36
+/*
+ 描述: 实际的websocket监听地址(端口为0时有效)
+*/
+func (bk *Broker) WsAddr() string {
+	bk.lock.RLock()
+	defer bk.lock.RUnlock()
+
+	if bk.server == nil || bk.wsAddr == nil {
+		return ""
+	}
+	return bk.wsAddr.String()
+}
+
+// Clients 2026-10-19 18:00:05
+/*
+ 描述: 在线客户端数
+*/
+func (bk *Broker) Clients() int {
+	bk.lock.RLock()
+	defer bk.lock.RUnlock()
+	return len(bk.sessions)
+}
+
+// acceptTCP 2026-10-19 18:02:31
+/*
+ 参数: ln,监听对象
+ 描述: 接受tcp连接
+*/
+func (bk *Broker) acceptTCP(ln net.Listener) {
+	defer bk.wg.Done()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return //监听关闭
+		}
+
+		bk.wg.Add(1)
+		go func() {
+			defer bk.wg.Done()
+			newSession(bk, conn).serve()
+		}()
+	}
+}
+
+// authenticate 2026-10-19 18:05:47
+/*
+ 参数: user,用户名
+ 参数: pwd,密码
+ 描述: 校验用户,返回connack代码
+*/
+func (bk *Broker) authenticate(cp *packets.ConnectPacket) byte {
+	if len(bk.opts.Users) < 1 {
+		return packets.Accepted
+	}
+
+	if !cp.UsernameFlag {
+		return packets.ErrRefusedNotAuthorised
+	}
+
+	pwd, ok := bk.opts.Users[cp.Username]
+	if !ok || pwd != string(cp.Password) {
+		return packets.ErrRefusedBadUsernameOrPassword
+	}
+	return packets.Accepted
+}
+
+// register 2026-10-19 18:09:14
+/*
+ 参数: ss,会话
+ 参数: clean,清除会话
+ 描述: 登记会话,踢出同id的旧会话,返回是否恢复了保留的会话状态
+*/
+func (bk *Broker) register(ss *session, clean bool) (present bool) {
+	bk.lock.Lock()
+	old := bk.sessions[ss.id]
+	bk.sessions[ss.id] = ss
+
+	if clean {
+		delete(bk.states, ss.id)
+		ss.st = newState()
+	} else {
+		ss.st, present = bk.states[ss.id]
+		if present && bk.expired(ss.st, time.Now()) {
+			present = false
+		}
+
+		if !present {
+			ss.st = newState()
+			bk.states[ss.id] = ss.st
+		}
+		ss.st.offline = time.Time{}
+	}
+	bk.lock.Unlock()
+
+	if old != nil {
+		old.close()
+	}
+
+	if bk.opts.HintInfo {
+		Info(fmt.Sprintf("znlib.broker: %s connected from %s", ss.id, ss.conn.RemoteAddr()))
+	}
+	return
+}
+
+// unregister 2026-10-19 18:11:38
+/*
+ 参数: ss,会话
+ 描述: 删除会话
+*/
+func (bk *Broker) unregister(ss *session) {
+	bk.lock.Lock()
+	if bk.sessions[ss.id] == ss {
+		delete(bk.sessions, ss.id)
+		if bk.states[ss.id] == ss.st {
+			ss.st.offline = time.Now()
+		}
+		bk.evictStates()
+	}
+	bk.lock.Unlock()
+
+	if bk.opts.HintInfo {
+		Info(fmt.Sprintf("znlib.broker: %s disconnected", ss.id))
+	}
+}
+
+// expired 2026-10-19 18:12:20
+/*
+ 参数: st,会话状态
+ 参数: now,当前时间
+ 描述: 离线会话是否已过期
+
+ 备注: 调用方需持有 bk.lock
+*/
+func (bk *Broker) expired(st *state, now time.Time) bool {
+	return !st.offline.IsZero() && now.Sub(st.offline) > bk.opts.SessionExpiry
+}
+
+// evictStates 2026-10-19 18:13:05
+/*
+ 描述: 删除过期的离线会话,数量超出时删除最早离线的
+
+ 备注: 调用方需持有 bk.lock
+*/
+func (bk *Broker) evictStates() {
+	now := time.Now()
+	offline := 0
+	var oldest string
+
+	for id, st := range bk.states {
+		if st.offline.IsZero() {
+			continue
+		}
+
+		if bk.expired(st, now) {
+			delete(bk.states, id)
+			continue
+		}
+
+		offline++
+		if oldest == "" || st.offline.Before(bk.states[oldest].offline) {
+			oldest = id
+		}
+	}
+
+	for offline > bk.opts.MaxOffline { //每次断开最多新增一个离线会话
+		delete(bk.states, oldest)
+		offline--
+
+		oldest = ""
+		for id, st := range bk.states {
+			if !st.offline.IsZero() && (oldest == "" || st.offline.Before(bk.states[oldest].offline)) {
+				oldest = id
+			}
+		}
+	}
+}
+
+// publish 2026-10-19 18:14:02
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: retain,保留
+ 参数: payload,消息
+ 描述: 将消息投递给所有订阅者
+*/
+func (bk *Broker) publish(topic string, qos byte, retain bool, payload []byte) {
+	bk.lock.Lock()
+	if retain {
+		if len(payload) < 1 {
+			delete(bk.retained, topic)
+		} else {
+			bk.retained[topic] = &retainedMsg{topic: topic, qos: qos, payload: payload}
+		}
+	}
+
+	targets := make([]*session, 0, len(bk.sessions))
+	for _, v := range bk.sessions {
+		targets = append(targets, v)
+	}
+
+	now := time.Now()
+	for id, st := range bk.states { //离线会话缓存 qos1 消息,重连后发送
+		if _, ok := bk.sessions[id]; ok {
+			continue
+		}
+
+		if bk.expired(st, now) {
+			delete(bk.states, id)
+			continue
+		}
+
+		if subQos, ok := st.match(topic); ok && minQos(qos, subQos) > 0 {
+			st.track(newPublish(topic, 1, false, payload), bk.opts.MaxInflight)
+		}
+	}
+	bk.lock.Unlock()
+
+	for _, ss := range targets {
+		if subQos, ok := ss.st.match(topic); ok {
+			ss.deliver(topic, minQos(qos, subQos), false, payload)
+		}
+	}
+}
+
+// sendRetained 2026-10-19 18:18:26
+/*
+ 参数: ss,会话
+ 参数: filter,订阅的主题过滤器
+ 参数: qos,订阅的qos
+ 描述: 向新订阅发送匹配的保留消息
+*/
+func (bk *Broker) sendRetained(ss *session, filter string, qos byte) {
+	bk.lock.RLock()
+	list := make([]*retainedMsg, 0)
+	for _, v := range bk.retained {
+		if mqtt.MatchTopic(filter, v.topic) {
+			list = append(list, v)
+		}
+	}
+	bk.lock.RUnlock()
+
+	for _, v := range list {
+		ss.deliver(v.topic, minQos(v.qos, qos), true, v.payload)
+	}
+}
+
+// minQos 2026-10-19 18:20:40
+/*
+ 描述: 取较小的qos,最大为1
+*/
+func minQos(a, b byte) byte {
+	if b < a {
+		a = b
+	}
+
+	if a > 1 {
+		a = 1
+	}
+	return a
+}
@@ -0,0 +1,452 @@
+// Package broker
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 18:25:36
+  描述: broker 客户端会话
+******************************************************************************/
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// state 会话状态,clean session=false 时在断线后保留
+type state struct {
+	lock     sync.RWMutex             //同步锁定
+	subs     map[string]byte          //订阅: 过滤器 -> qos
+	msgID    uint16                   //下行消息编号
+	inflight []*packets.PublishPacket //未确认的 qos1 下行消息,按发送顺序
+	offline  time.Time                //离线时间,零值为在线(受 Broker.lock 保护)
+}
+
+// session 客户端会话
+type session struct {
+	bk     *Broker
+	conn   net.Conn
+	st     *state                 //会话状态
+	id     string                 //客户端标识
+	lock   sync.Mutex             //写入锁定
+	will   *packets.PublishPacket //遗嘱消息
+	qos2   map[uint16]bool        //等待 pubrel 的上行消息
+	once   sync.Once              //关闭一次
+	closed bool                   //已关闭
+}
+
+// newState 2026-10-19 19:30:16
+/*
+ 描述: 创建会话状态
+*/
+func newState() *state {
+	return &state{
+		subs:     make(map[string]byte),
+		inflight: make([]*packets.PublishPacket, 0),
+	}
+}
+
+// newSession 2026-10-19 18:29:10
+/*
+ 参数: bk,broker
+ 参数: conn,链路
+ 描述: 创建会话
+*/
+func newSession(bk *Broker, conn net.Conn) *session {
+	return &session{
+		bk:   bk,
+		conn: conn,
+		qos2: make(map[uint16]bool),
+	}
+}
+
+// serve 2026-10-19 18:31:45
+/*
+ 描述: 处理客户端报文,直到连接断开
+*/
+func (ss *session) serve() {
+	caller := "znlib.broker.session"
+	defer DeferHandle(false, caller)
+	reader := bufio.NewReader(ss.conn)
+
+	_ = ss.conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	pkt, err := packets.ReadPacket(reader)
+	if err != nil {
+		_ = ss.conn.Close()
+		return
+	}
+
+	cp, ok := pkt.(*packets.ConnectPacket)
+	if !ok { //首个报文必须是 connect
+		_ = ss.conn.Close()
+		return
+	}
+
+	keepAlive, ok := ss.connect(cp)
+	if !ok {
+		return
+	}
+
+	graceful := false
+	defer func() {
+		ss.close()
+		ss.bk.unregister(ss)
+
+		if !graceful && ss.will != nil { //异常断开,发布遗嘱
+			ss.bk.publish(ss.will.TopicName, ss.will.Qos, ss.will.Retain, ss.will.Payload)
+		}
+	}()
+
+	for {
+		_ = ss.conn.SetReadDeadline(time.Now().Add(keepAlive * 3 / 2))
+		pkt, err = packets.ReadPacket(reader)
+		if err != nil {
+			return
+		}
+
+		switch p := pkt.(type) {
+		case *packets.PublishPacket:
+			ss.onPublish(p)
+		case *packets.PubackPacket:
+			ss.st.ack(p.MessageID) //qos1 下行完成
+		case *packets.PubrelPacket:
+			delete(ss.qos2, p.MessageID)
+			res := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+			res.MessageID = p.MessageID
+			ss.write(res)
+		case *packets.SubscribePacket:
+			ss.onSubscribe(p)
+		case *packets.UnsubscribePacket:
+			ss.st.lock.Lock()
+			for _, v := range p.Topics {
+				delete(ss.st.subs, v)
+			}
+			ss.st.lock.Unlock()
+
+			res := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+			res.MessageID = p.MessageID
+			ss.write(res)
+		case *packets.PingreqPacket:
+			ss.write(packets.NewControlPacket(packets.Pingresp))
+		case *packets.DisconnectPacket:
+			graceful = true
+			return
+		default:
+			ErrorCaller(fmt.Sprintf("%s: unexpected packet %s", ss.id, pkt.String()), caller)
+			return
+		}
+	}
+}
+
+// connect 2026-10-19 18:38:20
+/*
+ 参数: cp,连接报文
+ 描述: 校验连接请求并应答,返回心跳间隔
+*/
+func (ss *session) connect(cp *packets.ConnectPacket) (time.Duration, bool) {
+	ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+	ack.ReturnCode = cp.Validate()
+	if ack.ReturnCode == packets.Accepted {
+		ack.ReturnCode = ss.bk.authenticate(cp)
+	}
+
+	if ack.ReturnCode != packets.Accepted {
+		ss.write(ack)
+		_ = ss.conn.Close()
+		return 0, false
+	}
+
+	ss.id = cp.ClientIdentifier
+	if ss.id == "" {
+		ss.id = "auto-" + SerialID.MakeID(12)
+	}
+
+	if cp.WillFlag {
+		will := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		will.TopicName = cp.WillTopic
+		will.Payload = cp.WillMessage
+		will.Qos = cp.WillQos
+		will.Retain = cp.WillRetain
+		ss.will = will
+	}
+
+	ack.SessionPresent = ss.bk.register(ss, cp.CleanSession)
+	if !ss.write(ack) {
+		return 0, false
+	}
+
+	for _, pub := range ss.st.pending() { //重发未确认的消息
+		if !ss.write(pub) {
+			return 0, false
+		}
+	}
+
+	keepAlive := time.Duration(cp.Keepalive) * time.Second
+	if keepAlive <= 0 {
+		keepAlive = ss.bk.opts.KeepAlive
+	}
+	return keepAlive, true
+}
+
+// onPublish 2026-10-19 18:45:02
+/*
+ 参数: p,发布报文
+ 描述: 应答并转发客户端发布的消息
+*/
+func (ss *session) onPublish(p *packets.PublishPacket) {
+	if strings.ContainsAny(p.TopicName, "+#") {
+		ss.close() //发布主题不能有通配符
+		return
+	}
+
+	switch p.Qos {
+	case 1:
+		res := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+		res.MessageID = p.MessageID
+		ss.write(res)
+	case 2:
+		res := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+		res.MessageID = p.MessageID
+		ss.write(res)
+
+		if ss.qos2[p.MessageID] { //重复发送
+			return
+		}
+		ss.qos2[p.MessageID] = true
+	}
+
+	ss.bk.publish(p.TopicName, p.Qos, p.Retain, p.Payload)
+}
+
+// onSubscribe 2026-10-19 18:50:33
+/*
+ 参数: p,订阅报文
+ 描述: 登记订阅并发送保留消息
+*/
+func (ss *session) onSubscribe(p *packets.SubscribePacket) {
+	res := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+	res.MessageID = p.MessageID
+	res.ReturnCodes = make([]byte, len(p.Topics))
+
+	ss.st.lock.Lock()
+	for i, v := range p.Topics {
+		qos := minQos(p.Qoss[i], 1)
+		if !validFilter(v) {
+			res.ReturnCodes[i] = 0x80 //失败
+			continue
+		}
+
+		ss.st.subs[v] = qos
+		res.ReturnCodes[i] = qos
+	}
+	ss.st.lock.Unlock()
+
+	if !ss.write(res) {
+		return
+	}
+
+	for i, v := range p.Topics {
+		if res.ReturnCodes[i] != 0x80 {
+			ss.bk.sendRetained(ss, v, res.ReturnCodes[i])
+		}
+	}
+}
+
+// match 2026-10-19 18:55:17
+/*
+ 参数: topic,主题
+ 描述: 检查是否订阅了topic,返回最大的qos
+*/
+func (st *state) match(topic string) (byte, bool) {
+	st.lock.RLock()
+	defer st.lock.RUnlock()
+
+	var (
+		qos   byte
+		found bool
+	)
+
+	for filter, q := range st.subs {
+		if mqtt.MatchTopic(filter, topic) {
+			if !found || q > qos {
+				qos = q
+			}
+			found = true
+		}
+	}
+	return qos, found
+}
+
+// track 2026-10-19 19:33:52
+/*
+ 参数: pub,qos1 消息
+ 参数: max,最大缓存数
+ 描述: 为pub分配编号并缓存至确认,超过max时丢弃最早的消息
+*/
+func (st *state) track(pub *packets.PublishPacket, max int) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	st.msgID++
+	if st.msgID == 0 {
+		st.msgID = 1
+	}
+	pub.MessageID = st.msgID
+
+	if len(st.inflight) >= max {
+		st.inflight = append(st.inflight[:0], st.inflight[1:]...)
+	}
+
+	cp := *pub
+	st.inflight = append(st.inflight, &cp)
+}
+
+// sent 2026-10-19 19:36:20
+/*
+ 参数: id,消息编号
+ 描述: 标记消息已发送,重发时设置 dup
+*/
+func (st *state) sent(id uint16) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	for _, v := range st.inflight {
+		if v.MessageID == id {
+			v.Dup = true
+			return
+		}
+	}
+}
+
+// ack 2026-10-19 19:38:05
+/*
+ 参数: id,消息编号
+ 描述: 客户端已确认,删除缓存
+*/
+func (st *state) ack(id uint16) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	for i, v := range st.inflight {
+		if v.MessageID == id {
+			st.inflight = append(st.inflight[:i], st.inflight[i+1:]...)
+			return
+		}
+	}
+}
+
+// pending 2026-10-19 19:40:41
+/*
+ 描述: 待重发的消息,曾发送过的设置 dup
+*/
+func (st *state) pending() []*packets.PublishPacket {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	list := make([]*packets.PublishPacket, 0, len(st.inflight))
+	for _, v := range st.inflight {
+		cp := *v
+		list = append(list, &cp)
+		v.Dup = true
+	}
+	return list
+}
+
+// newPublish 2026-10-19 19:43:18
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: retain,保留标记
+ 参数: payload,消息
+ 描述: 创建下行发布报文
+*/
+func newPublish(topic string, qos byte, retain bool, payload []byte) *packets.PublishPacket {
+	pub := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	pub.TopicName = topic
+	pub.Qos = qos
+	pub.Retain = retain
+	pub.Payload = payload
+	return pub
+}
+
+// deliver 2026-10-19 18:58:49
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: retain,保留标记
+ 参数: payload,消息
+ 描述: 向客户端发送消息,qos1 消息缓存至确认
+*/
+func (ss *session) deliver(topic string, qos byte, retain bool, payload []byte) {
+	pub := newPublish(topic, qos, retain, payload)
+	if qos > 0 {
+		ss.st.track(pub, ss.bk.opts.MaxInflight)
+	}
+
+	if ss.write(pub) && qos > 0 {
+		ss.st.sent(pub.MessageID)
+	}
+}
+
+// write 2026-10-19 19:02:11
+/*
+ 参数: pkt,报文
+ 描述: 发送报文,失败时关闭连接
+*/
+func (ss *session) write(pkt packets.ControlPacket) bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	if ss.closed {
+		return false
+	}
+
+	_ = ss.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := pkt.Write(ss.conn); err != nil {
+		ss.closed = true
+		_ = ss.conn.Close()
+		return false
+	}
+	return true
+}
+
+// close 2026-10-19 19:05:40
+/*
+ 描述: 关闭连接
+*/
+func (ss *session) close() {
+	ss.once.Do(func() {
+		ss.lock.Lock()
+		ss.closed = true
+		ss.lock.Unlock()
+		_ = ss.conn.Close()
+	})
+}
+
+// validFilter 2026-10-19 19:08:12
+/*
+ 参数: filter,主题过滤器
+ 描述: 检查通配符位置是否合法
+*/
+func validFilter(filter string) bool {
+	if filter == "" {
+		return false
+	}
+
+	levels := strings.Split(filter, "/")
+	for i, v := range levels {
+		if strings.Contains(v, "#") && (v != "#" || i != len(levels)-1) {
+			return false
+		}
+
+		if strings.Contains(v, "+") && v != "+" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,123 @@
+// Package broker
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 19:12:26
+  描述: broker websocket 监听
+
+备注:
+*.mqtt over websocket: 子协议为 mqtt,报文使用二进制帧传输
+******************************************************************************/
+package broker
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/gorilla/websocket"
+)
+
+// upgrader websocket 升级
+var upgrader = websocket.Upgrader{
+	Subprotocols:    []string{"mqtt"},
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+	CheckOrigin: func(r *http.Request) bool {
+		return true
+	},
+}
+
+// serveWs 2026-10-19 19:15:40
+/*
+ 参数: w,应答
+ 参数: r,请求
+ 描述: 升级为websocket连接并处理mqtt报文
+*/
+func (bk *Broker) serveWs(w http.ResponseWriter, r *http.Request) {
+	bk.lock.Lock()
+	if !bk.running { //停止中,避免与 Stop 中的 wg.Wait 竞争
+		bk.lock.Unlock()
+		http.Error(w, "broker is stopping", http.StatusServiceUnavailable)
+		return
+	}
+
+	bk.wg.Add(1)
+	bk.lock.Unlock()
+	defer bk.wg.Done()
+
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ErrorCaller(err, "znlib.broker.serveWs")
+		return
+	}
+	newSession(bk, &wsConn{Conn: ws}).serve()
+}
+
+// wsConn 将 websocket 适配为 net.Conn
+type wsConn struct {
+	*websocket.Conn
+	reader io.Reader  //当前帧
+	lock   sync.Mutex //写入锁定
+}
+
+// Read 2026-10-19 19:19:02
+/*
+ 参数: buf,缓冲
+ 描述: 读取二进制帧数据
+*/
+func (wc *wsConn) Read(buf []byte) (int, error) {
+	for {
+		if wc.reader == nil {
+			mt, reader, err := wc.NextReader()
+			if err != nil {
+				return 0, err
+			}
+
+			if mt != websocket.BinaryMessage {
+				continue
+			}
+			wc.reader = reader
+		}
+
+		n, err := wc.reader.Read(buf)
+		if err == io.EOF {
+			wc.reader = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write 2026-10-19 19:22:15
+/*
+ 参数: data,数据
+ 描述: 以二进制帧发送数据
+*/
+func (wc *wsConn) Write(data []byte) (int, error) {
+	wc.lock.Lock()
+	defer wc.lock.Unlock()
+
+	if err := wc.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
+
+// SetDeadline 2026-10-19 19:24:30
+/*
+ 参数: t,截止时间
+ 描述: 设置读写截止时间
+*/
+func (wc *wsConn) SetDeadline(t time.Time) error {
+	if err := wc.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return wc.SetWriteDeadline(t)
+}
+
+var _ net.Conn = (*wsConn)(nil)
@@ -0,0 +1,345 @@
+// Package mqtt
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 19:40:21
+  描述: 消息编码与标准封装
+
+备注:
+*.使用方法
+  //1.发布
+  Client.Envelope = EnvelopeOption{Schema: "1.0", Zip: true}
+  Client.PublishJSON("dev/01/status", Qos1, &status)
+  //2.订阅
+  SubscribeTyped(Client, "dev/+/status", Qos1, func(env *Envelope, data *Status) {...})
+*.消息体统一使用 Envelope(json)封装:
+  1.id,ts,src,schema: 消息标识、时间戳(毫秒)、来源客户端、数据版本
+  2.codec: 业务数据的编码方式,订阅方据此解码
+  3.zip,enc: 业务数据已压缩(ZipUtils)、已加密(Encrypter)
+*.msgpack,proto 不引入第三方库:
+  1.默认只支持 tinylib/msgp、gogo/protobuf 等生成代码的序列化方法,
+    PublishMsgpack,PublishProto 的参数须实现 MsgpMarshaler,ProtoMarshaler;
+    SubscribeTyped 的 *T 须实现 MsgpUnmarshaler,ProtoUnmarshaler
+  2.普通结构体使用 PublishJSON,或使用 RegisterCodec 替换为完整实现后,
+    调用 PublishCodec 发布
+******************************************************************************/
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	mt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Codec 业务数据编码
+type Codec interface {
+	Name() string                       //编码名称
+	Marshal(v any) ([]byte, error)      //编码
+	Unmarshal(data []byte, v any) error //解码
+}
+
+const (
+	CodecJSON    = "json"    //json
+	CodecMsgpack = "msgpack" //msgpack
+	CodecProto   = "proto"   //protobuf
+)
+
+// Envelope 标准消息封装
+type Envelope struct {
+	ID      string `json:"id"`               //消息标识
+	Time    int64  `json:"ts"`               //时间戳(毫秒)
+	Source  string `json:"src"`              //来源客户端
+	Schema  string `json:"schema,omitempty"` //数据版本
+	Codec   string `json:"codec"`            //编码方式
+	Zip     bool   `json:"zip,omitempty"`    //已压缩
+	Encrypt bool   `json:"enc,omitempty"`    //已加密
+	Payload []byte `json:"payload"`          //业务数据
+}
+
+// EnvelopeOption 发布时的封装选项
+type EnvelopeOption struct {
+	Schema    string     //数据版本
+	Zip       bool       //压缩业务数据
+	Encrypter *Encrypter //加密业务数据,nil时不加密
+}
+
+type (
+	// MsgpMarshaler tinylib/msgp 生成的编码方法
+	MsgpMarshaler interface {
+		MarshalMsg(b []byte) ([]byte, error)
+	}
+
+	// MsgpUnmarshaler tinylib/msgp 生成的解码方法
+	MsgpUnmarshaler interface {
+		UnmarshalMsg(b []byte) ([]byte, error)
+	}
+
+	// ProtoMarshaler gogo/protobuf 等生成的编码方法
+	ProtoMarshaler interface {
+		Marshal() ([]byte, error)
+	}
+
+	// ProtoUnmarshaler gogo/protobuf 等生成的解码方法
+	ProtoUnmarshaler interface {
+		Unmarshal(data []byte) error
+	}
+)
+
+var (
+	// codecs 已注册的编码
+	codecs = map[string]Codec{
+		CodecJSON:    jsonCodec{},
+		CodecMsgpack: msgpackCodec{},
+		CodecProto:   protoCodec{},
+	}
+
+	// codecsLock 同步锁定
+	codecsLock sync.RWMutex
+)
+
+// RegisterCodec 2026-10-19 19:46:12
+/*
+ 参数: codec,编码
+ 描述: 注册编码,同名时替换
+*/
+func RegisterCodec(codec Codec) {
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[codec.Name()] = codec
+}
+
+// GetCodec 2026-10-19 19:47:35
+/*
+ 参数: name,编码名称
+ 描述: 获取名称为name的编码
+*/
+func GetCodec(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+
+	if codec, ok := codecs[name]; ok {
+		return codec, nil
+	}
+	return nil, fmt.Errorf("znlib.mqtt.codec: %s not registered", name)
+}
+
+// jsonCodec json 编码
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string {
+	return CodecJSON
+}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+// msgpackCodec msgpack 编码
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string {
+	return CodecMsgpack
+}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(MsgpMarshaler); ok {
+		return m.MarshalMsg(nil)
+	}
+	return nil, fmt.Errorf("znlib.mqtt.codec: %T not support msgpack", v)
+}
+
+func (msgpackCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(MsgpUnmarshaler); ok {
+		_, err := m.UnmarshalMsg(data)
+		return err
+	}
+	return fmt.Errorf("znlib.mqtt.codec: %T not support msgpack", v)
+}
+
+// protoCodec protobuf 编码
+type protoCodec struct{}
+
+func (protoCodec) Name() string {
+	return CodecProto
+}
+
+func (protoCodec) Marshal(v any) ([]byte, error) {
+	if m, ok := v.(ProtoMarshaler); ok {
+		return m.Marshal()
+	}
+	return nil, fmt.Errorf("znlib.mqtt.codec: %T not support proto", v)
+}
+
+func (protoCodec) Unmarshal(data []byte, v any) error {
+	if m, ok := v.(ProtoUnmarshaler); ok {
+		return m.Unmarshal(data)
+	}
+	return fmt.Errorf("znlib.mqtt.codec: %T not support proto", v)
+}
+
+// Pack 2026-10-19 19:52:08
+/*
+ 参数: codec,编码名称
+ 参数: v,业务数据
+ 描述: 使用codec编码v,并按 mc.Envelope 压缩、加密、封装
+*/
+func (mc *Utils) Pack(codec string, v any) ([]byte, error) {
+	cc, err := GetCodec(codec)
+	if err != nil {
+		return nil, err
+	}
+
+	env := Envelope{
+		ID:     strconv.FormatUint(SerialID.NextID(), 10),
+		Time:   time.Now().UnixMilli(),
+		Schema: mc.Envelope.Schema,
+		Codec:  codec,
+	}
+
+	if mc.Options != nil {
+		env.Source = mc.Options.ClientID
+	}
+
+	if env.Payload, err = cc.Marshal(v); err != nil {
+		return nil, err
+	}
+
+	if mc.Envelope.Zip {
+		if env.Payload, err = NewZipper().ZipData(env.Payload); err != nil {
+			return nil, err
+		}
+		env.Zip = true
+	}
+
+	if mc.Envelope.Encrypter != nil {
+		if env.Payload, err = mc.Envelope.Encrypter.Encrypt(env.Payload, false); err != nil {
+			return nil, err
+		}
+		env.Encrypt = true
+	}
+
+	return json.Marshal(&env)
+}
+
+// Unpack 2026-10-19 19:58:44
+/*
+ 参数: data,消息体
+ 参数: v,业务数据
+ 描述: 解析封装,按需解密、解压后解码到v
+*/
+func (mc *Utils) Unpack(data []byte, v any) (*Envelope, error) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, err
+	}
+
+	cc, err := GetCodec(env.Codec)
+	if err != nil {
+		return nil, err
+	}
+
+	if env.Encrypt {
+		if mc.Envelope.Encrypter == nil {
+			return nil, fmt.Errorf("znlib.mqtt.Unpack: message %s is encrypted", env.ID)
+		}
+
+		if env.Payload, err = mc.Envelope.Encrypter.Decrypt(env.Payload, false); err != nil {
+			return nil, err
+		}
+	}
+
+	if env.Zip {
+		if env.Payload, err = NewZipper().UnzipData(env.Payload); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = cc.Unmarshal(env.Payload, v); err != nil {
+		return nil, err
+	}
+	return &env, nil
+}
+
+// PublishCodec 2026-10-19 20:03:17
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: codec,编码名称
+ 参数: v,业务数据
+ 描述: 编码并封装v后发布
+*/
+func (mc *Utils) PublishCodec(topic string, qos Qos, codec string, v any) error {
+	data, err := mc.Pack(codec, v)
+	if err != nil {
+		return err
+	}
+	return mc.Publish(topic, qos, data)
+}
+
+// PublishJSON 2026-10-19 20:04:40
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: v,业务数据
+ 描述: 使用json编码发布v
+*/
+func (mc *Utils) PublishJSON(topic string, qos Qos, v any) error {
+	return mc.PublishCodec(topic, qos, CodecJSON, v)
+}
+
+// PublishMsgpack 2026-10-19 20:05:22
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: v,业务数据
+ 描述: 使用msgpack编码发布v
+ 备注: v 须为 tinylib/msgp 等生成的类型,普通结构体请使用 PublishJSON
+*/
+func (mc *Utils) PublishMsgpack(topic string, qos Qos, v MsgpMarshaler) error {
+	return mc.PublishCodec(topic, qos, CodecMsgpack, v)
+}
+
+// PublishProto 2026-10-19 20:06:03
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: v,业务数据
+ 描述: 使用protobuf编码发布v
+ 备注: v 须为 gogo/protobuf 等生成的类型,普通结构体请使用 PublishJSON
+*/
+func (mc *Utils) PublishProto(topic string, qos Qos, v ProtoMarshaler) error {
+	return mc.PublishCodec(topic, qos, CodecProto, v)
+}
+
+// SubscribeTyped 2026-10-19 20:08:37
+/*
+ 参数: mc,客户端
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: fn,处理函数
+ 描述: 订阅topic,将消息解码为T后调用fn
+ 备注: 消息使用 msgpack,proto 编码时,*T 须实现对应的解码方法
+*/
+func SubscribeTyped[T any](mc *Utils, topic string, qos Qos, fn func(env *Envelope, data *T)) error {
+	if fn == nil {
+		return fmt.Errorf("znlib.mqtt.SubscribeTyped: handler is nil")
+	}
+
+	return mc.Subscribe(topic, qos, func(cli mt.Client, msg mt.Message) {
+		data := new(T)
+		env, err := mc.Unpack(msg.Payload(), data)
+		if err != nil {
+			mc.hintMsg(fmt.Sprintf("%s: %v", msg.Topic(), err), "znlib.mqtt.SubscribeTyped")
+			return
+		}
+
+		fn(env, data)
+	})
+}
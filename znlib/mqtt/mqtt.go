@@ -9,19 +9,25 @@
   Client.Start(func(cli mt.Client, msg mt.Message) {
     Info(string(msg.Topic()) + string(msg.Payload()))
   })
+  //1.1 按主题处理
+  Client.Subscribe("dev/+/cmd", Qos1, func(cli mt.Client, msg mt.Message) {...})
   //2.发布
   Client.Publish("", 0, []byte("hello")
+  //2.1 发布限流: 每个主题每秒最多10条
+  Client.Limiter = NewTokenBucket(RateLimit{Rate: 10, Period: time.Second})
+  //2.2 发布结构体: 使用标准封装
+  Client.PublishJSON("dev/01/status", Qos1, &status)
   //3.停止
   Client.Stop()
+*.多个连接
+  配置 mqtt.conns 中的命名连接,使用 Get("cloud").Start(nil) 单独启停
 ******************************************************************************/
 package mqtt
 
 import (
-	"crypto/tls"
-	"crypto/x509"
 	"fmt"
-	"os"
 	"reflect"
+	"sync"
 	"time"
 
 	. "github.com/dmznlin/znlib-go/znlib"
@@ -53,29 +59,38 @@ const (
 
 // Utils 辅助类
 type Utils struct {
-	Client    mt.Client
+	Name      string            //连接名称
+	Client    mt.Client         //链路
 	Options   *mt.ClientOptions //选项
-	SubTopics map[string]Qos    //订阅主题
+	SubTopics map[string]Qos    //订阅主题,由 subLock 保护
 	PubTopics map[string]Qos    //发布主题
+	Router    *Router           //消息路由
 
 	HintInfo     bool           //打印提示信息
 	KeyEncrypted bool           //密码已加密
+	Limiter      RateLimiter    //发布限流(按主题)
+	Envelope     EnvelopeOption //消息封装选项
+	cfg          *MqttConfig    //连接配置
+	queue        *offlineQueue  //离线消息队列
+	rpc          rpcClient      //请求/应答
 	events       []EventHandler //事件处理列表
 	waitePub     *Waiter[bool]  //等待注册完成
+	subLock      sync.RWMutex   //订阅主题锁
 }
 
-// Client 客户端
-var Client = &Utils{
-	Client:    nil,
-	Options:   mt.NewClientOptions(),
-	SubTopics: make(map[string]Qos),
-	PubTopics: make(map[string]Qos),
-
-	events:       nil,
-	waitePub:     nil,
-	HintInfo:     true,
-	KeyEncrypted: true,
-}
+// DefaultName 默认连接名称
+const DefaultName = "default"
+
+var (
+	// Client 默认客户端
+	Client = NewUtils(DefaultName)
+
+	// clients 命名连接列表
+	clients = map[string]*Utils{DefaultName: Client}
+
+	// clientsLock 同步锁定
+	clientsLock sync.RWMutex
+)
 
 // initMqtt 2024-01-09 17:03:09
 /*
@@ -83,18 +98,108 @@ var Client = &Utils{
 */
 func init() {
 	Application.RegisterExitHandler(func() {
-		Client.Stop()
-		//退出时停止
+		for _, mc := range All() {
+			mc.Stop()
+			//退出时停止
+		}
 	})
 
 	Application.RegisterInitHandler(func(cfg *LibConfig) {
+		if cfg.Mqtt.Name == "" {
+			cfg.Mqtt.Name = DefaultName
+		}
+
 		err := Client.ApplyConfig(&cfg.Mqtt)
 		if err != nil {
 			ErrorCaller(err, "znlib.mqtt.init")
 		}
+
+		for _, v := range cfg.Mqtt.Conns {
+			if v.Name == "" || v.Name == DefaultName {
+				ErrorCaller("connection name is invalid", "znlib.mqtt.init")
+				continue
+			}
+
+			mc := Get(v.Name)
+			if mc == nil {
+				mc = Register(NewUtils(v.Name))
+			}
+
+			if err = mc.ApplyConfig(v); err != nil {
+				ErrorCaller(err, "znlib.mqtt.init: "+v.Name)
+			}
+		}
 	})
 }
 
+// NewUtils 2026-10-19 17:02:15
+/*
+ 参数: name,连接名称
+ 描述: 创建名称为name的客户端,需调用 Register 加入列表
+*/
+func NewUtils(name string) *Utils {
+	return &Utils{
+		Name:      name,
+		Client:    nil,
+		Options:   mt.NewClientOptions(),
+		SubTopics: make(map[string]Qos),
+		PubTopics: make(map[string]Qos),
+		Router:    NewRouter(),
+
+		cfg:          nil,
+		events:       nil,
+		waitePub:     nil,
+		Limiter:      nil,
+		Envelope:     EnvelopeOption{},
+		queue:        nil,
+		HintInfo:     true,
+		KeyEncrypted: true,
+	}
+}
+
+// Register 2026-10-19 17:05:48
+/*
+ 参数: mc,客户端
+ 描述: 将mc加入命名连接列表,同名时替换
+*/
+func Register(mc *Utils) *Utils {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	clients[mc.Name] = mc
+	return mc
+}
+
+// Get 2026-10-19 17:07:33
+/*
+ 参数: name,连接名称
+ 描述: 获取名称为name的客户端,空名称返回默认客户端
+*/
+func Get(name string) *Utils {
+	if name == "" {
+		name = DefaultName
+	}
+
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+	return clients[name]
+}
+
+// All 2026-10-19 17:09:20
+/*
+ 描述: 获取所有命名连接
+*/
+func All() []*Utils {
+	clientsLock.RLock()
+	defer clientsLock.RUnlock()
+
+	list := make([]*Utils, 0, len(clients))
+	for _, v := range clients {
+		list = append(list, v)
+	}
+	return list
+}
+
 // hintMsg 2026-03-31 18:37:59
 /*
  参数: msg,提示信息
@@ -130,9 +235,11 @@ func (mc *Utils) checkToken(token mt.Token, caller string) error {
 // ApplyConfig 2026-03-09 15:04:52
 /*
  参数: cfg,mqtt配置
- 描述:
+ 描述: 使用cfg配置客户端
 */
 func (mc *Utils) ApplyConfig(cfg *MqttConfig) error {
+	mc.cfg = cfg
+	//发布时按名称匹配主题
 	if !cfg.Enable {
 		return nil
 	}
@@ -142,43 +249,13 @@ func (mc *Utils) ApplyConfig(cfg *MqttConfig) error {
 	}
 
 	if cfg.Tls.Used {
-		cfg.Tls.CA = FixPathVar(cfg.Tls.CA)
-		if !FileExists(cfg.Tls.CA, false) {
-			return fmt.Errorf("mqtt.Tls.ca is miss")
-		}
-
-		cfg.Tls.Key = FixPathVar(cfg.Tls.Key)
-		if !FileExists(cfg.Tls.Key, false) {
-			return fmt.Errorf("mqtt.Tls.key is miss")
-		}
-
-		cfg.Tls.Cert = FixPathVar(cfg.Tls.Cert)
-		if !FileExists(cfg.Tls.Cert, false) {
-			return fmt.Errorf("mqtt.Tls.cert is miss")
-		}
-
-		rootCA, err := os.ReadFile(cfg.Tls.CA)
-		if err != nil {
-			return fmt.Errorf("mqtt.ReadFile: %v", err)
-		}
-
-		cp := x509.NewCertPool()
-		if !cp.AppendCertsFromPEM(rootCA) {
-			return fmt.Errorf("mqtt.Tls.ca load error")
-		}
-
-		cert, err := tls.LoadX509KeyPair(cfg.Tls.Cert, cfg.Tls.Key)
+		loader, err := newTLSLoader(&cfg.Tls, cfg.Broker)
 		if err != nil {
-			return fmt.Errorf("mqtt.LoadX509KeyPair: %v", err)
+			return err
 		}
 
-		mc.Options.SetTLSConfig(&tls.Config{
-			RootCAs:            cp,
-			ClientAuth:         tls.NoClientCert,
-			ClientCAs:          nil,
-			InsecureSkipVerify: true,
-			Certificates:       []tls.Certificate{cert},
-		})
+		mc.Options.SetTLSConfig(loader.config())
+		//证书变动后自动加载
 	}
 
 	if cfg.Password != "" && mc.KeyEncrypted { // broker 密码
@@ -200,11 +277,17 @@ func (mc *Utils) ApplyConfig(cfg *MqttConfig) error {
 		cfg.ClientID = cfg.ClientID + SerialID.MakeID(cfg.IDAuto)
 	}
 
+	if err := mc.EnableQueue(&cfg.Queue); err != nil {
+		return err
+	}
+
 	for _, v := range cfg.TopicSub {
 		if len(v.Topic) > 0 {
 			v.Topic = StrReplace(v.Topic, cfg.ClientID, "$id")
 			//更新数据通道标识
+			mc.subLock.Lock()
 			mc.SubTopics[v.Topic] = v.Qos
+			mc.subLock.Unlock()
 			//订阅主题
 		}
 	}
@@ -286,10 +369,13 @@ func (mc *Utils) Start(msgHandler mt.MessageHandler, waitPub ...time.Duration) e
 	}
 
 	if msgHandler != nil {
-		mc.Options.SetDefaultPublishHandler(msgHandler)
-		//默认消息处理句柄
+		mc.Router.Default = msgHandler
+		//无匹配规则时使用
 	}
 
+	mc.Options.SetDefaultPublishHandler(mc.Router.Dispatch)
+	//订阅时不设回调,每条消息只经路由分发一次
+
 	if len(waitPub) > 0 { //等待订阅
 		if mc.waitePub == nil {
 			mc.waitePub = NewWaiter[bool](nil)
@@ -356,6 +442,8 @@ func (mc *Utils) isConnected() error {
  参数: qos,送达级别
  参数: msg,消息
  描述: 向topic发布msg消息
+
+ 备注: 启用离线队列时,断线期间的消息缓存后补发
 */
 func (mc *Utils) Publish(topic string, qos Qos, msg []byte) (res error) {
 	caller := "znlib.mqtt.publish"
@@ -365,18 +453,24 @@ func (mc *Utils) Publish(topic string, qos Qos, msg []byte) (res error) {
 		}
 	}) //捕捉网络异常
 
-	if err := mc.isConnected(); err != nil {
-		return err
-	}
-
 	var retain = false
 	pub := func() error {
-		token := mc.Client.Publish(topic, qos, retain, msg)
-		if err := mc.checkToken(token, caller); err != nil {
-			return err
+		err := mc.isConnected()
+		if mc.queue != nil && (err != nil || mc.queue.size() > 0) { //缓存,保证顺序
+			if e := mc.queue.push(&QueueMessage{Topic: topic, Qos: qos, Retain: retain, Payload: msg}); e != nil {
+				return e
+			}
+
+			if err == nil {
+				go mc.replayQueue()
+			}
+			return nil
 		}
 
-		return nil
+		if err != nil {
+			return err
+		}
+		return mc.publish(topic, qos, retain, msg, caller)
 	}
 
 	useCfg := qos == QosNone
@@ -392,7 +486,7 @@ func (mc *Utils) Publish(topic string, qos Qos, msg []byte) (res error) {
 		//明确主题
 	}
 
-	for _, tp := range GlobalConfig.Mqtt.TopicPub {
+	for _, tp := range mc.pubTopics() {
 		if tp.Name == topic { //名称匹配
 			if useCfg {
 				qos = tp.Qos
@@ -410,14 +504,45 @@ func (mc *Utils) Publish(topic string, qos Qos, msg []byte) (res error) {
 	return pub() //定义主题
 }
 
+// pubTopics 2026-10-19 17:15:42
+/*
+ 描述: 当前连接配置的发布主题
+*/
+func (mc *Utils) pubTopics() []*MqttTopic {
+	if mc.cfg == nil {
+		return GlobalConfig.Mqtt.TopicPub
+	}
+	return mc.cfg.TopicPub
+}
+
+// publish 2026-10-19 14:02:36
+/*
+ 参数: topic,主题
+ 参数: qos,送达级别
+ 参数: retain,保留
+ 参数: msg,消息
+ 描述: 限流后发送消息,并等待应答
+*/
+func (mc *Utils) publish(topic string, qos Qos, retain bool, msg []byte, caller string) error {
+	if mc.Limiter != nil { //按主题限流
+		if err := mc.Limiter.Wait(Application.Ctx, topic); err != nil {
+			return err
+		}
+	}
+
+	token := mc.Client.Publish(topic, qos, retain, msg)
+	return mc.checkToken(token, caller)
+}
+
 // Subscribe 2024-01-14 14:52:25
 /*
  参数: topic,主题
  参数: qos,模式
+ 参数: handler,主题处理函数,nil时使用默认处理函数
  参数: ctl,控制参数(1.true:重置订阅;2.true:立即订阅)
  描述: 新增订阅topic主题
 */
-func (mc *Utils) Subscribe(topic string, qos Qos, ctl ...bool) (res error) {
+func (mc *Utils) Subscribe(topic string, qos Qos, handler mt.MessageHandler, ctl ...bool) (res error) {
 	caller := "znlib.mqtt.subscribe"
 	defer DeferHandle(false, caller, func(err error) {
 		if err != nil {
@@ -441,16 +566,20 @@ func (mc *Utils) Subscribe(topic string, qos Qos, ctl ...bool) (res error) {
 		via = ctl[1]
 	}
 
+	mc.subLock.Lock()
 	if reset {
 		mc.SubTopics = make(map[string]Qos)
 		//重置为空
 	}
 
 	mc.SubTopics[topic] = qos
+	mc.subLock.Unlock()
 	//添加新主题
+	mc.Router.Handle(topic, handler)
+	//重连后依然有效
 
 	if via {
-		token := mc.Client.Subscribe(topic, qos, nil) //开始订阅
+		token := mc.Client.Subscribe(topic, qos, nil) //开始订阅,由默认处理函数分发
 		if err := mc.checkToken(token, caller); err != nil {
 			return err
 		}
@@ -466,7 +595,8 @@ func (mc *Utils) Subscribe(topic string, qos Qos, ctl ...bool) (res error) {
  描述: 订阅主题列表
 */
 func (mc *Utils) SubscribeMultiple() (res error) {
-	if len(mc.SubTopics) < 1 {
+	topics := mc.subTopics()
+	if len(topics) < 1 {
 		return nil
 	}
 
@@ -481,19 +611,36 @@ func (mc *Utils) SubscribeMultiple() (res error) {
 		return err
 	}
 
-	token := mc.Client.SubscribeMultiple(mc.SubTopics, nil)
+	token := mc.Client.SubscribeMultiple(topics, nil)
 	if err := mc.checkToken(token, caller); err != nil {
 		return err
 	}
 
-	mc.hintMsg(fmt.Sprintf(caller+": %v", mc.SubTopics))
+	mc.hintMsg(fmt.Sprintf(caller+": %v", topics))
 	return nil
 }
 
+// subTopics 2026-10-19 21:20:16
+/*
+ 描述: 订阅主题列表的副本
+*/
+func (mc *Utils) subTopics() map[string]Qos {
+	mc.subLock.RLock()
+	defer mc.subLock.RUnlock()
+
+	topics := make(map[string]Qos, len(mc.SubTopics))
+	for tp, qos := range mc.SubTopics {
+		topics[tp] = qos
+	}
+	return topics
+}
+
 // Unsubscribe 2026-03-03 11:30:37
 /*
  参数: topic,待退订主题
  描述: 退订主题,默认退订所有
+
+ 备注: 指定主题时,同时从订阅列表和路由中删除
 */
 func (mc *Utils) Unsubscribe(topics ...string) (res error) {
 	caller := "znlib.mqtt.unsubscribe"
@@ -507,17 +654,15 @@ func (mc *Utils) Unsubscribe(topics ...string) (res error) {
 		return err
 	}
 
-	if len(topics) < 1 {
-		idx := len(mc.SubTopics)
-		if idx < 1 {
-			return nil
+	explicit := len(topics) > 0
+	//指定主题
+	if !explicit {
+		for tp := range mc.subTopics() { //退订所有主题
+			topics = append(topics, tp)
 		}
 
-		topics = make([]string, idx)
-		idx = 0
-		for tp := range mc.SubTopics { //退订所有主题
-			topics[idx] = tp
-			idx++
+		if len(topics) < 1 {
+			return nil
 		}
 	}
 
@@ -526,6 +671,15 @@ func (mc *Utils) Unsubscribe(topics ...string) (res error) {
 		return err
 	}
 
+	if explicit {
+		mc.subLock.Lock()
+		for _, tp := range topics {
+			delete(mc.SubTopics, tp)
+		}
+		mc.subLock.Unlock()
+		mc.Router.Remove(topics...)
+	}
+
 	mc.hintMsg(fmt.Sprintf(caller+": %v", topics))
 	return nil
 }
@@ -0,0 +1,383 @@
+// Package mqtt
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 13:05:22
+  描述: 断线时缓存待发布消息,连接恢复后按顺序补发
+
+备注:
+*.使用方法
+  //1.配置文件: mqtt.queue.enable = true
+  //2.代码启用
+  Client.EnableQueue(&MqttQueue{Enable: true, MaxSize: 1000, Policy: QueueDropOldest})
+  //3.统计
+  queued, dropped := Client.QueueStats()
+*.file 不为空时,消息同时写入文件,程序重启后继续补发
+*.EventConnected(含自动重连成功)时补发; EventReConnect 在链路恢复前触发,不补发
+*.连接正常但单条消息连续补发失败 retry 次后丢弃,计入丢弃数
+******************************************************************************/
+package mqtt
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+)
+
+const (
+	QueueDropOldest = "oldest" //队列满时丢弃最早的消息
+	QueueDropNewest = "newest" //队列满时丢弃新消息
+	QueueRetry      = 3        //默认补发失败次数
+)
+
+// QueueMessage 待发布消息
+type QueueMessage struct {
+	Topic   string `json:"topic"`   //主题
+	Qos     Qos    `json:"qos"`     //送达级别
+	Retain  bool   `json:"retain"`  //保留
+	Payload []byte `json:"payload"` //消息
+}
+
+// QueueStore 消息持久化接口
+type QueueStore interface {
+	Load() ([]*QueueMessage, error)  //载入已保存的消息
+	Append(msg *QueueMessage) error  //追加一条消息
+	Save(msgs []*QueueMessage) error //覆盖保存全部消息
+}
+
+// offlineQueue 离线消息队列
+type offlineQueue struct {
+	lock      sync.Mutex      //同步锁定
+	msgs      []*QueueMessage //消息列表
+	maxSize   int             //最大消息数
+	dropOld   bool            //满时丢弃最早消息
+	retry     int             //补发失败次数
+	store     QueueStore      //持久化
+	dropped   uint64          //丢弃计数
+	replaying int32           //补发中标记
+}
+
+// EnableQueue 2026-10-19 13:12:40
+/*
+ 参数: cfg,队列配置
+ 描述: 启用离线消息队列
+*/
+func (mc *Utils) EnableQueue(cfg *MqttQueue) error {
+	if !cfg.Enable {
+		mc.queue = nil
+		return nil
+	}
+
+	if cfg.Policy == "" {
+		cfg.Policy = QueueDropOldest
+	}
+
+	if cfg.Policy != QueueDropOldest && cfg.Policy != QueueDropNewest {
+		return fmt.Errorf("mqtt.queue.policy is invalid: %s", cfg.Policy)
+	}
+
+	queue := &offlineQueue{
+		msgs:    make([]*QueueMessage, 0),
+		maxSize: cfg.MaxSize,
+		dropOld: cfg.Policy == QueueDropOldest,
+		retry:   cfg.Retry,
+	}
+
+	if queue.retry < 1 {
+		queue.retry = QueueRetry
+	}
+
+	if cfg.File != "" {
+		queue.store = &fileStore{file: FixPathVar(cfg.File)}
+		msgs, err := queue.store.Load()
+		if err != nil {
+			return err
+		}
+
+		queue.msgs = append(queue.msgs, msgs...)
+		//上次未发送的消息
+	}
+
+	mc.queue = queue
+	mc.RegisterEventHandler(queueEventHandler)
+	return nil
+}
+
+// SetQueueStore 2026-10-19 13:18:07
+/*
+ 参数: store,持久化对象
+ 描述: 使用自定义的store保存离线消息
+*/
+func (mc *Utils) SetQueueStore(store QueueStore) error {
+	if mc.queue == nil {
+		return fmt.Errorf("mqtt.queue is not enabled")
+	}
+
+	msgs, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	mc.queue.lock.Lock()
+	defer mc.queue.lock.Unlock()
+
+	mc.queue.store = store
+	mc.queue.msgs = append(msgs, mc.queue.msgs...)
+	return store.Save(mc.queue.msgs)
+}
+
+// QueueStats 2026-10-19 13:21:33
+/*
+ 描述: 返回当前缓存和累计丢弃的消息数
+*/
+func (mc *Utils) QueueStats() (queued, dropped int) {
+	if mc.queue == nil {
+		return 0, 0
+	}
+
+	mc.queue.lock.Lock()
+	defer mc.queue.lock.Unlock()
+	return len(mc.queue.msgs), int(atomic.LoadUint64(&mc.queue.dropped))
+}
+
+// queueEventHandler 2026-10-19 13:24:50
+/*
+ 参数: mc,mqtt对象
+ 参数: event,事件
+ 描述: 连接成功后补发缓存的消息
+*/
+func queueEventHandler(mc *Utils, event Event) {
+	if mc.queue != nil && event == EventConnected {
+		go mc.replayQueue()
+	}
+}
+
+// push 2026-10-19 13:28:16
+/*
+ 参数: msg,消息
+ 描述: 缓存msg,队列满时按策略丢弃
+*/
+func (q *offlineQueue) push(msg *QueueMessage) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.maxSize > 0 && len(q.msgs) >= q.maxSize {
+		atomic.AddUint64(&q.dropped, 1)
+		if !q.dropOld { //丢弃新消息
+			return nil
+		}
+
+		q.msgs = append(q.msgs[:0], q.msgs[1:]...)
+		q.msgs = append(q.msgs, msg)
+		if q.store != nil {
+			return q.store.Save(q.msgs)
+		}
+		return nil
+	}
+
+	q.msgs = append(q.msgs, msg)
+	if q.store != nil {
+		return q.store.Append(msg)
+	}
+	return nil
+}
+
+// size 2026-10-19 13:31:02
+/*
+ 描述: 缓存的消息数
+*/
+func (q *offlineQueue) size() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.msgs)
+}
+
+// replayQueue 2026-10-19 13:33:45
+/*
+ 描述: 按顺序补发缓存的消息,断线时保留剩余消息
+*/
+func (mc *Utils) replayQueue() {
+	queue := mc.queue
+	if queue == nil {
+		return
+	}
+
+	for atomic.CompareAndSwapInt32(&queue.replaying, 0, 1) {
+		lost := mc.replayOnce(queue)
+		atomic.StoreInt32(&queue.replaying, 0)
+
+		//补发中断线: 若已重连,期间的连接事件因补发中被忽略,需再次补发
+		if !lost || queue.size() < 1 || mc.isConnected() != nil {
+			return
+		}
+	}
+}
+
+// replayOnce 2026-10-19 13:36:12
+/*
+ 参数: queue,消息队列
+ 描述: 补发queue中的消息,返回是否因断线中止
+*/
+func (mc *Utils) replayOnce(queue *offlineQueue) (lost bool) {
+	caller := "znlib.mqtt.replayQueue"
+	defer DeferHandle(false, caller)
+
+	sent, dropped, failed := 0, 0, 0
+	for {
+		queue.lock.Lock()
+		if len(queue.msgs) < 1 {
+			queue.lock.Unlock()
+			break
+		}
+
+		msg := queue.msgs[0]
+		queue.lock.Unlock()
+
+		if mc.isConnected() != nil {
+			lost = true
+			break
+		}
+
+		err := mc.publish(msg.Topic, msg.Qos, msg.Retain, msg.Payload, caller)
+		if err != nil {
+			if mc.isConnected() != nil { //发送中断线
+				lost = true
+				break
+			}
+
+			failed++
+			if failed < queue.retry {
+				continue
+			}
+
+			ErrorCaller(fmt.Sprintf("drop message [%s] after %d retries: %v", msg.Topic, failed, err), caller)
+			atomic.AddUint64(&queue.dropped, 1)
+		}
+
+		queue.lock.Lock()
+		if len(queue.msgs) > 0 && queue.msgs[0] == msg { //未被丢弃
+			queue.msgs = queue.msgs[1:]
+		}
+		queue.lock.Unlock()
+
+		if err == nil {
+			sent++
+		} else {
+			dropped++
+		}
+		failed = 0
+	}
+
+	if sent > 0 || dropped > 0 {
+		queue.lock.Lock()
+		if queue.store != nil {
+			if err := queue.store.Save(queue.msgs); err != nil {
+				ErrorCaller(err, caller)
+			}
+		}
+		queue.lock.Unlock()
+
+		mc.hintMsg(fmt.Sprintf("%s: %d sent, %d dropped", caller, sent, dropped))
+	}
+
+	return
+}
+
+//--------------------------------------------------------------------------------
+
+// fileStore 文件存储,每行一条json消息
+type fileStore struct {
+	file string
+}
+
+// Load 2026-10-19 13:42:18
+/*
+ 描述: 从文件载入消息
+*/
+func (fs *fileStore) Load() ([]*QueueMessage, error) {
+	msgs := make([]*QueueMessage, 0)
+	fh, err := os.Open(fs.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return msgs, nil
+		}
+		return nil, fmt.Errorf("mqtt.queue.Load: %v", err)
+	}
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) < 1 {
+			continue
+		}
+
+		msg := &QueueMessage{}
+		if err = json.Unmarshal(scanner.Bytes(), msg); err != nil {
+			ErrorCaller(err, "znlib.mqtt.queue.Load")
+			continue //跳过损坏的记录
+		}
+		msgs = append(msgs, msg)
+	}
+
+	return msgs, scanner.Err()
+}
+
+// Append 2026-10-19 13:46:57
+/*
+ 参数: msg,消息
+ 描述: 追加msg到文件末尾
+*/
+func (fs *fileStore) Append(msg *QueueMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(fs.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("mqtt.queue.Append: %v", err)
+	}
+	defer fh.Close()
+
+	_, err = fh.Write(append(data, '\n'))
+	return err
+}
+
+// Save 2026-10-19 13:50:31
+/*
+ 参数: msgs,消息列表
+ 描述: 使用msgs覆盖文件
+*/
+func (fs *fileStore) Save(msgs []*QueueMessage) error {
+	tmp := fs.file + ".tmp"
+	fh, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("mqtt.queue.Save: %v", err)
+	}
+
+	wr := bufio.NewWriter(fh)
+	for _, msg := range msgs {
+		data, err := json.Marshal(msg)
+		if err != nil {
+			_ = fh.Close()
+			return err
+		}
+
+		_, _ = wr.Write(data)
+		_ = wr.WriteByte('\n')
+	}
+
+	if err = wr.Flush(); err != nil {
+		_ = fh.Close()
+		return err
+	}
+
+	if err = fh.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fs.file)
+}
@@ -0,0 +1,178 @@
+// Package mqtt
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 14:35:10
+  描述: 按主题(支持 +/# 通配符)分发消息
+
+备注:
+*.使用方法
+  Client.Subscribe("dev/+/status", Qos1, func(cli mt.Client, msg mt.Message) {
+    Info(msg.Topic())
+  })
+  Client.Router.Handle("dev/#", handler) //只分发,不订阅
+*.多个规则匹配同一主题时,依次调用;无匹配时调用 Router.Default
+*.单个处理函数异常不影响其它函数
+******************************************************************************/
+package mqtt
+
+import (
+	"strings"
+	"sync"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	mt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// route 分发规则
+type route struct {
+	pattern string            //主题过滤器
+	filter  []string          //过滤器分级
+	handler mt.MessageHandler //处理函数
+}
+
+// Router 消息路由
+type Router struct {
+	lock    sync.RWMutex      //同步锁定
+	routes  []*route          //规则列表
+	Default mt.MessageHandler //默认处理函数
+}
+
+// NewRouter 2026-10-19 14:40:26
+/*
+ 描述: 创建消息路由
+*/
+func NewRouter() *Router {
+	return &Router{
+		routes:  make([]*route, 0),
+		Default: nil,
+	}
+}
+
+// Handle 2026-10-19 14:42:51
+/*
+ 参数: pattern,主题过滤器
+ 参数: handler,处理函数
+ 描述: 设置pattern的处理函数,已存在时替换
+*/
+func (r *Router) Handle(pattern string, handler mt.MessageHandler) {
+	if handler == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, v := range r.routes {
+		if v.pattern == pattern {
+			v.handler = handler
+			return
+		}
+	}
+
+	r.routes = append(r.routes, &route{
+		pattern: pattern,
+		filter:  strings.Split(shareTopic(pattern), "/"),
+		handler: handler,
+	})
+}
+
+// Remove 2026-10-19 14:46:09
+/*
+ 参数: patterns,主题过滤器
+ 描述: 删除patterns的处理函数
+*/
+func (r *Router) Remove(patterns ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	idx := 0
+	for _, v := range r.routes {
+		if !StrIn(v.pattern, patterns...) {
+			r.routes[idx] = v
+			idx++
+		}
+	}
+	r.routes = r.routes[:idx]
+}
+
+// Dispatch 2026-10-19 14:49:37
+/*
+ 参数: cli,链路
+ 参数: msg,消息
+ 描述: 将msg分发给匹配的处理函数
+*/
+func (r *Router) Dispatch(cli mt.Client, msg mt.Message) {
+	r.lock.RLock()
+	handlers := make([]mt.MessageHandler, 0, 1)
+	levels := strings.Split(msg.Topic(), "/")
+
+	for _, v := range r.routes {
+		if matchLevels(v.filter, levels) {
+			handlers = append(handlers, v.handler)
+		}
+	}
+
+	if len(handlers) < 1 && r.Default != nil {
+		handlers = append(handlers, r.Default)
+	}
+	r.lock.RUnlock()
+
+	for _, fn := range handlers {
+		func() {
+			defer DeferHandle(false, "znlib.mqtt.router: "+msg.Topic())
+			fn(cli, msg)
+		}()
+	}
+}
+
+// MatchTopic 2026-10-19 14:55:02
+/*
+ 参数: pattern,主题过滤器
+ 参数: topic,主题
+ 描述: 判断topic是否匹配pattern
+*/
+func MatchTopic(pattern, topic string) bool {
+	return matchLevels(strings.Split(shareTopic(pattern), "/"), strings.Split(topic, "/"))
+}
+
+// shareTopic 2026-10-19 14:57:18
+/*
+ 参数: pattern,主题过滤器
+ 描述: 去掉共享订阅的前缀: $share/group/
+*/
+func shareTopic(pattern string) string {
+	if strings.HasPrefix(pattern, "$share/") {
+		if idx := strings.Index(pattern[7:], "/"); idx >= 0 {
+			return pattern[7+idx+1:]
+		}
+	}
+	return pattern
+}
+
+// matchLevels 2026-10-19 15:00:43
+/*
+ 参数: filter,过滤器分级
+ 参数: levels,主题分级
+ 描述: 逐级匹配主题
+*/
+func matchLevels(filter, levels []string) bool {
+	if len(levels) > 0 && strings.HasPrefix(levels[0], "$") &&
+		len(filter) > 0 && (filter[0] == "+" || filter[0] == "#") {
+		return false //通配符不匹配系统主题
+	}
+
+	for i, f := range filter {
+		if f == "#" {
+			return true //匹配当前及所有子级
+		}
+
+		if i >= len(levels) {
+			return false
+		}
+
+		if f != "+" && f != levels[i] {
+			return false
+		}
+	}
+
+	return len(filter) == len(levels)
+}
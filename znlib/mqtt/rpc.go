@@ -0,0 +1,251 @@
+// Package mqtt
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 15:20:48
+  描述: 基于主题的请求/应答(rpc)
+
+备注:
+*.客户端
+  ctx, cancel := context.WithTimeout(Application.Ctx, 3*time.Second)
+  defer cancel()
+  reply, err := Client.Request(ctx, "dev/01/cmd", []byte("status"))
+*.服务端
+  Client.HandleRequests("dev/01/cmd", func(req []byte) ([]byte, error) {
+    return []byte("ok"), nil
+  })
+*.请求和应答均使用 RpcEnvelope 封装:
+  1.id: 关联标识(随机生成,防止伪造应答),应答原样返回
+  2.replyTo: 应答主题,默认为 rpc/reply/<client id>
+*.paho.mqtt.golang 只支持 v3.1.1,没有 v5 的 response-topic/correlation-data
+  属性,所以关联信息统一放在消息体中
+******************************************************************************/
+package mqtt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	mt "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	// RpcReplyPrefix 应答主题前缀
+	RpcReplyPrefix = "rpc/reply"
+
+	// RpcTimeout ctx未设置截止时间时的默认超时
+	RpcTimeout = 10 * time.Second
+
+	// ErrRpcTimeout 等待应答超时
+	ErrRpcTimeout = errors.New("znlib.mqtt.rpc: wait reply timeout")
+)
+
+// RpcEnvelope 请求/应答封装
+type RpcEnvelope struct {
+	ID      string `json:"id"`                //关联标识
+	ReplyTo string `json:"replyTo,omitempty"` //应答主题
+	Payload []byte `json:"payload"`           //业务数据
+	Error   string `json:"error,omitempty"`   //服务端错误
+}
+
+// RpcHandler 服务端请求处理函数
+type RpcHandler = func(req []byte) ([]byte, error)
+
+// rpcClient 请求状态
+type rpcClient struct {
+	lock    sync.Mutex                      //同步锁定
+	reply   string                          //应答主题
+	pending map[string]*Waiter[RpcEnvelope] //等待应答列表
+}
+
+// replyTopic 2026-10-19 15:28:35
+/*
+ 描述: 订阅应答主题,返回主题名称
+*/
+func (mc *Utils) replyTopic() (string, error) {
+	mc.rpc.lock.Lock()
+	defer mc.rpc.lock.Unlock()
+
+	if mc.rpc.reply != "" {
+		return mc.rpc.reply, nil
+	}
+
+	topic := fmt.Sprintf("%s/%s", RpcReplyPrefix, mc.Options.ClientID)
+	if err := mc.Subscribe(topic, Qos1, mc.onReply); err != nil {
+		return "", err
+	}
+
+	mc.rpc.reply = topic
+	return topic, nil
+}
+
+// onReply 2026-10-19 15:32:07
+/*
+ 参数: cli,链路
+ 参数: msg,应答消息
+ 描述: 唤醒等待msg的请求
+*/
+func (mc *Utils) onReply(cli mt.Client, msg mt.Message) {
+	var env RpcEnvelope
+	if err := json.Unmarshal(msg.Payload(), &env); err != nil {
+		mc.hintMsg(err, "znlib.mqtt.onReply")
+		return
+	}
+
+	mc.rpc.lock.Lock()
+	waiter, ok := mc.rpc.pending[env.ID]
+	mc.rpc.lock.Unlock()
+
+	if ok {
+		waiter.Wakeup(&env, true)
+	}
+}
+
+// newRequestID 2026-10-19 21:26:40
+/*
+ 描述: 生成随机的关联标识,其它客户端无法猜测
+*/
+func newRequestID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Request 2026-10-19 15:36:52
+/*
+ 参数: ctx,上下文
+ 参数: topic,请求主题或名称
+ 参数: payload,请求数据
+ 描述: 发送请求并等待应答
+*/
+func (mc *Utils) Request(ctx context.Context, topic string, payload []byte) ([]byte, error) {
+	reply, err := mc.replyTopic()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newRequestID()
+	if err != nil {
+		return nil, err
+	}
+
+	env := RpcEnvelope{
+		ID:      id,
+		ReplyTo: reply,
+		Payload: payload,
+	}
+
+	data, err := json.Marshal(&env)
+	if err != nil {
+		return nil, err
+	}
+
+	waiter := NewWaiter[RpcEnvelope](nil)
+	mc.rpc.lock.Lock()
+	if mc.rpc.pending == nil {
+		mc.rpc.pending = make(map[string]*Waiter[RpcEnvelope])
+	}
+	mc.rpc.pending[env.ID] = waiter
+	mc.rpc.lock.Unlock()
+
+	defer func() {
+		mc.rpc.lock.Lock()
+		delete(mc.rpc.pending, env.ID)
+		mc.rpc.lock.Unlock()
+	}()
+
+	if err = mc.Publish(topic, Qos1, data); err != nil {
+		return nil, err
+	}
+
+	timeout := RpcTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+		if timeout <= 0 {
+			return nil, ErrRpcTimeout
+		}
+	}
+
+	var (
+		res  *RpcEnvelope
+		done = make(chan bool, 1)
+	)
+
+	go func() {
+		var ok bool
+		res, ok = waiter.WaitFor(timeout)
+		done <- ok
+	}()
+
+	var ok bool
+	select {
+	case <-ctx.Done():
+		waiter.Wakeup(nil, true)
+		<-done //释放等待
+		return nil, ctx.Err()
+	case ok = <-done:
+	}
+
+	if !ok || res == nil {
+		return nil, ErrRpcTimeout
+	}
+
+	if res.Error != "" {
+		return res.Payload, errors.New(res.Error)
+	}
+	return res.Payload, nil
+}
+
+// HandleRequests 2026-10-19 15:45:19
+/*
+ 参数: topic,请求主题
+ 参数: fn,处理函数
+ 描述: 订阅topic,使用fn处理请求并发送应答
+*/
+func (mc *Utils) HandleRequests(topic string, fn RpcHandler) error {
+	if fn == nil {
+		return fmt.Errorf("znlib.mqtt.HandleRequests: handler is nil")
+	}
+
+	return mc.Subscribe(topic, Qos1, func(cli mt.Client, msg mt.Message) {
+		caller := "znlib.mqtt.HandleRequests"
+		var req RpcEnvelope
+		if err := json.Unmarshal(msg.Payload(), &req); err != nil || req.ReplyTo == "" {
+			mc.hintMsg(fmt.Sprintf("invalid request on %s", msg.Topic()), caller)
+			return
+		}
+
+		go func() {
+			res := RpcEnvelope{ID: req.ID}
+			func() {
+				defer DeferHandle(false, caller, func(err error) {
+					if err != nil {
+						res.Error = err.Error()
+					}
+				})
+
+				var err error
+				res.Payload, err = fn(req.Payload)
+				if err != nil {
+					res.Error = err.Error()
+				}
+			}()
+
+			data, err := json.Marshal(&res)
+			if err == nil {
+				err = mc.Publish(req.ReplyTo, Qos1, data)
+			}
+
+			if err != nil {
+				mc.hintMsg(err, caller)
+			}
+		}()
+	})
+}
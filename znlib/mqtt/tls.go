@@ -0,0 +1,270 @@
+// Package mqtt
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 16:05:33
+  描述: mqtt tls 校验与证书热加载
+
+备注:
+*.ca: 校验服务器证书,为空时使用系统证书
+*.key,cert: 客户端证书,同时为空时只校验服务器(单向认证)
+*.serverName: 校验服务器名称,为空时使用 broker 主机名(SNI);以 ip 连接时没有 SNI,
+  证书须匹配配置的 broker 地址之一;无名称可校验时拒绝连接
+*.fingerprints: 服务器证书 sha256 指纹,不为空时必须匹配其一
+*.证书文件变化后,下次握手(重连)时自动重新加载
+******************************************************************************/
+package mqtt
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+)
+
+// tlsVersions tls 版本
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsLoader 证书加载
+type tlsLoader struct {
+	lock     sync.Mutex           //同步锁定
+	cfg      MqttTLS              //tls 配置
+	pins     []string             //证书指纹
+	hosts    []string             //broker 主机
+	roots    *x509.CertPool       //服务器 ca
+	cert     *tls.Certificate     //客户端证书
+	modified map[string]time.Time //文件修改时间
+}
+
+// newTLSLoader 2026-10-19 16:12:40
+/*
+ 参数: cfg,tls配置
+ 参数: brokers,broker 地址
+ 描述: 校验配置并加载证书
+*/
+func newTLSLoader(cfg *MqttTLS, brokers []string) (*tlsLoader, error) {
+	cfg.CA = FixPathVar(cfg.CA)
+	if cfg.CA != "" && !FileExists(cfg.CA, false) {
+		return nil, fmt.Errorf("mqtt.Tls.ca is miss")
+	}
+
+	if (cfg.Key == "") != (cfg.Cert == "") {
+		return nil, fmt.Errorf("mqtt.Tls.key and cert must be set together")
+	}
+
+	if cfg.Key != "" {
+		cfg.Key = FixPathVar(cfg.Key)
+		if !FileExists(cfg.Key, false) {
+			return nil, fmt.Errorf("mqtt.Tls.key is miss")
+		}
+
+		cfg.Cert = FixPathVar(cfg.Cert)
+		if !FileExists(cfg.Cert, false) {
+			return nil, fmt.Errorf("mqtt.Tls.cert is miss")
+		}
+	}
+
+	if cfg.MinVersion == "" {
+		cfg.MinVersion = "1.2"
+	}
+
+	if _, ok := tlsVersions[cfg.MinVersion]; !ok {
+		return nil, fmt.Errorf("mqtt.Tls.minVersion is invalid: %s", cfg.MinVersion)
+	}
+
+	loader := &tlsLoader{
+		cfg:      *cfg,
+		pins:     make([]string, 0, len(cfg.Fingerprints)),
+		modified: make(map[string]time.Time),
+	}
+
+	for _, v := range brokers {
+		if uri, err := url.Parse(v); err == nil && uri.Hostname() != "" {
+			loader.hosts = append(loader.hosts, uri.Hostname())
+		}
+	}
+
+	for _, v := range cfg.Fingerprints {
+		pin := strings.ToLower(StrReplace(v, "", ":", " "))
+		if len(pin) != sha256.Size*2 {
+			return nil, fmt.Errorf("mqtt.Tls.fingerprint is invalid: %s", v)
+		}
+		loader.pins = append(loader.pins, pin)
+	}
+
+	if err := loader.reload(); err != nil {
+		return nil, err
+	}
+	return loader, nil
+}
+
+// changed 2026-10-19 16:20:18
+/*
+ 参数: files,文件列表
+ 描述: 检查files是否有变动
+*/
+func (tl *tlsLoader) changed(files ...string) bool {
+	changed := false
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+
+		info, err := os.Stat(f)
+		if err != nil {
+			continue //文件替换过程中,使用旧证书
+		}
+
+		if last, ok := tl.modified[f]; !ok || !last.Equal(info.ModTime()) {
+			tl.modified[f] = info.ModTime()
+			changed = true
+		}
+	}
+
+	return changed
+}
+
+// reload 2026-10-19 16:24:51
+/*
+ 描述: 文件有变动时,重新加载证书
+*/
+func (tl *tlsLoader) reload() error {
+	tl.lock.Lock()
+	defer tl.lock.Unlock()
+
+	if tl.cfg.CA != "" && tl.changed(tl.cfg.CA) {
+		rootCA, err := os.ReadFile(tl.cfg.CA)
+		if err != nil {
+			delete(tl.modified, tl.cfg.CA) //下次重试
+			return fmt.Errorf("mqtt.ReadFile: %v", err)
+		}
+
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM(rootCA) {
+			delete(tl.modified, tl.cfg.CA)
+			return fmt.Errorf("mqtt.Tls.ca load error")
+		}
+
+		if tl.roots != nil {
+			Info("znlib.mqtt.tls: ca reloaded")
+		}
+		tl.roots = cp
+	}
+
+	if tl.cfg.Cert != "" && tl.changed(tl.cfg.Cert, tl.cfg.Key) {
+		cert, err := tls.LoadX509KeyPair(tl.cfg.Cert, tl.cfg.Key)
+		if err != nil { //证书和秘钥未同时更新完成,下次重试
+			delete(tl.modified, tl.cfg.Cert)
+			delete(tl.modified, tl.cfg.Key)
+			return fmt.Errorf("mqtt.LoadX509KeyPair: %v", err)
+		}
+
+		if tl.cert != nil {
+			Info("znlib.mqtt.tls: certificate reloaded")
+		}
+		tl.cert = &cert
+	}
+
+	return nil
+}
+
+// config 2026-10-19 16:31:07
+/*
+ 描述: 生成每次握手时动态加载证书的tls配置
+*/
+func (tl *tlsLoader) config() *tls.Config {
+	return &tls.Config{
+		ServerName: tl.cfg.ServerName,
+		MinVersion: tlsVersions[tl.cfg.MinVersion],
+		//由 VerifyConnection 使用最新的 ca 校验
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if err := tl.reload(); err != nil {
+				ErrorCaller(err, "znlib.mqtt.tls")
+			}
+
+			tl.lock.Lock()
+			defer tl.lock.Unlock()
+			if tl.cert == nil {
+				return &tls.Certificate{}, nil //单向认证
+			}
+			return tl.cert, nil
+		},
+		VerifyConnection: tl.verify,
+	}
+}
+
+// verify 2026-10-19 16:38:42
+/*
+ 参数: cs,连接状态
+ 描述: 校验服务器证书链、名称和指纹
+*/
+func (tl *tlsLoader) verify(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) < 1 {
+		return fmt.Errorf("mqtt.tls: no server certificate")
+	}
+
+	if err := tl.reload(); err != nil {
+		ErrorCaller(err, "znlib.mqtt.tls")
+	}
+
+	leaf := cs.PeerCertificates[0]
+	if len(tl.pins) > 0 {
+		sum := sha256.Sum256(leaf.Raw)
+		if !StrIn(hex.EncodeToString(sum[:]), tl.pins...) {
+			return fmt.Errorf("mqtt.tls: server certificate fingerprint mismatch")
+		}
+	}
+
+	if tl.cfg.SkipVerify {
+		return nil
+	}
+
+	var names []string
+	switch {
+	case tl.cfg.ServerName != "":
+		names = []string{tl.cfg.ServerName}
+	case cs.ServerName != "":
+		names = []string{cs.ServerName}
+	default: //以 ip 连接,没有 SNI
+		names = tl.hosts
+	}
+
+	if len(names) < 1 { //不校验名称时,同一 ca 签发的任意证书均可通过
+		return fmt.Errorf("mqtt.tls: no server name to verify, set serverName")
+	}
+
+	tl.lock.Lock()
+	opts := x509.VerifyOptions{
+		Roots:         tl.roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	tl.lock.Unlock()
+
+	for _, v := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(v)
+	}
+
+	if _, err := leaf.Verify(opts); err != nil {
+		return fmt.Errorf("mqtt.tls: %v", err)
+	}
+
+	var err error
+	for _, name := range names {
+		if err = leaf.VerifyHostname(name); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("mqtt.tls: %v", err)
+}
@@ -0,0 +1,327 @@
+// Package mqttssh
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 21:38:27
+  描述: 网页端,将 xterm.js 的 websocket 转发到 mqtt
+
+备注:
+*.网页指令以 CmdTag(6字节)开头:
+  1.conn: 打开会话
+  2.resize<cols>,<rows>: 调整终端大小
+*.其它数据作为终端输入转发给设备端
+*.必须设置 Options.User 认证用户,认证失败时拒绝连接:
+  gw := mqttssh.NewGateway(mqtt.Client, &mqttssh.Options{
+      Prefix: "ssh/kt001",
+      Secret: "..",
+      User:   mqttssh.BasicAuth(map[string]string{"admin": "password"}),
+  })
+******************************************************************************/
+package mqttssh
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	mt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/gorilla/websocket"
+)
+
+// Gateway 网页端
+type Gateway struct {
+	mc       *mqtt.Utils           //mqtt 客户端
+	opts     *Options              //参数
+	lock     sync.Mutex            //同步锁定
+	sessions map[string]*wsSession //会话列表
+	started  bool                  //已订阅
+	upgrader websocket.Upgrader    //websocket 升级
+}
+
+// wsSession 网页会话
+type wsSession struct {
+	gw     *Gateway
+	sid    string          //会话标识
+	user   string          //用户
+	ws     *websocket.Conn //websocket
+	lock   sync.Mutex      //写入锁定
+	cols   uint16          //列数
+	rows   uint16          //行数
+	closed bool            //设备端已关闭
+	seqIn  uint64          //输入序号
+	seqCtl uint64          //指令序号
+}
+
+// NewGateway 2026-10-19 21:42:16
+/*
+ 参数: mc,mqtt客户端
+ 参数: opts,参数
+ 描述: 创建网页端
+*/
+func NewGateway(mc *mqtt.Utils, opts *Options) (*Gateway, error) {
+	opt, err := fixOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.User == nil {
+		return nil, fmt.Errorf("znlib.mqttssh: user authenticator is empty")
+	}
+
+	return &Gateway{
+		mc:       mc,
+		opts:     opt,
+		sessions: make(map[string]*wsSession),
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  4096,
+			WriteBufferSize: 4096,
+		},
+	}, nil
+}
+
+// start 2026-10-19 21:45:33
+/*
+ 描述: 订阅输出和状态主题
+*/
+func (gw *Gateway) start() error {
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+
+	if gw.started {
+		return nil
+	}
+
+	err := gw.mc.Subscribe(topic(gw.opts.Prefix, "+", TopicOut), mqtt.Qos1, gw.onOutput)
+	if err == nil {
+		err = gw.mc.Subscribe(topic(gw.opts.Prefix, "+", TopicState), mqtt.Qos1, gw.onState)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	gw.started = true
+	return nil
+}
+
+// get 2026-10-19 21:47:10
+/*
+ 参数: tp,主题
+ 描述: 获取主题对应的会话
+*/
+func (gw *Gateway) get(tp string) *wsSession {
+	tp = strings.TrimPrefix(tp, gw.opts.Prefix+"/")
+	if idx := strings.Index(tp, "/"); idx > 0 {
+		tp = tp[:idx]
+	}
+
+	gw.lock.Lock()
+	defer gw.lock.Unlock()
+	return gw.sessions[tp]
+}
+
+// onOutput 2026-10-19 21:49:02
+/*
+ 参数: cli,链路
+ 参数: msg,终端输出
+ 描述: 将设备端输出发送到网页
+*/
+func (gw *Gateway) onOutput(cli mt.Client, msg mt.Message) {
+	if ss := gw.get(msg.Topic()); ss != nil {
+		ss.write(msg.Payload())
+	}
+}
+
+// onState 2026-10-19 21:50:45
+/*
+ 参数: cli,链路
+ 参数: msg,会话状态
+ 描述: 设备端关闭会话时,断开网页
+*/
+func (gw *Gateway) onState(cli mt.Client, msg mt.Message) {
+	ss := gw.get(msg.Topic())
+	if ss == nil {
+		return
+	}
+
+	var ctl Control
+	if err := json.Unmarshal(msg.Payload(), &ctl); err != nil || ctl.Cmd != CmdClosed {
+		return
+	}
+
+	ss.lock.Lock()
+	ss.closed = true
+	ss.lock.Unlock()
+
+	ss.write([]byte(fmt.Sprintf("\r\n会话已关闭: %s\r\n", ctl.Reason)))
+	_ = ss.ws.Close()
+}
+
+// ServeHTTP 2026-10-19 21:54:18
+/*
+ 参数: w,应答
+ 参数: r,请求
+ 描述: 处理网页终端的websocket连接
+*/
+func (gw *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	caller := "znlib.mqttssh.Gateway"
+	user := gw.opts.User(r)
+	if user == "" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="mqttssh"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	if err := gw.start(); err != nil {
+		ErrorCaller(err, caller)
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	sid, err := newSessionID()
+	if err != nil {
+		ErrorCaller(err, caller)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ws, err := gw.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		ErrorCaller(err, caller)
+		return
+	}
+
+	ss := &wsSession{
+		gw:   gw,
+		sid:  sid,
+		user: user,
+		ws:   ws,
+	}
+
+	gw.lock.Lock()
+	gw.sessions[ss.sid] = ss
+	gw.lock.Unlock()
+
+	defer func() {
+		gw.lock.Lock()
+		delete(gw.sessions, ss.sid)
+		gw.lock.Unlock()
+
+		ss.lock.Lock()
+		closed := ss.closed
+		ss.lock.Unlock()
+
+		if !closed { //网页断开
+			ss.control(&Control{Cmd: CmdClose})
+		}
+		_ = ws.Close()
+	}()
+
+	for {
+		_ = ws.SetReadDeadline(time.Now().Add(gw.opts.IdleTimeout))
+		_, data, err := ws.ReadMessage()
+		if err != nil {
+			return //断开 or 空闲超时
+		}
+
+		if err = ss.handle(data); err != nil {
+			ErrorCaller(err, caller)
+			return
+		}
+	}
+}
+
+// BasicAuth 2026-10-19 21:59:37
+/*
+ 参数: users,用户名:密码
+ 描述: 使用 basic auth 认证网页端用户,返回 Options.User
+*/
+func BasicAuth(users map[string]string) func(r *http.Request) string {
+	return func(r *http.Request) string {
+		user, pwd, ok := r.BasicAuth()
+		if !ok {
+			return ""
+		}
+
+		expect, ok := users[user]
+		if !ok || subtle.ConstantTimeCompare([]byte(pwd), []byte(expect)) != 1 {
+			return ""
+		}
+		return user
+	}
+}
+
+// handle 2026-10-19 22:01:48
+/*
+ 参数: data,网页数据
+ 描述: 解析网页指令或转发终端输入
+*/
+func (ss *wsSession) handle(data []byte) error {
+	if !bytes.HasPrefix(data, CmdTag) {
+		ss.seqIn++
+		data = sealInput(ss.gw.opts.Secret, ss.sid, ss.seqIn, data)
+		return ss.gw.mc.Publish(topic(ss.gw.opts.Prefix, ss.sid, TopicIn), mqtt.Qos1, data)
+	}
+
+	cmd := string(data[len(CmdTag):])
+	switch {
+	case cmd == "conn":
+		return ss.control(&Control{Cmd: CmdOpen, User: ss.user, Cols: ss.cols, Rows: ss.rows})
+	case strings.HasPrefix(cmd, CmdResize):
+		size := strings.Split(cmd[len(CmdResize):], ",")
+		if len(size) != 2 {
+			return nil
+		}
+
+		cols, err := strconv.ParseUint(strings.TrimSpace(size[0]), 10, 16)
+		if err != nil {
+			return nil
+		}
+
+		rows, err := strconv.ParseUint(strings.TrimSpace(size[1]), 10, 16)
+		if err != nil {
+			return nil
+		}
+
+		ss.cols, ss.rows = uint16(cols), uint16(rows)
+		return ss.control(&Control{Cmd: CmdResize, Cols: ss.cols, Rows: ss.rows})
+	}
+
+	return nil
+}
+
+// control 2026-10-19 22:06:30
+/*
+ 参数: ctl,控制指令
+ 描述: 向设备端发送控制指令
+*/
+func (ss *wsSession) control(ctl *Control) error {
+	ss.seqCtl++
+	signControl(ss.gw.opts.Secret, ss.sid, ss.seqCtl, ctl)
+	data, err := json.Marshal(ctl)
+	if err != nil {
+		return err
+	}
+	return ss.gw.mc.Publish(topic(ss.gw.opts.Prefix, ss.sid, TopicCtl), mqtt.Qos1, data)
+}
+
+// write 2026-10-19 22:08:12
+/*
+ 参数: data,终端输出
+ 描述: 发送数据到网页
+*/
+func (ss *wsSession) write(data []byte) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+
+	_ = ss.ws.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	if err := ss.ws.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		_ = ss.ws.Close()
+	}
+}
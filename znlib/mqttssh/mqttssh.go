@@ -0,0 +1,243 @@
+// Package mqttssh
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 20:20:36
+  描述: 基于 mqtt 的远程终端(ssh)
+
+备注:
+*.设备端(Server): 收到连接指令后启动本地 shell(pty),转发输入输出
+  srv := mqttssh.NewServer(mqtt.Client, &mqttssh.Options{Prefix: "ssh/kt001"})
+  srv.Start()
+*.网页端(Gateway): 将 res/terminal(xterm.js) 的 websocket 转发到 mqtt
+  gw := mqttssh.NewGateway(mqtt.Client, &mqttssh.Options{Prefix: "ssh/kt001"})
+  http.Handle("/terminal", gw)
+*.主题: <prefix>/<session id>/..
+  1.in: 终端输入(网页 -> 设备)
+  2.out: 终端输出(设备 -> 网页)
+  3.ctl: 控制指令(网页 -> 设备),json 格式的 Control
+  4.state: 会话状态(设备 -> 网页),json 格式的 Control
+*.会话空闲超过 Options.IdleTimeout 后自动关闭
+*.设备端按用户记录输入的命令(日志)
+*.用户身份由网页端认证(Options.User),两端必须设置相同的 Options.Secret
+*.网页端对每条控制指令和终端输入签名(hmac-sha256),签名包含会话标识和序号;
+  设备端只接受签名正确且序号递增的数据,防止其它 broker 客户端伪造或重放
+*.终端输入格式: 序号(8字节,大端) + 签名(32字节) + 数据
+*.会话标识为随机数,防止其它 broker 客户端猜测并注入会话
+******************************************************************************/
+package mqttssh
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Command 控制指令
+type Command = string
+
+const (
+	CmdOpen   Command = "open"   //打开会话
+	CmdResize Command = "resize" //调整终端大小
+	CmdClose  Command = "close"  //关闭会话
+	CmdClosed Command = "closed" //会话已关闭
+)
+
+const (
+	TopicIn    = "in"    //终端输入
+	TopicOut   = "out"   //终端输出
+	TopicCtl   = "ctl"   //控制指令
+	TopicState = "state" //会话状态
+)
+
+// CmdTag 网页端指令前缀,见 res/terminal/terminal.js
+var CmdTag = []byte{0, 1, 2, 3, 4, 5}
+
+// Control 控制指令
+type Control struct {
+	Cmd    Command `json:"cmd"`              //指令
+	User   string  `json:"user,omitempty"`   //用户
+	Cols   uint16  `json:"cols,omitempty"`   //列数
+	Rows   uint16  `json:"rows,omitempty"`   //行数
+	Reason string  `json:"reason,omitempty"` //关闭原因
+	Time   int64   `json:"time,omitempty"`   //签名时间(unix)
+	Seq    uint64  `json:"seq,omitempty"`    //序号
+	Sign   string  `json:"sign,omitempty"`   //签名: hmac-sha256
+}
+
+// Options 参数
+type Options struct {
+	Prefix      string        //主题前缀,ex: ssh/kt001
+	Shell       string        //设备端 shell,默认 $SHELL 或 /bin/sh
+	IdleTimeout time.Duration //空闲超时,默认 10 分钟
+	Audit       bool          //记录用户命令
+	Secret      string        //网页端与设备端共享的签名密钥
+
+	User func(r *http.Request) string
+	//网页端认证用户,返回用户名称;返回空时拒绝连接,见 BasicAuth
+}
+
+// fixOptions 2026-10-19 20:26:18
+/*
+ 参数: opts,参数
+ 描述: 校验并补全参数
+*/
+func fixOptions(opts *Options) (*Options, error) {
+	if opts == nil || opts.Prefix == "" {
+		return nil, fmt.Errorf("znlib.mqttssh: prefix is empty")
+	}
+
+	if opts.Secret == "" {
+		return nil, fmt.Errorf("znlib.mqttssh: secret is empty")
+	}
+
+	dst := *opts
+	if dst.IdleTimeout <= 0 {
+		dst.IdleTimeout = 10 * time.Minute
+	}
+	return &dst, nil
+}
+
+// topic 2026-10-19 20:28:40
+/*
+ 参数: prefix,主题前缀
+ 参数: sid,会话标识
+ 参数: kind,主题类型
+ 描述: 生成会话主题
+*/
+func topic(prefix, sid, kind string) string {
+	return fmt.Sprintf("%s/%s/%s", prefix, sid, kind)
+}
+
+// signMaxAge 签名有效期
+const signMaxAge = 5 * time.Minute
+
+// newSessionID 2026-10-19 20:31:05
+/*
+ 描述: 生成随机的会话标识
+*/
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// inputHeader 终端输入头: 序号 + 签名
+const inputHeader = 8 + sha256.Size
+
+// signature 2026-10-19 20:33:26
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: ctl,控制指令
+ 描述: 计算ctl的签名
+*/
+func signature(secret, sid string, ctl *Control) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s|%s|%s|%s|%d|%d|%d|%d", sid, TopicCtl, ctl.Cmd, ctl.User,
+		ctl.Cols, ctl.Rows, ctl.Time, ctl.Seq)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signControl 2026-10-19 20:35:12
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: seq,序号
+ 参数: ctl,控制指令
+ 描述: 网页端为ctl签名
+*/
+func signControl(secret, sid string, seq uint64, ctl *Control) {
+	ctl.Seq = seq
+	ctl.Time = time.Now().Unix()
+	ctl.Sign = signature(secret, sid, ctl)
+}
+
+// verifyControl 2026-10-19 20:37:02
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: ctl,控制指令
+ 描述: 设备端校验ctl的签名
+*/
+func verifyControl(secret, sid string, ctl *Control) error {
+	if !hmac.Equal([]byte(ctl.Sign), []byte(signature(secret, sid, ctl))) {
+		return errors.New("znlib.mqttssh: invalid signature")
+	}
+	return nil
+}
+
+// verifyUser 2026-10-19 20:38:47
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: ctl,控制指令
+ 描述: 设备端校验打开指令,返回可信的用户身份
+*/
+func verifyUser(secret, sid string, ctl *Control) (string, error) {
+	if err := verifyControl(secret, sid, ctl); err != nil {
+		return "", err
+	}
+
+	age := time.Since(time.Unix(ctl.Time, 0))
+	if age > signMaxAge || age < -signMaxAge {
+		return "", errors.New("znlib.mqttssh: signature expired")
+	}
+	return ctl.User, nil
+}
+
+// inputMAC 2026-10-19 20:40:15
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: seq,序号
+ 参数: data,终端输入
+ 描述: 计算终端输入的签名
+*/
+func inputMAC(secret, sid string, seq uint64, data []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = fmt.Fprintf(mac, "%s|%s|%d|", sid, TopicIn, seq)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// sealInput 2026-10-19 20:41:36
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: seq,序号
+ 参数: data,终端输入
+ 描述: 网页端为终端输入添加序号和签名
+*/
+func sealInput(secret, sid string, seq uint64, data []byte) []byte {
+	buf := make([]byte, inputHeader, inputHeader+len(data))
+	binary.BigEndian.PutUint64(buf, seq)
+	copy(buf[8:], inputMAC(secret, sid, seq, data))
+	return append(buf, data...)
+}
+
+// openInput 2026-10-19 20:43:02
+/*
+ 参数: secret,密钥
+ 参数: sid,会话标识
+ 参数: payload,签名的终端输入
+ 描述: 设备端校验终端输入,返回序号和数据
+*/
+func openInput(secret, sid string, payload []byte) (uint64, []byte, error) {
+	if len(payload) < inputHeader {
+		return 0, nil, errors.New("znlib.mqttssh: invalid input")
+	}
+
+	seq := binary.BigEndian.Uint64(payload)
+	data := payload[inputHeader:]
+	if !hmac.Equal(payload[8:inputHeader], inputMAC(secret, sid, seq, data)) {
+		return 0, nil, errors.New("znlib.mqttssh: invalid signature")
+	}
+	return seq, data, nil
+}
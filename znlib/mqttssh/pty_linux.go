@@ -0,0 +1,85 @@
+// Package mqttssh
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 20:32:15
+  描述: linux 伪终端(pty)
+******************************************************************************/
+package mqttssh
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// ioctl 2026-10-19 20:33:40
+/*
+ 参数: fd,文件
+ 参数: cmd,指令
+ 参数: ptr,参数
+ 描述: 调用ioctl
+*/
+func ioctl(fd, cmd, ptr uintptr) error {
+	_, _, e := syscall.Syscall(syscall.SYS_IOCTL, fd, cmd, ptr)
+	if e != 0 {
+		return e
+	}
+	return nil
+}
+
+// startPty 2026-10-19 20:35:52
+/*
+ 参数: cmd,命令
+ 描述: 在新的伪终端中运行cmd,返回主设备
+*/
+func startPty(cmd *exec.Cmd) (*os.File, error) {
+	ptm, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var num uint32
+	if err = ioctl(ptm.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&num))); err != nil {
+		_ = ptm.Close()
+		return nil, fmt.Errorf("znlib.mqttssh.TIOCGPTN: %v", err)
+	}
+
+	var unlock int32
+	if err = ioctl(ptm.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		_ = ptm.Close()
+		return nil, fmt.Errorf("znlib.mqttssh.TIOCSPTLCK: %v", err)
+	}
+
+	pts, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", num), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		_ = ptm.Close()
+		return nil, err
+	}
+	defer pts.Close() //子进程已继承
+
+	cmd.Stdin = pts
+	cmd.Stdout = pts
+	cmd.Stderr = pts
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+
+	if err = cmd.Start(); err != nil {
+		_ = ptm.Close()
+		return nil, err
+	}
+	return ptm, nil
+}
+
+// resizePty 2026-10-19 20:40:26
+/*
+ 参数: ptm,主设备
+ 参数: cols,列数
+ 参数: rows,行数
+ 描述: 调整终端大小
+*/
+func resizePty(ptm *os.File, cols, rows uint16) error {
+	ws := struct {
+		Row, Col, X, Y uint16
+	}{Row: rows, Col: cols}
+	return ioctl(ptm.Fd(), syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&ws)))
+}
@@ -0,0 +1,23 @@
+// Package mqttssh
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 20:42:08
+  描述: windows 不支持伪终端(pty)
+******************************************************************************/
+package mqttssh
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// errPtyNotSupported 不支持伪终端
+var errPtyNotSupported = errors.New("znlib.mqttssh: pty not supported on windows")
+
+func startPty(cmd *exec.Cmd) (*os.File, error) {
+	return nil, errPtyNotSupported
+}
+
+func resizePty(ptm *os.File, cols, rows uint16) error {
+	return errPtyNotSupported
+}
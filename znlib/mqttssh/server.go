@@ -0,0 +1,500 @@
+// Package mqttssh
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 20:45:10
+  描述: 设备端,在伪终端中运行 shell 并通过 mqtt 转发
+
+备注:
+*.只处理签名正确且序号递增的控制指令和终端输入
+*.已打开过的会话标识在签名有效期内不能再次打开,防止重放打开指令
+******************************************************************************/
+package mqttssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/dmznlin/znlib-go/znlib/mqtt"
+	mt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Server 设备端
+type Server struct {
+	mc       *mqtt.Utils       //mqtt 客户端
+	opts     *Options          //参数
+	lock     sync.Mutex        //同步锁定
+	sessions map[string]*shell //会话列表
+	opened   map[string]int64  //已打开的会话标识: 打开时间(UnixNano)
+	stop     chan struct{}     //停止信号
+}
+
+// shell 终端会话
+type shell struct {
+	srv    *Server
+	sid    string    //会话标识
+	user   string    //用户
+	cmd    *exec.Cmd //shell 进程
+	ptm    *os.File  //伪终端
+	active int64     //最后活动时间(UnixNano)
+	once   sync.Once //关闭一次
+	seqIn  uint64    //最后的输入序号
+	seqCtl uint64    //最后的指令序号
+
+	line   []byte //审计: 当前输入行
+	escape int    //审计: 0,普通;1,收到ESC;2,控制序列中
+}
+
+// NewServer 2026-10-19 20:49:33
+/*
+ 参数: mc,mqtt客户端
+ 参数: opts,参数
+ 描述: 创建设备端
+*/
+func NewServer(mc *mqtt.Utils, opts *Options) (*Server, error) {
+	opt, err := fixOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if opt.Shell == "" {
+		opt.Shell = os.Getenv("SHELL")
+		if opt.Shell == "" {
+			opt.Shell = "/bin/sh"
+		}
+	}
+
+	return &Server{
+		mc:       mc,
+		opts:     opt,
+		sessions: make(map[string]*shell),
+		opened:   make(map[string]int64),
+	}, nil
+}
+
+// Start 2026-10-19 20:52:06
+/*
+ 描述: 订阅输入和控制主题,开始服务
+*/
+func (srv *Server) Start() error {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+
+	if srv.stop != nil {
+		return nil
+	}
+
+	err := srv.mc.Subscribe(topic(srv.opts.Prefix, "+", TopicCtl), mqtt.Qos1, srv.onControl)
+	if err == nil {
+		err = srv.mc.Subscribe(topic(srv.opts.Prefix, "+", TopicIn), mqtt.Qos1, srv.onInput)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	srv.stop = make(chan struct{})
+	go srv.checkIdle(srv.stop)
+	return nil
+}
+
+// Stop 2026-10-19 20:55:48
+/*
+ 描述: 停止服务并关闭所有会话
+*/
+func (srv *Server) Stop() {
+	srv.lock.Lock()
+	if srv.stop == nil {
+		srv.lock.Unlock()
+		return
+	}
+
+	close(srv.stop)
+	srv.stop = nil
+	list := make([]*shell, 0, len(srv.sessions))
+	for _, v := range srv.sessions {
+		list = append(list, v)
+	}
+	srv.lock.Unlock()
+
+	for _, v := range list {
+		v.close("server stop")
+	}
+
+	err := srv.mc.Unsubscribe(topic(srv.opts.Prefix, "+", TopicCtl), topic(srv.opts.Prefix, "+", TopicIn))
+	if err != nil {
+		ErrorCaller(err, "znlib.mqttssh.Stop")
+	}
+}
+
+// Sessions 2026-10-19 20:58:12
+/*
+ 描述: 当前会话数
+*/
+func (srv *Server) Sessions() int {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return len(srv.sessions)
+}
+
+// sessionID 2026-10-19 20:59:40
+/*
+ 参数: tp,主题
+ 描述: 从主题中解析会话标识
+*/
+func (srv *Server) sessionID(tp string) string {
+	tp = strings.TrimPrefix(tp, srv.opts.Prefix+"/")
+	if idx := strings.Index(tp, "/"); idx > 0 {
+		return tp[:idx]
+	}
+	return ""
+}
+
+// get 2026-10-19 21:01:15
+/*
+ 参数: sid,会话标识
+ 描述: 获取会话
+*/
+func (srv *Server) get(sid string) *shell {
+	srv.lock.Lock()
+	defer srv.lock.Unlock()
+	return srv.sessions[sid]
+}
+
+// accept 2026-10-19 21:02:20
+/*
+ 参数: last,最后的序号
+ 参数: seq,当前序号
+ 描述: 序号递增时接受数据,否则视为重放
+*/
+func (sh *shell) accept(last *uint64, seq uint64) bool {
+	sh.srv.lock.Lock()
+	defer sh.srv.lock.Unlock()
+
+	if seq <= *last {
+		return false
+	}
+
+	*last = seq
+	return true
+}
+
+// onControl 2026-10-19 21:03:27
+/*
+ 参数: cli,链路
+ 参数: msg,控制指令
+ 描述: 处理控制指令
+*/
+func (srv *Server) onControl(cli mt.Client, msg mt.Message) {
+	caller := "znlib.mqttssh.onControl"
+	sid := srv.sessionID(msg.Topic())
+
+	var ctl Control
+	if err := json.Unmarshal(msg.Payload(), &ctl); err != nil || sid == "" {
+		ErrorCaller(fmt.Sprintf("invalid control on %s", msg.Topic()), caller)
+		return
+	}
+
+	switch ctl.Cmd {
+	case CmdOpen:
+		if srv.get(sid) != nil {
+			return //已打开
+		}
+
+		if err := srv.open(sid, &ctl); err != nil {
+			ErrorCaller(err, caller)
+			srv.state(sid, &Control{Cmd: CmdClosed, Reason: err.Error()})
+		}
+	case CmdResize, CmdClose:
+		sh := srv.get(sid)
+		if sh == nil {
+			return
+		}
+
+		if err := verifyControl(srv.opts.Secret, sid, &ctl); err != nil {
+			ErrorCaller(fmt.Sprintf("session %s: %s", sid, err), caller)
+			return
+		}
+
+		if !sh.accept(&sh.seqCtl, ctl.Seq) {
+			ErrorCaller(fmt.Sprintf("session %s: replayed control %d", sid, ctl.Seq), caller)
+			return
+		}
+
+		if ctl.Cmd == CmdClose {
+			sh.close("client close")
+			return
+		}
+
+		if ctl.Cols > 0 && ctl.Rows > 0 {
+			if err := resizePty(sh.ptm, ctl.Cols, ctl.Rows); err != nil {
+				ErrorCaller(err, caller)
+			}
+		}
+	}
+}
+
+// onInput 2026-10-19 21:08:50
+/*
+ 参数: cli,链路
+ 参数: msg,终端输入
+ 描述: 将输入写入伪终端
+*/
+func (srv *Server) onInput(cli mt.Client, msg mt.Message) {
+	caller := "znlib.mqttssh.onInput"
+	sid := srv.sessionID(msg.Topic())
+	sh := srv.get(sid)
+	if sh == nil {
+		return
+	}
+
+	seq, data, err := openInput(srv.opts.Secret, sid, msg.Payload())
+	if err != nil {
+		ErrorCaller(fmt.Sprintf("session %s: %s", sid, err), caller)
+		return
+	}
+
+	if !sh.accept(&sh.seqIn, seq) {
+		ErrorCaller(fmt.Sprintf("session %s: replayed input %d", sid, seq), caller)
+		return
+	}
+
+	sh.touch()
+	if srv.opts.Audit {
+		sh.audit(data)
+	}
+
+	if _, err = sh.ptm.Write(data); err != nil {
+		sh.close(err.Error())
+	}
+}
+
+// open 2026-10-19 21:11:35
+/*
+ 参数: sid,会话标识
+ 参数: ctl,连接指令
+ 描述: 启动shell并转发输出
+*/
+func (srv *Server) open(sid string, ctl *Control) error {
+	cmd := exec.Command(srv.opts.Shell)
+	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+
+	user, err := verifyUser(srv.opts.Secret, sid, ctl)
+	if err != nil { //用户身份以网页端签名为准
+		return err
+	}
+
+	srv.lock.Lock()
+	_, ok := srv.opened[sid]
+	if !ok {
+		srv.opened[sid] = time.Now().UnixNano()
+	}
+	srv.lock.Unlock()
+
+	if ok {
+		return fmt.Errorf("znlib.mqttssh: session %s already opened", sid)
+	}
+
+	ptm, err := startPty(cmd)
+	if err != nil {
+		return err
+	}
+
+	sh := &shell{
+		srv:    srv,
+		sid:    sid,
+		user:   user,
+		cmd:    cmd,
+		ptm:    ptm,
+		seqCtl: ctl.Seq,
+	}
+
+	sh.touch()
+	if ctl.Cols > 0 && ctl.Rows > 0 {
+		_ = resizePty(ptm, ctl.Cols, ctl.Rows)
+	}
+
+	srv.lock.Lock()
+	srv.sessions[sid] = sh
+	srv.lock.Unlock()
+
+	Info(fmt.Sprintf("znlib.mqttssh: user %s open session %s", sh.user, sid))
+	go sh.forward()
+	return nil
+}
+
+// state 2026-10-19 21:15:02
+/*
+ 参数: sid,会话标识
+ 参数: ctl,状态
+ 描述: 发布会话状态
+*/
+func (srv *Server) state(sid string, ctl *Control) {
+	data, err := json.Marshal(ctl)
+	if err == nil {
+		err = srv.mc.Publish(topic(srv.opts.Prefix, sid, TopicState), mqtt.Qos1, data)
+	}
+
+	if err != nil {
+		ErrorCaller(err, "znlib.mqttssh.state")
+	}
+}
+
+// checkIdle 2026-10-19 21:17:44
+/*
+ 参数: stop,停止信号
+ 描述: 关闭空闲超时的会话
+*/
+func (srv *Server) checkIdle(stop chan struct{}) {
+	interval := srv.opts.IdleTimeout / 2
+	if interval > 30*time.Second {
+		interval = 30 * time.Second
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-Application.Ctx.Done():
+			srv.Stop()
+			return
+		case <-ticker.C:
+			idle := make([]*shell, 0)
+			deadline := time.Now().Add(-srv.opts.IdleTimeout).UnixNano()
+
+			expired := time.Now().Add(-2 * signMaxAge).UnixNano()
+
+			srv.lock.Lock()
+			for _, v := range srv.sessions {
+				if atomic.LoadInt64(&v.active) < deadline {
+					idle = append(idle, v)
+				}
+			}
+
+			for k, v := range srv.opened {
+				if v < expired { //打开指令的签名已过期
+					delete(srv.opened, k)
+				}
+			}
+			srv.lock.Unlock()
+
+			for _, v := range idle {
+				v.close("idle timeout")
+			}
+		}
+	}
+}
+
+// touch 2026-10-19 21:21:18
+/*
+ 描述: 更新活动时间
+*/
+func (sh *shell) touch() {
+	atomic.StoreInt64(&sh.active, time.Now().UnixNano())
+}
+
+// forward 2026-10-19 21:22:30
+/*
+ 描述: 将伪终端输出发布到mqtt
+*/
+func (sh *shell) forward() {
+	reason := "shell exit"
+	buf := make([]byte, 4096)
+	out := topic(sh.srv.opts.Prefix, sh.sid, TopicOut)
+
+	for {
+		n, err := sh.ptm.Read(buf)
+		if n > 0 {
+			sh.touch()
+			data := make([]byte, n)
+			copy(data, buf[:n])
+
+			if e := sh.srv.mc.Publish(out, mqtt.Qos1, data); e != nil {
+				reason = e.Error()
+				break
+			}
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	sh.close(reason)
+	_ = sh.cmd.Wait()
+}
+
+// close 2026-10-19 21:25:53
+/*
+ 参数: reason,原因
+ 描述: 结束shell并通知网页端
+*/
+func (sh *shell) close(reason string) {
+	sh.once.Do(func() {
+		if sh.cmd.Process != nil {
+			_ = sh.cmd.Process.Kill()
+		}
+		_ = sh.ptm.Close()
+
+		sh.srv.lock.Lock()
+		delete(sh.srv.sessions, sh.sid)
+		sh.srv.lock.Unlock()
+
+		sh.srv.state(sh.sid, &Control{Cmd: CmdClosed, Reason: reason})
+		Info(fmt.Sprintf("znlib.mqttssh: user %s close session %s: %s", sh.user, sh.sid, reason))
+	})
+}
+
+// audit 2026-10-19 21:29:16
+/*
+ 参数: data,终端输入
+ 描述: 按行记录用户输入的命令
+
+ 备注: 只还原退格,不处理光标移动和补全
+*/
+func (sh *shell) audit(data []byte) {
+	for _, b := range data {
+		switch sh.escape {
+		case 1: //ESC 后的字符
+			if b == '[' || b == 'O' {
+				sh.escape = 2
+			} else {
+				sh.escape = 0
+			}
+			continue
+		case 2: //控制序列,以 0x40-0x7e 结束
+			if b >= 0x40 && b <= 0x7e {
+				sh.escape = 0
+			}
+			continue
+		}
+
+		switch {
+		case b == 0x1b:
+			sh.escape = 1
+		case b == '\r' || b == '\n':
+			if len(sh.line) > 0 {
+				Info(fmt.Sprintf("znlib.mqttssh: user %s session %s cmd: %s", sh.user, sh.sid, sh.line))
+				sh.line = sh.line[:0]
+			}
+		case b == 0x7f || b == 0x08: //退格
+			if len(sh.line) > 0 {
+				_, size := utf8.DecodeLastRune(sh.line)
+				sh.line = sh.line[:len(sh.line)-size]
+			}
+		case b == 0x03 || b == 0x15: //ctrl+c,ctrl+u
+			sh.line = sh.line[:0]
+		case b >= 0x20:
+			sh.line = append(sh.line, b)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+// Package znlib
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 10:25:16
+  描述: 限流器
+
+备注:
+*.使用方法
+  limiter := NewTokenBucket(RateLimit{Rate: 10, Period: time.Second})
+  limiter.SetLimit("slow", RateLimit{Rate: 1, Period: time.Second})
+  //1.立即检查
+  if limiter.Allow("key") {...}
+  //2.等待许可
+  err := limiter.Wait(Application.Ctx, "key")
+  //3.预约许可
+  delay, err := limiter.Reserve("key")
+*.令牌已回满的桶与新建的桶等价,每隔 TokenBucketSweep 清理一次,避免 key 过多
+  (如按 topic、设备限流)时内存无限增长
+*.分布式限流: 参考 redis.NewSlidingWindow
+******************************************************************************/
+package znlib
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimitCanceled 等待许可时被取消
+var ErrRateLimitCanceled = errors.New("znlib.RateLimiter: wait canceled")
+
+// RateLimit 限流参数
+type RateLimit struct {
+	Rate   int           //周期内允许的次数,<=0 不限流
+	Period time.Duration //统计周期
+	Burst  int           //突发容量(令牌桶容量),默认等于 Rate
+}
+
+// RateLimiter 限流器接口
+type RateLimiter interface {
+	// Allow 检查 key 是否可立即执行,可执行时消耗一次许可
+	Allow(key string) bool
+	// Wait 等待 key 的许可,直到 ctx 取消
+	Wait(ctx context.Context, key string) error
+	// Reserve 预约 key 的一次许可,返回需要等待的时长
+	Reserve(key string) (time.Duration, error)
+	// SetLimit 设置 key 的限流参数
+	SetLimit(key string, limit RateLimit)
+}
+
+// limitOf 2026-10-19 10:31:40
+/*
+ 参数: limits,限流参数列表
+ 参数: def,默认参数
+ 参数: key,标识
+ 描述: 获取key的限流参数
+*/
+func limitOf(limits map[string]RateLimit, def RateLimit, key string) RateLimit {
+	if limit, ok := limits[key]; ok {
+		return limit
+	}
+	return def
+}
+
+// waitLimiter 2026-10-19 10:36:02
+/*
+ 参数: ctx,上下文
+ 参数: limiter,限流器
+ 参数: key,标识
+ 参数: cancel,取消预约
+ 描述: 预约许可并等待,ctx 取消时调用 cancel 归还许可
+*/
+func waitLimiter(ctx context.Context, limiter RateLimiter, key string, cancel func()) error {
+	delay, err := limiter.Reserve(key)
+	if err != nil {
+		return err
+	}
+
+	if delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		if cancel != nil {
+			cancel()
+		}
+		return ErrRateLimitCanceled
+	case <-timer.C:
+		return nil
+	}
+}
+
+//--------------------------------------------------------------------------------
+
+// TokenBucketSweep 清理空闲令牌桶的间隔
+const TokenBucketSweep = time.Minute
+
+// tokenBucket 令牌桶
+type tokenBucket struct {
+	tokens float64   //剩余令牌
+	last   time.Time //上次填充时间
+}
+
+// TokenBucket 本地令牌桶限流器
+type TokenBucket struct {
+	lock    sync.Mutex              //同步锁定
+	def     RateLimit               //默认参数
+	limits  map[string]RateLimit    //独立参数
+	buckets map[string]*tokenBucket //令牌桶
+	swept   time.Time               //上次清理时间
+}
+
+// NewTokenBucket 2026-10-19 10:42:25
+/*
+ 参数: def,默认限流参数
+ 描述: 创建本地令牌桶限流器
+*/
+func NewTokenBucket(def RateLimit) *TokenBucket {
+	return &TokenBucket{
+		def:     def,
+		limits:  make(map[string]RateLimit),
+		buckets: make(map[string]*tokenBucket),
+		swept:   time.Now(),
+	}
+}
+
+// Len 2026-10-19 21:10:32
+/*
+ 描述: 当前缓存的令牌桶个数
+*/
+func (tb *TokenBucket) Len() int {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	return len(tb.buckets)
+}
+
+// Sweep 2026-10-19 21:12:05
+/*
+ 描述: 清理令牌已回满(空闲)的桶
+ 备注: 每隔 TokenBucketSweep 自动调用
+*/
+func (tb *TokenBucket) Sweep() {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+	tb.sweep(time.Now())
+}
+
+// sweep 2026-10-19 21:14:48
+/*
+ 参数: now,当前时间
+ 描述: 清理令牌已回满的桶,调用方需加锁
+*/
+func (tb *TokenBucket) sweep(now time.Time) {
+	tb.swept = now
+	for key, bucket := range tb.buckets {
+		limit := limitOf(tb.limits, tb.def, key)
+		if limit.Rate <= 0 || limit.Period <= 0 { //不限流
+			delete(tb.buckets, key)
+			continue
+		}
+
+		if limit.Burst <= 0 {
+			limit.Burst = limit.Rate
+		}
+
+		tokens := bucket.tokens + float64(limit.Rate)*float64(now.Sub(bucket.last))/float64(limit.Period)
+		if tokens >= float64(limit.Burst) {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// SetLimit 2026-10-19 10:44:51
+/*
+ 参数: key,标识
+ 参数: limit,限流参数
+ 描述: 设置key的限流参数
+*/
+func (tb *TokenBucket) SetLimit(key string, limit RateLimit) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	tb.limits[key] = limit
+	delete(tb.buckets, key)
+	//参数变更后重新计数
+}
+
+// refill 2026-10-19 10:47:36
+/*
+ 参数: key,标识
+ 参数: now,当前时间
+ 描述: 按时间流逝填充key的令牌,返回令牌桶和限流参数
+*/
+func (tb *TokenBucket) refill(key string, now time.Time) (*tokenBucket, RateLimit) {
+	if now.Sub(tb.swept) >= TokenBucketSweep {
+		tb.sweep(now)
+	}
+
+	limit := limitOf(tb.limits, tb.def, key)
+	if limit.Burst <= 0 {
+		limit.Burst = limit.Rate
+	}
+
+	bucket, ok := tb.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), last: now}
+		tb.buckets[key] = bucket
+		return bucket, limit
+	}
+
+	if limit.Rate > 0 && limit.Period > 0 {
+		bucket.tokens += float64(limit.Rate) * float64(now.Sub(bucket.last)) / float64(limit.Period)
+		if bucket.tokens > float64(limit.Burst) {
+			bucket.tokens = float64(limit.Burst)
+		}
+	}
+
+	bucket.last = now
+	return bucket, limit
+}
+
+// Allow 2026-10-19 10:53:10
+/*
+ 参数: key,标识
+ 描述: 有可用令牌时消耗一个并返回true
+*/
+func (tb *TokenBucket) Allow(key string) bool {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	bucket, limit := tb.refill(key, time.Now())
+	if limit.Rate <= 0 { //不限流
+		return true
+	}
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	return false
+}
+
+// Reserve 2026-10-19 10:56:42
+/*
+ 参数: key,标识
+ 描述: 预支一个令牌,返回令牌可用前需等待的时长
+*/
+func (tb *TokenBucket) Reserve(key string) (time.Duration, error) {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	bucket, limit := tb.refill(key, time.Now())
+	if limit.Rate <= 0 {
+		return 0, nil
+	}
+
+	if limit.Period <= 0 {
+		return 0, errors.New("znlib.TokenBucket: invalid period")
+	}
+
+	bucket.tokens--
+	if bucket.tokens >= 0 {
+		return 0, nil
+	}
+
+	return time.Duration(-bucket.tokens * float64(limit.Period) / float64(limit.Rate)), nil
+}
+
+// Wait 2026-10-19 11:01:18
+/*
+ 参数: ctx,上下文
+ 参数: key,标识
+ 描述: 等待key的令牌,取消时归还预支的令牌
+*/
+func (tb *TokenBucket) Wait(ctx context.Context, key string) error {
+	return waitLimiter(ctx, tb, key, func() {
+		tb.lock.Lock()
+		defer tb.lock.Unlock()
+
+		if bucket, ok := tb.buckets[key]; ok {
+			bucket.tokens++
+		}
+	})
+}
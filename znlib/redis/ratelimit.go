@@ -0,0 +1,189 @@
+// Package redis
+/******************************************************************************
+  作者: dmzn@163.com 2026-10-19 11:12:08
+  描述: 基于redis的分布式滑动窗口限流器
+
+备注:
+*.使用方法
+  limiter := NewSlidingWindow(Client, RateLimit{Rate: 100, Period: time.Minute})
+  err := limiter.Wait(Application.Ctx, "api.query")
+*.同一 key 在所有使用相同 redis 的进程间共享计数
+******************************************************************************/
+package redis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/dmznlin/znlib-go/znlib"
+	"github.com/go-redis/redis/v8"
+)
+
+// slidingWindowScript 滑动窗口脚本
+/*
+ KEYS[1]: 窗口key
+ ARGV[1]: 当前时间(毫秒)
+ ARGV[2]: 窗口长度(毫秒)
+ ARGV[3]: 窗口内允许次数
+ ARGV[4]: 本次请求标识
+ ARGV[5]: 1,预约;0,仅检查
+ 返回: -1,拒绝;>=0,需等待的毫秒数
+*/
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+local count = redis.call('ZCARD', key)
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	return 0
+end
+
+if ARGV[5] ~= '1' then
+	return -1
+end
+
+local first = redis.call('ZRANGE', key, count - limit, count - limit, 'WITHSCORES')
+local at = tonumber(first[2]) + window
+redis.call('ZADD', key, at, ARGV[4])
+redis.call('PEXPIRE', key, at - now + window)
+return at - now
+`)
+
+// SlidingWindow 分布式滑动窗口限流器
+type SlidingWindow struct {
+	ru     *Utils               //redis 客户端
+	lock   sync.RWMutex         //同步锁定
+	def    RateLimit            //默认参数
+	limits map[string]RateLimit //独立参数
+	serial uint64               //请求序号
+}
+
+// NewSlidingWindow 2026-10-19 11:20:33
+/*
+ 参数: ru,redis客户端
+ 参数: def,默认限流参数
+ 描述: 创建基于ru的滑动窗口限流器
+*/
+func NewSlidingWindow(ru *Utils, def RateLimit) *SlidingWindow {
+	return &SlidingWindow{
+		ru:     ru,
+		def:    def,
+		limits: make(map[string]RateLimit),
+	}
+}
+
+// SetLimit 2026-10-19 11:22:47
+/*
+ 参数: key,标识
+ 参数: limit,限流参数
+ 描述: 设置key的限流参数
+*/
+func (sw *SlidingWindow) SetLimit(key string, limit RateLimit) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	sw.limits[key] = limit
+}
+
+// limitOf 2026-10-19 11:24:15
+/*
+ 参数: key,标识
+ 描述: 获取key的限流参数
+*/
+func (sw *SlidingWindow) limitOf(key string) RateLimit {
+	sw.lock.RLock()
+	defer sw.lock.RUnlock()
+
+	if limit, ok := sw.limits[key]; ok {
+		return limit
+	}
+	return sw.def
+}
+
+// acquire 2026-10-19 11:27:52
+/*
+ 参数: key,标识
+ 参数: reserve,是否预约
+ 描述: 执行限流脚本,返回请求标识和需等待时长(<0 拒绝)
+*/
+func (sw *SlidingWindow) acquire(key string, reserve bool) (string, time.Duration, error) {
+	limit := sw.limitOf(key)
+	if limit.Rate <= 0 {
+		return "", 0, nil
+	}
+
+	if limit.Period < time.Millisecond {
+		return "", 0, fmt.Errorf("redis.SlidingWindow: invalid period")
+	}
+
+	now := time.Now().UnixMilli()
+	member := fmt.Sprintf("%s-%d-%d", Application.HostName, now, atomic.AddUint64(&sw.serial, 1))
+	flag := "0"
+	if reserve {
+		flag = "1"
+	}
+
+	ms, err := slidingWindowScript.Run(Application.Ctx, sw.ru.Cmdable, []string{"ratelimit.window:" + key},
+		now, limit.Period.Milliseconds(), limit.Rate, member, flag).Int64()
+	if err != nil {
+		return "", 0, ErrorMsg(err, "redis.SlidingWindow")
+	}
+
+	return member, time.Duration(ms) * time.Millisecond, nil
+}
+
+// Allow 2026-10-19 11:33:06
+/*
+ 参数: key,标识
+ 描述: 窗口未满时记录本次请求并返回true
+*/
+func (sw *SlidingWindow) Allow(key string) bool {
+	_, delay, err := sw.acquire(key, false)
+	if err != nil {
+		ErrorCaller(err, "znlib.redis.SlidingWindow.Allow")
+		return false
+	}
+
+	return delay >= 0
+}
+
+// Reserve 2026-10-19 11:35:40
+/*
+ 参数: key,标识
+ 描述: 预约窗口中的一个位置,返回需等待的时长
+*/
+func (sw *SlidingWindow) Reserve(key string) (time.Duration, error) {
+	_, delay, err := sw.acquire(key, true)
+	return delay, err
+}
+
+// Wait 2026-10-19 11:38:21
+/*
+ 参数: ctx,上下文
+ 参数: key,标识
+ 描述: 等待key的许可,取消时删除预约
+*/
+func (sw *SlidingWindow) Wait(ctx context.Context, key string) error {
+	member, delay, err := sw.acquire(key, true)
+	if err != nil || delay <= 0 {
+		return err
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		sw.ru.ZRem(Application.Ctx, "ratelimit.window:"+key, member)
+		return ErrRateLimitCanceled
+	case <-timer.C:
+		return nil
+	}
+}
@@ -2,26 +2,76 @@
 /******************************************************************************
   作者: dmzn@163.com 2022-08-11 19:50:59
   描述: 支持集群的redis客户端
+
+备注:
+*.连接模式
+  1.sentinel.master 不为空: 哨兵模式,Single 为主节点连接
+  2.cluster = true: 集群模式,使用 Cluster
+  3.其它: 单机模式,使用 Single
+*.节点事件
+  Client.RegisterEventHandler(func(ru *Utils, node string, event Event) {
+    if event == EventDisconnect {
+      Warn("redis lost: " + node)
+    }
+  })
+*.节点名称
+  1.单机: 服务器地址
+  2.集群: 各分片地址;拓扑不可用时为 cluster
+  3.哨兵: 主节点地址,各哨兵为 sentinel:地址;主节点切换时触发 EventFailover
+*.NewUtils 创建独立的客户端,使用 Close 关闭
 ******************************************************************************/
 package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"math/rand"
+	"net"
+	"os"
+	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	. "github.com/dmznlin/znlib-go/znlib"
 	"github.com/go-redis/redis/v8"
 )
 
+type (
+	Event        = byte                                      //event 代码
+	EventHandler = func(ru *Utils, node string, event Event) //event 事件
+)
+
+const (
+	EventConnected  Event = iota //节点首次连接成功
+	EventDisconnect              //节点连接断开
+	EventReConnect               //节点断开后恢复
+	EventFailover                //哨兵模式主节点切换,node 为新主节点
+)
+
+// nodeCluster 集群拓扑节点
+const nodeCluster = "cluster"
+
 type (
 	Utils struct {
 		redis.Cmdable //redis 操作接口
+
+		events []EventHandler  //事件处理列表
+		nodes  map[string]bool //节点状态: true,在线
+		lock   sync.RWMutex    //同步锁定
+
+		single     *redis.Client           //单机 or 哨兵模式连接
+		cluster    *redis.ClusterClient    //集群模式连接
+		sentinels  []*redis.SentinelClient //哨兵连接
+		sentinelAt []string                //哨兵地址
+		master     string                  //哨兵模式主节点名称
+		masterAddr string                  //哨兵模式主节点地址
+		cancel     context.CancelFunc      //停止健康检查
 	}
 
 	Locker struct {
@@ -41,6 +91,8 @@ var (
 	// Client 全局redis统一接口
 	Client = &Utils{
 		Cmdable: nil,
+		events:  nil,
+		nodes:   make(map[string]bool),
 	}
 )
 
@@ -50,57 +102,239 @@ var (
 */
 func init() {
 	Application.RegisterInitHandler(func(cfg *LibConfig) {
-		if len(cfg.Redis.Servers) < 1 {
-			Error("redis.initRedis: server list empty.")
+		var err error
+		cfg.Redis.Password, err = decryptPassword(cfg.Redis.Password)
+		if err != nil {
+			ErrorCaller(err, "znlib.redis.init")
 			return
 		}
 
-		if len(cfg.Redis.Password) > 0 {
-			buf, err := NewEncrypter(EncryptDesEcb, []byte(DefaultEncryptKey)).Decrypt([]byte(cfg.Redis.Password), true)
-			if err == nil {
-				cfg.Redis.Password = string(buf)
-			} else {
-				ErrorCaller(err, "znlib.redis.init")
-				return
-			}
+		cfg.Redis.Sentinel.Password, err = decryptPassword(cfg.Redis.Sentinel.Password)
+		if err != nil {
+			ErrorCaller(err, "znlib.redis.init")
+			return
 		}
 
 		cfg.Redis.Timeout.Dial = cfg.Redis.Timeout.Dial * time.Second
 		cfg.Redis.Timeout.Read = cfg.Redis.Timeout.Read * time.Second
 		cfg.Redis.Timeout.Write = cfg.Redis.Timeout.Write * time.Second
 		cfg.Redis.Timeout.Pool = cfg.Redis.Timeout.Pool * time.Second
+		cfg.Redis.Timeout.Health = cfg.Redis.Timeout.Health * time.Second
 
-		if cfg.Redis.Cluster {
-			Cluster = redis.NewClusterClient(&redis.ClusterOptions{
-				Addrs:    cfg.Redis.Servers,
-				Password: cfg.Redis.Password,
-				PoolSize: cfg.Redis.PoolSize,
-
-				//超时设置
-				DialTimeout:  cfg.Redis.Timeout.Dial,  //连接建立超时时间，默认5秒。
-				ReadTimeout:  cfg.Redis.Timeout.Read,  //读超时，默认3秒， -1表示取消读超时
-				WriteTimeout: cfg.Redis.Timeout.Write, //写超时，默认等于读超时，-1表示取消读超时
-				PoolTimeout:  cfg.Redis.Timeout.Pool,  //当所有连接都处在繁忙状态时，客户端等待可用连接的最大等待时长，默认为读超时+1秒。
-			})
+		if err = Client.open(&cfg.Redis); err != nil {
+			ErrorCaller(err, "znlib.redis.init")
+			return
+		}
 
-			Client.Cmdable = Cluster
-		} else {
-			Single = redis.NewClient(&redis.Options{
-				Addr:     cfg.Redis.Servers[0],
-				Password: cfg.Redis.Password,
-				PoolSize: cfg.Redis.PoolSize,
-				DB:       cfg.Redis.DefaultDB,
-
-				//超时设置
-				DialTimeout:  cfg.Redis.Timeout.Dial,
-				ReadTimeout:  cfg.Redis.Timeout.Read,
-				WriteTimeout: cfg.Redis.Timeout.Write,
-				PoolTimeout:  cfg.Redis.Timeout.Pool,
-			})
+		Single, Cluster = Client.single, Client.cluster
+	})
+}
 
-			Client.Cmdable = Single
+// NewUtils 2026-10-19 10:05:16
+/*
+ 参数: cfg,redis配置
+ 描述: 依据cfg创建客户端
+
+ 备注:
+ *.cfg 中的密码为明文,超时为 time.Duration
+ *.cfg.Enable 时启动健康检查,使用 Close 停止
+*/
+func NewUtils(cfg *RedisConfig) (*Utils, error) {
+	ru := &Utils{nodes: make(map[string]bool)}
+	if err := ru.open(cfg); err != nil {
+		return nil, err
+	}
+	return ru, nil
+}
+
+// open 2026-10-19 10:08:42
+/*
+ 参数: cfg,redis配置
+ 描述: 依据cfg创建连接,启动健康检查
+*/
+func (ru *Utils) open(cfg *RedisConfig) (err error) {
+	sentinel := cfg.Sentinel.Master != ""
+	if sentinel {
+		if len(cfg.Sentinel.Servers) < 1 {
+			return errors.New("redis.initRedis: sentinel list empty")
 		}
-	})
+	} else if len(cfg.Servers) < 1 {
+		return errors.New("redis.initRedis: server list empty")
+	}
+
+	var tlsCfg *tls.Config
+	if cfg.Tls.Used {
+		tlsCfg, err = loadTLSConfig(&cfg.Tls)
+		if err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case sentinel:
+		ru.single = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       cfg.Sentinel.Master,
+			SentinelAddrs:    cfg.Sentinel.Servers,
+			SentinelPassword: cfg.Sentinel.Password,
+			Password:         cfg.Password,
+			PoolSize:         cfg.PoolSize,
+			DB:               cfg.DefaultDB,
+			TLSConfig:        tlsCfg,
+
+			//超时设置
+			DialTimeout:  cfg.Timeout.Dial,
+			ReadTimeout:  cfg.Timeout.Read,
+			WriteTimeout: cfg.Timeout.Write,
+			PoolTimeout:  cfg.Timeout.Pool,
+		})
+
+		ru.master = cfg.Sentinel.Master
+		ru.sentinelAt = cfg.Sentinel.Servers
+		for _, addr := range cfg.Sentinel.Servers {
+			ru.sentinels = append(ru.sentinels, redis.NewSentinelClient(&redis.Options{
+				Addr:      addr,
+				Password:  cfg.Sentinel.Password,
+				PoolSize:  1,
+				TLSConfig: tlsCfg,
+
+				DialTimeout:  cfg.Timeout.Dial,
+				ReadTimeout:  cfg.Timeout.Read,
+				WriteTimeout: cfg.Timeout.Write,
+				PoolTimeout:  cfg.Timeout.Pool,
+			}))
+		}
+
+		ru.Cmdable = ru.single
+	case cfg.Cluster:
+		ru.cluster = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     cfg.Servers,
+			Password:  cfg.Password,
+			PoolSize:  cfg.PoolSize,
+			TLSConfig: tlsCfg,
+
+			//超时设置
+			DialTimeout:  cfg.Timeout.Dial,  //连接建立超时时间，默认5秒。
+			ReadTimeout:  cfg.Timeout.Read,  //读超时，默认3秒， -1表示取消读超时
+			WriteTimeout: cfg.Timeout.Write, //写超时，默认等于读超时，-1表示取消读超时
+			PoolTimeout:  cfg.Timeout.Pool,  //当所有连接都处在繁忙状态时，客户端等待可用连接的最大等待时长，默认为读超时+1秒。
+		})
+
+		ru.Cmdable = ru.cluster
+	default:
+		ru.single = redis.NewClient(&redis.Options{
+			Addr:      cfg.Servers[0],
+			Password:  cfg.Password,
+			PoolSize:  cfg.PoolSize,
+			DB:        cfg.DefaultDB,
+			TLSConfig: tlsCfg,
+
+			//超时设置
+			DialTimeout:  cfg.Timeout.Dial,
+			ReadTimeout:  cfg.Timeout.Read,
+			WriteTimeout: cfg.Timeout.Write,
+			PoolTimeout:  cfg.Timeout.Pool,
+		})
+
+		ru.Cmdable = ru.single
+	}
+
+	if cfg.Enable && cfg.Timeout.Health > 0 {
+		ctx, cancel := context.WithCancel(Application.Ctx)
+		ru.cancel = cancel
+		go ru.healthCheck(ctx, cfg.Timeout.Health)
+		//节点健康检查
+
+		for _, v := range ru.sentinels {
+			go ru.watchMaster(ctx, v)
+			//主节点切换
+		}
+	}
+
+	return nil
+}
+
+// Close 2026-10-19 10:15:30
+/*
+ 描述: 停止健康检查并关闭连接
+*/
+func (ru *Utils) Close() error {
+	ru.lock.Lock()
+	cancel := ru.cancel
+	ru.cancel = nil
+	ru.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	for _, v := range ru.sentinels {
+		_ = v.Close()
+	}
+
+	if ru.cluster != nil {
+		return ru.cluster.Close()
+	}
+
+	if ru.single != nil {
+		return ru.single.Close()
+	}
+	return nil
+}
+
+// decryptPassword 2026-10-19 09:12:30
+/*
+ 参数: pwd,des加密的密码
+ 描述: 解密配置文件中的密码
+*/
+func decryptPassword(pwd string) (string, error) {
+	if len(pwd) < 1 {
+		return pwd, nil
+	}
+
+	buf, err := NewEncrypter(EncryptDesEcb, []byte(DefaultEncryptKey)).Decrypt([]byte(pwd), true)
+	if err != nil {
+		return "", err
+	}
+
+	return string(buf), nil
+}
+
+// loadTLSConfig 2026-10-19 09:20:15
+/*
+ 参数: cfg,tls配置
+ 描述: 依据cfg生成tls参数,客户端证书可选
+*/
+func loadTLSConfig(cfg *RedisTLS) (*tls.Config, error) {
+	tc := &tls.Config{
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.SkipVerify,
+	}
+
+	if cfg.CA != "" {
+		cfg.CA = FixPathVar(cfg.CA)
+		rootCA, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("redis.Tls.ca: %v", err)
+		}
+
+		cp := x509.NewCertPool()
+		if !cp.AppendCertsFromPEM(rootCA) {
+			return nil, fmt.Errorf("redis.Tls.ca load error")
+		}
+		tc.RootCAs = cp
+	}
+
+	if cfg.Cert != "" || cfg.Key != "" {
+		cfg.Key = FixPathVar(cfg.Key)
+		cfg.Cert = FixPathVar(cfg.Cert)
+		cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("redis.LoadX509KeyPair: %v", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
 }
 
 // Ping 2022-08-12 19:21:09
@@ -235,3 +469,220 @@ func (ru *Utils) DateID(key string, idLen int, prefix ...string) (id string, err
 
 	return id + base, nil
 }
+
+// RegisterEventHandler 2026-10-19 09:35:42
+/*
+ 参数: fn,事件句柄
+ 描述: 添加fn处理redis节点事件
+*/
+func (ru *Utils) RegisterEventHandler(fn EventHandler) {
+	if IsNil(fn) {
+		return
+	}
+
+	ru.lock.Lock()
+	defer ru.lock.Unlock()
+
+	if IsNil(ru.events) {
+		ru.events = make([]EventHandler, 0, 2)
+	}
+
+	pFun := reflect.ValueOf(fn)
+	for _, v := range ru.events {
+		if reflect.ValueOf(v).Pointer() == pFun.Pointer() { //重复注册
+			return
+		}
+	}
+
+	ru.events = append(ru.events, fn)
+	//注册
+}
+
+// eventAction 2026-10-19 09:38:16
+/*
+ 参数: node,节点地址
+ 参数: event,事件代码
+ 描述: 触发node节点的event事件
+*/
+func (ru *Utils) eventAction(node string, event Event) {
+	ru.lock.RLock()
+	events := ru.events
+	ru.lock.RUnlock()
+
+	if len(events) < 1 {
+		return
+	}
+
+	defer DeferHandle(false, "znlib.redis.eventAction")
+	for _, do := range events {
+		do(ru, node, event)
+	}
+}
+
+// NodeStatus 2026-10-19 09:41:05
+/*
+ 描述: 返回各节点在线状态
+*/
+func (ru *Utils) NodeStatus() map[string]bool {
+	ru.lock.RLock()
+	defer ru.lock.RUnlock()
+
+	nodes := make(map[string]bool, len(ru.nodes))
+	for k, v := range ru.nodes {
+		nodes[k] = v
+	}
+	return nodes
+}
+
+// updateNode 2026-10-19 09:45:27
+/*
+ 参数: node,节点地址
+ 参数: err,检查结果
+ 描述: 更新node节点状态,状态变化时触发事件
+*/
+func (ru *Utils) updateNode(node string, err error) {
+	ru.lock.Lock()
+	online, exists := ru.nodes[node]
+	ru.nodes[node] = err == nil
+	ru.lock.Unlock()
+
+	switch {
+	case err == nil && !exists:
+		Info("znlib.redis.connected: " + node)
+		ru.eventAction(node, EventConnected)
+	case err == nil && !online:
+		Info("znlib.redis.recovered: " + node)
+		ru.eventAction(node, EventReConnect)
+	case err != nil && (online || !exists):
+		ErrorCaller(fmt.Sprintf("%s: %v", node, err), "znlib.redis.lostconnect")
+		ru.eventAction(node, EventDisconnect)
+	}
+}
+
+// switchMaster 2026-10-19 09:48:36
+/*
+ 参数: addr,主节点地址
+ 描述: 哨兵模式更新主节点,地址变化时触发事件
+*/
+func (ru *Utils) switchMaster(addr string) {
+	ru.lock.Lock()
+	old := ru.masterAddr
+	ru.masterAddr = addr
+	switched := old != "" && old != addr
+
+	if switched { //旧主节点不再检查
+		delete(ru.nodes, old)
+	}
+	ru.lock.Unlock()
+
+	if switched {
+		Warn(fmt.Sprintf("znlib.redis.failover: %s -> %s", old, addr))
+		ru.eventAction(addr, EventFailover)
+	}
+}
+
+// watchMaster 2026-10-19 09:50:02
+/*
+ 参数: ctx,上下文
+ 参数: sentinel,哨兵
+ 描述: 订阅哨兵的主节点切换消息,直到ctx结束
+*/
+func (ru *Utils) watchMaster(ctx context.Context, sentinel *redis.SentinelClient) {
+	defer DeferHandle(false, "znlib.redis.watchMaster")
+	pubsub := sentinel.Subscribe(ctx, "+switch-master")
+	defer pubsub.Close()
+
+	ch := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+
+			//<master name> <old ip> <old port> <new ip> <new port>
+			args := strings.Fields(msg.Payload)
+			if len(args) == 5 && args[0] == ru.master {
+				ru.switchMaster(net.JoinHostPort(args[3], args[4]))
+			}
+		}
+	}
+}
+
+// healthCheck 2026-10-19 09:52:11
+/*
+ 参数: ctx,上下文
+ 参数: interval,检查间隔
+ 描述: 定时检查各节点连接状态,直到ctx结束
+*/
+func (ru *Utils) healthCheck(ctx context.Context, interval time.Duration) {
+	defer DeferHandle(false, "znlib.redis.healthCheck")
+	update := func(node string, err error) {
+		if ctx.Err() == nil { //未停止
+			ru.updateNode(node, err)
+		}
+	}
+
+	check := func() {
+		ctx, cancel := context.WithTimeout(ctx, interval)
+		defer cancel()
+
+		switch {
+		case ru.cluster != nil:
+			err := ru.cluster.ForEachShard(ctx, func(ctx context.Context, shard *redis.Client) error {
+				update(shard.Options().Addr, shard.Ping(ctx).Err())
+				return nil
+			})
+
+			ru.lock.RLock()
+			_, exists := ru.nodes[nodeCluster]
+			ru.lock.RUnlock()
+
+			if err != nil || exists { //集群拓扑不可用 or 恢复
+				update(nodeCluster, err)
+			}
+		case ru.master != "":
+			addr := ""
+			for idx, v := range ru.sentinels {
+				err := v.Ping(ctx).Err()
+				update("sentinel:"+ru.sentinelAt[idx], err)
+
+				if err == nil && addr == "" {
+					master, e := v.GetMasterAddrByName(ctx, ru.master).Result()
+					if e == nil && len(master) == 2 {
+						addr = net.JoinHostPort(master[0], master[1])
+					}
+				}
+			}
+
+			if addr != "" {
+				ru.switchMaster(addr)
+			}
+
+			ru.lock.RLock()
+			addr = ru.masterAddr
+			ru.lock.RUnlock()
+
+			if addr != "" { //哨兵均不可用时,检查已知的主节点
+				update(addr, ru.single.Ping(ctx).Err())
+			}
+		default:
+			update(ru.single.Options().Addr, ru.single.Ping(ctx).Err())
+		}
+	}
+
+	check()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}